@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/internal/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+// The values that flags with a fixed set of them can take, for completion
+var flagChoices = map[string][]string{
+	"format":         {"svg", "png", "jpg", "dot", "csv", "jsongraph", "sankey", "neo4j"},
+	"sort":           {"size", "address", "name"},
+	"owners-order":   {"dfs", "bfs"},
+	"metrics-format": {"prometheus", "otlp"},
+	"layout":         layouts,
+	"rankdir":        rankDirs,
+}
+
+var shells = []string{"bash", "zsh", "fish"}
+
+// A flag as completion scripts need to know it
+type completionFlag struct {
+	name        string
+	description string
+	takesValue  bool
+	choices     []string
+}
+
+// Writes a script that completes heapspurs' commands, flags, and the
+// aliases in the configuration file, for the indicated shell.
+func writeCompletion(w io.Writer, shell string, aliases map[string]string) error {
+	words := slices.Clone(config.Commands)
+	for alias := range aliases {
+		words = append(words, alias)
+	}
+	sort.Strings(words)
+	flags := make([]completionFlag, 0)
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		// The first clause of the help is plenty for a completion menu
+		description, _, _ := strings.Cut(f.Usage, ";")
+		flags = append(flags, completionFlag{
+			name:        f.Name,
+			description: description,
+			takesValue:  f.Value.Type() != "bool",
+			choices:     flagChoices[f.Name],
+		})
+	})
+
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, words, flags)
+	case "zsh":
+		writeZshCompletion(w, words, flags)
+	case "fish":
+		writeFishCompletion(w, words, flags)
+	default:
+		return fmt.Errorf("Unknown shell '%s'; must be one of %s", shell, strings.Join(shells, ", "))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, words []string, flags []completionFlag) {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = "--" + f.name
+	}
+	fmt.Fprintf(w, "# bash completion for heapspurs; load with: source <(heapspurs completion bash)\n")
+	fmt.Fprintf(w, "_heapspurs() {\n")
+	fmt.Fprintf(w, "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(w, "  case \"$prev\" in\n")
+	for _, f := range flags {
+		if len(f.choices) > 0 {
+			fmt.Fprintf(w, "    --%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", f.name, strings.Join(f.choices, " "))
+		}
+	}
+	fmt.Fprintf(w, "    %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(valuedFlags(flags), "|"))
+	fmt.Fprintf(w, "    completion) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", strings.Join(shells, " "))
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "  if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "    return\n")
+	fmt.Fprintf(w, "  fi\n")
+	// Commands only come before the dump, so they're offered until the
+	// first word that isn't a flag or a flag's value
+	fmt.Fprintf(w, "  local i\n")
+	fmt.Fprintf(w, "  for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "    case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(w, "      %s) ((i++)) ;;\n", strings.Join(valuedFlags(flags), "|"))
+	fmt.Fprintf(w, "      -*) ;;\n")
+	fmt.Fprintf(w, "      *) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "  done\n")
+	fmt.Fprintf(w, "  COMPREPLY=($(compgen -W \"%s\" -- \"$cur\") $(compgen -f -- \"$cur\"))\n", strings.Join(words, " "))
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o filenames -F _heapspurs heapspurs\n")
+}
+
+func writeZshCompletion(w io.Writer, words []string, flags []completionFlag) {
+	escape := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	fmt.Fprintf(w, "#compdef heapspurs\n")
+	fmt.Fprintf(w, "# zsh completion for heapspurs; load with: source <(heapspurs completion zsh)\n")
+	fmt.Fprintf(w, "_heapspurs() {\n")
+	fmt.Fprintf(w, "  local state line\n")
+	fmt.Fprintf(w, "  _arguments \\\n")
+	for _, f := range flags {
+		switch {
+		case len(f.choices) > 0:
+			fmt.Fprintf(w, "    '--%s=[%s]:%s:(%s)' \\\n", f.name, escape.Replace(f.description), f.name, strings.Join(f.choices, " "))
+		case f.takesValue:
+			fmt.Fprintf(w, "    '--%s=[%s]:%s:_files' \\\n", f.name, escape.Replace(f.description), f.name)
+		default:
+			fmt.Fprintf(w, "    '--%s[%s]' \\\n", f.name, escape.Replace(f.description))
+		}
+	}
+	fmt.Fprintf(w, "    '1: :->command' \\\n")
+	fmt.Fprintf(w, "    '*: :->argument'\n")
+	fmt.Fprintf(w, "  case $state in\n")
+	fmt.Fprintf(w, "    command) _alternative 'commands:command:(%s)' 'files:dump file:_files' ;;\n", strings.Join(words, " "))
+	fmt.Fprintf(w, "    argument) if [[ ${line[1]} == completion ]]; then _values shell %s; else _files; fi ;;\n", strings.Join(shells, " "))
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "compdef _heapspurs heapspurs\n")
+}
+
+func writeFishCompletion(w io.Writer, words []string, flags []completionFlag) {
+	escape := strings.NewReplacer("\\", "\\\\", "'", "\\'")
+	fmt.Fprintf(w, "# fish completion for heapspurs; load with: heapspurs completion fish | source\n")
+	fmt.Fprintf(w, "complete -c heapspurs -n '__fish_use_subcommand' -a '%s'\n", strings.Join(words, " "))
+	fmt.Fprintf(w, "complete -c heapspurs -n '__fish_seen_subcommand_from completion' -x -a '%s'\n", strings.Join(shells, " "))
+	for _, f := range flags {
+		switch {
+		case len(f.choices) > 0:
+			fmt.Fprintf(w, "complete -c heapspurs -l %s -x -a '%s' -d '%s'\n", f.name, strings.Join(f.choices, " "), escape.Replace(f.description))
+		case f.takesValue:
+			fmt.Fprintf(w, "complete -c heapspurs -l %s -r -d '%s'\n", f.name, escape.Replace(f.description))
+		default:
+			fmt.Fprintf(w, "complete -c heapspurs -l %s -d '%s'\n", f.name, escape.Replace(f.description))
+		}
+	}
+}
+
+// Returns the flags that take a value, as they're written on the command line
+func valuedFlags(flags []completionFlag) []string {
+	names := make([]string, 0)
+	for _, f := range flags {
+		if f.takesValue {
+			names = append(names, "--"+f.name)
+		}
+	}
+	return names
+}
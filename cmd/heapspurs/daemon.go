@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adamroach/heapspurs/internal/pkg/config"
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/treeclimber"
+	"github.com/goccy/go-graphviz"
+)
+
+// Serves analysis of dumps over JSON-RPC (version 1.0, as spoken by Go's
+// net/rpc/jsonrpc), so that editors and web frontends can use heapspurs
+// without parsing dumps themselves. Methods are named "Heapspurs.Load",
+// "Heapspurs.Find", and so on; see the README for their parameters.
+type Daemon struct {
+	conf  *config.Config
+	mutex sync.Mutex // guards dumps
+	dumps map[string]*loadedDump
+}
+
+// A TreeClimber can only do one thing at a time, so requests for the same
+// dump take turns.
+type loadedDump struct {
+	mutex   sync.Mutex
+	climber *treeclimber.TreeClimber
+	err     error
+	session Session
+}
+
+type LoadArgs struct {
+	Dumpfile string `json:"dumpfile"`
+}
+
+type LoadReply struct {
+	Dump string `json:"dump"` // what to pass as "dump" to other methods
+}
+
+type DumpArgs struct {
+	Dump string `json:"dump"`
+}
+
+type FindArgs struct {
+	Dump    string `json:"dump"`
+	Pattern string `json:"pattern"`
+}
+
+type AddressArgs struct {
+	Dump    string `json:"dump"`
+	Address string `json:"address"`
+}
+
+type OwnersArgs struct {
+	Dump    string `json:"dump"`
+	Address string `json:"address"`
+	Depth   int    `json:"depth"`
+}
+
+type HistogramArgs struct {
+	Dump  string `json:"dump"`
+	Count int    `json:"count"`
+}
+
+type SitesArgs struct {
+	Dump   string `json:"dump"`
+	Count  int    `json:"count"`
+	Frames int    `json:"frames"`
+}
+
+type GraphArgs struct {
+	Dump    string `json:"dump"`
+	Address string `json:"address"`
+	Hops    int    `json:"hops"`
+	Format  string `json:"format"`
+}
+
+type TextReply struct {
+	Output string `json:"output"`
+}
+
+type GraphReply struct {
+	Format string `json:"format"`
+	Data   []byte `json:"data"` // base64 encoded in JSON
+}
+
+func serveDaemon(conf *config.Config) error {
+	d := &Daemon{conf: conf, dumps: make(map[string]*loadedDump)}
+	server := rpc.NewServer()
+	err := server.RegisterName("Heapspurs", d)
+	if err != nil {
+		return err
+	}
+	if len(conf.Dumpfile) > 0 {
+		var reply LoadReply
+		err = d.Load(LoadArgs{Dumpfile: conf.Dumpfile}, &reply)
+		if err != nil {
+			return err
+		}
+	}
+
+	network := "unix"
+	if strings.Contains(conf.Listen, ":") {
+		network = "tcp"
+	} else if info, err := os.Stat(conf.Listen); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// Left behind by a daemon that didn't get to clean up
+		os.Remove(conf.Listen)
+	}
+	listener, err := net.Listen(network, conf.Listen)
+	if err != nil {
+		return err
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		listener.Close()
+	}()
+
+	heapdump.Logger().Info("Serving JSON-RPC", "network", network, "address", conf.Listen)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Reads a dump, if it hasn't been read already. Dumps are known by their
+// absolute paths; loading the same file twice doesn't read it again.
+func (d *Daemon) Load(args LoadArgs, reply *LoadReply) error {
+	path, err := filepath.Abs(args.Dumpfile)
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	dump, loaded := d.dumps[path]
+	if !loaded {
+		dump = &loadedDump{}
+		d.dumps[path] = dump
+		// Other requests for this dump wait until it's read
+		dump.mutex.Lock()
+	}
+	d.mutex.Unlock()
+
+	if loaded {
+		dump.mutex.Lock()
+	} else {
+		heapdump.Logger().Info("Reading dump", "file", path)
+		dump.climber, dump.err = loadClimber(path)
+		if dump.err == nil {
+			dump.err = configureClimber(dump.climber, d.conf)
+		}
+	}
+	err = dump.err
+	dump.mutex.Unlock()
+
+	if err != nil {
+		// Let it be tried again
+		d.mutex.Lock()
+		delete(d.dumps, path)
+		d.mutex.Unlock()
+		return err
+	}
+	reply.Dump = path
+	return nil
+}
+
+// Forgets a dump, freeing the memory used to analyze it.
+func (d *Daemon) Unload(args DumpArgs, reply *TextReply) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, loaded := d.dumps[args.Dump]; !loaded {
+		return fmt.Errorf("Dump '%s' is not loaded", args.Dump)
+	}
+	delete(d.dumps, args.Dump)
+	return nil
+}
+
+func (d *Daemon) Find(args FindArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "find", args.Pattern)
+}
+
+func (d *Daemon) Owners(args OwnersArgs, reply *TextReply) error {
+	depth := args.Depth
+	if depth == 0 {
+		depth = 1
+	}
+	return d.text(args.Dump, reply, "owners", args.Address, strconv.Itoa(depth))
+}
+
+func (d *Daemon) Anchors(args AddressArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "anchors", args.Address)
+}
+
+func (d *Daemon) Retained(args AddressArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "retained", args.Address)
+}
+
+func (d *Daemon) Path(args AddressArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "path", args.Address)
+}
+
+func (d *Daemon) Hexdump(args AddressArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "hexdump", args.Address)
+}
+
+func (d *Daemon) Histogram(args HistogramArgs, reply *TextReply) error {
+	return d.text(args.Dump, reply, "histogram", strconv.Itoa(args.Count))
+}
+
+func (d *Daemon) Sites(args SitesArgs, reply *TextReply) error {
+	if args.Frames > 0 {
+		return d.text(args.Dump, reply, "sites", strconv.Itoa(args.Count), strconv.Itoa(args.Frames))
+	}
+	return d.text(args.Dump, reply, "sites", strconv.Itoa(args.Count))
+}
+
+// Draws the graph of an object's owners, or only those within the indicated
+// number of hops of it. The format defaults to that given by --format.
+func (d *Daemon) Graph(args GraphArgs, reply *GraphReply) error {
+	format := args.Format
+	if len(format) == 0 {
+		format = d.conf.Format
+	}
+	var out bytes.Buffer
+	err := d.execute(args.Dump, &out, graphviz.Format(format), "graph", args.Address, strconv.Itoa(args.Hops))
+	if err != nil {
+		return err
+	}
+	reply.Format = format
+	reply.Data = out.Bytes()
+	return nil
+}
+
+func (d *Daemon) text(dump string, reply *TextReply, command string, args ...string) error {
+	var out bytes.Buffer
+	err := d.execute(dump, &out, graphviz.Format(d.conf.Format), command, args...)
+	if err != nil {
+		return err
+	}
+	reply.Output = out.String()
+	return nil
+}
+
+func (d *Daemon) execute(path string, out *bytes.Buffer, format graphviz.Format, command string, args ...string) error {
+	d.mutex.Lock()
+	dump, loaded := d.dumps[path]
+	d.mutex.Unlock()
+	if !loaded {
+		return fmt.Errorf("Dump '%s' is not loaded", path)
+	}
+	dump.mutex.Lock()
+	defer dump.mutex.Unlock()
+	if dump.err != nil {
+		return dump.err
+	}
+	err := dump.climber.Execute(command, args, out, format)
+	if err != nil {
+		return err
+	}
+	query := Query{Command: command, Args: args}
+	if command == "graph" {
+		query.Format = string(format)
+	}
+	if len(dump.session.Dumpfile) == 0 {
+		dump.session.Dumpfile = path
+	}
+	dump.session.Queries = append(dump.session.Queries, query)
+	return nil
+}
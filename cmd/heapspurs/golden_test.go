@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of the reference dumps instead of checking them")
+
+// One of the analyses in testdata/corpus/queries
+type query struct {
+	name string
+	args []string
+}
+
+// Runs each of the analyses in testdata/corpus/queries on every reference
+// dump in testdata/corpus/dumps, and compares their output with the golden
+// files in testdata/corpus/golden, so that a change in how dumps are parsed
+// or analyzed -- whether from a change to heapspurs or to the dump format
+// of a new Go release -- shows up as a failure. With -update, the golden
+// files are rewritten instead.
+func TestGolden(t *testing.T) {
+	corpus := filepath.Join("..", "..", "testdata", "corpus")
+	queries, err := readQueries(filepath.Join(corpus, "queries"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumps, err := filepath.Glob(filepath.Join(corpus, "dumps", "*", "*.dump"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) == 0 {
+		t.Skip("No reference dumps")
+	}
+	binary := filepath.Join(t.TempDir(), "heapspurs")
+	if output, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Could not build heapspurs: %v\n%s", err, output)
+	}
+
+	for _, dump := range dumps {
+		version := filepath.Base(filepath.Dir(dump))
+		name := strings.TrimSuffix(filepath.Base(dump), ".dump")
+		for _, q := range queries {
+			t.Run(version+"/"+name+"/"+q.name, func(t *testing.T) {
+				output := runQuery(t, binary, q, dump)
+				golden := filepath.Join(corpus, "golden", version, name+"."+q.name+".txt")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, output, 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if line, found := firstDifference(want, output); found {
+					t.Errorf("Output differs from %s at line %d; if the change is intended, rerun with -update\n%s",
+						golden, line+1, output)
+				}
+			})
+		}
+	}
+}
+
+// Reads the queries file: a name for the golden file, then the arguments
+// to heapspurs that go before the dump, split on spaces, one per line.
+func readQueries(filename string) ([]query, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	queries := make([]query, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		queries = append(queries, query{name: fields[0], args: fields[1:]})
+	}
+	return queries, scanner.Err()
+}
+
+// Returns what heapspurs prints for a query on a dump. Logs and panics name
+// files on this machine, so only the exit status of a failed run is kept.
+func runQuery(t *testing.T, binary string, q query, dump string) []byte {
+	args := append([]string{"--quiet"}, q.args...)
+	cmd := exec.Command(binary, append(args, dump)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&output, "exit status %d\n", exit.ExitCode())
+	}
+	return output.Bytes()
+}
+
+// Returns the first line at which two outputs differ, if they do.
+func firstDifference(a, b []byte) (int, bool) {
+	if bytes.Equal(a, b) {
+		return 0, false
+	}
+	aLines, bLines := bytes.Split(a, []byte("\n")), bytes.Split(b, []byte("\n"))
+	for i := 0; i < min(len(aLines), len(bLines)); i++ {
+		if !bytes.Equal(aLines[i], bLines[i]) {
+			return i, true
+		}
+	}
+	return min(len(aLines), len(bLines)), true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The exit status of a run that's stopped for going over --timeout or
+// --memory-limit, so that scripts can tell it from other failures.
+const limitExceededStatus = 3
+
+// How often the memory in use is checked against --memory-limit.
+const memoryCheckInterval = 100 * time.Millisecond
+
+// Keeps an analysis within the time and memory it's been given, so that a
+// huge or hostile dump can't take over the machine it's being analyzed on.
+// The memory limit is also handed to the garbage collector, which works
+// harder as it's approached; only if that isn't enough is the run stopped.
+// Memory-mapped dump files (see --mmap) aren't counted, since the operating
+// system can drop their pages as it needs to. When a limit is exceeded,
+// abort is called with the reason, and should not return.
+//
+// The returned function stops enforcing the limits.
+func enforceLimits(timeout time.Duration, memoryLimit string, abort func(reason error)) (func(), error) {
+	ctx := context.Background()
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	ctx, stop := context.WithCancelCause(ctx)
+
+	if len(memoryLimit) > 0 {
+		limit, err := units.ParseSize(memoryLimit)
+		if err != nil || limit == 0 {
+			stop(nil)
+			cancel()
+			return nil, fmt.Errorf("Bad memory limit '%s'", memoryLimit)
+		}
+		debug.SetMemoryLimit(int64(limit))
+		go watchMemory(ctx, limit, memoryLimit, stop)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			abort(fmt.Errorf("Analysis took longer than %v", timeout))
+		} else if cause := context.Cause(ctx); cause != context.Canceled {
+			abort(cause)
+		}
+	}()
+	return func() {
+		stop(nil)
+		cancel()
+	}, nil
+}
+
+// Stops the run, by way of its context, once the memory that the runtime
+// has taken from the operating system goes over the limit. This is the
+// same memory that debug.SetMemoryLimit counts.
+func watchMemory(ctx context.Context, limit uint64, spec string, stop context.CancelCauseFunc) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		metrics.Read(samples)
+		used := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+		if used > limit {
+			stop(fmt.Errorf("Analysis needed more than %s of memory (%d bytes in use)", spec, used))
+			return
+		}
+	}
+}
+
+// Logs why the run was stopped, writes out whatever self-debugging results
+// have been gathered (they're most useful for exactly this sort of run),
+// and exits.
+func abortRun(logger *slog.Logger, selfDebug *selfDebugger) func(reason error) {
+	return func(reason error) {
+		logger.Error("Stopping", "error", reason)
+		if selfDebug != nil {
+			err := selfDebug.finish()
+			if err != nil {
+				logger.Error("Writing self-debugging results", "error", err)
+			}
+		}
+		os.Exit(limitExceededStatus)
+	}
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/adamroach/heapspurs/internal/pkg/config"
+	"github.com/adamroach/heapspurs/pkg/capture"
 	"github.com/adamroach/heapspurs/pkg/heapdump"
 	"github.com/adamroach/heapspurs/pkg/treeclimber"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"github.com/goccy/go-graphviz"
 )
 
 func main() {
@@ -19,18 +30,107 @@ func main() {
 		panic(fmt.Sprintf("Config: %v\n", err))
 	}
 
+	if conf.Command == "completion" {
+		err = writeCompletion(os.Stdout, conf.Shell, conf.Aliases)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	level := slog.LevelInfo
+	if conf.Verbose {
+		level = slog.LevelDebug
+	} else if conf.Quiet {
+		level = slog.LevelWarn
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	heapdump.SetLogger(logger)
+	heapdump.SetMaxObjectSize(conf.MaxObjectSize)
+	units.SetSI(conf.SI)
+	heapdump.SetLenient(conf.Lenient)
+	heapdump.SetInterning(!conf.NoIntern)
+	heapdump.SetFullNames(conf.FullNames)
+	heapdump.SetLinkFormat(conf.LinkFormat, writesToTerminal(conf))
+	heapdump.SetPointerCanonicalization(conf.PointerMask, conf.PointerAlign)
+
+	if conf.Lenient {
+		defer func() {
+			records, bytes := heapdump.SkippedRecords()
+			if records > 0 {
+				logger.Warn("Skipped records of unknown types", "records", records, "bytes", bytes)
+			}
+		}()
+	}
+
+	if conf.PrintStats {
+		stats := heapdump.NewStats()
+		heapdump.SetStats(stats)
+		defer stats.Print(os.Stderr)
+	}
+
+	var selfDebug *selfDebugger
+	if len(conf.SelfDebug) > 0 {
+		selfDebug, err = startSelfDebug(conf.SelfDebug)
+		if err != nil {
+			panic(fmt.Sprintf("Self-debug: %v\n", err))
+		}
+		defer func() {
+			err := selfDebug.finish()
+			if err != nil {
+				logger.Error("Writing self-debugging results", "error", err)
+			}
+		}()
+	}
+
+	// The daemon and the explorer run for as long as they're wanted, so only
+	// their memory is limited
+	timeout := conf.Timeout
+	if conf.Command == "daemon" || conf.Command == "serve" {
+		timeout = 0
+	}
+	stopLimits, err := enforceLimits(timeout, conf.MemoryLimit, abortRun(logger, selfDebug))
+	if err != nil {
+		panic(err)
+	}
+	defer stopLimits()
+
+	// Graphs and record listings name things from the default symbol table,
+	// so that's where everything goes.
+	symbols := heapdump.DefaultSymbols()
+
 	if len(conf.Oid) > 0 {
 		file, err := os.Open(conf.Oid)
 		if err != nil {
 			panic(fmt.Sprintf("Open OID file '%s': %v\n", conf.Oid, err))
 		}
-		err = heapdump.ReadOids(file)
+		err = symbols.ReadOids(file)
 		if err != nil {
 			panic(fmt.Sprintf("Reading OID file '%s': %v\n", conf.Oid, err))
 		}
 		file.Close()
 	}
 
+	// Dumps written with package capture say what wrote them
+	metadata, err := capture.ReadMetadata(conf.Dumpfile)
+	if err != nil {
+		logger.Warn("Ignoring dump metadata", "error", err)
+	} else if metadata != nil {
+		logger.Info("Read dump metadata", "program", metadata.Path, "revision", metadata.Revision,
+			"go", metadata.GoVersion, "host", metadata.Hostname, "time", metadata.Time)
+		heapdump.SetGoVersion(metadata.GoVersion)
+	}
+
+	if conf.Program == "auto" {
+		conf.Program, err = findProgram(conf.Dumpfile, metadata)
+		if err != nil {
+			logger.Warn("Symbols will not be available", "error", err)
+		} else {
+			logger.Info("Found program", "program", conf.Program)
+		}
+	}
+
 	if len(conf.Program) > 0 {
 		cmd := exec.Command("go", "tool", "nm", conf.Program)
 		stdout, err := cmd.StdoutPipe()
@@ -44,18 +144,80 @@ func main() {
 		if err != nil {
 			panic(fmt.Sprintf("Open program file '%s': %v\n", conf.Program, err))
 		}
-		err = heapdump.ReadSymbols(stdout)
+		logger.Info("Reading symbols", "program", conf.Program)
+		err = symbols.ReadSymbols(stdout)
 		if err != nil {
 			panic(fmt.Sprintf("Reading program file '%s': %v\n", conf.Program, err))
 		}
 		cmd.Wait()
+
+		err = symbols.ReadProgram(conf.Program)
+		if err != nil {
+			logger.Warn("Interface names and source locations will not be available", "error", err)
+		}
 	}
 
-	file, err := os.Open(conf.Dumpfile)
-	if err != nil {
-		panic(fmt.Sprintf("Open '%s': %v\n", conf.Dumpfile, err))
+	programSymbols = symbols.Clone()
+
+	// Patterns can only be matched once the dump has been read
+	matchPattern, isMatch := strings.CutPrefix(conf.AddressSpec, "match:")
+	biggestPattern, byRetained := strings.CutPrefix(conf.AddressSpec, "biggest-retained:")
+	if !byRetained {
+		biggestPattern, _ = strings.CutPrefix(conf.AddressSpec, "biggest:")
+	}
+	isBiggest := biggestPattern != conf.AddressSpec
+	if !isMatch && !isBiggest {
+		conf.Address, err = symbols.ParseAddress(conf.AddressSpec)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if conf.Command == "daemon" {
+		err = serveDaemon(conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "info" {
+		err = printInfo(conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var reader heapdump.Reader
+	var file *os.File
+	if conf.Mmap {
+		mapped, err := heapdump.NewMmapReader(conf.Dumpfile)
+		if err != nil {
+			panic(fmt.Sprintf("Open '%s': %v\n", conf.Dumpfile, err))
+		}
+		// Records point into the mapping, so it stays open until we exit
+		defer mapped.Close()
+		reader = mapped
+	} else {
+		file, err = os.Open(conf.Dumpfile)
+		if err != nil {
+			panic(fmt.Sprintf("Open '%s': %v\n", conf.Dumpfile, err))
+		}
+		reader, err = heapdump.NewFileReader(file)
+		if err != nil {
+			panic(fmt.Sprintf("Stat '%s': %v\n", conf.Dumpfile, err))
+		}
+	}
+
+	// Pointers can point ahead in the dump, so saying what they point to
+	// takes a pass of its own
+	if conf.Print || (len(conf.Find) > 0 && !conf.Aggregate) {
+		err = indexRecords(conf.Dumpfile)
+		if err != nil {
+			panic(err)
+		}
 	}
-	reader := bufio.NewReader(file)
 
 	if conf.Print {
 		err = heapdump.PrintRecords(reader, "")
@@ -66,14 +228,22 @@ func main() {
 	}
 
 	if len(conf.Find) > 0 {
-		err = heapdump.PrintRecords(reader, conf.Find)
+		if len(conf.Sort) > 0 || conf.Aggregate {
+			err = heapdump.FindObjects(reader, conf.Find, conf.Sort, conf.Aggregate)
+		} else {
+			err = heapdump.PrintRecords(reader, conf.Find)
+		}
 		if err != nil {
 			panic(err)
 		}
 		return
 	}
 
+	logger.Info("Reading dump", "file", conf.Dumpfile)
 	climber, err := treeclimber.NewTreeClimber(reader)
+	if selfDebug != nil {
+		selfDebug.keep = climber
+	}
 
 	if len(conf.MakeDump) > 0 {
 		f, err := os.Create(conf.MakeDump)
@@ -90,10 +260,107 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	file.Close()
+	if file != nil {
+		file.Close()
+	}
+
+	err = configureClimber(climber, conf)
+	if err != nil {
+		panic(err)
+	}
+
+	addresses := []uint64{conf.Address}
+	if isMatch {
+		addresses, err = matchAddresses(climber, conf, matchPattern)
+		if err != nil {
+			panic(err)
+		}
+		conf.Address = addresses[0]
+	} else if isBiggest {
+		conf.Address, err = climber.BiggestMatch(biggestPattern, byRetained)
+		if err != nil {
+			panic(err)
+		}
+		addresses[0] = conf.Address
+		logger.Info("Using the biggest match", "address", heapdump.DefaultSymbols().FormatAddr(conf.Address))
+	}
+
+	if conf.Command == "run" {
+		err = runScript(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "at" {
+		err = forEachAddress(addresses, climber.PrintRecord)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "export" {
+		err = export(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "report" {
+		err = writeReport(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "serve" {
+		err = serveExplorer(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "track" {
+		err = track(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Command == "budget" {
+		exceeded, err := checkBudgets(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		if exceeded > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if conf.Command == "inventory" {
+		unexpected, err := checkInventory(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		if unexpected > 0 {
+			os.Exit(1)
+		}
+		return
+	}
 
 	if conf.Anchors {
-		err := climber.PrintAnchors(conf.Address)
+		query := climber.PrintAnchors
+		if conf.Hubs {
+			query = climber.PrintHubs
+		}
+		err := forEachAddress(addresses, query)
 		if err != nil {
 			panic(err)
 		}
@@ -101,7 +368,227 @@ func main() {
 	}
 
 	if conf.Owners != 0 {
-		err := climber.PrintOwners(conf.Address, conf.Owners)
+		err := forEachAddress(addresses, func(address uint64) error {
+			return climber.PrintOwners(address, conf.Owners)
+		})
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.TopOwners > 0 {
+		err := climber.PrintTopOwners(conf.TopOwners)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(conf.OwnersOfType) > 0 {
+		err := climber.PrintTypeOwners(conf.OwnersOfType, conf.Goroutine)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Fan || conf.MinFanIn > 0 || conf.MinFanOut > 0 {
+		err := climber.PrintFanStats(conf.MinFanIn, conf.MinFanOut)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Chains > 0 {
+		err := climber.PrintChains(conf.Chains)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Retainers {
+		err := forEachAddress(addresses, climber.PrintRetainers)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Breaks {
+		err := forEachAddress(addresses, climber.PrintBreaks)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(conf.PathMatches) > 0 {
+		err := forEachAddress(addresses, func(address uint64) error {
+			return climber.PrintPathMatches(address, conf.PathMatches)
+		})
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Retained {
+		err := forEachAddress(addresses, climber.PrintRetained)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(conf.RetainedSet) > 0 {
+		err = writeRetainedSet(climber, conf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.StackStats {
+		err := climber.PrintStackStats()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Defers {
+		err := climber.PrintDefers()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Channels {
+		err := climber.PrintChannels()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Pools {
+		err := climber.PrintPools()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.SlackSlices {
+		err := climber.PrintSlackSlices()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Entropy {
+		err := climber.PrintEntropy()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.NamingConflict {
+		err := climber.PrintNamingConflicts()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Tags {
+		err := climber.PrintTags()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.SizeClasses {
+		err := climber.PrintSizeClassWaste()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.InlineFields > 0 {
+		err := climber.PrintInlineFields(conf.InlineFields)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Unknown {
+		err := climber.PrintUnknownTargets()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.OffHeap {
+		err := climber.PrintOffHeapPointers()
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(conf.Implements) > 0 {
+		err := climber.PrintImplements(conf.Implements)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(conf.Diff) > 0 {
+		other, err := loadClimber(conf.Diff)
+		if err != nil {
+			panic(err)
+		}
+		diff, err := climber.Diff(other, conf.Address)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Print(diff)
+		return
+	}
+
+	if len(conf.Compare) > 0 {
+		logger.Info("Reading dump", "file", conf.Compare)
+		other, err := loadClimber(conf.Compare)
+		if err != nil {
+			panic(err)
+		}
+		treeclimber.Compare(climber, other).Print(os.Stdout, 0)
+		return
+	}
+
+	if len(conf.Persists) > 0 {
+		others := make([]*treeclimber.TreeClimber, 0, len(conf.Persists))
+		for _, filename := range conf.Persists {
+			logger.Info("Reading dump", "file", filename)
+			other, err := loadClimber(filename)
+			if err != nil {
+				panic(err)
+			}
+			others = append(others, other)
+		}
+		err := climber.PrintPersistence(others, conf.Persists, conf.Address)
 		if err != nil {
 			panic(err)
 		}
@@ -109,11 +596,22 @@ func main() {
 	}
 
 	if conf.Hexdump {
-		hexdump, err := climber.Hexdump(conf.Address)
+		err := forEachAddress(addresses, func(address uint64) error {
+			hexdump, err := climber.HexdumpContext(address, conf.Context)
+			fmt.Print(hexdump)
+			return err
+		})
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if conf.Format == "neo4j" {
+		err = writeNeo4j(climber, conf.Output)
 		if err != nil {
 			panic(err)
 		}
-		fmt.Print(hexdump)
 		return
 	}
 
@@ -121,6 +619,426 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("Create '%s': %v\n", conf.Output, err))
 	}
-	climber.WriteSVG(conf.Address, out)
+	format := graphviz.Format(conf.Format)
+	if conf.Format == "csv" {
+		err = climber.WriteEdgeList(out)
+	} else if conf.Format == "jsongraph" {
+		err = climber.WriteJSONGraph(out)
+	} else if conf.Format == "sankey" {
+		err = climber.WriteSankey(out)
+	} else if _, found := findRenderer(conf.Format); found {
+		err = climber.Render(conf.Format, out)
+	} else if len(conf.GraphType) > 0 {
+		err = climber.WriteTypeGraphMatching(conf.GraphType, out, format)
+	} else if conf.Goroutine > 0 {
+		err = climber.WriteGoroutine(conf.Goroutine, max(conf.Neighborhood, 1), out, format)
+	} else if conf.CollapseTypes {
+		err = climber.WriteTypeGraph(conf.Address, out, format)
+	} else if conf.Neighborhood > 0 {
+		err = climber.WriteNeighborhood(conf.Address, conf.Neighborhood, out, format)
+	} else {
+		err = climber.WriteImage(conf.Address, out, format)
+	}
 	out.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Resolves a "match:" address to the objects and globals whose names match
+// its pattern. Only the queries that go through forEachAddress can be run
+// on more than one of them.
+func matchAddresses(climber *treeclimber.TreeClimber, conf *config.Config, pattern string) ([]uint64, error) {
+	addresses, total, err := climber.MatchAddresses(pattern, conf.MaxMatches)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("No objects or globals match '%s'", pattern)
+	}
+	if total > len(addresses) {
+		heapdump.Logger().Warn("Only querying some of the matches; raise --max-matches to see more",
+			"matches", total, "max-matches", conf.MaxMatches)
+	}
+	multiple := conf.Command == "at" || conf.Anchors || conf.Owners != 0 || conf.Retainers || conf.Breaks || len(conf.PathMatches) > 0 || conf.Retained || conf.Hexdump
+	if len(addresses) > 1 && !multiple {
+		return nil, fmt.Errorf("'%s' matches %d objects and globals; only at, --anchors, --owners, --retainers, --breaks, --path-matches, --retained, and --hexdump can take more than one", pattern, total)
+	}
+	return addresses, nil
+}
+
+// Runs a query on each address in turn, with a heading for each if there's
+// more than one.
+func forEachAddress(addresses []uint64, query func(address uint64) error) error {
+	for i, address := range addresses {
+		if len(addresses) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("=== %s\n", heapdump.DefaultSymbols().FormatAddr(address))
+		}
+		err := query(address)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Looks for the program that wrote the dump next to the dump, in the
+// current directory, and everywhere that executables are usually found.
+func findProgram(dumpfile string, metadata *capture.Metadata) (string, error) {
+	file, err := os.Open(dumpfile)
+	if err != nil {
+		return "", fmt.Errorf("Open '%s': %w", dumpfile, err)
+	}
+	defer file.Close()
+	reader, err := heapdump.NewFileReader(file)
+	if err != nil {
+		return "", fmt.Errorf("Stat '%s': %w", dumpfile, err)
+	}
+
+	dirs := []string{filepath.Dir(dumpfile), "."}
+	if metadata != nil && len(metadata.Executable) > 0 {
+		// The program may well still be where it was run from
+		dirs = append([]string{filepath.Dir(metadata.Executable)}, dirs...)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	dirs = append(dirs, os.Getenv("GOBIN"))
+	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+		dirs = append(dirs, filepath.Join(gopath, "bin"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, "go", "bin"))
+	}
+	return heapdump.FindProgram(reader, dirs)
+}
+
+// Whether reports go to a terminal, where escape sequences can make links
+// clickable. Scripts and the daemon write their reports elsewhere.
+func writesToTerminal(conf *config.Config) bool {
+	if conf.Command == "run" || conf.Command == "daemon" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// The Graphviz layout engines that --layout can choose, and the directions
+// that --rankdir can
+var layouts = []string{"dot", "neato", "fdp", "sfdp"}
+var rankDirs = []string{"TB", "LR", "BT", "RL"}
+
+// Applies the options that affect how a dump is analyzed and drawn.
+func configureClimber(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	err := climber.SetPrune(conf.Prune)
+	if err != nil {
+		return err
+	}
+	err = climber.SetWeakReferences(conf.WeakFinalizers, conf.WeakTypes)
+	if err != nil {
+		return err
+	}
+	if conf.OwnersOrder != "dfs" && conf.OwnersOrder != "bfs" {
+		return fmt.Errorf("Unknown owners order '%s'; must be \"dfs\" or \"bfs\"", conf.OwnersOrder)
+	}
+	climber.SetExact(conf.Exact)
+	climber.SetOwnerTraversal(treeclimber.OwnerTraversal{
+		BreadthFirst:  conf.OwnersOrder == "bfs",
+		PerPathCycles: conf.OwnersPerPath,
+		MaxPaths:      conf.MaxPaths,
+		MaxDepth:      conf.MaxDepth,
+	})
+	if !slices.Contains(layouts, conf.Layout) {
+		return fmt.Errorf("Unknown layout '%s'; must be one of %s", conf.Layout, strings.Join(layouts, ", "))
+	}
+	conf.RankDir = strings.ToUpper(conf.RankDir)
+	if !slices.Contains(rankDirs, conf.RankDir) {
+		return fmt.Errorf("Unknown rank direction '%s'; must be one of %s", conf.RankDir, strings.Join(rankDirs, ", "))
+	}
+	climber.SetRenderOptions(treeclimber.RenderOptions{
+		DPI:           conf.DPI,
+		Size:          conf.Size,
+		Page:          conf.Page,
+		Layout:        conf.Layout,
+		RankDir:       conf.RankDir,
+		HideUnknown:   conf.HideUnknown,
+		MinEdgeWeight: conf.MinEdgeWeight,
+	})
+	if len(conf.Annotations) > 0 {
+		file, err := os.Open(conf.Annotations)
+		if err != nil {
+			return fmt.Errorf("Open annotations file '%s': %w", conf.Annotations, err)
+		}
+		defer file.Close()
+		err = climber.ReadAnnotations(file)
+		if err != nil {
+			return fmt.Errorf("Reading annotations file '%s': %w", conf.Annotations, err)
+		}
+	}
+	return nil
+}
+
+// The program's symbols and the OIDs, before the names of any dump's objects
+// were added; each dump read by loadClimber gets a copy of its own.
+var programSymbols = heapdump.NewSymbolTable()
+
+// Reads another dump file, for commands that compare dumps.
+func loadClimber(filename string) (*treeclimber.TreeClimber, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Open '%s': %w", filename, err)
+	}
+	defer file.Close()
+	reader, err := heapdump.NewFileReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("Stat '%s': %w", filename, err)
+	}
+	return treeclimber.NewTreeClimberWithSymbols(reader, programSymbols.Clone())
+}
+
+func printInfo(conf *config.Config) error {
+	file, err := os.Open(conf.Dumpfile)
+	if err != nil {
+		return fmt.Errorf("Open '%s': %w", conf.Dumpfile, err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Stat '%s': %w", conf.Dumpfile, err)
+	}
+
+	reader, err := heapdump.NewFileReader(file)
+	if err != nil {
+		return fmt.Errorf("Stat '%s': %w", conf.Dumpfile, err)
+	}
+	info, err := heapdump.ReadInfo(reader)
+	if err != nil {
+		return err
+	}
+	info.FileSize = uint64(stat.Size())
+	info.Metadata, err = capture.ReadMetadata(conf.Dumpfile)
+	if err != nil {
+		return err
+	}
+
+	if conf.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+	fmt.Print(info.String())
+	return nil
+}
+
+// Returns the renderer for an output format: one that's been registered
+// with treeclimber.RegisterRenderer, or failing that, a program named
+// heapspurs-render-<format> in PATH, which is registered for next time.
+func findRenderer(format string) (treeclimber.Renderer, bool) {
+	r, found := treeclimber.LookupRenderer(format)
+	if found {
+		return r, true
+	}
+	path, err := exec.LookPath("heapspurs-render-" + format)
+	if err != nil {
+		return nil, false
+	}
+	r = &treeclimber.CommandRenderer{Path: path}
+	treeclimber.RegisterRenderer(format, r)
+	return r, true
+}
+
+func writeNeo4j(climber *treeclimber.TreeClimber, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	nodes, err := os.Create(filepath.Join(dir, "nodes.csv"))
+	if err != nil {
+		return err
+	}
+	defer nodes.Close()
+	edges, err := os.Create(filepath.Join(dir, "edges.csv"))
+	if err != nil {
+		return err
+	}
+	defer edges.Close()
+	return climber.WriteNeo4j(nodes, edges)
+}
+
+// Runs a script, leaving the results in the directory named by --output;
+// by default, this is named after the script.
+func runScript(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	script, err := os.Open(conf.Script)
+	if err != nil {
+		return err
+	}
+	defer script.Close()
+	dir := conf.Output
+	if dir == "heapdump."+conf.Format {
+		dir = strings.TrimSuffix(conf.Script, filepath.Ext(conf.Script)) + ".out"
+	}
+	return climber.RunScript(script, dir, graphviz.Format(conf.Format))
+}
+
+// Indexes the records of a dump, so that the pointers printed by --print
+// and --find can be shown with the records that they point to.
+func indexRecords(dumpfile string) error {
+	file, err := os.Open(dumpfile)
+	if err != nil {
+		return fmt.Errorf("Open '%s': %w", dumpfile, err)
+	}
+	defer file.Close()
+	reader, err := heapdump.NewFileReader(file)
+	if err != nil {
+		return fmt.Errorf("Stat '%s': %w", dumpfile, err)
+	}
+	index, err := heapdump.ReadRecordIndex(reader)
+	if err != nil {
+		return err
+	}
+	heapdump.SetRecordIndex(index)
+	return nil
+}
+
+// Writes an HTML report on the dump to the directory named by --output; by
+// default, this is the name of the dump with ".report" in place of its
+// extension.
+func writeReport(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	dir := conf.Output
+	if dir == "heapdump."+conf.Format {
+		dir = strings.TrimSuffix(conf.Dumpfile, filepath.Ext(conf.Dumpfile)) + ".report"
+	}
+	err := climber.WriteReport(dir, "Heap report for "+filepath.Base(conf.Dumpfile))
+	if err != nil {
+		return err
+	}
+	heapdump.Logger().Info("Wrote report", "file", filepath.Join(dir, "index.html"))
+	return nil
+}
+
+// Serves the web explorer over HTTP on --listen until interrupted, starting
+// at --address if one was given.
+func serveExplorer(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	server := &http.Server{
+		Addr:    conf.Listen,
+		Handler: climber.ExploreHandler("Heap of "+filepath.Base(conf.Dumpfile), conf.Address),
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		server.Close()
+	}()
+	heapdump.Logger().Info("Serving explorer", "url", "http://"+conf.Listen+"/")
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func writeRetainedSet(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	address, err := heapdump.DefaultSymbols().ParseAddress(conf.RetainedSet)
+	if err != nil {
+		return err
+	}
+	if conf.Format != "csv" {
+		return climber.PrintRetainedSet(address)
+	}
+	out, err := os.Create(conf.Output)
+	if err != nil {
+		return fmt.Errorf("Create '%s': %w", conf.Output, err)
+	}
+	defer out.Close()
+	return climber.WriteRetainedSet(address, out)
+}
+
+func checkBudgets(climber *treeclimber.TreeClimber, conf *config.Config) (int, error) {
+	file, err := os.Open(conf.Budgets)
+	if err != nil {
+		return 0, fmt.Errorf("Open budget file '%s': %w", conf.Budgets, err)
+	}
+	defer file.Close()
+	return climber.CheckBudgets(file)
+}
+
+func checkInventory(climber *treeclimber.TreeClimber, conf *config.Config) (int, error) {
+	file, err := os.Open(conf.Inventory)
+	if err != nil {
+		return 0, fmt.Errorf("Open inventory file '%s': %w", conf.Inventory, err)
+	}
+	defer file.Close()
+	return climber.CheckInventory(file)
+}
+
+// Follows the objects named by --type from the first dump through the
+// others given to the track command.
+func track(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	if len(conf.Type) == 0 {
+		return fmt.Errorf("Nothing to track; use --type")
+	}
+	others := make([]*treeclimber.TreeClimber, 0, len(conf.Tracked))
+	for _, filename := range conf.Tracked {
+		heapdump.Logger().Info("Reading dump", "file", filename)
+		other, err := loadClimber(filename)
+		if err != nil {
+			return err
+		}
+		others = append(others, other)
+	}
+	return climber.PrintTrack(conf.Type, others, append([]string{conf.Dumpfile}, conf.Tracked...))
+}
+
+func export(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	if len(conf.SQLite) == 0 && len(conf.Metrics) == 0 {
+		return fmt.Errorf("Nothing to export to; use --sqlite or --metrics")
+	}
+	logger := heapdump.Logger()
+	switch conf.SQLite {
+	case "":
+	case "-":
+		err := climber.WriteSQL(os.Stdout)
+		if err != nil {
+			return err
+		}
+	default:
+		logger.Info("Writing database", "file", conf.SQLite)
+		err := climber.WriteSQLite(conf.SQLite)
+		if err != nil {
+			return err
+		}
+	}
+	if len(conf.Metrics) > 0 {
+		return exportMetrics(climber, conf)
+	}
+	return nil
+}
+
+// Writes the metrics of each type in the dump, timestamped with when the
+// dump was written. Files are written under a temporary name and then
+// renamed, so that a collector never reads one that's half written.
+func exportMetrics(climber *treeclimber.TreeClimber, conf *config.Config) error {
+	taken := time.Now()
+	if info, err := os.Stat(conf.Dumpfile); err == nil {
+		taken = info.ModTime()
+	}
+	if conf.Metrics == "-" {
+		return climber.WriteMetrics(os.Stdout, conf.MetricsFormat, taken)
+	}
+	heapdump.Logger().Info("Writing metrics", "file", conf.Metrics)
+	temp := conf.Metrics + ".tmp"
+	out, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("Create '%s': %w", temp, err)
+	}
+	err = climber.WriteMetrics(out, conf.MetricsFormat, taken)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, conf.Metrics)
 }
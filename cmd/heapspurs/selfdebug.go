@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Profiles heapspurs itself while it runs, so that performance problems in
+// the tool can be diagnosed. Everything is written to a single directory:
+// a CPU profile, a heap profile, a heap dump, and the time spent in each
+// phase of the analysis.
+type selfDebugger struct {
+	dir    string
+	start  time.Time
+	cpu    *os.File
+	mutex  sync.Mutex
+	phases []string // in the order they were first seen
+	totals map[string]time.Duration
+	counts map[string]int
+	keep   any // kept reachable so that it shows up in the heap dump
+}
+
+func startSelfDebug(dir string) (*selfDebugger, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	d := &selfDebugger{
+		dir:    dir,
+		start:  time.Now(),
+		totals: make(map[string]time.Duration),
+		counts: make(map[string]int),
+	}
+	d.cpu, err = os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	err = pprof.StartCPUProfile(d.cpu)
+	if err != nil {
+		d.cpu.Close()
+		return nil, err
+	}
+	heapdump.SetPhaseFunc(d.record)
+	return d, nil
+}
+
+func (d *selfDebugger) record(name string, elapsed time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, seen := d.totals[name]; !seen {
+		d.phases = append(d.phases, name)
+	}
+	d.totals[name] += elapsed
+	d.counts[name]++
+}
+
+// Stops profiling and writes out everything that was collected.
+func (d *selfDebugger) finish() error {
+	elapsed := time.Since(d.start)
+	heapdump.SetPhaseFunc(nil)
+	pprof.StopCPUProfile()
+	d.cpu.Close()
+
+	heap, err := os.Create(filepath.Join(d.dir, "heap.pprof"))
+	if err != nil {
+		return err
+	}
+	defer heap.Close()
+	runtime.GC()
+	err = pprof.WriteHeapProfile(heap)
+	if err != nil {
+		return err
+	}
+
+	dump, err := os.Create(filepath.Join(d.dir, "heapspurs.dump"))
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+	debug.WriteHeapDump(dump.Fd())
+	runtime.KeepAlive(d.keep)
+
+	timings, err := os.Create(filepath.Join(d.dir, "timings.txt"))
+	if err != nil {
+		return err
+	}
+	defer timings.Close()
+	d.writeTimings(io.MultiWriter(os.Stderr, timings), elapsed)
+	heapdump.Logger().Info("Wrote self-debugging results", "dir", d.dir)
+	return nil
+}
+
+func (d *selfDebugger) writeTimings(w io.Writer, elapsed time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	fmt.Fprintf(w, "Phase timings:\n")
+	for _, name := range d.phases {
+		fmt.Fprintf(w, "  %-10s %12v", name, d.totals[name].Round(time.Microsecond))
+		if d.counts[name] > 1 {
+			fmt.Fprintf(w, " (%d times)", d.counts[name])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  %-10s %12v\n", "total", elapsed.Round(time.Microsecond))
+}
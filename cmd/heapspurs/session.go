@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// What's been done with a dump in the daemon: the addresses bookmarked, the
+// notes taken, and the queries run (including the graphs drawn). Sessions
+// are saved as JSON, so that an investigation can be picked up later, or
+// handed to someone else.
+type Session struct {
+	Dumpfile  string     `json:"dumpfile"`
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Notes     []Note     `json:"notes"`
+	Queries   []Query    `json:"queries"`
+}
+
+type Bookmark struct {
+	Name    string `json:"name"`
+	Address string `json:"address"` // in hex
+}
+
+type Note struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// A command that was run against the dump, as it would be written in a
+// script (see "heapspurs run"), and the format of the graph it drew, if any.
+type Query struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Format  string   `json:"format,omitempty"`
+}
+
+type BookmarkArgs struct {
+	Dump    string `json:"dump"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type NoteArgs struct {
+	Dump string `json:"dump"`
+	Text string `json:"text"`
+}
+
+type SessionFileArgs struct {
+	Dump string `json:"dump"`
+	File string `json:"file"`
+}
+
+type OpenSessionArgs struct {
+	File string `json:"file"`
+	// The dump to open the session with, if it isn't where the session says
+	// it is (as when someone else's session is opened)
+	Dumpfile string `json:"dumpfile"`
+}
+
+type SessionReply struct {
+	Dump    string  `json:"dump"`
+	Session Session `json:"session"`
+}
+
+// Names the object at an address, replacing any bookmark of the same name.
+func (d *Daemon) Bookmark(args BookmarkArgs, reply *TextReply) error {
+	if len(args.Name) == 0 {
+		return fmt.Errorf("Bookmarks need a name")
+	}
+	address, err := heapdump.DefaultSymbols().ParseAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	bookmark := Bookmark{Name: args.Name, Address: fmt.Sprintf("0x%x", address)}
+	reply.Output = fmt.Sprintf("%s = %s\n", bookmark.Name, bookmark.Address)
+	return d.withSession(args.Dump, func(s *Session) error {
+		for i, b := range s.Bookmarks {
+			if b.Name == args.Name {
+				s.Bookmarks[i] = bookmark
+				return nil
+			}
+		}
+		s.Bookmarks = append(s.Bookmarks, bookmark)
+		return nil
+	})
+}
+
+// Adds a note to the session.
+func (d *Daemon) Note(args NoteArgs, reply *TextReply) error {
+	return d.withSession(args.Dump, func(s *Session) error {
+		s.Notes = append(s.Notes, Note{Time: time.Now(), Text: args.Text})
+		return nil
+	})
+}
+
+// Returns the session of a dump, as it stands.
+func (d *Daemon) Session(args DumpArgs, reply *SessionReply) error {
+	reply.Dump = args.Dump
+	return d.withSession(args.Dump, func(s *Session) error {
+		reply.Session = copySession(s)
+		return nil
+	})
+}
+
+// Writes the session of a dump to a file.
+func (d *Daemon) SaveSession(args SessionFileArgs, reply *TextReply) error {
+	var session Session
+	err := d.withSession(args.Dump, func(s *Session) error {
+		session = copySession(s)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(args.File, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("Write session '%s': %w", args.File, err)
+	}
+	reply.Output = fmt.Sprintf("Saved %d bookmarks, %d notes, and %d queries\n",
+		len(session.Bookmarks), len(session.Notes), len(session.Queries))
+	return nil
+}
+
+// Reads a session file, loads its dump, and picks the session up where it
+// was saved, replacing whatever has been done with the dump since it was
+// loaded. The queries aren't run again; the client can rerun whichever of
+// them it needs.
+func (d *Daemon) OpenSession(args OpenSessionArgs, reply *SessionReply) error {
+	data, err := os.ReadFile(args.File)
+	if err != nil {
+		return fmt.Errorf("Read session '%s': %w", args.File, err)
+	}
+	var session Session
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return fmt.Errorf("Bad session file '%s': %w", args.File, err)
+	}
+	dumpfile := session.Dumpfile
+	if len(args.Dumpfile) > 0 {
+		dumpfile = args.Dumpfile
+	}
+	if len(dumpfile) == 0 {
+		return fmt.Errorf("Session '%s' doesn't say which dump it's for", args.File)
+	}
+
+	var loaded LoadReply
+	err = d.Load(LoadArgs{Dumpfile: dumpfile}, &loaded)
+	if err != nil {
+		return err
+	}
+	session.Dumpfile = loaded.Dump
+	reply.Dump = loaded.Dump
+	return d.withSession(loaded.Dump, func(s *Session) error {
+		*s = session
+		reply.Session = copySession(s)
+		return nil
+	})
+}
+
+// Calls f with the session of a loaded dump, while no other request can
+// use the dump.
+func (d *Daemon) withSession(path string, f func(s *Session) error) error {
+	d.mutex.Lock()
+	dump, loaded := d.dumps[path]
+	d.mutex.Unlock()
+	if !loaded {
+		return fmt.Errorf("Dump '%s' is not loaded", path)
+	}
+	dump.mutex.Lock()
+	defer dump.mutex.Unlock()
+	if dump.err != nil {
+		return dump.err
+	}
+	if len(dump.session.Dumpfile) == 0 {
+		dump.session.Dumpfile = path
+	}
+	return f(&dump.session)
+}
+
+// Copies a session, so that it can be used once the dump is unlocked.
+func copySession(s *Session) Session {
+	return Session{
+		Dumpfile:  s.Dumpfile,
+		Bookmarks: append(make([]Bookmark, 0, len(s.Bookmarks)), s.Bookmarks...),
+		Notes:     append(make([]Note, 0, len(s.Notes)), s.Notes...),
+		Queries:   append(make([]Query, 0, len(s.Queries)), s.Queries...),
+	}
+}
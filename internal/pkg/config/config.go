@@ -4,64 +4,408 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/adamroach/heapspurs/pkg/units"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Dumpfile string
-	Output   string
-	Oid      string
-	Program  string
-	Address  uint64
-	Children bool
-	Print    bool
-	Find     string
-	Hexdump  bool
-	Anchors  bool
-	Owners   int
-	MakeDump string
+	Command        string
+	Script         string   `mapstructure:"-"`
+	Budgets        string   `mapstructure:"-"`
+	Inventory      string   `mapstructure:"-"`
+	Shell          string   `mapstructure:"-"`
+	Tracked        []string `mapstructure:"-"`
+	SQLite         string   `mapstructure:"sqlite"`
+	Metrics        string
+	MetricsFormat  string `mapstructure:"metrics-format"`
+	Dumpfile       string
+	Output         string
+	Oid            string
+	Program        string
+	AddressSpec    string `mapstructure:"address"`
+	Address        uint64 `mapstructure:"-"`
+	MaxMatches     int    `mapstructure:"max-matches"`
+	Children       bool
+	Print          bool
+	Find           string
+	Sort           string
+	Aggregate      bool
+	Hexdump        bool
+	Context        uint64
+	Anchors        bool
+	Owners         int
+	OwnersOrder    string `mapstructure:"owners-order"`
+	OwnersPerPath  bool   `mapstructure:"owners-per-path"`
+	MaxPaths       int    `mapstructure:"max-paths"`
+	MaxDepth       int    `mapstructure:"max-depth"`
+	MakeDump       string
+	SelfDebug      string `mapstructure:"self-debug"`
+	PrintStats     bool   `mapstructure:"print-stats"`
+	PointerMask    uint64 `mapstructure:"pointer-mask"`
+	PointerAlign   uint64 `mapstructure:"pointer-align"`
+	Mmap           bool
+	MaxObjectSpec  string `mapstructure:"max-object-size"`
+	MaxObjectSize  uint64 `mapstructure:"-"`
+	Lenient        bool
+	SI             bool
+	Timeout        time.Duration
+	MemoryLimit    string `mapstructure:"memory-limit"`
+	NoIntern       bool   `mapstructure:"no-intern"`
+	TopOwners      int    `mapstructure:"top-owners"`
+	Json           bool
+	Neighborhood   int
+	Goroutine      uint64
+	OwnersOfType   string `mapstructure:"owners-of-type"`
+	Channels       bool
+	Pools          bool
+	SlackSlices    bool `mapstructure:"slack-slices"`
+	NamingConflict bool `mapstructure:"naming-conflicts"`
+	Entropy        bool
+	StackStats     bool   `mapstructure:"stack-stats"`
+	Defers         bool   `mapstructure:"defers"`
+	Hubs           bool   `mapstructure:"hubs"`
+	RetainedSet    string `mapstructure:"retained-set"`
+	Retainers      bool
+	Breaks         bool
+	PathMatches    string `mapstructure:"path-matches"`
+	Retained       bool
+	Exact          bool
+	FullNames      bool   `mapstructure:"full-names"`
+	LinkFormat     string `mapstructure:"link-format"`
+	Chains         int
+	Fan            bool
+	MinFanIn       int `mapstructure:"min-fanin"`
+	MinFanOut      int `mapstructure:"min-fanout"`
+	Implements     string
+	Unknown        bool
+	OffHeap        bool   `mapstructure:"off-heap"`
+	SizeClasses    bool   `mapstructure:"size-classes"`
+	InlineFields   int    `mapstructure:"inline-fields"`
+	HideUnknown    bool   `mapstructure:"hide-unknown"`
+	CollapseTypes  bool   `mapstructure:"collapse-types"`
+	GraphType      string `mapstructure:"graph-type"`
+	MinEdgeWeight  int    `mapstructure:"min-edge-weight"`
+	Annotations    string
+	Tags           bool
+	Diff           string
+	Compare        string
+	Listen         string
+	Persists       []string
+	Type           string
+	Verbose        bool
+	Quiet          bool
+	DPI            float64
+	Size           string
+	Page           string
+	Layout         string
+	RankDir        string `mapstructure:"rankdir"`
+	Format         string
+	Prune          []string
+	WeakTypes      []string `mapstructure:"weak-types"`
+	WeakFinalizers bool     `mapstructure:"weak-finalizers"`
+	ConfigFile     string   `mapstructure:"config"`
+	Aliases        map[string]string
 }
 
+// The commands that can come before the dump file; aliases can't take
+// their names.
+var Commands = []string{"info", "export", "report", "at", "run", "budget", "inventory", "track", "serve", "daemon", "completion"}
+
 func Initialize() (*Config, error) {
 
 	flag.String("dumpfile", "", "Heap dump file to read")
 	flag.String("output", "heapdump.svg", "Output file")
 	flag.String("oid", "", "File that maps from OIDs to object names")
-	flag.String("program", "", "File to read symbol information from")
-	flag.Int("address", 0, "Address of object to analyze")
+	flag.String("program", "", "File to read symbol information from; \"auto\" looks for the program that wrote the dump next to it, in the current directory, and in PATH and Go's bin directories")
+	flag.String("address", "", "Address of object to analyze; may be an expression like '0xc000123456+0x40' or 'sym:main.cache', 'match:' followed by a regular expression for every object or global whose name matches, or 'biggest:' or 'biggest-retained:' followed by one for the largest matching object, or the one that retains the most")
+	flag.Int("max-matches", 20, "The most objects and globals that a 'match:' address is resolved to; zero means no limit")
 	// flag.Bool("children", false, "If set, will show children rather than parents")
 	flag.Bool("print", false, "If set, will list all dumpfile records and exit")
 	flag.String("find", "", "Finds an object whose name matches the specified regular expression")
+	flag.String("sort", "", "Order in which --find lists objects: \"size\" lists the largest first, \"address\" by address, and \"name\" by name; by default, they're listed in the order they're in in the dump")
+	flag.Bool("aggregate", false, "If set, --find prints the number and total size of the matching objects of each type, largest total first (or in the order given by --sort), rather than each object")
 	flag.Bool("hexdump", false, "If set, will print a hexdump of the specified object and exit")
+	flag.Uint64("context", 0, "If positive, --hexdump only shows the lines within this many bytes of the address, which may point into the middle of a record")
 	flag.Bool("anchors", false, "If set, will print a list of the anchors keeping the indicated object alive")
 	flag.Int("owners", 0, "If positive, will print the owners of the specified object to the depth indicated, and exit; if negative, will print owners to their full depth")
+	flag.String("owners-order", "dfs", "Order in which --owners visits owners: \"dfs\" follows each owner all the way before the next; \"bfs\" visits them level by level, so each is shown at its shortest distance")
+	flag.Bool("owners-per-path", false, "If set, --owners only stops at records already on the path being printed, rather than at any record already printed; this shows every path, and can produce a lot of output")
+	flag.Int("max-paths", 0, "If positive, --anchors will stop after printing this many anchors, and report how many paths it didn't follow")
+	flag.Int("max-depth", 0, "If positive, --anchors will only follow this many hops of owners looking for anchors, and report how many paths it didn't follow")
+	flag.Bool("hubs", false, "If set, --anchors will group the anchors under the records that many of their shortest paths to the object go through, with what each of those retains")
+	flag.String("config", "", "Configuration file to read defaults from (default is .heapspurs.yaml in the current or home directory)")
+	flag.String("format", "svg", "Output format: svg, png, jpg, or dot for graphs (other Graphviz formats, such as pdf or ps, require the 'dot' command); csv for an edge list of the whole heap; jsongraph for the whole heap as JSON, with retained sizes and distances from anchors; sankey for an HTML diagram of how the heap's memory flows from the ways it's kept alive to the types that use it; neo4j for a directory of CSV files suitable for neo4j-admin import; a format with a heapspurs-render-<format> program in PATH is rendered by that program instead")
+	flag.Float64("dpi", 0, "Resolution of rendered graphs, in dots per inch")
+	flag.String("size", "", "Maximum size of rendered graphs, in inches (e.g., '8.5,11'); add '!' to scale smaller graphs up to this size")
+	flag.String("page", "", "Page size for rendered graphs, in inches (e.g., '8.5,11'); large graphs are split across pages in formats that support it, such as ps")
+	flag.String("layout", "dot", "Graphviz layout engine for rendered graphs: \"dot\" draws owners above what they own; \"neato\", \"fdp\", and \"sfdp\" place nodes by simulating springs between them, and sfdp copes far better with graphs of thousands of nodes")
+	flag.String("rankdir", "TB", "Direction that dot lays out rendered graphs in, from owners to what they own: \"TB\" (top to bottom), \"LR\", \"BT\", or \"RL\"")
+	// Regular expressions can have commas in them, so these are given once
+	// for each expression rather than as comma-separated lists
+	pflag.StringArray("prune", nil, "Regular expression; graphs won't follow the owners of objects with matching names. May be given more than once")
+	pflag.StringArray("weak-types", nil, "Regular expression; objects with matching names (e.g., caches that drop entries under memory pressure) aren't counted as retaining what they point to. May be given more than once")
+	flag.Bool("weak-finalizers", false, "If set, objects reachable only from the finalizer queue aren't counted as retained")
+	flag.String("sqlite", "", "With the export command: the SQLite database to write the dump into (requires the 'sqlite3' command), or '-' to write SQL statements to stdout")
+	flag.String("metrics", "", "With the export command: the file to write gauges of the objects, bytes, and retained bytes of each type into, or '-' for stdout")
+	flag.String("metrics-format", "prometheus", "Format of --metrics: \"prometheus\" for the text format read by the node exporter's textfile collector, or \"otlp\" for OpenTelemetry metrics in JSON")
 	flag.String("makedump", "", "For debugging and examples: dump heapspurs' heap")
+	flag.String("self-debug", "", "If set, will write CPU and heap profiles, a heap dump, and phase timings of heapspurs' own run to this directory")
+	flag.Bool("print-stats", false, "If set, will print how many records of each type were read from the dump, how many bytes they took up, and how long they took to parse")
+	flag.Int("top-owners", 0, "If positive, will print the specified number of owners that retain the most memory, and exit")
+	flag.Int("neighborhood", 0, "If positive, the graph will show only the specified number of hops of owners and children around the object")
+	flag.Int("chains", 0, "If positive, will print chains of same-shaped objects (e.g., linked lists) at least this long, and exit")
+	flag.Bool("fan", false, "If set, will print how the number of pointers into and out of each object is distributed, and exit")
+	flag.Int("min-fanin", 0, "If positive, --fan also lists the objects with at least this many pointers into them (e.g., global caches)")
+	flag.Int("min-fanout", 0, "If positive, --fan also lists the objects with at least this many pointers out of them (e.g., huge arrays)")
+	flag.String("implements", "", "If set, will print the objects held in interface values of this type (e.g., 'io.Closer') and the memory they retain, and exit; requires --program")
+	flag.Bool("unknown", false, "If set, will summarize the pointers to addresses that aren't in any record of the dump (shown as \"???\" in graphs), and exit")
+	flag.Bool("off-heap", false, "If set, will list the fields holding pointers to memory outside of the heap and the data and BSS segments (e.g., memory allocated by C code), grouped by owner type, and exit; best with --program")
+	flag.Bool("hide-unknown", false, "If set, graphs will leave out pointers to addresses that aren't in any record of the dump")
+	flag.Bool("size-classes", false, "If set, will print the types that lose the most memory to allocations being rounded up to a size class, and exit; requires --oid and --program")
+	flag.Int("inline-fields", 0, "If positive, will print the specified number of struct types that use the most memory, with how their bytes divide up among the structs and arrays embedded in them, padding, and size class rounding, and exit; requires --oid and --program")
+	flag.Bool("collapse-types", false, "If set, graphs will merge all records of each type into one node, and all pointers between two types into one edge; without --address, the whole heap is graphed this way")
+	flag.String("graph-type", "", "If set, the graph will show every path from an anchor to any object whose name matches this regular expression, collapsed by type as with --collapse-types")
+	flag.Uint64("goroutine", 0, "If set, the graph will show the stack frames of the goroutine with this ID as a chain, with the records each frame points to beside it; --neighborhood sets how many hops of those are shown (default 1)")
+	flag.String("owners-of-type", "", "If set, will summarize what owns the objects whose names match this regular expression, following only paths from the stack of the --goroutine, if one is given, and exit")
+	flag.Int("min-edge-weight", 0, "With --collapse-types, graphs will leave out edges that stand for fewer than this many pointers")
+	flag.Bool("channels", false, "If set, will print every channel with its length, capacity, element type, and the memory it retains, and exit")
+	flag.Bool("pools", false, "If set, will list the sync.Pools in the heap with the memory each keeps cached for reuse, and exit")
+	flag.Bool("slack-slices", false, "If set, will list the slices whose capacity is far beyond their length, with the memory left unused in their backing arrays, and exit; requires --program")
+	flag.Bool("naming-conflicts", false, "If set, will list the objects whose OIDs, the itabs of interface values pointing to them, and the declared types of pointers to them disagree about their types, with how confident each is, and exit; requires --program")
+	flag.Bool("entropy", false, "If set, will list the big objects that are mostly zeros, and the types whose objects hold the most zero bytes, with the entropy of their contents, and exit")
+	flag.Bool("stack-stats", false, "If set, will print a summary of goroutine stack depths and frame sizes, and exit")
+	flag.Bool("defers", false, "If set, will list the pending defers and panics in progress of each goroutine, with the memory they hold on to, and exit")
+	flag.String("retained-set", "", "Address of an object; will list everything that would be freed if it were (as CSV, with --format csv), and exit")
+	flag.Bool("retainers", false, "If set, will explain which anchors and owners keep the specified object alive, and whether they share it, and exit")
+	flag.Bool("retained", false, "If set, will print how much memory the specified object retains, estimated by sampling what it can reach so that it's quick on huge heaps, and exit")
+	flag.Bool("exact", false, "If set, --retained (and the retained command of scripts and the daemon) computes what an object retains exactly from the dominator tree of the whole heap, rather than estimating it")
+	flag.String("path-matches", "", "If set, will print the paths from anchors to the specified object whose record names, joined by '->', match this regular expression (e.g., 'StackFrame.*->.*Cache->.*Session'), or, if it starts with '!', don't match the rest of it, and exit")
+	flag.Bool("breaks", false, "If set, will list the fewest references that would have to be broken to free the specified object, with where each is declared, and exit")
+	flag.String("annotations", "", "File of tags for records: each line is a tag followed by regular expressions matching object names, or addresses; tagged objects are colored by tag in graphs")
+	flag.Bool("tags", false, "If set, will print how many objects have each tag in the --annotations file, with the memory they use and retain, and exit")
+	flag.String("diff", "", "If set, will compare the contents of the specified object against the same object in this other dump file, and exit")
+	flag.String("compare", "", "If set, will compare how many objects of each type there are, and how much memory they use, against this other dump file, and exit")
+	flag.String("type", "", "With the track command: regular expression for the names of the objects to follow across the dumps")
+	flag.String("persists", "", "Comma-separated other dump files; will report whether the specified object can be found, unchanged, in each of them (or, with no --address, summarize by type the objects found unchanged in all of them), and exit")
+	flag.String("listen", "heapspurs.sock", "With the daemon command: the Unix socket to serve JSON-RPC requests on, or a host:port to serve them over TCP; with the serve command, the host:port to serve the explorer on; the serve command uses localhost:7070 unless --listen is given")
+	flag.Bool("verbose", false, "If set, will log debugging details about how the dump is parsed")
+	flag.Bool("quiet", false, "If set, will only log warnings and errors")
+	flag.Bool("json", false, "If set, will produce JSON output for commands that support it")
+	flag.String("max-object-size", "", "If set, dumps containing any object larger than this size (e.g., \"64MiB\") are treated as corrupt; otherwise, objects are only limited by the size of the dump file")
+	flag.Bool("si", false, "If set, sizes are shown in powers of 1000 (kB, MB, GB) rather than powers of 1024 (kiB, MiB, GiB)")
+	flag.Bool("lenient", false, "If set, will skip records of unknown types (as from a newer Go) rather than stopping, and report how many were skipped")
+	flag.Duration("timeout", 0, "If positive, will stop the analysis with exit status 3 if it takes longer than this (e.g., \"5m\"); the daemon and the serve command aren't limited")
+	flag.String("memory-limit", "", "If set, will keep heapspurs' memory use under this size (e.g., \"4GiB\"), collecting garbage harder as it's approached, and stop the analysis with exit status 3 if it can't")
+	flag.Uint64("pointer-mask", 0, "Bits to clear from every pointer before using it, for pointers with tags in them (e.g., 0x7 for tags in the low three bits)")
+	flag.Uint64("pointer-align", 0, "If greater than one, every pointer is rounded down to a multiple of this before being used")
+	flag.Bool("full-names", false, "If set, will show type and function names in full, rather than shortening import paths and long generic type arguments")
+	flag.String("link-format", "", "Template for source locations in reports, using {file} and {line} (e.g., 'vscode://file{file}:{line}'); when writing a URL to a terminal, locations are shown as clickable file:line links")
+	flag.Bool("no-intern", false, "If set, records with the same contents or names won't share a single copy of them; this makes reading the dump a little faster, but can use a lot more memory")
+	flag.Bool("mmap", false, "If set, will memory-map the dump file instead of reading it through a buffer")
 
 	v := viper.New()
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.CommandLine.MarkHidden("dumpfile")
 	pflag.CommandLine.MarkHidden("makedump")
 	pflag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s [dumpfile]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s [info | export | report | at address | run script.hsp | budget check | inventory check | track | serve | daemon | completion shell | alias] [dumpfile...] [budgets.yaml | inventory.yaml]\n", os.Args[0])
 		pflag.PrintDefaults()
 	}
 	pflag.Parse()
 	v.BindPFlags(pflag.CommandLine)
 
+	// Flags take precedence over values in the configuration file, which
+	// in turn take precedence over the flag defaults.
+	configFile, _ := pflag.CommandLine.GetString("config")
+	if len(configFile) > 0 {
+		v.SetConfigFile(configFile)
+	} else {
+		v.SetConfigName(".heapspurs")
+		v.SetConfigType("yaml")
+		v.AddConfigPath(".")
+		home, err := os.UserHomeDir()
+		if err == nil {
+			v.AddConfigPath(home)
+		}
+	}
+	err := v.ReadInConfig()
+	if err != nil {
+		_, notFound := err.(viper.ConfigFileNotFoundError)
+		if !notFound {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	// An alias from the configuration file stands for the arguments it's
+	// defined as, which go first, so that anything else on the command
+	// line overrides them.
+	err = expandAlias(v)
+	if err != nil {
+		return nil, err
+	}
+
 	conf := &Config{}
-	err := v.Unmarshal(conf)
+	err = v.Unmarshal(conf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if len(conf.MaxObjectSpec) > 0 {
+		conf.MaxObjectSize, err = units.ParseSize(conf.MaxObjectSpec)
+		if err != nil {
+			return nil, fmt.Errorf("bad --max-object-size: %w", err)
+		}
+	}
+
+	if conf.Output == "heapdump.svg" && conf.Format == "jsongraph" {
+		conf.Output = "heapdump.json"
+	} else if conf.Output == "heapdump.svg" && conf.Format == "sankey" {
+		conf.Output = "heapdump.html"
+	} else if conf.Output == "heapdump.svg" && conf.Format != "svg" {
+		conf.Output = "heapdump." + conf.Format
+	}
+
 	args := pflag.Args()
+	if len(args) > 1 && args[0] == "info" {
+		conf.Command = args[0]
+		args = args[1:]
+	} else if len(args) > 1 && args[0] == "export" {
+		conf.Command = args[0]
+		args = args[1:]
+	} else if len(args) > 1 && args[0] == "report" {
+		conf.Command = args[0]
+		args = args[1:]
+	} else if len(args) > 1 && args[0] == "serve" {
+		conf.Command = args[0]
+		args = args[1:]
+		// A browser can't connect to a Unix socket
+		if conf.Listen == "heapspurs.sock" {
+			conf.Listen = "localhost:7070"
+		}
+	} else if len(args) > 2 && args[0] == "at" {
+		conf.Command = args[0]
+		conf.AddressSpec = args[1]
+		args = args[2:]
+	} else if len(args) > 2 && args[0] == "run" {
+		conf.Command = args[0]
+		conf.Script = args[1]
+		args = args[2:]
+	} else if len(args) > 3 && args[0] == "budget" && args[1] == "check" {
+		conf.Command = args[0]
+		conf.Budgets = args[3]
+		args = args[2:3]
+	} else if len(args) > 3 && args[0] == "inventory" && args[1] == "check" {
+		conf.Command = args[0]
+		conf.Inventory = args[3]
+		args = args[2:3]
+	} else if len(args) > 2 && args[0] == "track" {
+		// Every dump after the first is read by the track command itself
+		conf.Command = args[0]
+		conf.Tracked = args[2:]
+		args = args[1:2]
+	} else if len(args) > 1 && args[0] == "completion" {
+		// Completion scripts don't need a dump, either
+		conf.Command = args[0]
+		conf.Shell = args[1]
+		return conf, nil
+	} else if len(args) > 0 && args[0] == "daemon" {
+		// The daemon loads dumps when it's asked to, so it doesn't need one
+		// to start with
+		conf.Command = args[0]
+		args = args[1:]
+	} else if len(args) > 0 && slices.Contains(Commands, args[0]) {
+		// A command without the arguments it needs, which would otherwise
+		// be taken for the name of the dump
+		pflag.Usage()
+		os.Exit(-1)
+	}
 	if len(args) > 0 {
 		conf.Dumpfile = args[0]
-	} else if len(conf.Dumpfile) == 0 {
+	} else if len(conf.Dumpfile) == 0 && conf.Command != "daemon" {
 		pflag.Usage()
 		os.Exit(-1)
 	}
 	return conf, nil
 }
+
+// Replaces an alias at the start of the command line with what the
+// configuration file defines it as, and parses the flags again. Aliases are
+// split on spaces, without quoting, as lines of scripts are; viper folds
+// their names to lower case.
+func expandAlias(v *viper.Viper) error {
+	args := pflag.Args()
+	if len(args) == 0 {
+		return nil
+	}
+	aliases := v.GetStringMapString("aliases")
+	for name := range aliases {
+		if slices.Contains(Commands, name) {
+			return fmt.Errorf("alias '%s' has the same name as a command", name)
+		}
+	}
+	expansion, found := aliases[strings.ToLower(args[0])]
+	if !found {
+		return nil
+	}
+	expanded := strings.Fields(expansion)
+	if len(expanded) == 0 {
+		return fmt.Errorf("alias '%s' is empty", args[0])
+	}
+
+	// The flags are parsed again from the original arguments rather than
+	// from their parsed values, which don't always read back the way they
+	// were given; flags such as --prune add to their values each time
+	// they're set, so they're put back to their defaults first.
+	var err error
+	pflag.CommandLine.Visit(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			err = s.Replace(nil)
+		} else {
+			err = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+	if err != nil {
+		return err
+	}
+	return pflag.CommandLine.Parse(aliasArgs(pflag.CommandLine, os.Args[1:], expanded))
+}
+
+// Returns raw with its first argument that is neither a flag nor a flag's
+// value removed, and expanded put ahead of the rest, so that the flags that
+// follow override the ones in expanded, or add to them.
+func aliasArgs(flags *pflag.FlagSet, raw []string, expanded []string) []string {
+	for i := 0; i < len(raw); i++ {
+		arg := raw[i]
+		if arg == "--" {
+			if i+1 < len(raw) {
+				return slices.Concat(expanded, raw[:i+1], raw[i+2:])
+			}
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return slices.Concat(expanded, raw[:i], raw[i+1:])
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := flags.Lookup(name)
+		if f == nil && len(name) == 1 {
+			f = flags.ShorthandLookup(name)
+		}
+		if f != nil && len(f.NoOptDefVal) == 0 {
+			// The flag's value is the next argument
+			i++
+		}
+	}
+	return slices.Concat(expanded, raw)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestAliasArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Int("top-owners", 0, "")
+	flags.Bool("mmap", false, "")
+	prune := flags.StringArray("prune", nil, "")
+
+	raw := []string{"--mmap", "--prune", "Foo", "leaks", "--prune", "a{1,2}", "dump"}
+	expanded := []string{"--top-owners", "20", "--prune", `^runtime\.`}
+	err := flags.Parse(aliasArgs(flags, raw, expanded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`^runtime\.`, "Foo", "a{1,2}"}
+	if !slices.Equal(*prune, want) {
+		t.Errorf("prune is %q, want %q", *prune, want)
+	}
+	if !slices.Equal(flags.Args(), []string{"dump"}) {
+		t.Errorf("arguments are %q, want [dump]", flags.Args())
+	}
+	if n, _ := flags.GetInt("top-owners"); n != 20 {
+		t.Errorf("top-owners is %d, want 20", n)
+	}
+}
+
+func TestAliasArgsRepeatedPrune(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	prune := flags.StringArray("prune", nil, "")
+	weak := flags.StringArray("weak-types", nil, "")
+
+	// Commas are part of each expression, whether the value follows an
+	// equals sign or is the next argument
+	raw := []string{"--prune=x{1,3}", "leaks", "--prune", "y{2,}", "--weak-types", "Cache[int,string]", "dump"}
+	expanded := []string{"--prune", "a{1,2}", "--prune", `^runtime\.`}
+	err := flags.Parse(aliasArgs(flags, raw, expanded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a{1,2}", `^runtime\.`, "x{1,3}", "y{2,}"}
+	if !slices.Equal(*prune, want) {
+		t.Errorf("prune is %q, want %q", *prune, want)
+	}
+	if !slices.Equal(*weak, []string{"Cache[int,string]"}) {
+		t.Errorf("weak-types is %q, want [Cache[int,string]]", *weak)
+	}
+	if !slices.Equal(flags.Args(), []string{"dump"}) {
+		t.Errorf("arguments are %q, want [dump]", flags.Args())
+	}
+}
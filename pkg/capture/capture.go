@@ -0,0 +1,143 @@
+// Package capture writes heap dumps from inside the program being debugged,
+// along with what heapspurs needs to know about the program that wrote
+// them. It only depends on the standard library, so it adds little to the
+// program that imports it.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// What's known about the program that wrote a dump, and when. It's kept
+// next to the dump in a sidecar file (see MetadataPath), which heapspurs
+// reads automatically.
+type Metadata struct {
+	GoVersion  string    `json:"go_version"`           // e.g., "go1.22.3"
+	GOOS       string    `json:"goos"`                 // e.g., "linux"
+	GOARCH     string    `json:"goarch"`               // e.g., "amd64"
+	Path       string    `json:"path,omitempty"`       // the main package's import path
+	Module     string    `json:"module,omitempty"`     // the main module's path
+	Version    string    `json:"version,omitempty"`    // the main module's version, e.g., "(devel)"
+	Revision   string    `json:"revision,omitempty"`   // the commit the program was built from, if known
+	Modified   bool      `json:"modified,omitempty"`   // whether the working tree had uncommitted changes
+	Executable string    `json:"executable,omitempty"` // where the program was run from
+	Hostname   string    `json:"hostname,omitempty"`
+	Pid        int       `json:"pid"`
+	Time       time.Time `json:"time"` // when the dump was written
+}
+
+// Returns the name of the sidecar file that holds the metadata of the
+// indicated dump.
+func MetadataPath(dumpfile string) string {
+	return dumpfile + ".meta.json"
+}
+
+// Forces a garbage collection, so that the dump only holds what's actually
+// reachable, and writes a heap dump to the indicated file, with the metadata
+// of the running program next to it.
+func WriteHeapDump(path string) error {
+	metadata := CurrentMetadata()
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Create '%s': %w", path, err)
+	}
+	runtime.GC()
+	debug.WriteHeapDump(f.Fd())
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("Write '%s': %w", path, err)
+	}
+	return WriteMetadata(path, metadata)
+}
+
+// Returns the metadata of the running program, as of now.
+func CurrentMetadata() *Metadata {
+	m := &Metadata{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		Pid:       os.Getpid(),
+		Time:      time.Now(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		m.Path = info.Path
+		m.Module = info.Main.Path
+		m.Version = info.Main.Version
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				m.Revision = setting.Value
+			case "vcs.modified":
+				m.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if executable, err := os.Executable(); err == nil {
+		m.Executable = executable
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		m.Hostname = hostname
+	}
+	return m
+}
+
+// Writes the sidecar file of the indicated dump.
+func WriteMetadata(dumpfile string, m *Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := MetadataPath(dumpfile)
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("Write '%s': %w", path, err)
+	}
+	return nil
+}
+
+// Reads the sidecar file of the indicated dump. Dumps that weren't written
+// by WriteHeapDump don't have one, in which case this returns nil and no
+// error.
+func ReadMetadata(dumpfile string) (*Metadata, error) {
+	path := MetadataPath(dumpfile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Read '%s': %w", path, err)
+	}
+	m := &Metadata{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("Bad metadata file '%s': %w", path, err)
+	}
+	return m, nil
+}
+
+// Describes the program and when it wrote the dump, in a line or two.
+func (m *Metadata) String() string {
+	s := fmt.Sprintf("Written by %s", m.Path)
+	if len(m.Path) == 0 {
+		s = "Written by a program"
+	}
+	if len(m.Revision) > 0 {
+		s += fmt.Sprintf(" at revision %s", m.Revision)
+		if m.Modified {
+			s += " (modified)"
+		}
+	} else if len(m.Version) > 0 && m.Version != "(devel)" {
+		s += " " + m.Version
+	}
+	s += fmt.Sprintf(", built with %s for %s/%s\n", m.GoVersion, m.GOOS, m.GOARCH)
+	s += fmt.Sprintf("Dumped %s", m.Time.Format(time.RFC3339))
+	if len(m.Hostname) > 0 {
+		s += " on " + m.Hostname
+	}
+	return s + fmt.Sprintf(" by process %d\n", m.Pid)
+}
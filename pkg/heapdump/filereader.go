@@ -0,0 +1,72 @@
+package heapdump
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// FileReader reads a heap dump through a buffer, keeping track of how much
+// of the file remains so that implausible lengths in the dump can be
+// rejected before they are allocated.
+type FileReader struct {
+	file      *os.File
+	reader    *bufio.Reader
+	remaining uint64
+	offset    uint64
+}
+
+func NewFileReader(file *os.File) (*FileReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{file: file, reader: bufio.NewReader(file), remaining: uint64(info.Size())}, nil
+}
+
+func (f *FileReader) Read(p []byte) (int, error) {
+	n, err := f.reader.Read(p)
+	f.consume(uint64(n))
+	return n, err
+}
+
+func (f *FileReader) ReadByte() (byte, error) {
+	b, err := f.reader.ReadByte()
+	if err == nil {
+		f.consume(1)
+	}
+	return b, err
+}
+
+// Returns the number of bytes of the file that have not yet been read.
+func (f *FileReader) Remaining() uint64 {
+	return f.remaining
+}
+
+// Returns how far into the file the next byte will be read from.
+func (f *FileReader) Offset() uint64 {
+	return f.offset
+}
+
+// Moves to the indicated offset in the file, which has to be a regular
+// file rather than a pipe.
+func (f *FileReader) Seek(offset uint64) error {
+	_, err := f.file.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	f.reader.Reset(f.file)
+	f.remaining += f.offset
+	f.remaining -= min(offset, f.remaining)
+	f.offset = offset
+	return nil
+}
+
+func (f *FileReader) consume(n uint64) {
+	f.offset += n
+	// The file may have grown since we looked at its size
+	if n > f.remaining {
+		n = f.remaining
+	}
+	f.remaining -= n
+}
@@ -0,0 +1,143 @@
+package heapdump
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Position-independent executables are loaded at a multiple of the smallest
+// page size of any platform Go runs on
+const loadAlignment = 4096
+
+// What a dump says about the program that wrote it: where its data and BSS
+// segments are, and which version of Go it was built with. Unless two
+// programs were built from the same source, it's very unlikely that they
+// have the same segments.
+type programFingerprint struct {
+	data    [2]uint64 // address and size
+	bss     [2]uint64
+	version string
+}
+
+// Looks through the executables in the indicated directories for the
+// program that wrote the dump: one whose data and BSS sections are where
+// the dump's segments are, and that was built with the same version of Go.
+// Directories are searched in order, and the first match is returned.
+//
+// Only programs that ran at the addresses they were linked at can match.
+// Position-independent executables (the default on macOS, and with
+// -buildmode=pie) are loaded somewhere else on every run, and neither their
+// symbols nor their images (see ReadProgram) can be placed in the dump
+// without knowing where. One whose sections match the dump's segments
+// apart from being moved, by a whole number of pages, is reported in the
+// error instead, so that it can be rebuilt.
+func FindProgram(reader Reader, dirs []string) (string, error) {
+	fp, err := readProgramFingerprint(reader)
+	if err != nil {
+		return "", err
+	}
+	seen := make(map[string]bool)
+	moved := ""
+	for _, dir := range dirs {
+		if len(dir) == 0 || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			filename := filepath.Join(dir, entry.Name())
+			match, exact := fp.matches(filename)
+			if match && exact {
+				return filename, nil
+			}
+			if match && len(moved) == 0 {
+				moved = filename
+			}
+		}
+	}
+	if len(moved) > 0 {
+		return "", fmt.Errorf("Could not use '%s', which looks like the program that wrote the dump, since it's position independent; rebuild it with -buildmode=exe", moved)
+	}
+	return "", fmt.Errorf("Could not find the program that wrote the dump")
+}
+
+func readProgramFingerprint(reader Reader) (*programFingerprint, error) {
+	err := ReadHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Reading header: %w\n", err)
+	}
+	fp := &programFingerprint{}
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch r := record.(type) {
+		case *DumpParams:
+			fp.version = r.GoExperiment
+		case *DataSegment:
+			fp.data = [2]uint64{r.Address, uint64(len(r.Contents))}
+		case *BssSegment:
+			fp.bss = [2]uint64{r.Address, uint64(len(r.Contents))}
+		case *Eof:
+			if fp.data[0] == 0 && fp.bss[0] == 0 {
+				return nil, fmt.Errorf("The dump has no data or BSS segment to identify its program by")
+			}
+			return fp, nil
+		}
+	}
+}
+
+// Reports whether the program's data and BSS sections are the size of the
+// dump's segments, the same distance apart, and moved from them by a whole
+// number of pages, if at all; and whether they're exactly where the
+// segments are.
+func (fp *programFingerprint) matches(filename string) (match bool, exact bool) {
+	var data, bss [2]uint64
+	if f, err := elf.Open(filename); err == nil {
+		defer f.Close()
+		if s := f.Section(".data"); s != nil {
+			data = [2]uint64{s.Addr, s.Size}
+		}
+		if s := f.Section(".bss"); s != nil {
+			bss = [2]uint64{s.Addr, s.Size}
+		}
+	} else if f, err := macho.Open(filename); err == nil {
+		defer f.Close()
+		if s := f.Section("__data"); s != nil {
+			data = [2]uint64{s.Addr, s.Size}
+		}
+		if s := f.Section("__bss"); s != nil {
+			bss = [2]uint64{s.Addr, s.Size}
+		}
+	} else {
+		return false, false
+	}
+	if data[1] != fp.data[1] || bss[1] != fp.bss[1] || bss[0]-data[0] != fp.bss[0]-fp.data[0] {
+		return false, false
+	}
+	if slide := fp.data[0] - data[0]; slide%loadAlignment != 0 {
+		return false, false
+	}
+
+	// Older dumps don't record the Go version, and older programs don't
+	// record their build info, so this is only checked if both do.
+	if goVersion.MatchString(fp.version) {
+		info, err := buildinfo.ReadFile(filename)
+		if err == nil && info.GoVersion != fp.version {
+			return false, false
+		}
+	}
+	return true, data == fp.data && bss == fp.bss
+}
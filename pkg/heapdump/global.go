@@ -0,0 +1,82 @@
+package heapdump
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A global variable: the part of a data or BSS segment from one symbol to
+// the next. Globals aren't records in the dump; SplitSegment carves them
+// out of a segment, so that what a program's globals point to can be
+// attributed to the variable holding it, rather than to a segment with
+// thousands of pointers.
+type Global struct {
+	Name     string  // the variable's symbol; empty for the part of a segment before its first symbol
+	Address  uint64  // address of the start of the variable
+	Contents []byte  // the variable's part of the segment's contents
+	Fields   []Field // pointer-containing fields in the variable, at offsets from its start
+	Segment  Owner   // the DataSegment or BssSegment the variable is in
+}
+
+func (r *Global) GetAddress() uint64 {
+	return r.Address
+}
+
+func (r *Global) GetContents() []byte {
+	return r.Contents
+}
+
+func (r *Global) GetFields() []Field {
+	return r.Fields
+}
+
+func (r *Global) String() string {
+	name := r.Name
+	if len(name) == 0 {
+		name = "(unnamed)"
+	}
+	return fmt.Sprintf("Global %s @ 0x%x with %d pointers in %d bytes", name, r.Address, len(r.Fields), len(r.Contents))
+}
+
+// Globals are made by SplitSegment, never read from a dump.
+func (r *Global) Read(reader Reader) error {
+	return fmt.Errorf("Globals are not records in the dump")
+}
+
+// Splits a data or BSS segment into the global variables in it, at the
+// addresses of the names in the indicated symbol table. The globals cover
+// the whole segment, and each pointer in it belongs to exactly one of them.
+// Returns nil if the table doesn't have any names in the segment (such as
+// when the program's symbols haven't been read).
+func SplitSegment(segment Owner, t *SymbolTable) []*Global {
+	start := segment.GetAddress()
+	contents := segment.GetContents()
+	starts := t.NamesBetween(start, start+uint64(len(contents)))
+	if len(starts) == 0 {
+		return nil
+	}
+	if starts[0] != start {
+		starts = append([]uint64{start}, starts...)
+	}
+
+	globals := make([]*Global, len(starts))
+	for i, address := range starts {
+		end := start + uint64(len(contents))
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		globals[i] = &Global{
+			Name:     t.GetName(address),
+			Address:  address,
+			Contents: contents[address-start : end-start],
+			Fields:   make([]Field, 0),
+			Segment:  segment,
+		}
+	}
+	for _, field := range segment.GetFields() {
+		address := start + field.Offset
+		i := sort.Search(len(globals), func(i int) bool { return globals[i].Address > address }) - 1
+		globals[i].Fields = append(globals[i].Fields, Field{Kind: field.Kind, Offset: address - globals[i].Address})
+	}
+	return globals
+}
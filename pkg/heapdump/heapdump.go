@@ -3,15 +3,24 @@ package heapdump
 // See https://github.com/golang/go/wiki/heapdump15-through-heapdump17
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"strings"
+	"time"
 )
 
+// Reader is the subset of bufio.Reader that record parsing relies on.
+// Both *bufio.Reader and *MmapReader satisfy it.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
 type Record interface {
-	Read(r *bufio.Reader) error
+	Read(r Reader) error
 }
 
 type Addressable interface {
@@ -21,7 +30,7 @@ type Addressable interface {
 type Owner interface {
 	Addressable
 	GetContents() []byte
-	GetFields() []uint64
+	GetFields() []Field
 }
 
 type RecordType int
@@ -49,7 +58,7 @@ const (
 
 const Header = "go1.7 heap dump\n"
 
-func ReadHeader(reader *bufio.Reader) (err error) {
+func ReadHeader(reader Reader) (err error) {
 	val := make([]byte, len(Header))
 	n, err := io.ReadFull(reader, val)
 	if err != nil {
@@ -66,12 +75,61 @@ func ReadHeader(reader *bufio.Reader) (err error) {
 	return
 }
 
-func ReadRecord(reader *bufio.Reader) (record Record, err error) {
+// Reads the next record from a dump. Objects aren't named after the OIDs
+// they start with, since that depends on which SymbolTable the dump is
+// being read with; call NameObject on that table (DefaultSymbols, for the
+// OIDs read by the package-level ReadOids) to name each Object read.
+func ReadRecord(reader Reader) (record Record, err error) {
+	if stats != nil {
+		return readRecordWithStats(reader, stats)
+	}
+	return readRecord(reader)
+}
+
+func readRecordWithStats(reader Reader, s *Stats) (record Record, err error) {
+	start := time.Now()
+	r, isRemainder := reader.(remainder)
+	var before uint64
+	if isRemainder {
+		before = r.Remaining()
+	}
+	record, err = readRecord(reader)
+	if err != nil {
+		return
+	}
+	var bytes uint64
+	if isRemainder {
+		bytes = before - r.Remaining()
+	}
+	s.add(record, bytes, time.Since(start))
+	return
+}
+
+func readRecord(reader Reader) (record Record, err error) {
+	s, canSeek := reader.(seeker)
+	var start uint64
+	if canSeek {
+		start = s.Offset()
+	}
 	rt, err := binary.ReadUvarint(reader)
 	if err != nil {
 		return
 	}
-	switch RecordType(rt) {
+	record = newRecord(RecordType(rt))
+	if record == nil {
+		if lenient && canSeek {
+			return resynchronize(s, start, rt)
+		}
+		return nil, fmt.Errorf("Unexpected record type: %v", rt)
+	}
+	err = record.Read(reader)
+	return
+}
+
+// Returns an empty record of the indicated type, or nil if the type isn't
+// known.
+func newRecord(rt RecordType) (record Record) {
+	switch rt {
 	case EofType:
 		record = &Eof{}
 	case ObjectType:
@@ -108,12 +166,7 @@ func ReadRecord(reader *bufio.Reader) (record Record, err error) {
 		record = &AllocFreeProfileRecord{}
 	case AllocStackTraceSampleType:
 		record = &AllocStackTraceSample{}
-	default:
-		return nil, fmt.Errorf("Unexpected record type: %v", rt)
 	}
-
-	err = record.Read(reader)
-
 	return
 }
 
@@ -132,17 +185,27 @@ func GetPointersSourceAddress(o Owner, target uint64, p *DumpParams) uint64 {
 	return 0
 }
 
+// Returns where the pointers in an owner are, and what they point to. The
+// owner's fields must have passed CheckFields.
 func GetPointerInfo(o Owner, p *DumpParams) (pointerSource, pointerTarget []uint64) {
+	return GetPointerRange(o, p, 0, len(o.GetFields()))
+}
+
+// Returns where the pointers in a range of an owner's pointer fields (from
+// index start up to, but not including, end) are, and what they point to,
+// so that owners with huge numbers of pointers can be read a piece at a
+// time.
+func GetPointerRange(o Owner, p *DumpParams, start int, end int) (pointerSource, pointerTarget []uint64) {
 	var byteOrder binary.ByteOrder = binary.LittleEndian
 	if p.BigEndian {
 		byteOrder = binary.BigEndian
 	}
 	contents := o.GetContents()
-	fields := o.GetFields()
+	fields := o.GetFields()[start:end]
 	pointerSource = make([]uint64, len(fields))
 	pointerTarget = make([]uint64, len(fields))
 	for i := 0; i < len(fields); i++ {
-		offset := fields[i]
+		offset := fields[i].Offset
 		pointerSource[i] = o.GetAddress() + offset
 		switch p.PointerSize {
 		case 2:
@@ -154,10 +217,174 @@ func GetPointerInfo(o Owner, p *DumpParams) (pointerSource, pointerTarget []uint
 		default:
 			panic(fmt.Sprintf("Cannot handle pointers of size %d", p.PointerSize))
 		}
+		pointerTarget[i] = canonicalPointer(pointerTarget[i])
 	}
 	return
 }
 
+var pointerMask, pointerAlign uint64
+
+// Some values that the runtime reports as pointers carry tags: flags packed
+// into low bits that are always zero in a real address (as in sync.Mutex
+// state), or into otherwise unused high bits. Setting a mask clears those
+// bits from every pointer before it is used; setting an alignment rounds
+// every pointer down to a multiple of it. Both are off by default.
+func SetPointerCanonicalization(mask uint64, align uint64) {
+	pointerMask = mask
+	pointerAlign = align
+}
+
+func canonicalPointer(p uint64) uint64 {
+	p &^= pointerMask
+	if pointerAlign > 1 {
+		p -= p % pointerAlign
+	}
+	return p
+}
+
+// Readers that can hand out slices of their underlying storage (such as
+// MmapReader) implement this to avoid copying large byte fields.
+type slicer interface {
+	Slice(n uint64) ([]byte, error)
+}
+
+// Readers that know how much of the dump is left (such as MmapReader and
+// FileReader) implement this, so that lengths can be checked before
+// anything is allocated for them.
+type remainder interface {
+	Remaining() uint64
+}
+
+var maxObjectSize uint64
+
+// Sets the largest length that will be accepted for any single variable-sized
+// field in the dump. Zero, the default, means no limit other than the amount
+// of the dump that remains to be read.
+func SetMaxObjectSize(n uint64) {
+	maxObjectSize = n
+}
+
+// Makes sure that a length read from the dump is plausible. A corrupted
+// varint can otherwise ask for an enormous allocation, which will take down
+// the whole process rather than producing an error.
+func checkLength(reader Reader, n uint64) error {
+	if maxObjectSize > 0 && n > maxObjectSize {
+		return fmt.Errorf("Length %d exceeds maximum object size of %d bytes", n, maxObjectSize)
+	}
+	r, isRemainder := reader.(remainder)
+	if isRemainder && n > r.Remaining() {
+		return fmt.Errorf("Length %d exceeds the %d bytes remaining in the dump", n, r.Remaining())
+	}
+	return nil
+}
+
+// Makes sure that a count of entries read from the dump is plausible, given
+// that each entry takes up at least the indicated number of bytes of it.
+func checkCount(reader Reader, count uint64, size uint64) error {
+	r, isRemainder := reader.(remainder)
+	if isRemainder && count > r.Remaining()/size {
+		return fmt.Errorf("Count %d exceeds what fits in the %d bytes remaining in the dump", count, r.Remaining())
+	}
+	return nil
+}
+
+// The most that's allocated at once for a field whose length can't be
+// checked against the size of the dump
+const readChunk = 1 << 20
+
+func readBytes(reader Reader, n uint64) ([]byte, error) {
+	err := checkLength(reader, n)
+	if err != nil {
+		return nil, err
+	}
+	s, isSlicer := reader.(slicer)
+	if isSlicer {
+		return s.Slice(n)
+	}
+	if _, isRemainder := reader.(remainder); !isRemainder && n > readChunk {
+		// Nothing vouches for the length, so memory is only allocated as
+		// the bytes actually arrive.
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("Length %d is too large", n)
+		}
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, reader, int64(n))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf.Bytes(), err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(reader, buf)
+	return buf, err
+}
+
+func readString(reader Reader, n uint64) (string, error) {
+	buf, err := readBytes(reader, n)
+	return string(buf), err
+}
+
+// The kinds of entries in a field list. Dumps from before Go 1.5 also
+// described interfaces in field lists; they're no longer written, and
+// heapspurs doesn't read those dumps anyway.
+type FieldKind uint64
+
+const (
+	FieldKindEol FieldKind = 0 // ends the list
+	FieldKindPtr FieldKind = 1 // a pointer
+)
+
+// An entry in the field list of an object, a stack frame, or a segment,
+// locating one of the pointers in its contents.
+type Field struct {
+	Kind   FieldKind
+	Offset uint64
+}
+
+// Reads a field list, up to and including the entry that ends it. Every
+// field must be a pointer that starts within the indicated number of bytes
+// of contents; anything else means that the dump is corrupt.
+func readFieldList(reader Reader, size uint64) ([]Field, error) {
+	fields := make([]Field, 0)
+	for {
+		kind, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if FieldKind(kind) == FieldKindEol {
+			return fields, nil
+		}
+		if FieldKind(kind) != FieldKindPtr {
+			return nil, fmt.Errorf("Unexpected field kind %d", kind)
+		}
+		offset, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if offset >= size {
+			return nil, fmt.Errorf("Field offset %d is outside of %d bytes of contents", offset, size)
+		}
+		fields = append(fields, Field{Kind: FieldKind(kind), Offset: offset})
+	}
+}
+
+// Checks that every pointer in an owner's field list ends within its
+// contents. Field lists are checked as they're read, but only that each
+// pointer starts within the contents, since the pointer size comes from
+// the dump parameters, which needn't come first; this finishes the job
+// once they've been read, and has to pass before the owner's pointers can
+// be read (see GetPointerInfo).
+func CheckFields(o Owner, p *DumpParams) error {
+	size := uint64(len(o.GetContents()))
+	for _, f := range o.GetFields() {
+		if f.Offset+p.PointerSize > size {
+			return fmt.Errorf("Pointer at offset %d of the record at 0x%x runs past the end of its %d bytes",
+				f.Offset, o.GetAddress(), size)
+		}
+	}
+	return nil
+}
+
 ///////////////////////////////////////////////////////////////////////////
 
 type Eof struct {
@@ -167,14 +394,14 @@ func (r *Eof) String() string {
 	return "End Of File"
 }
 
-func (r *Eof) Read(reader *bufio.Reader) (err error) {
+func (r *Eof) Read(reader Reader) (err error) {
 	return
 }
 
 type Object struct {
-	Address  uint64   // address of object
-	Contents []byte   // contents of object
-	Fields   []uint64 // describes pointer-containing fields of the object
+	Address  uint64  // address of object
+	Contents []byte  // contents of object
+	Fields   []Field // describes pointer-containing fields of the object
 	Name     string
 }
 
@@ -186,11 +413,21 @@ func (r *Object) GetContents() []byte {
 	return r.Contents
 }
 
-func (r *Object) GetFields() []uint64 {
+func (r *Object) GetFields() []Field {
 	return r.Fields
 }
 
+// Returns the object's name, abbreviated for display by AbbreviateName.
 func (r *Object) GetName() string {
+	if len(r.Name) > 0 {
+		return AbbreviateName(r.Name)
+	}
+	return "Object"
+}
+
+// Returns the object's name exactly as it was assigned, for matching and
+// for machine-readable output.
+func (r *Object) GetFullName() string {
 	if len(r.Name) > 0 {
 		return r.Name
 	}
@@ -201,7 +438,7 @@ func (r *Object) String() string {
 	return fmt.Sprintf("%s @ 0x%x with %d pointers in %d bytes", r.GetName(), r.Address, len(r.Fields), len(r.Contents))
 }
 
-func (r *Object) Read(reader *bufio.Reader) (err error) {
+func (r *Object) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -213,40 +450,17 @@ func (r *Object) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	r.Contents = make([]byte, ContentsLen)
-	_, err = io.ReadFull(reader, r.Contents)
+	r.Contents, err = readBytes(reader, ContentsLen)
 	if err != nil {
 		return
 	}
 
 	// Read Fields as fieldlist
-	r.Fields = make([]uint64, 0)
-	var kind uint64
-	for {
-		kind, err = binary.ReadUvarint(reader)
-		if err != nil {
-			return
-		}
-		if kind == 0 {
-			break
-		}
-		var value uint64
-		value, err = binary.ReadUvarint(reader)
-		if kind == 0 {
-			break
-		}
-		r.Fields = append(r.Fields, value)
-	}
-
-	// Assign a class name if this object starts with an OID
-	if len(r.Contents) > 8 {
-		oid := binary.LittleEndian.Uint64(r.Contents[:])
-		className, found := oidMap[oid]
-		if found {
-			r.Name = className
-			AddName(r.Address, className)
-		}
+	fields, err := readFieldList(reader, uint64(len(r.Contents)))
+	if err != nil {
+		return
 	}
+	r.Fields = fields
 
 	return
 }
@@ -264,18 +478,47 @@ func (r *OtherRoot) GetAddress() uint64 {
 	return r.Address
 }
 
-func (r *OtherRoot) Read(reader *bufio.Reader) (err error) {
+// Maps substrings found in runtime-provided root descriptions onto
+// friendlier category names. Order matters: first match wins.
+var otherRootCategories = []struct {
+	match    string
+	category string
+}{
+	{"finq", "Finalizer queue"},
+	{"finalizer", "Finalizer queue"},
+	{"defer", "Defer pool"},
+	{"panic", "Panic records"},
+	{"work", "GC work buffers"},
+	{"wbuf", "GC work buffers"},
+	{"span", "Span specials"},
+	{"special", "Span specials"},
+	{"stack", "Goroutine stacks"},
+	{"data", "Data segment"},
+	{"bss", "Bss segment"},
+}
+
+// Returns a human-friendly grouping for the runtime structure this
+// root represents, based on its description.
+func (r *OtherRoot) Category() string {
+	description := strings.ToLower(r.Description)
+	for _, c := range otherRootCategories {
+		if strings.Contains(description, c.match) {
+			return c.category
+		}
+	}
+	return "Other runtime roots"
+}
+
+func (r *OtherRoot) Read(reader Reader) (err error) {
 	// Read Description as string
 	DescriptionLen, err := binary.ReadUvarint(reader)
 	if err != nil {
 		return
 	}
-	DescriptionBuf := make([]byte, DescriptionLen)
-	_, err = io.ReadFull(reader, DescriptionBuf)
+	r.Description, err = readString(reader, DescriptionLen)
 	if err != nil {
 		return
 	}
-	r.Description = string(DescriptionBuf)
 
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
@@ -301,7 +544,7 @@ func (r *TypeDescriptor) String() string {
 	return fmt.Sprintf("TypeDescriptor for '%s' @ 0x%x: Objects are %d bytes", r.Name, r.Address, r.TypeSize)
 }
 
-func (r *TypeDescriptor) Read(reader *bufio.Reader) (err error) {
+func (r *TypeDescriptor) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -319,12 +562,10 @@ func (r *TypeDescriptor) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	NameBuf := make([]byte, NameLen)
-	_, err = io.ReadFull(reader, NameBuf)
+	r.Name, err = readString(reader, NameLen)
 	if err != nil {
 		return
 	}
-	r.Name = string(NameBuf)
 
 	// Read Indirect as bool
 	IndirectInt, err := binary.ReadUvarint(reader)
@@ -386,7 +627,7 @@ func (s StatusType) String() string {
 	return fmt.Sprintf("Unknown status %d", uint64(s))
 }
 
-func (r *Goroutine) Read(reader *bufio.Reader) (err error) {
+func (r *Goroutine) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -444,12 +685,10 @@ func (r *Goroutine) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	WaitReasonBuf := make([]byte, WaitReasonLen)
-	_, err = io.ReadFull(reader, WaitReasonBuf)
+	r.WaitReason, err = readString(reader, WaitReasonLen)
 	if err != nil {
 		return
 	}
-	r.WaitReason = string(WaitReasonBuf)
 
 	// Read CurrentContextPointer as uvarint
 	r.CurrentContextPointer, err = binary.ReadUvarint(reader)
@@ -479,15 +718,15 @@ func (r *Goroutine) Read(reader *bufio.Reader) (err error) {
 }
 
 type StackFrame struct {
-	Address        uint64   // stack pointer (lowest address in frame)
-	Depth          uint64   // depth in stack (0 = top of stack)
-	ChildPointer   uint64   // stack pointer of child frame (or 0 if none)
-	Contents       []byte   // contents of stack frame
-	EntryPc        uint64   // entry pc for function
-	CurrentPc      uint64   // current pc for function
-	ContinuationPc uint64   // continuation pc for function (where function may resume, if anywhere)
-	Name           string   // function name
-	Fields         []uint64 // list of kind and offset of pointer-containing fields in this frame
+	Address        uint64  // stack pointer (lowest address in frame)
+	Depth          uint64  // depth in stack (0 = top of stack)
+	ChildPointer   uint64  // stack pointer of child frame (or 0 if none)
+	Contents       []byte  // contents of stack frame
+	EntryPc        uint64  // entry pc for function
+	CurrentPc      uint64  // current pc for function
+	ContinuationPc uint64  // continuation pc for function (where function may resume, if anywhere)
+	Name           string  // function name
+	Fields         []Field // list of kind and offset of pointer-containing fields in this frame
 }
 
 func (r *StackFrame) GetAddress() uint64 {
@@ -498,17 +737,17 @@ func (r *StackFrame) GetContents() []byte {
 	return r.Contents
 }
 
-func (r *StackFrame) GetFields() []uint64 {
+func (r *StackFrame) GetFields() []Field {
 	return r.Fields
 }
 
 func (r *StackFrame) String() string {
 	return fmt.Sprintf("StackFrame[%d] @ 0x%x: %s with %d pointers in %d bytes; child = 0x%x",
-		r.Depth, r.Address, r.Name, len(r.Fields), len(r.Contents), r.ChildPointer,
+		r.Depth, r.Address, AbbreviateName(r.Name), len(r.Fields), len(r.Contents), r.ChildPointer,
 	)
 }
 
-func (r *StackFrame) Read(reader *bufio.Reader) (err error) {
+func (r *StackFrame) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -532,8 +771,7 @@ func (r *StackFrame) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	r.Contents = make([]byte, ContentsLen)
-	_, err = io.ReadFull(reader, r.Contents)
+	r.Contents, err = readBytes(reader, ContentsLen)
 	if err != nil {
 		return
 	}
@@ -561,31 +799,17 @@ func (r *StackFrame) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	NameBuf := make([]byte, NameLen)
-	_, err = io.ReadFull(reader, NameBuf)
+	r.Name, err = readString(reader, NameLen)
 	if err != nil {
 		return
 	}
-	r.Name = string(NameBuf)
 
 	// Read Fields as fieldlist
-	r.Fields = make([]uint64, 0)
-	var kind uint64
-	for {
-		kind, err = binary.ReadUvarint(reader)
-		if err != nil {
-			return
-		}
-		if kind == 0 {
-			break
-		}
-		var value uint64
-		value, err = binary.ReadUvarint(reader)
-		if kind == 0 {
-			break
-		}
-		r.Fields = append(r.Fields, value)
+	fields, err := readFieldList(reader, uint64(len(r.Contents)))
+	if err != nil {
+		return
 	}
+	r.Fields = fields
 
 	return
 }
@@ -612,7 +836,7 @@ func (r *DumpParams) String() string {
 	)
 }
 
-func (r *DumpParams) Read(reader *bufio.Reader) (err error) {
+func (r *DumpParams) Read(reader Reader) (err error) {
 	// Read BigEndian as bool
 	BigEndianInt, err := binary.ReadUvarint(reader)
 	if err != nil {
@@ -625,6 +849,12 @@ func (r *DumpParams) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	switch r.PointerSize {
+	case 2, 4, 8:
+	default:
+		err = fmt.Errorf("Unsupported pointer size %d", r.PointerSize)
+		return
+	}
 
 	// Read HeapStart as uvarint
 	r.HeapStart, err = binary.ReadUvarint(reader)
@@ -643,24 +873,20 @@ func (r *DumpParams) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	ArchitectureBuf := make([]byte, ArchitectureLen)
-	_, err = io.ReadFull(reader, ArchitectureBuf)
+	r.Architecture, err = readString(reader, ArchitectureLen)
 	if err != nil {
 		return
 	}
-	r.Architecture = string(ArchitectureBuf)
 
 	// Read GoExperiment as string
 	GoExperimentLen, err := binary.ReadUvarint(reader)
 	if err != nil {
 		return
 	}
-	GoExperimentBuf := make([]byte, GoExperimentLen)
-	_, err = io.ReadFull(reader, GoExperimentBuf)
+	r.GoExperiment, err = readString(reader, GoExperimentLen)
 	if err != nil {
 		return
 	}
-	r.GoExperiment = string(GoExperimentBuf)
 
 	// Read Ncpu as uvarint
 	r.Ncpu, err = binary.ReadUvarint(reader)
@@ -688,7 +914,7 @@ func (r *RegisteredFinalizer) String() string {
 	)
 }
 
-func (r *RegisteredFinalizer) Read(reader *bufio.Reader) (err error) {
+func (r *RegisteredFinalizer) Read(reader Reader) (err error) {
 	// Read ObjectAddress as uvarint
 	r.ObjectAddress, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -735,7 +961,7 @@ func (r *Itab) String() string {
 	return fmt.Sprintf("Itab @ 0x%x: 0x%x", r.Address, r.TypeDescriptorAddress)
 }
 
-func (r *Itab) Read(reader *bufio.Reader) (err error) {
+func (r *Itab) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -757,11 +983,15 @@ type OsThread struct {
 	OsId                    uint64 // os's id for thread
 }
 
+func (r *OsThread) GetAddress() uint64 {
+	return r.ThreadDescriptorAddress
+}
+
 func (r *OsThread) String() string {
 	return fmt.Sprintf("OsThread @ 0x%x: GoId = %d; OsId = 0x%x", r.ThreadDescriptorAddress, r.GoId, r.OsId)
 }
 
-func (r *OsThread) Read(reader *bufio.Reader) (err error) {
+func (r *OsThread) Read(reader Reader) (err error) {
 	// Read ThreadDescriptorAddress as uvarint
 	r.ThreadDescriptorAddress, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -813,10 +1043,15 @@ type MemStats struct {
 }
 
 func (r *MemStats) String() string {
-	return fmt.Sprintf("MemStats: %+v", *r)
+	return fmt.Sprintf("MemStats: Alloc=%d, TotalAlloc=%d, Sys=%d, Mallocs=%d, Frees=%d, HeapAlloc=%d, HeapSys=%d, HeapIdle=%d, HeapInuse=%d, HeapReleased=%d, HeapObjects=%d, StackInuse=%d, NextGC=%d, NumGC=%d, HeapFragmentation=%.1f%%, GC pauses: %s",
+		r.Alloc, r.TotalAlloc, r.Sys, r.Mallocs, r.Frees,
+		r.HeapAlloc, r.HeapSys, r.HeapIdle, r.HeapInuse, r.HeapReleased, r.HeapObjects,
+		r.StackInuse, r.NextGC, r.NumGC,
+		100*r.HeapFragmentation(), r.gcPauseSummary(),
+	)
 }
 
-func (r *MemStats) Read(reader *bufio.Reader) (err error) {
+func (r *MemStats) Read(reader Reader) (err error) {
 	// Read Alloc as uvarint
 	r.Alloc, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -995,7 +1230,7 @@ func (r *QueuedFinalizer) String() string {
 	)
 }
 
-func (r *QueuedFinalizer) Read(reader *bufio.Reader) (err error) {
+func (r *QueuedFinalizer) Read(reader Reader) (err error) {
 	// Read ObjectAddress as uvarint
 	r.ObjectAddress, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1030,9 +1265,9 @@ func (r *QueuedFinalizer) Read(reader *bufio.Reader) (err error) {
 }
 
 type DataSegment struct {
-	Address  uint64   // address of the start of the data segment
-	Contents []byte   // contents of the data segment
-	Fields   []uint64 // kind and offset of pointer-containing fields in the data segment.
+	Address  uint64  // address of the start of the data segment
+	Contents []byte  // contents of the data segment
+	Fields   []Field // kind and offset of pointer-containing fields in the data segment.
 }
 
 func (r *DataSegment) GetAddress() uint64 {
@@ -1043,7 +1278,7 @@ func (r *DataSegment) GetContents() []byte {
 	return r.Contents
 }
 
-func (r *DataSegment) GetFields() []uint64 {
+func (r *DataSegment) GetFields() []Field {
 	return r.Fields
 }
 
@@ -1051,7 +1286,7 @@ func (r *DataSegment) String() string {
 	return fmt.Sprintf("DataSegment @ 0x%x-0x%x with %d pointers", r.Address, r.Address+uint64(len(r.Contents)), len(r.Fields))
 }
 
-func (r *DataSegment) Read(reader *bufio.Reader) (err error) {
+func (r *DataSegment) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1063,38 +1298,25 @@ func (r *DataSegment) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	r.Contents = make([]byte, ContentsLen)
-	_, err = io.ReadFull(reader, r.Contents)
+	r.Contents, err = readBytes(reader, ContentsLen)
 	if err != nil {
 		return
 	}
 
 	// Read Fields as fieldlist
-	r.Fields = make([]uint64, 0)
-	var kind uint64
-	for {
-		kind, err = binary.ReadUvarint(reader)
-		if err != nil {
-			return
-		}
-		if kind == 0 {
-			break
-		}
-		var value uint64
-		value, err = binary.ReadUvarint(reader)
-		if kind == 0 {
-			break
-		}
-		r.Fields = append(r.Fields, value)
+	fields, err := readFieldList(reader, uint64(len(r.Contents)))
+	if err != nil {
+		return
 	}
+	r.Fields = fields
 
 	return
 }
 
 type BssSegment struct {
-	Address  uint64   // address of the start of the data segment
-	Contents []byte   // contents of the data segment
-	Fields   []uint64 // kind and offset of pointer-containing fields in the data segment.
+	Address  uint64  // address of the start of the data segment
+	Contents []byte  // contents of the data segment
+	Fields   []Field // kind and offset of pointer-containing fields in the data segment.
 }
 
 func (r *BssSegment) GetAddress() uint64 {
@@ -1105,7 +1327,7 @@ func (r *BssSegment) GetContents() []byte {
 	return r.Contents
 }
 
-func (r *BssSegment) GetFields() []uint64 {
+func (r *BssSegment) GetFields() []Field {
 	return r.Fields
 }
 
@@ -1113,7 +1335,7 @@ func (r *BssSegment) String() string {
 	return fmt.Sprintf("BssSegment @ 0x%x-0x%x with %d pointers", r.Address, r.Address+uint64(len(r.Contents)), len(r.Fields))
 }
 
-func (r *BssSegment) Read(reader *bufio.Reader) (err error) {
+func (r *BssSegment) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1125,30 +1347,17 @@ func (r *BssSegment) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	r.Contents = make([]byte, ContentsLen)
-	_, err = io.ReadFull(reader, r.Contents)
+	r.Contents, err = readBytes(reader, ContentsLen)
 	if err != nil {
 		return
 	}
 
 	// Read Fields as fieldlist
-	r.Fields = make([]uint64, 0)
-	var kind uint64
-	for {
-		kind, err = binary.ReadUvarint(reader)
-		if err != nil {
-			return
-		}
-		if kind == 0 {
-			break
-		}
-		var value uint64
-		value, err = binary.ReadUvarint(reader)
-		if kind == 0 {
-			break
-		}
-		r.Fields = append(r.Fields, value)
+	fields, err := readFieldList(reader, uint64(len(r.Contents)))
+	if err != nil {
+		return
 	}
+	r.Fields = fields
 
 	return
 }
@@ -1167,7 +1376,11 @@ func (r *DeferRecord) GetAddress() uint64 {
 	return r.Address
 }
 
-func (r *DeferRecord) Read(reader *bufio.Reader) (err error) {
+func (r *DeferRecord) String() string {
+	return fmt.Sprintf("DeferRecord @ 0x%x: goroutine @ 0x%x, funcval = 0x%x, entry = %s; next = 0x%x", r.Address, r.ContainingGoroutine, r.FuncVal, FormatPC(r.EntryPointPc), r.Next)
+}
+
+func (r *DeferRecord) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1226,7 +1439,11 @@ func (r *PanicRecord) GetAddress() uint64 {
 	return r.Address
 }
 
-func (r *PanicRecord) Read(reader *bufio.Reader) (err error) {
+func (r *PanicRecord) String() string {
+	return fmt.Sprintf("PanicRecord @ 0x%x: goroutine @ 0x%x, arg = (0x%x, 0x%x), defer = 0x%x; next = 0x%x", r.Address, r.Goroutine, r.PanicArgType, r.PanicArgData, r.DeferRecordPtr, r.Next)
+}
+
+func (r *PanicRecord) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1284,7 +1501,7 @@ func (r *AllocFreeProfileRecord) String() string {
 	return fmt.Sprintf("AllocFreeProfileRecord: %+v", *r)
 }
 
-func (r *AllocFreeProfileRecord) Read(reader *bufio.Reader) (err error) {
+func (r *AllocFreeProfileRecord) Read(reader Reader) (err error) {
 	// Read Id as uvarint
 	r.Id, err = binary.ReadUvarint(reader)
 	if err != nil {
@@ -1302,9 +1519,15 @@ func (r *AllocFreeProfileRecord) Read(reader *bufio.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	r.Frames = make([]frame, FrameCount)
+	// Each frame takes at least a byte for each of its three fields
+	err = checkCount(reader, FrameCount, 3)
+	if err != nil {
+		return
+	}
+	r.Frames = make([]frame, 0, min(FrameCount, readChunk))
 
 	for i := uint64(0); i < FrameCount; i++ {
+		var f frame
 		var NameLen, FilenameLen uint64
 
 		// Read Name as string
@@ -1312,30 +1535,27 @@ func (r *AllocFreeProfileRecord) Read(reader *bufio.Reader) (err error) {
 		if err != nil {
 			return
 		}
-		NameBuf := make([]byte, NameLen)
-		_, err = io.ReadFull(reader, NameBuf)
+		f.Name, err = readString(reader, NameLen)
 		if err != nil {
 			return
 		}
-		r.Frames[i].Name = string(NameBuf)
 
 		// Read Filename as string
 		FilenameLen, err = binary.ReadUvarint(reader)
 		if err != nil {
 			return
 		}
-		FilenameBuf := make([]byte, FilenameLen)
-		_, err = io.ReadFull(reader, FilenameBuf)
+		f.Filename, err = readString(reader, FilenameLen)
 		if err != nil {
 			return
 		}
-		r.Frames[i].Filename = string(FilenameBuf)
 
 		// Read Line as uvarint
-		r.Frames[i].Line, err = binary.ReadUvarint(reader)
+		f.Line, err = binary.ReadUvarint(reader)
 		if err != nil {
 			return
 		}
+		r.Frames = append(r.Frames, f)
 	}
 
 	// Read AllocationCount as uvarint
@@ -1366,7 +1586,7 @@ func (r *AllocStackTraceSample) String() string {
 	return fmt.Sprintf("AllocStackTraceSample: %+v", *r)
 }
 
-func (r *AllocStackTraceSample) Read(reader *bufio.Reader) (err error) {
+func (r *AllocStackTraceSample) Read(reader Reader) (err error) {
 	// Read Address as uvarint
 	r.Address, err = binary.ReadUvarint(reader)
 	if err != nil {
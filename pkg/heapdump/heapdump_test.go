@@ -0,0 +1,65 @@
+package heapdump
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Fuzzes the record readers (see "make fuzz"), starting from the dumps in
+// testdata/fuzz/corpus. Each input is read both the way the --mmap flag
+// reads dumps, with every length checked against what remains of the dump,
+// and the way a plain stream is read, where nothing can be checked in
+// advance. Either way, a dump must only ever produce an error; a panic, or
+// an allocation far beyond the size of the dump, is a bug.
+func FuzzReadRecord(f *testing.F) {
+	seeds, err := filepath.Glob(filepath.Join("testdata", "fuzz", "corpus", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range seeds {
+		data, err := os.ReadFile(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readAll(&MmapReader{data: data})
+		readAll(bytes.NewReader(data))
+	})
+}
+
+// Reads every record of a dump, along with the pointers in those that have
+// them, up to the first error.
+func readAll(reader Reader) {
+	if ReadHeader(reader) != nil {
+		return
+	}
+	var params *DumpParams
+	owners := make([]Owner, 0)
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return
+		}
+		switch r := record.(type) {
+		case *Eof:
+			// As in treeclimber, the parameters can come anywhere
+			if params == nil && len(owners) > 0 {
+				return
+			}
+			for _, o := range owners {
+				if CheckFields(o, params) == nil {
+					GetPointers(o, params)
+				}
+			}
+			return
+		case *DumpParams:
+			params = r
+		case Owner:
+			owners = append(owners, r)
+		}
+	}
+}
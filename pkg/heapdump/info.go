@@ -0,0 +1,108 @@
+package heapdump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adamroach/heapspurs/pkg/capture"
+)
+
+// Summary information about a dump, gathered in a single streaming pass
+// without keeping any of the records around.
+type DumpInfo struct {
+	FileSize     uint64            `json:"file_size"`
+	Metadata     *capture.Metadata `json:"metadata,omitempty"` // from the dump's metadata file, if it has one
+	Params       *DumpParams       `json:"params"`
+	MemStats     *MemStats         `json:"mem_stats"`
+	RecordCounts map[string]uint64 `json:"record_counts"`
+	Types        uint64            `json:"types"` // distinct types described by TypeDescriptor records
+
+	// Derived from MemStats, if the dump has them
+	LiveBytes          uint64                   `json:"live_bytes,omitempty"`
+	HeapFragmentation  float64                  `json:"heap_fragmentation,omitempty"`
+	GCPausePercentiles map[string]time.Duration `json:"gc_pause_percentiles_ns,omitempty"`
+}
+
+func ReadInfo(reader Reader) (*DumpInfo, error) {
+	err := ReadHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Reading header: %w\n", err)
+	}
+
+	info := &DumpInfo{RecordCounts: make(map[string]uint64)}
+	// Several descriptors can describe the same type; see CanonicalTypeName
+	types := make(map[string]bool)
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch r := record.(type) {
+		case *DumpParams:
+			info.Params = r
+		case *TypeDescriptor:
+			key := fmt.Sprintf("%s/%d", CanonicalTypeName(r.Name), r.TypeSize)
+			if !types[key] {
+				types[key] = true
+				info.Types++
+			}
+		case *MemStats:
+			info.MemStats = r
+			info.LiveBytes = r.LiveBytes()
+			info.HeapFragmentation = r.HeapFragmentation()
+			if pauses := r.GCPausePercentiles(summaryPercentiles...); pauses != nil {
+				info.GCPausePercentiles = make(map[string]time.Duration)
+				for i, p := range summaryPercentiles {
+					info.GCPausePercentiles[percentileName(p)] = pauses[i]
+				}
+			}
+		}
+		info.RecordCounts[recordName(record)]++
+		if _, isEof := record.(*Eof); isEof {
+			break
+		}
+	}
+	return info, nil
+}
+
+func recordName(record Record) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", record), "*heapdump.")
+}
+
+func (i *DumpInfo) String() string {
+	var b strings.Builder
+	if i.Metadata != nil {
+		b.WriteString(i.Metadata.String())
+		if i.Params != nil && goVersion.MatchString(i.Params.GoExperiment) && i.Params.GoExperiment != i.Metadata.GoVersion {
+			fmt.Fprintf(&b, "Warning: the metadata file is for %s, but the dump was written by %s\n",
+				i.Metadata.GoVersion, i.Params.GoExperiment)
+		}
+	}
+	fmt.Fprintf(&b, "File size: %d bytes\n", i.FileSize)
+	if i.Params != nil {
+		fmt.Fprintf(&b, "%s\n", i.Params.String())
+	}
+	if i.MemStats != nil {
+		m := i.MemStats
+		fmt.Fprintf(&b, "MemStats: HeapAlloc=%d, HeapSys=%d, HeapObjects=%d, StackInuse=%d, Sys=%d, NumGC=%d\n",
+			m.HeapAlloc, m.HeapSys, m.HeapObjects, m.StackInuse, m.Sys, m.NumGC)
+		fmt.Fprintf(&b, "Live heap: %d bytes in %d objects; %.1f%% of in-use heap spans is unused\n",
+			m.LiveBytes(), m.HeapObjects, 100*m.HeapFragmentation())
+		fmt.Fprintf(&b, "GC pauses: %s\n", m.gcPauseSummary())
+	}
+	names := make([]string, 0, len(i.RecordCounts))
+	for name := range i.RecordCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "Records:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-24s %d\n", name, i.RecordCounts[name])
+	}
+	if descriptors := i.RecordCounts["TypeDescriptor"]; descriptors > i.Types {
+		fmt.Fprintf(&b, "%d type descriptors describe %d distinct types\n", descriptors, i.Types)
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"strings"
+)
+
+// A field of a struct type whose value is stored in the struct itself, and
+// can be big: a nested or embedded struct, or an array. Slices, strings,
+// and interface values are left out, since what they hold is elsewhere.
+type InlineField struct {
+	Path   string // from the outermost struct, e.g., "reader.buf"
+	Type   string // e.g., "bufio.Reader" or "[4096]uint8"
+	Offset uint64 // from the start of the outermost struct
+	Size   uint64
+	Depth  int // how many structs it's nested in, not counting the outermost
+}
+
+// The layout of a struct type, from the program's debug info.
+type StructLayout struct {
+	Size uint64
+	// The bytes in between and after its fields, which the compiler adds
+	// to align them
+	Padding uint64
+	// Its inline fields, in the order they're laid out, with the inline
+	// fields of each nested struct right after it
+	Inline []InlineField
+}
+
+// Returns the layout of the named struct type (e.g., "net.TCPConn"), for
+// working out how much of the memory used by its objects goes to each of
+// the structs and arrays embedded in it. Fields nested more deeply than
+// the indicated depth aren't listed; zero only lists the struct's own.
+//
+// This requires that ReadProgram has been called on a program built with
+// debug info; this uses the program read into the default symbol table.
+func GetStructLayout(name string, depth int) (*StructLayout, bool) {
+	return defaultSymbols.GetStructLayout(name, depth)
+}
+
+// Returns the layout of the named struct type, as GetStructLayout does,
+// using the program read into this table.
+func (t *SymbolTable) GetStructLayout(name string, depth int) (*StructLayout, bool) {
+	s := t.sources()
+	if s == nil {
+		return nil, false
+	}
+	st, found := s.structs[strings.TrimPrefix(name, "*")]
+	if !found || st.Size() <= 0 {
+		return nil, false
+	}
+	layout := &StructLayout{Size: uint64(st.Size())}
+	used := uint64(0)
+	for _, f := range st.Field {
+		if f.Type.Size() > 0 {
+			used += uint64(f.Type.Size())
+		}
+	}
+	layout.Padding = layout.Size - min(used, layout.Size)
+	layout.Inline = inlineFields(st, 0, "", 0, depth, make([]InlineField, 0))
+	return layout, true
+}
+
+// Appends the inline fields of a struct, at the indicated offset in the
+// outermost one, to a list of them.
+func inlineFields(t *dwarf.StructType, offset uint64, path string, depth int, maxDepth int, fields []InlineField) []InlineField {
+	for _, f := range t.Field {
+		if f.Type.Size() <= 0 {
+			continue
+		}
+		name, nested := inlineType(f.Type)
+		if len(name) == 0 {
+			continue
+		}
+		fields = append(fields, InlineField{
+			Path:   path + f.Name,
+			Type:   name,
+			Offset: offset + uint64(f.ByteOffset),
+			Size:   uint64(f.Type.Size()),
+			Depth:  depth,
+		})
+		if nested != nil && depth < maxDepth {
+			fields = inlineFields(nested, offset+uint64(f.ByteOffset), path+f.Name+".", depth+1, maxDepth, fields)
+		}
+	}
+	return fields
+}
+
+// Names the type of an inline field, along with the struct it is, if it's
+// one, or returns an empty name if it's not a struct or an array.
+func inlineType(t dwarf.Type) (string, *dwarf.StructType) {
+	// Go describes named types as typedefs of what they're defined as
+	name := ""
+	for typedef, isTypedef := t.(*dwarf.TypedefType); isTypedef; typedef, isTypedef = t.(*dwarf.TypedefType) {
+		if len(name) == 0 {
+			name = typedef.Name
+		}
+		t = typedef.Type
+	}
+	switch t := t.(type) {
+	case *dwarf.StructType:
+		if isBuiltinStruct(t.StructName) || isBuiltinStruct(name) {
+			return "", nil
+		}
+		if len(name) == 0 {
+			name = t.StructName
+		}
+		if len(name) == 0 {
+			name = "struct"
+		}
+		return name, t
+	case *dwarf.ArrayType:
+		if len(name) == 0 {
+			name = t.String()
+		}
+		return name, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,104 @@
+package heapdump
+
+import (
+	"bytes"
+	"hash/maphash"
+)
+
+var internDisabled bool
+
+// Sets whether analyses that keep records around have them share a single
+// copy of contents and names that are repeated from record to record (the
+// default). Many heaps are full of identical small objects (zeroed buffers,
+// empty structs of the same type, and the like), and stack frames repeat
+// the same function names over and over, so this can save a lot of memory.
+// Turning it off saves the time spent looking for repeats.
+func SetInterning(enabled bool) {
+	internDisabled = !enabled
+}
+
+// An Interner finds the contents and names of records that are the same as
+// those of records it has already seen, and replaces them with the copy it
+// saw first, so that the duplicates can be freed. Contents are found by
+// their hash; names are simply looked up.
+//
+// Nothing in heapspurs changes a record's contents once they've been read,
+// which is what makes sharing them safe. Programs that do must not use an
+// Interner.
+type Interner struct {
+	seed     maphash.Seed
+	contents map[uint64][][]byte
+	strings  map[string]string
+	saved    uint64
+}
+
+// Returns an Interner for the records read from the indicated reader, or
+// nil if interning has been turned off with SetInterning. Calling Intern
+// on a nil Interner does nothing.
+func NewInterner(reader Reader) *Interner {
+	if internDisabled {
+		return nil
+	}
+	i := &Interner{strings: make(map[string]string)}
+	// Contents read through a slicer (such as MmapReader) are views of the
+	// dump, not private copies, so there's nothing to be saved by sharing
+	// them.
+	if _, isSlicer := reader.(slicer); !isSlicer {
+		i.seed = maphash.MakeSeed()
+		i.contents = make(map[uint64][][]byte)
+	}
+	return i
+}
+
+// Replaces the contents and names of the record with shared copies.
+func (i *Interner) Intern(record Record) {
+	if i == nil {
+		return
+	}
+	switch r := record.(type) {
+	case *Object:
+		r.Contents = i.bytes(r.Contents)
+	case *StackFrame:
+		r.Contents = i.bytes(r.Contents)
+		r.Name = i.string(r.Name)
+	case *TypeDescriptor:
+		r.Name = i.string(r.Name)
+	case *Goroutine:
+		r.WaitReason = i.string(r.WaitReason)
+	}
+}
+
+// Returns the number of bytes of duplicates that have been replaced.
+func (i *Interner) Saved() uint64 {
+	if i == nil {
+		return 0
+	}
+	return i.saved
+}
+
+func (i *Interner) bytes(b []byte) []byte {
+	if i.contents == nil || len(b) == 0 {
+		return b
+	}
+	hash := maphash.Bytes(i.seed, b)
+	for _, c := range i.contents[hash] {
+		if bytes.Equal(b, c) {
+			i.saved += uint64(len(b))
+			return c
+		}
+	}
+	i.contents[hash] = append(i.contents[hash], b)
+	return b
+}
+
+func (i *Interner) string(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	if c, found := i.strings[s]; found {
+		i.saved += uint64(len(s))
+		return c
+	}
+	i.strings[s] = s
+	return s
+}
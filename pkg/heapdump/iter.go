@@ -0,0 +1,43 @@
+package heapdump
+
+import (
+	"fmt"
+	"iter"
+)
+
+// Returns an iterator over the records of a dump, for reading it without
+// keeping the records around:
+//
+//	for record, err := range heapdump.Records(reader) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The header is read first, and the iterator stops at the end of the dump,
+// without yielding the Eof record. A failed read yields the error, with a
+// nil record, and nothing after it. The reader is consumed as the iterator
+// is, so it can only be ranged over once.
+func Records(reader Reader) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		err := ReadHeader(reader)
+		if err != nil {
+			yield(nil, fmt.Errorf("Reading header: %w", err))
+			return
+		}
+		for {
+			record, err := ReadRecord(reader)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if _, isEof := record.(*Eof); isEof {
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
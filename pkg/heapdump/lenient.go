@@ -0,0 +1,107 @@
+package heapdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// Readers that can move around in the dump (such as MmapReader and
+// FileReader) implement this, so that unknown records can be skipped.
+type seeker interface {
+	Reader
+	Offset() uint64
+	Seek(offset uint64) error
+}
+
+// How far past the start of an unknown record to look for the next record
+const resyncLimit = 1 << 20
+
+// How many records have to be read in a row from a place in the dump for it
+// to be taken as the start of a record. Small records, like the varints
+// that most records are made of, are easy to find by chance.
+const resyncRecords = 16
+
+var lenient bool
+
+// The number of unknown records skipped, and the bytes they took up
+var skippedRecords, skippedBytes atomic.Uint64
+
+// Sets whether ReadRecord skips records of types it doesn't know, rather
+// than failing. Newer runtimes could add record types, and the dump format
+// doesn't say how long records are (nor does it change its version when
+// records are added), so there's no way to know how big an unknown record
+// is. Instead, the rest of the dump is searched for the first place that
+// a run of known records can be read from, and reading picks up there.
+// This is a guess: the end of an unknown record can look like the start of
+// a known one, so a bogus record or two may be read before reading falls
+// back into step with the dump.
+// This only works with readers that can move around in the dump, like
+// FileReader and MmapReader; see SkippedRecords for what was skipped.
+func SetLenient(enabled bool) {
+	lenient = enabled
+}
+
+// Returns the number of unknown records that have been skipped (see
+// SetLenient), and the number of bytes skipped with them.
+func SkippedRecords() (uint64, uint64) {
+	return skippedRecords.Load(), skippedBytes.Load()
+}
+
+// Finds the next record after an unknown one that starts at the indicated
+// offset, and reads it.
+func resynchronize(r seeker, start uint64, rt uint64) (Record, error) {
+	for candidate := start + 1; candidate <= start+resyncLimit; candidate++ {
+		err := r.Seek(candidate)
+		if err != nil {
+			break
+		}
+		record, next, found := readRecordRun(r)
+		if !found {
+			continue
+		}
+		err = r.Seek(next)
+		if err != nil {
+			return nil, err
+		}
+		skippedRecords.Add(1)
+		skippedBytes.Add(candidate - start)
+		Logger().Debug("Skipped unknown record", "type", rt, "offset", start, "bytes", candidate-start)
+		return record, nil
+	}
+	return nil, fmt.Errorf("Unexpected record type %v at offset %d, with no known record after it", rt, start)
+}
+
+// Reads a record, and checks that it's followed by a run of others (or that
+// the dump ends first). Returns the first record, and where the second
+// starts.
+func readRecordRun(r seeker) (Record, uint64, bool) {
+	var first Record
+	var next uint64
+	for i := 0; i < resyncRecords; i++ {
+		record, err := readKnownRecord(r)
+		if err != nil {
+			return nil, 0, false
+		}
+		if i == 0 {
+			first, next = record, r.Offset()
+		}
+		if _, isEof := record.(*Eof); isEof {
+			remaining, isRemainder := r.(remainder)
+			return first, next, isRemainder && remaining.Remaining() == 0
+		}
+	}
+	return first, next, true
+}
+
+func readKnownRecord(r Reader) (Record, error) {
+	rt, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	record := newRecord(RecordType(rt))
+	if record == nil {
+		return nil, fmt.Errorf("Unexpected record type: %v", rt)
+	}
+	return record, record.Read(r)
+}
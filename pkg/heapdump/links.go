@@ -0,0 +1,43 @@
+package heapdump
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var linkFormat string
+var hyperlinks bool
+
+// Controls how source locations (see GetSourceLocation) are written. The
+// template can refer to the file and line as "{file}" and "{line}" (e.g.,
+// "vscode://file{file}:{line}"); if it's empty, locations are written as
+// "file:line", which most editors and terminals already know how to open.
+//
+// If hyperlink is set and the template produces a URL, locations are
+// still written as "file:line", but wrapped in an OSC 8 escape sequence
+// that makes them a link to the URL in terminals that support it. This
+// should only be set when writing to a terminal.
+func SetLinkFormat(template string, hyperlink bool) {
+	linkFormat = template
+	hyperlinks = hyperlink
+}
+
+// Formats a source location according to SetLinkFormat.
+func sourceLink(file string, line int) string {
+	location := fmt.Sprintf("%s:%d", file, line)
+	if len(linkFormat) == 0 {
+		return location
+	}
+	isURL := strings.Contains(linkFormat, "://")
+	if isURL {
+		// Spaces and the like need escaping, but separators don't
+		file = (&url.URL{Path: file}).EscapedPath()
+	}
+	link := strings.NewReplacer("{file}", file, "{line}", strconv.Itoa(line)).Replace(linkFormat)
+	if hyperlinks && isURL {
+		return "\x1b]8;;" + link + "\x1b\\" + location + "\x1b]8;;\x1b\\"
+	}
+	return link
+}
@@ -0,0 +1,18 @@
+package heapdump
+
+import (
+	"log/slog"
+)
+
+var logger = slog.Default()
+
+// Sets the logger that heapspurs packages use to report progress and
+// diagnostic details about parse decisions. Pass a logger with a handler
+// that discards everything to silence them.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+func Logger() *slog.Logger {
+	return logger
+}
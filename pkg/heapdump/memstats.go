@@ -0,0 +1,86 @@
+package heapdump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returns the bytes of heap objects that were allocated when the dump was
+// written. Since runtime/debug.WriteHeapDump is usually called right after
+// a garbage collection, these are almost all live.
+func (r *MemStats) LiveBytes() uint64 {
+	return r.HeapAlloc
+}
+
+// Returns the fraction (from 0 to 1) of the heap's in-use spans that isn't
+// holding objects. This is memory that the heap has set aside for objects
+// of particular size classes but can't use for anything else, so a high
+// value means that the heap is holding on to much more than the program
+// is using.
+func (r *MemStats) HeapFragmentation() float64 {
+	if r.HeapInuse == 0 || r.HeapAlloc > r.HeapInuse {
+		return 0
+	}
+	return float64(r.HeapInuse-r.HeapAlloc) / float64(r.HeapInuse)
+}
+
+// Returns the most recent GC pauses, in the order they happened. The
+// runtime only keeps the last 256.
+func (r *MemStats) GCPauses() []time.Duration {
+	count := r.NumGC
+	if count > uint64(len(r.PauseNs)) {
+		count = uint64(len(r.PauseNs))
+	}
+	pauses := make([]time.Duration, 0, count)
+	for i := r.NumGC - count; i < r.NumGC; i++ {
+		pauses = append(pauses, time.Duration(r.PauseNs[i%uint64(len(r.PauseNs))]))
+	}
+	return pauses
+}
+
+// Returns the indicated percentiles (from 0 to 100) of the most recent GC
+// pauses, or nil if there haven't been any.
+func (r *MemStats) GCPausePercentiles(percentiles ...float64) []time.Duration {
+	pauses := r.GCPauses()
+	if len(pauses) == 0 {
+		return nil
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+	results := make([]time.Duration, len(percentiles))
+	for i, p := range percentiles {
+		index := int(p / 100 * float64(len(pauses)-1))
+		if index < 0 {
+			index = 0
+		} else if index >= len(pauses) {
+			index = len(pauses) - 1
+		}
+		results[i] = pauses[index]
+	}
+	return results
+}
+
+// The percentiles of GC pauses shown in summaries
+var summaryPercentiles = []float64{50, 90, 99, 100}
+
+func percentileName(p float64) string {
+	if p == 100 {
+		return "max"
+	}
+	return fmt.Sprintf("p%.f", p)
+}
+
+// Describes the GC pause percentiles, e.g., "p50=1ms, p90=2ms, p99=3ms,
+// max=4ms over the last 12 GCs".
+func (r *MemStats) gcPauseSummary() string {
+	pauses := r.GCPausePercentiles(summaryPercentiles...)
+	if pauses == nil {
+		return "no GCs"
+	}
+	parts := make([]string, len(pauses))
+	for i, p := range summaryPercentiles {
+		parts[i] = fmt.Sprintf("%s=%v", percentileName(p), pauses[i])
+	}
+	return fmt.Sprintf("%s over the last %d GCs", strings.Join(parts, ", "), len(r.GCPauses()))
+}
@@ -0,0 +1,94 @@
+package heapdump
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// MmapReader reads a heap dump directly out of a read-only memory mapping
+// of the dump file. Unlike reading through a bufio.Reader, the byte contents
+// of objects, stack frames, and segments are not copied: they are slices that
+// point into the mapping. As a consequence, records read from an MmapReader
+// must not be used after the reader has been closed.
+type MmapReader struct {
+	data   []byte
+	offset uint64
+}
+
+func NewMmapReader(filename string) (*MmapReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := mmapFile(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("Mapping '%s': %w", filename, err)
+	}
+	return &MmapReader{data: data}, nil
+}
+
+func (m *MmapReader) Read(p []byte) (int, error) {
+	if m.offset >= uint64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.offset:])
+	m.offset += uint64(n)
+	return n, nil
+}
+
+func (m *MmapReader) ReadByte() (byte, error) {
+	if m.offset >= uint64(len(m.data)) {
+		return 0, io.EOF
+	}
+	b := m.data[m.offset]
+	m.offset++
+	return b, nil
+}
+
+// Returns the next n bytes of the mapping without copying them.
+func (m *MmapReader) Slice(n uint64) ([]byte, error) {
+	remaining := uint64(len(m.data)) - m.offset
+	if n > remaining {
+		m.offset = uint64(len(m.data))
+		return nil, io.ErrUnexpectedEOF
+	}
+	s := m.data[m.offset : m.offset+n : m.offset+n]
+	m.offset += n
+	return s, nil
+}
+
+// Returns the number of bytes of the mapping that have not yet been read.
+func (m *MmapReader) Remaining() uint64 {
+	return uint64(len(m.data)) - m.offset
+}
+
+// Returns how far into the mapping the next byte will be read from.
+func (m *MmapReader) Offset() uint64 {
+	return m.offset
+}
+
+// Moves to the indicated offset in the mapping.
+func (m *MmapReader) Seek(offset uint64) error {
+	if offset > uint64(len(m.data)) {
+		return io.ErrUnexpectedEOF
+	}
+	m.offset = offset
+	return nil
+}
+
+func (m *MmapReader) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmapFile(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package heapdump
+
+import (
+	"io"
+	"os"
+)
+
+// No mmap support on this platform; fall back to reading the whole file
+// into a single buffer, which at least avoids double-buffering.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(file, data)
+	return data, err
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -1,77 +1,58 @@
 package heapdump
 
 import (
-	"fmt"
 	"io"
-	"strconv"
 )
 
-var nameMap map[uint64]string
-var oidMap map[uint64]string
-
-func init() {
-	nameMap = make(map[uint64]string)
-	oidMap = make(map[uint64]string)
-}
+// The functions in this file work on the default symbol table (see
+// DefaultSymbols). Programs that analyze more than one dump at a time
+// should give each its own SymbolTable instead.
 
+// Deprecated: use a SymbolTable's AddOid.
 func AddOid(oid uint64, name string) {
-	oidMap[oid] = name
+	defaultSymbols.AddOid(oid, name)
 }
 
+// Deprecated: use a SymbolTable's AddName.
 func AddName(addr uint64, name string) {
-	nameMap[addr] = name
+	defaultSymbols.AddName(addr, name)
 }
 
+// Deprecated: use a SymbolTable's GetName.
 func GetName(addr uint64) string {
-	name, found := nameMap[addr]
-	if found {
-		return name
-	}
-	return ""
+	return defaultSymbols.GetName(addr)
+}
+
+// Returns the address of the named symbol, if known.
+//
+// Deprecated: use a SymbolTable's LookupName.
+func LookupName(name string) (uint64, bool) {
+	return defaultSymbols.LookupName(name)
+}
+
+// Parses an address expression; see SymbolTable.ParseAddress.
+//
+// Deprecated: use a SymbolTable's ParseAddress.
+func ParseAddress(expression string) (uint64, error) {
+	return defaultSymbols.ParseAddress(expression)
 }
 
+// Deprecated: use a SymbolTable's ReadOids.
 func ReadOids(r io.Reader) error {
-	var oid uint64
-	var name string
-	for {
-		n, err := fmt.Fscanln(r, &oid, &name)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		if n == 2 && oid > 0 && len(name) > 0 {
-			oidMap[oid] = name
-		}
-	}
-	return nil
+	return defaultSymbols.ReadOids(r)
 }
 
+// Reads symbols in the format produced by "go tool nm".
+//
+// Deprecated: use a SymbolTable's ReadSymbols.
 func ReadSymbols(r io.Reader) error {
-	var addr, kind, name string
-	for {
-		n, err := fmt.Fscanln(r, &addr, &kind, &name)
-		if err == io.EOF {
-			break
-		}
-		if err == nil && n == 3 {
-			addrInt, err := strconv.ParseUint(addr, 16, 64)
-			if err == nil {
-				nameMap[addrInt] = name
-			}
-		}
-	}
-	return nil
+	return defaultSymbols.ReadSymbols(r)
 }
 
-// Print out address and, if relevant, the name of what resides there
+// Print out address and, if relevant, the name of what resides there,
+// according to the default symbol table
 type Addr uint64
 
 func (a Addr) String() string {
-	name, found := nameMap[uint64(a)]
-	if found {
-		return fmt.Sprintf("0x%x (%s)", uint64(a), name)
-	}
-	return fmt.Sprintf("0x%x", uint64(a))
+	return defaultSymbols.FormatAddr(uint64(a))
 }
@@ -0,0 +1,136 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// One row of the program's line table: the instructions from address up
+// to the next row's address come from file:line. Rows that end a sequence
+// of instructions have no file.
+type lineRow struct {
+	address uint64
+	file    string
+	line    int
+}
+
+// Describes a program counter as the function it's in plus an offset (e.g.,
+// "0x4a2b1c (main.run+0x1c)"), using the names in the default symbol table.
+// See SymbolTable.FormatPC.
+func FormatPC(pc uint64) string {
+	return defaultSymbols.FormatPC(pc)
+}
+
+// Describes a program counter as the function it's in plus an offset (e.g.,
+// "0x4a2b1c (main.run+0x1c)"), followed by its source line if ReadProgram
+// has been called on a program with debug info (e.g., "0x4a2b1c (main.run+
+// 0x1c at /src/app/main.go:42)"). PCs that aren't the start of a function
+// are assumed to be return addresses, which point just past the call they
+// return from, so the line of the call is the one shown.
+func (t *SymbolTable) FormatPC(pc uint64) string {
+	name, offset, found := t.NearestSymbol(pc)
+	if !found {
+		return fmt.Sprintf("0x%x", pc)
+	}
+	return fmt.Sprintf("0x%x (%s)", pc, t.describePC(name, offset, pc))
+}
+
+// Describes where a stack frame's function is executing, as its name plus
+// an offset from its entry point, followed by its source line if
+// ReadProgram has been called on a program with debug info (e.g.,
+// "main.run+0x1c at /src/app/main.go:42"). Unlike FormatPC, this doesn't
+// need the program's symbols, since the frame has its function's name.
+func (r *StackFrame) Location() string {
+	if r.CurrentPc < r.EntryPc {
+		return AbbreviateName(r.Name)
+	}
+	return defaultSymbols.describePC(r.Name, r.CurrentPc-r.EntryPc, r.CurrentPc)
+}
+
+func (t *SymbolTable) describePC(name string, offset uint64, pc uint64) string {
+	description := AbbreviateName(name)
+	if offset > 0 {
+		description += fmt.Sprintf("+0x%x", offset)
+		pc--
+	}
+	if file, line, found := t.SourceLine(pc); found {
+		description += " at " + sourceLink(file, line)
+	}
+	return description
+}
+
+// Returns the source file and line that the instruction at the indicated
+// address was compiled from, using the program read into the default symbol
+// table. See SymbolTable.SourceLine.
+func SourceLine(pc uint64) (string, int, bool) {
+	return defaultSymbols.SourceLine(pc)
+}
+
+// Returns the source file and line that the instruction at the indicated
+// address was compiled from. This requires that ReadProgram has been called
+// on a program built with debug info.
+func (t *SymbolTable) SourceLine(pc uint64) (string, int, bool) {
+	program := t.image()
+	if program == nil || program.dwarf == nil {
+		return "", 0, false
+	}
+	program.linesOnce.Do(func() {
+		program.lines = readLineTable(program.dwarf)
+	})
+	rows := program.lines
+	i := sort.Search(len(rows), func(i int) bool { return rows[i].address > pc }) - 1
+	if i < 0 || len(rows[i].file) == 0 {
+		return "", 0, false
+	}
+	return rows[i].file, rows[i].line, true
+}
+
+// Reads the line tables of every compilation unit into one table, sorted by
+// address.
+func readLineTable(d *dwarf.Data) []lineRow {
+	rows := make([]lineRow, 0)
+	reader := d.Reader()
+	for {
+		e, err := reader.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+		lr, err := d.LineReader(e)
+		reader.SkipChildren()
+		if err != nil || lr == nil {
+			continue
+		}
+		var entry dwarf.LineEntry
+		for {
+			err := lr.Next(&entry)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				Logger().Debug("Could not read line table", "unit", e.Offset, "error", err)
+				break
+			}
+			row := lineRow{address: entry.Address}
+			if !entry.EndSequence && entry.File != nil {
+				row.file = entry.File.Name
+				row.line = entry.Line
+			}
+			rows = append(rows, row)
+		}
+	}
+	// Where sequences meet, the end of one and the start of the next have
+	// the same address; the start has to win.
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].address != rows[j].address {
+			return rows[i].address < rows[j].address
+		}
+		return len(rows[i].file) == 0 && len(rows[j].file) > 0
+	})
+	return rows
+}
@@ -0,0 +1,29 @@
+package heapdump
+
+import (
+	"time"
+)
+
+// A PhaseFunc is told how long each phase of an analysis took.
+type PhaseFunc func(name string, elapsed time.Duration)
+
+var phaseFunc PhaseFunc
+
+// Sets a function to be told how long each phase of an analysis (such as
+// parsing the dump, building the owner map, traversing it, and rendering
+// graphs) takes, for diagnosing the performance of heapspurs itself. Phases
+// can happen more than once.
+func SetPhaseFunc(f PhaseFunc) {
+	phaseFunc = f
+}
+
+// Starts timing the named phase; call the returned function when it ends.
+func StartPhase(name string) func() {
+	if phaseFunc == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		phaseFunc(name, time.Since(start))
+	}
+}
@@ -0,0 +1,90 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// A pointer in a record whose type is known from the program's debug info,
+// and so says what type of object it points to.
+type TypedPointer struct {
+	Offset uint64 // of the pointer, from the start of the record
+	Path   string // the field or variable holding the pointer, e.g., "session.conn"
+	Type   string // the struct type it points to, e.g., "net.TCPConn"
+}
+
+// Returns the pointers to struct types in a record, found the same way as
+// GetSliceHeaders finds slices. Slices, strings, and interface values are
+// left out, since what they point to isn't named by their types in the same
+// way.
+//
+// This requires that ReadProgram has been called on a program built with
+// debug info; this uses the program read into the default symbol table.
+func GetTypedPointers(r Record) []TypedPointer {
+	return defaultSymbols.GetTypedPointers(r)
+}
+
+// Returns the pointers to struct types in a record, as GetTypedPointers
+// does, using the program read into this table.
+func (t *SymbolTable) GetTypedPointers(r Record) []TypedPointer {
+	pointers := make([]TypedPointer, 0)
+	t.recordTypes(r, func(t dwarf.Type, offset uint64, path string) {
+		pointers = typedPointers(t, offset, path, pointers)
+	})
+	return pointers
+}
+
+// Appends the pointers to struct types in a value of the indicated type, at
+// the indicated offset, to a list of them.
+func typedPointers(t dwarf.Type, offset uint64, path string, pointers []TypedPointer) []TypedPointer {
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return typedPointers(t.Type, offset, path, pointers)
+	case *dwarf.PtrType:
+		// Go describes named types as typedefs of what they're defined as
+		name := ""
+		target := t.Type
+		for typedef, isTypedef := target.(*dwarf.TypedefType); isTypedef; typedef, isTypedef = target.(*dwarf.TypedefType) {
+			if len(name) == 0 {
+				name = typedef.Name
+			}
+			target = typedef.Type
+		}
+		st, isStruct := target.(*dwarf.StructType)
+		if len(name) == 0 && isStruct {
+			name = st.StructName
+		}
+		if isStruct && st.Size() > 0 && !isBuiltinStruct(st.StructName) && !isBuiltinStruct(name) {
+			pointers = append(pointers, TypedPointer{Offset: offset, Path: path, Type: name})
+		}
+	case *dwarf.StructType:
+		if isBuiltinStruct(t.StructName) {
+			return pointers
+		}
+		for _, f := range t.Field {
+			pointers = typedPointers(f.Type, offset+uint64(f.ByteOffset), path+"."+f.Name, pointers)
+		}
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		switch t.Type.(type) {
+		case *dwarf.StructType, *dwarf.TypedefType, *dwarf.ArrayType, *dwarf.PtrType:
+		default:
+			return pointers
+		}
+		if size <= 0 {
+			return pointers
+		}
+		for i := int64(0); i < t.Count; i++ {
+			pointers = typedPointers(t.Type, offset+uint64(i*size), fmt.Sprintf("%s[%d]", path, i), pointers)
+		}
+	}
+	return pointers
+}
+
+// Whether the named struct is one that Go describes its slices, strings,
+// and interface values with, rather than a type of the program's.
+func isBuiltinStruct(name string) bool {
+	return strings.HasPrefix(name, "[]") || name == "string" ||
+		name == "runtime.eface" || name == "runtime.iface"
+}
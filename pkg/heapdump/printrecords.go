@@ -1,12 +1,12 @@
 package heapdump
 
 import (
-	"bufio"
 	"fmt"
 	"regexp"
+	"sort"
 )
 
-func PrintRecords(reader *bufio.Reader, search string) error {
+func PrintRecords(reader Reader, search string) error {
 
 	re, err := regexp.Compile(search)
 	if err != nil {
@@ -29,6 +29,9 @@ func PrintRecords(reader *bufio.Reader, search string) error {
 		if isParams {
 			params = p
 		}
+		if o, isObject := record.(*Object); isObject {
+			defaultSymbols.NameObject(o)
+		}
 
 		_, isEof := record.(*Eof)
 		obj, isObject := record.(*Object)
@@ -43,13 +46,12 @@ func PrintRecords(reader *bufio.Reader, search string) error {
 		}
 		o, isOwner := record.(Owner)
 		if isOwner {
-			pointers := GetPointers(o, params)
-			for i := 0; i < len(pointers); i++ {
-				if pointers[i] != 0 {
-					a, _ := record.(Addressable)
-					address := a.GetAddress() + o.GetFields()[i]
-					fmt.Printf("  Pointer[%d]@%s = %s\n", i, Addr(address), Addr(pointers[i]))
-				}
+			// Pointers can't be read without their size and byte order,
+			// which the dump parameters usually (but needn't) come before
+			if params == nil {
+				fmt.Printf("  Pointers not shown: the dump parameters haven't been read yet\n")
+			} else {
+				printPointers(o, params)
 			}
 		}
 		if isEof {
@@ -58,3 +60,137 @@ func PrintRecords(reader *bufio.Reader, search string) error {
 	}
 	return nil
 }
+
+func printPointers(o Owner, params *DumpParams) {
+	if err := CheckFields(o, params); err != nil {
+		fmt.Printf("  Pointers not shown: %v\n", err)
+		return
+	}
+	pointers := GetPointers(o, params)
+	for i := 0; i < len(pointers); i++ {
+		if pointers[i] != 0 {
+			address := o.GetAddress() + o.GetFields()[i].Offset
+			target := Addr(pointers[i]).String()
+			if recordIndex != nil {
+				if description, found := recordIndex.Describe(pointers[i]); found {
+					target += " -> " + description
+				}
+			}
+			fmt.Printf("  Pointer[%d]@%s = %s\n", i, Addr(address), target)
+		}
+	}
+}
+
+// The orders that FindObjects can list objects in
+var findOrders = map[string]bool{"": true, "size": true, "address": true, "name": true}
+
+// The objects of one type found by FindObjects
+type foundType struct {
+	name   string
+	count  uint64
+	bytes  uint64
+	lowest uint64 // address of the first object
+}
+
+// Prints the objects whose names match the indicated regular expression, as
+// PrintRecords does, but in the indicated order: "size" (largest first),
+// "address", "name" (then address), or "" for the order they're in in the
+// dump. If aggregate is set, the objects of each type are rolled up into
+// one line with their count and total size, instead, in the same order (by
+// total size, address of the first object, or name; by default, by total
+// size).
+func FindObjects(reader Reader, search string, order string, aggregate bool) error {
+	if !findOrders[order] {
+		return fmt.Errorf("Unknown order '%s'; must be \"size\", \"address\", or \"name\"", order)
+	}
+	re, err := regexp.Compile(search)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w\n", search, err)
+	}
+	err = ReadHeader(reader)
+	if err != nil {
+		return fmt.Errorf("Reading header: %w\n", err)
+	}
+
+	var params *DumpParams
+	objects := make([]*Object, 0)
+	types := make(map[string]*foundType)
+readloop:
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return err
+		}
+		switch r := record.(type) {
+		case *Eof:
+			break readloop
+		case *DumpParams:
+			params = r
+		case *Object:
+			defaultSymbols.NameObject(r)
+			if !re.MatchString(r.Name) {
+				continue
+			}
+			if !aggregate {
+				objects = append(objects, r)
+				continue
+			}
+			t, found := types[r.GetName()]
+			if !found {
+				t = &foundType{name: r.GetName(), lowest: r.Address}
+				types[t.name] = t
+			}
+			t.count++
+			t.bytes += uint64(len(r.Contents))
+			t.lowest = min(t.lowest, r.Address)
+		}
+	}
+
+	if aggregate {
+		sorted := make([]*foundType, 0, len(types))
+		for _, t := range types {
+			sorted = append(sorted, t)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			a, b := sorted[i], sorted[j]
+			switch {
+			case order == "address":
+				return a.lowest < b.lowest
+			case order == "name":
+				return a.name < b.name
+			case a.bytes != b.bytes:
+				return a.bytes > b.bytes
+			}
+			return a.name < b.name
+		})
+		for _, t := range sorted {
+			fmt.Printf("%s: %d objects, %d bytes\n", t.name, t.count, t.bytes)
+		}
+		return nil
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		switch order {
+		case "size":
+			if len(a.Contents) != len(b.Contents) {
+				return len(a.Contents) > len(b.Contents)
+			}
+		case "name":
+			if a.GetName() != b.GetName() {
+				return a.GetName() < b.GetName()
+			}
+		case "":
+			return false
+		}
+		return a.Address < b.Address
+	})
+	if params == nil && len(objects) > 0 {
+		return fmt.Errorf("The dump has no parameters, so its pointers can't be read")
+	}
+	for _, o := range objects {
+		fmt.Printf("%s\n", o.String())
+		printPointers(o, params)
+	}
+	return nil
+}
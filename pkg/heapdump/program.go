@@ -0,0 +1,263 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// The read-only data of the program that produced a dump, which lets us
+// look inside runtime structures (such as itabs) that the dump only refers
+// to by address. It's kept by the SymbolTable the program was read into, and
+// shared by the tables cloned from it. This only works for programs that aren't position
+// independent, since we assume that the program was loaded at the addresses
+// it was linked at.
+type programImage struct {
+	byteOrder   binary.ByteOrder
+	pointerSize uint64
+	types       uint64 // runtime.types; type name offsets are relative to this
+	sections    []programSection
+	extents     [][2]uint64  // start and end of every section loaded into memory, including zero-filled ones
+	dwarf       *dwarf.Data  // nil if the program was built without debug info
+	source      *sourceIndex // lazily built from dwarf
+	sourceOnce  sync.Once
+	lines       []lineRow // lazily built from dwarf
+	linesOnce   sync.Once
+}
+
+type programSection struct {
+	address uint64
+	data    []byte
+}
+
+// Reads the sections of an ELF or Mach-O executable into the default symbol
+// table. See SymbolTable.ReadProgram.
+func ReadProgram(filename string) error {
+	return defaultSymbols.ReadProgram(filename)
+}
+
+// Reads the sections of an ELF or Mach-O executable so that the interfaces
+// named by itabs can be identified, along with its debug info (if any) so
+// that pointers can be traced back to source code. Tables cloned from this
+// one afterward share what was read.
+func (t *SymbolTable) ReadProgram(filename string) error {
+	image, err := readElf(filename)
+	if err != nil {
+		image, err = readMacho(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("Reading program '%s': not an ELF or Mach-O executable", filename)
+	}
+	if image.types == 0 {
+		return fmt.Errorf("Reading program '%s': could not find runtime.types", filename)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.program = image
+	return nil
+}
+
+// Returns the program read into the table, if any.
+func (t *SymbolTable) image() *programImage {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.program
+}
+
+func readElf(filename string) (*programImage, error) {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	image := &programImage{byteOrder: f.ByteOrder, pointerSize: 8}
+	if f.Class == elf.ELFCLASS32 {
+		image.pointerSize = 4
+	}
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		image.extents = append(image.extents, [2]uint64{s.Addr, s.Addr + s.Size})
+		if s.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		image.sections = append(image.sections, programSection{s.Addr, data})
+	}
+	symbols, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range symbols {
+		if s.Name == "runtime.types" {
+			image.types = s.Value
+		}
+	}
+	image.dwarf, _ = f.DWARF()
+	return image, nil
+}
+
+func readMacho(filename string) (*programImage, error) {
+	f, err := macho.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	image := &programImage{byteOrder: f.ByteOrder, pointerSize: 8}
+	if f.Magic == macho.Magic32 {
+		image.pointerSize = 4
+	}
+	for _, s := range f.Sections {
+		image.extents = append(image.extents, [2]uint64{s.Addr, s.Addr + s.Size})
+		if s.Flags&0xff == 1 { // S_ZEROFILL
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		image.sections = append(image.sections, programSection{s.Addr, data})
+	}
+	if f.Symtab != nil {
+		for _, s := range f.Symtab.Syms {
+			// Mach-O prefixes C symbols with an underscore
+			if s.Name == "runtime.types" || s.Name == "_runtime.types" {
+				image.types = s.Value
+			}
+		}
+	}
+	image.dwarf, _ = f.DWARF()
+	return image, nil
+}
+
+// Returns the program's bytes starting at the indicated address.
+func (p *programImage) bytes(address uint64) []byte {
+	for _, s := range p.sections {
+		if address >= s.address && address < s.address+uint64(len(s.data)) {
+			return s.data[address-s.address:]
+		}
+	}
+	return nil
+}
+
+func (p *programImage) word(address uint64) (uint64, bool) {
+	b := p.bytes(address)
+	if uint64(len(b)) < p.pointerSize {
+		return 0, false
+	}
+	if p.pointerSize == 4 {
+		return uint64(p.byteOrder.Uint32(b)), true
+	}
+	return p.byteOrder.Uint64(b), true
+}
+
+// Returns the name of the runtime type descriptor at the indicated address.
+// The name is stored as an offset from runtime.types to a flags byte,
+// followed by a varint length and the name itself.
+func (p *programImage) typeName(address uint64) (string, bool) {
+	// The offset follows Size_, PtrBytes, Hash, TFlag, Align_, FieldAlign_,
+	// Kind_, Equal, and GCData in abi.Type.
+	header := p.bytes(address)
+	strOffset := 4*p.pointerSize + 8
+	if uint64(len(header)) < strOffset+4 {
+		return "", false
+	}
+	tflag := header[2*p.pointerSize+4]
+	nameOffset := p.byteOrder.Uint32(header[strOffset:])
+
+	b := p.bytes(p.types + uint64(nameOffset))
+	if len(b) < 2 {
+		return "", false
+	}
+	length, n := binary.Uvarint(b[1:])
+	if n <= 0 || uint64(len(b)) < 1+uint64(n)+length {
+		return "", false
+	}
+	name := string(b[1+n : 1+uint64(n)+length])
+
+	// Types with TFlagExtraStar share their name with the pointer to
+	// them, so the name has an extra leading star.
+	if tflag&(1<<1) != 0 && len(name) > 0 && name[0] == '*' {
+		name = name[1:]
+	}
+	return name, true
+}
+
+// Returns the names of the interface and the concrete type for the itab at
+// the indicated address, from the program read into the default symbol
+// table. See SymbolTable.GetItabNames.
+func GetItabNames(itab uint64) (iface string, concrete string, ok bool) {
+	return defaultSymbols.GetItabNames(itab)
+}
+
+// Returns the names of the interface and the concrete type for the itab at
+// the indicated address. This requires that ReadProgram has been called,
+// and only works for itabs generated by the compiler; those created by the
+// runtime at run time don't live in the program image.
+func (t *SymbolTable) GetItabNames(itab uint64) (iface string, concrete string, ok bool) {
+	program := t.image()
+	if program == nil {
+		return "", "", false
+	}
+	interfaceType, ok := program.word(itab)
+	if !ok {
+		return "", "", false
+	}
+	concreteType, ok := program.word(itab + program.pointerSize)
+	if !ok {
+		return "", "", false
+	}
+	iface, ok = program.typeName(interfaceType)
+	if !ok {
+		return "", "", false
+	}
+	concrete, ok = program.typeName(concreteType)
+	return iface, concrete, ok
+}
+
+// Reports whether the indicated address is in the memory the program read
+// into the default symbol table was loaded into. See SymbolTable.InProgram.
+func InProgram(address uint64) bool {
+	return defaultSymbols.InProgram(address)
+}
+
+// Reports whether the indicated address is in the memory the program was
+// loaded into: its code, read-only data, or variables. This is always false
+// if ReadProgram hasn't been called.
+func (t *SymbolTable) InProgram(address uint64) bool {
+	program := t.image()
+	if program == nil {
+		return false
+	}
+	for _, e := range program.extents {
+		if address >= e[0] && address < e[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name of the runtime type descriptor at the indicated address,
+// from the program read into the default symbol table.
+func GetTypeName(address uint64) (string, bool) {
+	return defaultSymbols.GetTypeName(address)
+}
+
+// Returns the name of the runtime type descriptor at the indicated address.
+// This requires that ReadProgram has been called.
+func (t *SymbolTable) GetTypeName(address uint64) (string, bool) {
+	program := t.image()
+	if program == nil {
+		return "", false
+	}
+	return program.typeName(address)
+}
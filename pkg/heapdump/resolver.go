@@ -0,0 +1,205 @@
+package heapdump
+
+import (
+	"strings"
+)
+
+// What a name found for an address names
+type SymbolKind int
+
+const (
+	UnknownSymbol SymbolKind = iota // named with AddName, which doesn't say
+	ObjectSymbol                    // a heap object, named after its OID
+	TypeSymbol                      // a runtime type descriptor
+	FuncSymbol                      // a function's code
+	GlobalSymbol                    // a global variable (or other data in the program)
+	ItabSymbol                      // an itab the linker generated, named for its concrete type and interface
+)
+
+var symbolKindNames = []string{"unknown", "object", "type", "func", "global", "itab"}
+
+func (k SymbolKind) String() string {
+	if int(k) < len(symbolKindNames) {
+		return symbolKindNames[k]
+	}
+	return "unknown"
+}
+
+// What an address was resolved to
+type Resolution struct {
+	Name   string
+	Kind   SymbolKind
+	Offset uint64 // how far past the start of what's named the address is
+	Source string // where it's declared, if known: the file of a function, or the line of a type or global
+}
+
+// Names addresses. Every printer and renderer names things through one of
+// these, so that an address is named the same way wherever it's shown;
+// SymbolTable is the implementation, and is safe to use from several
+// goroutines at once.
+type Resolver interface {
+	// Names what's at, or contains, the indicated address: an object
+	// named after its OID or a type descriptor, at the address itself, or
+	// a function or global variable that the address is in.
+	Resolve(address uint64) (Resolution, bool)
+	// Names the type whose descriptor is at the indicated address, for
+	// addresses known to be type descriptors, such as the types of
+	// finalizers and panics.
+	ResolveType(address uint64) (string, bool)
+}
+
+var _ Resolver = (*SymbolTable)(nil)
+
+func (t *SymbolTable) Resolve(address uint64) (Resolution, bool) {
+	return t.resolve(address, true)
+}
+
+// Resolves an address, finding where what it names is declared only if
+// asked to, since that can mean parsing source.
+func (t *SymbolTable) resolve(address uint64, withSource bool) (Resolution, bool) {
+	t.mutex.RLock()
+	name, found := t.names[address]
+	kind := t.kinds[address]
+	t.mutex.RUnlock()
+	if found && kind == TypeSymbol {
+		typeName, found := t.ResolveType(address)
+		if found {
+			name = typeName
+		}
+		r := Resolution{Name: name, Kind: TypeSymbol}
+		if withSource {
+			r.Source = t.typeSource(name)
+		}
+		return r, true
+	}
+	offset := uint64(0)
+	if !found {
+		// Only functions and globals have addresses inside them that
+		// can be named; the names of objects and types are only good for
+		// their own addresses.
+		name, offset, found = t.NearestSymbol(address)
+		if !found {
+			return Resolution{}, false
+		}
+		t.mutex.RLock()
+		kind = t.kinds[address-offset]
+		t.mutex.RUnlock()
+		if kind == ObjectSymbol || kind == TypeSymbol {
+			return Resolution{}, false
+		}
+	}
+	r := Resolution{Name: name, Kind: kind, Offset: offset}
+	if s := t.sources(); s != nil && withSource {
+		switch kind {
+		case FuncSymbol:
+			if f, found := s.functions[name]; found && len(f.file) > 0 {
+				r.Source = f.file
+			}
+		case GlobalSymbol, UnknownSymbol:
+			pkg, local := splitQualifiedName(name)
+			if pos, found := s.declaration(pkg, local); found {
+				r.Source = sourceLink(pos.Filename, pos.Line)
+			}
+		}
+	}
+	return r, true
+}
+
+func (t *SymbolTable) ResolveType(address uint64) (string, bool) {
+	if name, found := t.GetTypeName(address); found {
+		return CanonicalTypeName(name), true
+	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.kinds[address] != TypeSymbol {
+		return "", false
+	}
+	name, found := t.names[address]
+	return name, found
+}
+
+// Names the interface and the concrete type of the itab at the indicated
+// address: from the symbol the linker gave it, such as
+// "go:itab.*main.conn,io.Reader", if the program's symbols include one, or
+// else from the itab itself, if the program has been read (see
+// GetItabNames).
+func (t *SymbolTable) ResolveItab(address uint64) (iface string, concrete string, ok bool) {
+	t.mutex.RLock()
+	name, found := t.names[address]
+	kind := t.kinds[address]
+	t.mutex.RUnlock()
+	if found && kind == ItabSymbol {
+		iface, concrete, ok = parseItabSymbol(name)
+		if ok {
+			return iface, concrete, true
+		}
+	}
+	return t.GetItabNames(address)
+}
+
+// Splits the name of an itab symbol into its interface and concrete type.
+// The type arguments of either can have commas of their own, so the comma
+// between them is the only one outside of brackets.
+func parseItabSymbol(name string) (iface string, concrete string, ok bool) {
+	name, found := strings.CutPrefix(name, "go:itab.")
+	if !found {
+		name, found = strings.CutPrefix(name, "go.itab.")
+	}
+	if !found {
+		return "", "", false
+	}
+	depth := 0
+	for i, r := range name {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				return name[i+1:], name[:i], i > 0 && i < len(name)-1
+			}
+		}
+	}
+	return "", "", false
+}
+
+// Names a type found by its descriptor in the dump (see TypeDescriptor), so
+// that it can be resolved.
+func (t *SymbolTable) AddType(address uint64, name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.names[address] = name
+	t.kinds[address] = TypeSymbol
+	t.sorted = nil
+}
+
+// Returns where the named type is declared, if it's known.
+func (t *SymbolTable) typeSource(name string) string {
+	s := t.sources()
+	if s == nil {
+		return ""
+	}
+	pkg, local := splitQualifiedName(strings.TrimLeft(name, "*"))
+	if pos, found := s.declaration(pkg, local); found {
+		return sourceLink(pos.Filename, pos.Line)
+	}
+	return ""
+}
+
+// Returns the kind of symbol that "go tool nm" describes with the
+// indicated letter.
+func nmSymbolKind(letter string, name string) SymbolKind {
+	switch {
+	case letter == "T" || letter == "t":
+		// Including the generated functions named "type:.eq..."
+		return FuncSymbol
+	case strings.HasPrefix(name, "type:") || strings.HasPrefix(name, "type."):
+		return TypeSymbol
+	case strings.HasPrefix(name, "go:itab.") || strings.HasPrefix(name, "go.itab."):
+		return ItabSymbol
+	case letter == "D" || letter == "d" || letter == "B" || letter == "b" || letter == "R" || letter == "r":
+		return GlobalSymbol
+	}
+	return UnknownSymbol
+}
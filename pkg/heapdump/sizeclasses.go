@@ -0,0 +1,101 @@
+package heapdump
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// The sizes that the Go runtime rounds small allocations up to, as of Go
+// 1.12, which added the 24-byte class. The table is the same for every
+// architecture.
+var sizeClasses = []uint64{
+	8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224,
+	240, 256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768,
+	896, 1024, 1152, 1280, 1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200,
+	3456, 4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240,
+	10880, 12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576,
+	27264, 28672, 32768,
+}
+
+// Allocations larger than the biggest size class are rounded up to a
+// multiple of the runtime's page size.
+const pageSize = 8192
+
+// SizeClasses describes how the runtime that produced a dump rounds up the
+// size of each allocation.
+type SizeClasses struct {
+	Version string   // Go version of the runtime, e.g., "go1.22.3", if known
+	Classes []uint64 // Size of each class, from smallest to largest
+}
+
+var goVersion = regexp.MustCompile(`^go1\.(\d+)`)
+
+var fallbackGoVersion string
+
+// Sets the Go version to assume for dumps that don't record one, such as
+// the version in a dump's metadata file (see package capture).
+func SetGoVersion(version string) {
+	fallbackGoVersion = version
+}
+
+// Returns the size classes used by the runtime that produced the dump.
+// Dumps record the Go version that wrote them; if that can't be parsed,
+// the version set by SetGoVersion is used, and failing that, the current
+// table is assumed.
+func GetSizeClasses(params *DumpParams) *SizeClasses {
+	s := &SizeClasses{Classes: sizeClasses}
+	version := fallbackGoVersion
+	if params != nil && goVersion.MatchString(params.GoExperiment) {
+		version = params.GoExperiment
+	}
+	m := goVersion.FindStringSubmatch(version)
+	if m == nil {
+		return s
+	}
+	s.Version = version
+	minor, _ := strconv.Atoi(m[1])
+	if minor < 12 {
+		s.Classes = make([]uint64, 0, len(sizeClasses)-1)
+		for _, size := range sizeClasses {
+			if size != 24 {
+				s.Classes = append(s.Classes, size)
+			}
+		}
+	}
+	return s
+}
+
+// Returns the number of bytes that the runtime actually allocates for a
+// request of the indicated size.
+func (s *SizeClasses) RoundUp(size uint64) uint64 {
+	if size > s.Classes[len(s.Classes)-1] {
+		return (size + pageSize - 1) / pageSize * pageSize
+	}
+	for _, class := range s.Classes {
+		if size <= class {
+			return class
+		}
+	}
+	return size
+}
+
+// Returns the largest allocation size that is smaller than the indicated
+// size, i.e., how small something would need to be for less to be
+// allocated for it. Returns zero if there's no smaller size.
+func (s *SizeClasses) Previous(size uint64) uint64 {
+	allocated := s.RoundUp(size)
+	if allocated > s.Classes[len(s.Classes)-1] {
+		if allocated-pageSize > s.Classes[len(s.Classes)-1] {
+			return allocated - pageSize
+		}
+		return s.Classes[len(s.Classes)-1]
+	}
+	previous := uint64(0)
+	for _, class := range s.Classes {
+		if class >= allocated {
+			break
+		}
+		previous = class
+	}
+	return previous
+}
@@ -0,0 +1,127 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// A slice header in a record, found by the record's type in the program's
+// debug info: the pointer to the slice's backing array, then its length,
+// then its capacity, each a word long.
+type SliceHeader struct {
+	Offset   uint64 // of the header, from the start of the record
+	Path     string // the field or variable holding the slice, e.g., "session.buf" or "queue[3].items"
+	ElemSize uint64 // the size of each element of the slice
+}
+
+// Returns the slice headers in a record: the slice-typed fields of an
+// object (if it's been named after a struct type, or an array of one), the
+// slice-typed local variables of a stack frame, and the slice-typed global
+// variables in a segment. Slices of zero-sized elements are left out.
+//
+// This requires that ReadProgram has been called on a program built with
+// debug info; this uses the program read into the default symbol table.
+func GetSliceHeaders(r Record) []SliceHeader {
+	return defaultSymbols.GetSliceHeaders(r)
+}
+
+// Returns the slice headers in a record, as GetSliceHeaders does, using the
+// program read into this table.
+func (t *SymbolTable) GetSliceHeaders(r Record) []SliceHeader {
+	headers := make([]SliceHeader, 0)
+	t.recordTypes(r, func(t dwarf.Type, offset uint64, path string) {
+		headers = sliceHeaders(t, offset, path, headers)
+	})
+	return headers
+}
+
+// Calls the indicated function with the type, offset, and name of each
+// value in a record whose type is known from the program's debug info: the
+// object itself (if it's been named after a struct type, or each element,
+// if it's an array of one), each local variable of a stack frame, and each
+// global variable in a segment.
+func (t *SymbolTable) recordTypes(r Record, visit func(t dwarf.Type, offset uint64, path string)) {
+	s := t.sources()
+	if s == nil {
+		return
+	}
+	switch o := r.(type) {
+	case *Object:
+		name := strings.TrimPrefix(o.Name, "*")
+		t, found := s.structs[name]
+		if !found || t.Size() <= 0 {
+			break
+		}
+		_, typeName := splitQualifiedName(name)
+		size := uint64(t.Size())
+		count := uint64(len(o.Contents)) / size
+		for i := uint64(0); i < count; i++ {
+			path := typeName
+			// Objects bigger than their type are arrays of it
+			if count > 1 {
+				path += fmt.Sprintf("[%d]", i)
+			}
+			visit(t, i*size, path)
+		}
+	case *StackFrame:
+		f, found := s.functions[o.Name]
+		if !found {
+			break
+		}
+		for _, local := range f.locals {
+			offset := int64(len(o.Contents)) + local.offset
+			if offset >= 0 && offset+local.typ.Size() <= int64(len(o.Contents)) {
+				visit(local.typ, uint64(offset), local.name)
+			}
+		}
+	case Owner:
+		// Segments and globals
+		start, end := o.GetAddress(), o.GetAddress()+uint64(len(o.GetContents()))
+		for _, g := range s.globals {
+			if g.address >= start && g.address+uint64(g.typ.Size()) <= end {
+				_, name := splitQualifiedName(g.name)
+				visit(g.typ, g.address-start, name)
+			}
+		}
+	}
+}
+
+// Appends the slice headers in a value of the indicated type, at the
+// indicated offset, to a list of them.
+func sliceHeaders(t dwarf.Type, offset uint64, path string, headers []SliceHeader) []SliceHeader {
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return sliceHeaders(t.Type, offset, path, headers)
+	case *dwarf.StructType:
+		if strings.HasPrefix(t.StructName, "[]") {
+			// Go describes slices as structs of array, len, and cap
+			if len(t.Field) != 3 {
+				return headers
+			}
+			array, isPointer := t.Field[0].Type.(*dwarf.PtrType)
+			if isPointer && array.Type.Size() > 0 {
+				headers = append(headers, SliceHeader{Offset: offset, Path: path, ElemSize: uint64(array.Type.Size())})
+			}
+			return headers
+		}
+		for _, f := range t.Field {
+			headers = sliceHeaders(f.Type, offset+uint64(f.ByteOffset), path+"."+f.Name, headers)
+		}
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		switch t.Type.(type) {
+		case *dwarf.StructType, *dwarf.TypedefType, *dwarf.ArrayType:
+		default:
+			// Nothing else can have slices in it
+			return headers
+		}
+		if size <= 0 {
+			return headers
+		}
+		for i := int64(0); i < t.Count; i++ {
+			headers = sliceHeaders(t.Type, offset+uint64(i*size), fmt.Sprintf("%s[%d]", path, i), headers)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,416 @@
+package heapdump
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// What we know about the program's source, gathered from its debug info.
+// Go's debug info records where functions and their local variables are
+// declared, but not where types, struct fields, or globals are; for those,
+// we parse the source files that the debug info lists for each package.
+type sourceIndex struct {
+	structs   map[string]*dwarf.StructType
+	functions map[string]*sourceFunction
+	globals   []sourceGlobal
+	packages  map[string][]string                  // import path -> source files
+	decls     map[string]map[string]token.Position // import path -> declarations, parsed on demand
+	mutex     sync.Mutex                           // guards decls
+}
+
+type sourceFunction struct {
+	file   string
+	locals []sourceLocal
+}
+
+type sourceLocal struct {
+	name   string
+	line   int64
+	offset int64 // from the canonical frame address
+	typ    dwarf.Type
+}
+
+// A global variable, at a fixed address
+type sourceGlobal struct {
+	name    string
+	address uint64
+	typ     dwarf.Type
+}
+
+// DWARF expression opcode for an offset from the frame base, which Go sets
+// to the canonical frame address.
+const dwOpFbreg = 0x91
+
+// DWARF expression opcode for a fixed address
+const dwOpAddr = 0x03
+
+// Describes the source declaration of whatever holds the pointer at the
+// indicated offset in a record: a struct field of an object (if the object
+// has been named after a struct type), a local variable of a stack frame, or
+// a global variable in a data or BSS segment. For example, "holder.b at
+// /src/app/main.go:14" (or a link to it; see SetLinkFormat).
+//
+// This requires that ReadProgram has been called on a program built with
+// debug info. Struct fields and globals are found by parsing the program's
+// source, so they are only located if the source is still where it was when
+// the program was built.
+func GetSourceLocation(r Record, offset uint64) (string, bool) {
+	return defaultSymbols.GetSourceLocation(r, offset)
+}
+
+// Describes the source declaration of whatever holds the pointer at the
+// indicated offset in a record, as GetSourceLocation does, using the names
+// of globals in this table.
+func (t *SymbolTable) GetSourceLocation(r Record, offset uint64) (string, bool) {
+	s := t.sources()
+	if s == nil {
+		return "", false
+	}
+
+	switch o := r.(type) {
+	case *Object:
+		name := strings.TrimPrefix(o.Name, "*")
+		t, found := s.structs[name]
+		if !found || t.Size() <= 0 {
+			return "", false
+		}
+		path := ""
+		// Objects bigger than their type are arrays of it
+		if uint64(len(o.Contents)) >= 2*uint64(t.Size()) {
+			path = fmt.Sprintf("[%d]", offset/uint64(t.Size()))
+			offset %= uint64(t.Size())
+		}
+		fields := fieldPath(t, int64(offset))
+		if len(fields) == 0 {
+			return "", false
+		}
+		pkg, typeName := splitQualifiedName(name)
+		path = typeName + path + joinFieldPath(fields)
+		pos, found := s.declaration(pkg, typeName+"."+fields[0])
+		if !found {
+			return path, true
+		}
+		return fmt.Sprintf("%s at %s", path, sourceLink(pos.Filename, pos.Line)), true
+
+	case *StackFrame:
+		f, found := s.functions[o.Name]
+		if !found {
+			return "", false
+		}
+		cfaOffset := int64(offset) - int64(len(o.Contents))
+		for _, local := range f.locals {
+			size := local.typ.Size()
+			if cfaOffset < local.offset || cfaOffset >= local.offset+size {
+				continue
+			}
+			path := local.name + joinFieldPath(fieldPath(local.typ, cfaOffset-local.offset))
+			return fmt.Sprintf("%s at %s", path, sourceLink(f.file, int(local.line))), true
+		}
+
+	case *DataSegment:
+		return s.global(t, o.Address+offset)
+	case *BssSegment:
+		return s.global(t, o.Address+offset)
+	case *Global:
+		return s.global(t, o.Address+offset)
+	}
+	return "", false
+}
+
+// Returns the size of the named struct type, from the debug info of the
+// program read into the default symbol table. See SymbolTable.GetTypeSize.
+func GetTypeSize(name string) (uint64, bool) {
+	return defaultSymbols.GetTypeSize(name)
+}
+
+// Returns the size of the named struct type (e.g., "main.Session"), as
+// recorded in the program's debug info. This requires that ReadProgram has
+// been called on a program built with debug info.
+func (t *SymbolTable) GetTypeSize(name string) (uint64, bool) {
+	s := t.sources()
+	if s == nil {
+		return 0, false
+	}
+	st, found := s.structs[strings.TrimPrefix(name, "*")]
+	if !found || st.Size() <= 0 {
+		return 0, false
+	}
+	return uint64(st.Size()), true
+}
+
+// Returns the index of the program's source, building it the first time
+// it's needed, or nil if there is no program with debug info.
+func (t *SymbolTable) sources() *sourceIndex {
+	program := t.image()
+	if program == nil || program.dwarf == nil {
+		return nil
+	}
+	program.sourceOnce.Do(func() {
+		program.source = newSourceIndex(program)
+	})
+	return program.source
+}
+
+func (s *sourceIndex) global(t *SymbolTable, address uint64) (string, bool) {
+	names := t.SymbolsAt(address)
+	for _, name := range names {
+		pkg, local := splitQualifiedName(name)
+		pos, found := s.declaration(pkg, local)
+		if found {
+			return fmt.Sprintf("%s at %s", local, sourceLink(pos.Filename, pos.Line)), true
+		}
+	}
+	return "", false
+}
+
+func newSourceIndex(program *programImage) *sourceIndex {
+	d := program.dwarf
+	s := &sourceIndex{
+		structs:   make(map[string]*dwarf.StructType),
+		functions: make(map[string]*sourceFunction),
+		packages:  make(map[string][]string),
+		decls:     make(map[string]map[string]token.Position),
+	}
+
+	// The line table of a compilation unit also lists files from other
+	// packages whose functions were inlined, so a package's own files are
+	// those in the same directory as its functions.
+	var unit string
+	var files []*dwarf.LineFile
+	dirs := make(map[string]bool)
+	finishUnit := func() {
+		for _, f := range files {
+			if f != nil && strings.HasSuffix(f.Name, ".go") && dirs[filepath.Dir(f.Name)] {
+				s.packages[unit] = append(s.packages[unit], f.Name)
+			}
+		}
+	}
+
+	var function *sourceFunction
+	reader := d.Reader()
+	depth := 0
+	for {
+		e, err := reader.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if e.Tag == 0 {
+			depth--
+			continue
+		}
+		if depth <= 1 {
+			function = nil
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+
+		switch {
+		case e.Tag == dwarf.TagCompileUnit:
+			finishUnit()
+			unit = name
+			files = nil
+			dirs = make(map[string]bool)
+			if lr, err := d.LineReader(e); err == nil && lr != nil {
+				files = lr.Files()
+			}
+		case depth == 1 && e.Tag == dwarf.TagStructType && len(name) > 0:
+			if t, err := d.Type(e.Offset); err == nil {
+				if st, isStruct := t.(*dwarf.StructType); isStruct {
+					s.structs[name] = st
+				}
+			}
+		case depth == 1 && e.Tag == dwarf.TagSubprogram && len(name) > 0:
+			function = &sourceFunction{}
+			if i, ok := e.Val(dwarf.AttrDeclFile).(int64); ok && i >= 0 && int(i) < len(files) && files[i] != nil {
+				function.file = files[i].Name
+				dirs[filepath.Dir(function.file)] = true
+			}
+			s.functions[name] = function
+		case depth == 1 && e.Tag == dwarf.TagVariable && len(name) > 0:
+			location, ok := e.Val(dwarf.AttrLocation).([]byte)
+			if !ok || uint64(len(location)) != 1+program.pointerSize || location[0] != dwOpAddr {
+				break
+			}
+			typeOffset, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if !ok {
+				break
+			}
+			t, err := d.Type(typeOffset)
+			if err != nil {
+				break
+			}
+			var address uint64
+			if program.pointerSize == 4 {
+				address = uint64(program.byteOrder.Uint32(location[1:]))
+			} else {
+				address = program.byteOrder.Uint64(location[1:])
+			}
+			s.globals = append(s.globals, sourceGlobal{name: name, address: address, typ: t})
+		case function != nil && (e.Tag == dwarf.TagVariable || e.Tag == dwarf.TagFormalParameter) && len(name) > 0:
+			// Only variables with a fixed place in the frame can be
+			// identified; those that move around have location lists.
+			location, ok := e.Val(dwarf.AttrLocation).([]byte)
+			if !ok || len(location) < 2 || location[0] != dwOpFbreg {
+				break
+			}
+			offset, n := readSleb128(location[1:])
+			typeOffset, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if n <= 0 || !ok {
+				break
+			}
+			t, err := d.Type(typeOffset)
+			if err != nil {
+				break
+			}
+			line, _ := e.Val(dwarf.AttrDeclLine).(int64)
+			function.locals = append(function.locals, sourceLocal{name: name, line: line, offset: offset, typ: t})
+		}
+
+		if e.Children {
+			depth++
+		}
+	}
+	finishUnit()
+	return s
+}
+
+// Decodes a signed LEB128 number, returning it and the number of bytes it
+// took up (or zero, if it was truncated).
+func readSleb128(b []byte) (int64, int) {
+	var result int64
+	var shift uint
+	for i, c := range b {
+		result |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// Returns the names of the fields (and array indices) that contain the
+// indicated offset into a value of the indicated type.
+func fieldPath(t dwarf.Type, offset int64) []string {
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return fieldPath(t.Type, offset)
+	case *dwarf.StructType:
+		for _, f := range t.Field {
+			if offset >= f.ByteOffset && offset < f.ByteOffset+f.Type.Size() {
+				return append([]string{f.Name}, fieldPath(f.Type, offset-f.ByteOffset)...)
+			}
+		}
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		if size > 0 {
+			return append([]string{fmt.Sprintf("[%d]", offset/size)}, fieldPath(t.Type, offset%size)...)
+		}
+	}
+	return nil
+}
+
+func joinFieldPath(fields []string) string {
+	var b strings.Builder
+	for _, f := range fields {
+		if !strings.HasPrefix(f, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(f)
+	}
+	return b.String()
+}
+
+// Splits a name like "github.com/a/b.T[int]" into its import path and the
+// name within the package, without any type arguments.
+func splitQualifiedName(name string) (string, string) {
+	if bracket := strings.IndexByte(name, '['); bracket >= 0 {
+		name = name[:bracket]
+	}
+	start := strings.LastIndexByte(name, '/') + 1
+	dot := strings.IndexByte(name[start:], '.')
+	if dot < 0 {
+		return "", name
+	}
+	return name[:start+dot], name[start+dot+1:]
+}
+
+// Returns where the named global, type, or struct field ("Type.field") of
+// the indicated package is declared.
+func (s *sourceIndex) declaration(pkg string, name string) (token.Position, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	decls, parsed := s.decls[pkg]
+	if !parsed {
+		decls = parseDeclarations(s.packages[pkg])
+		s.decls[pkg] = decls
+	}
+	pos, found := decls[name]
+	return pos, found
+}
+
+func parseDeclarations(files []string) map[string]token.Position {
+	decls := make(map[string]token.Position)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			Logger().Debug("Could not parse source", "file", file, "error", err)
+			continue
+		}
+		for _, decl := range f.Decls {
+			gen, isGen := decl.(*ast.GenDecl)
+			if !isGen {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						decls[n.Name] = fset.Position(n.Pos())
+					}
+				case *ast.TypeSpec:
+					decls[spec.Name.Name] = fset.Position(spec.Name.Pos())
+					st, isStruct := spec.Type.(*ast.StructType)
+					if !isStruct {
+						continue
+					}
+					for _, field := range st.Fields.List {
+						if len(field.Names) == 0 {
+							decls[spec.Name.Name+"."+embeddedName(field.Type)] = fset.Position(field.Type.Pos())
+						}
+						for _, n := range field.Names {
+							decls[spec.Name.Name+"."+n.Name] = fset.Position(n.Pos())
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// Returns the field name that an embedded type is known by.
+func embeddedName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.IndexExpr:
+		return embeddedName(e.X)
+	case *ast.IndexListExpr:
+		return embeddedName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package heapdump
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Counts of the records read from dumps, with the bytes they took up in the
+// file and the time spent parsing them, by record type. Byte totals are
+// only kept for readers that know how much of the dump remains (such as
+// FileReader and MmapReader).
+type Stats struct {
+	mutex    sync.Mutex
+	types    map[string]*RecordStats
+	duration time.Duration
+}
+
+type RecordStats struct {
+	Name     string        `json:"name"`
+	Count    uint64        `json:"count"`
+	Bytes    uint64        `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+func NewStats() *Stats {
+	return &Stats{types: make(map[string]*RecordStats)}
+}
+
+var stats *Stats
+
+// Sets the accumulator that ReadRecord adds every record it reads to; nil,
+// the default, turns off the accounting. Several dumps can be read into the
+// same Stats.
+func SetStats(s *Stats) {
+	stats = s
+}
+
+func (s *Stats) add(record Record, bytes uint64, elapsed time.Duration) {
+	name := recordName(record)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, found := s.types[name]
+	if !found {
+		r = &RecordStats{Name: name}
+		s.types[name] = r
+	}
+	r.Count++
+	r.Bytes += bytes
+	r.Duration += elapsed
+	s.duration += elapsed
+}
+
+// Returns the statistics for each record type, those that took the longest
+// to parse first.
+func (s *Stats) Records() []RecordStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]RecordStats, 0, len(s.types))
+	for _, r := range s.types {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Duration != list[j].Duration {
+			return list[i].Duration > list[j].Duration
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Returns the total time spent parsing records.
+func (s *Stats) Duration() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.duration
+}
+
+func (s *Stats) Print(w io.Writer) {
+	records := s.Records()
+	var count, bytes uint64
+	for _, r := range records {
+		count += r.Count
+		bytes += r.Bytes
+	}
+	fmt.Fprintf(w, "Parse statistics:\n")
+	fmt.Fprintf(w, "  %-22s %10s %14s %12s\n", "Record type", "Count", "Bytes", "Time")
+	for _, r := range records {
+		fmt.Fprintf(w, "  %-22s %10d %14d %12v\n", r.Name, r.Count, r.Bytes, r.Duration.Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "  %-22s %10d %14d %12v\n", "total", count, bytes, s.Duration().Round(time.Microsecond))
+}
@@ -0,0 +1,291 @@
+package heapdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A SymbolTable holds the names known for a dump: names of symbols in the
+// program that wrote it, and the OIDs that objects are named by, along with
+// the program itself, if it's been read (see ReadProgram). Each dump being
+// analyzed can have its own table, so that several can be worked on at
+// once; it's safe to use a table from several goroutines at once. What
+// ReadRecord counts (see SetStats and SkippedRecords) and the index that
+// PrintRecords describes pointers with (see SetRecordIndex) aren't kept by
+// a table, though, and are shared by every dump read.
+type SymbolTable struct {
+	mutex   sync.RWMutex
+	names   map[uint64]string     // address -> name
+	kinds   map[uint64]SymbolKind // address -> what's named there
+	oids    map[uint64]string     // OID -> object name
+	symbols map[string]uint64     // symbol name -> address
+	sorted  []uint64              // addresses of names, lazily sorted for NearestSymbol
+	program *programImage         // the program's image and debug info, if ReadProgram has been called
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		names:   make(map[uint64]string),
+		kinds:   make(map[uint64]SymbolKind),
+		oids:    make(map[uint64]string),
+		symbols: make(map[string]uint64),
+	}
+}
+
+var defaultSymbols = NewSymbolTable()
+
+// Returns a copy of the table, which can go on to get names of its own
+// (such as those of the objects in another dump) without affecting this
+// one. Reading a program's symbols and OIDs into one table and then
+// cloning it for each dump saves reading them again.
+func (t *SymbolTable) Clone() *SymbolTable {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	c := &SymbolTable{
+		names:   make(map[uint64]string, len(t.names)),
+		kinds:   make(map[uint64]SymbolKind, len(t.kinds)),
+		oids:    make(map[uint64]string, len(t.oids)),
+		symbols: make(map[string]uint64, len(t.symbols)),
+		program: t.program,
+	}
+	for addr, name := range t.names {
+		c.names[addr] = name
+	}
+	for addr, kind := range t.kinds {
+		c.kinds[addr] = kind
+	}
+	for oid, name := range t.oids {
+		c.oids[oid] = name
+	}
+	for name, addr := range t.symbols {
+		c.symbols[name] = addr
+	}
+	return c
+}
+
+// Returns the table used by the package-level naming functions, and for
+// the addresses shown by the String methods of records.
+func DefaultSymbols() *SymbolTable {
+	return defaultSymbols
+}
+
+func (t *SymbolTable) AddOid(oid uint64, name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.oids[oid] = name
+}
+
+func (t *SymbolTable) AddName(addr uint64, name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.names[addr] = name
+	delete(t.kinds, addr)
+	t.sorted = nil
+}
+
+func (t *SymbolTable) GetName(addr uint64) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.names[addr]
+}
+
+// Returns the address of the named symbol, if known.
+func (t *SymbolTable) LookupName(name string) (uint64, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	addr, found := t.symbols[name]
+	return addr, found
+}
+
+// Returns the addresses of every symbol whose name matches the indicated
+// regular expression.
+func (t *SymbolTable) MatchSymbols(re *regexp.Regexp) []uint64 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	addrs := make([]uint64, 0)
+	for name, addr := range t.symbols {
+		if re.MatchString(name) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Returns the names of every symbol at the indicated address. Markers like
+// runtime.bss share their address with the first real variable after them,
+// so there can be more than one; the name returned by GetName comes first.
+func (t *SymbolTable) SymbolsAt(addr uint64) []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	names := make([]string, 0, 1)
+	first, found := t.names[addr]
+	if found {
+		names = append(names, first)
+	}
+	for name, a := range t.symbols {
+		if a == addr && name != first {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns the name at or closest below the indicated address, along with
+// how far past it the address is; for example, a pointer in the middle of
+// a global struct is found as the global plus an offset.
+func (t *SymbolTable) NearestSymbol(addr uint64) (string, uint64, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sort()
+	i := sort.Search(len(t.sorted), func(i int) bool { return t.sorted[i] > addr }) - 1
+	if i < 0 {
+		return "", 0, false
+	}
+	return t.names[t.sorted[i]], addr - t.sorted[i], true
+}
+
+// Returns the addresses of every name from start up to (but not including)
+// end, in order.
+func (t *SymbolTable) NamesBetween(start, end uint64) []uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sort()
+	first := sort.Search(len(t.sorted), func(i int) bool { return t.sorted[i] >= start })
+	last := sort.Search(len(t.sorted), func(i int) bool { return t.sorted[i] >= end })
+	return append([]uint64(nil), t.sorted[first:last]...)
+}
+
+// Sorts the addresses of names, if they haven't been already. The caller
+// must hold the write lock.
+func (t *SymbolTable) sort() {
+	if t.sorted != nil {
+		return
+	}
+	t.sorted = make([]uint64, 0, len(t.names))
+	for a := range t.names {
+		t.sorted = append(t.sorted, a)
+	}
+	sort.Slice(t.sorted, func(i, j int) bool { return t.sorted[i] < t.sorted[j] })
+}
+
+// Names the object after the OID it starts with, if that OID is known.
+func (t *SymbolTable) NameObject(o *Object) {
+	if len(o.Contents) <= 8 {
+		return
+	}
+	oid := binary.LittleEndian.Uint64(o.Contents[:])
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	className, found := t.oids[oid]
+	if found {
+		o.Name = className
+		t.names[o.Address] = className
+		t.kinds[o.Address] = ObjectSymbol
+		t.sorted = nil
+	}
+}
+
+// Formats an address along with the name of what resides there, if known
+// (see Resolve).
+func (t *SymbolTable) FormatAddr(addr uint64) string {
+	if r, found := t.resolve(addr, false); found && r.Offset == 0 && len(r.Name) > 0 {
+		return fmt.Sprintf("0x%x (%s)", addr, AbbreviateName(r.Name))
+	}
+	return fmt.Sprintf("0x%x", addr)
+}
+
+// Parses an address expression: one or more terms separated by '+' or '-',
+// where each term is a hex (0x...) or decimal number, or "sym:" followed by
+// the name of a symbol (e.g., "sym:main.cache+0x10").
+func (t *SymbolTable) ParseAddress(expression string) (uint64, error) {
+	expression = strings.TrimSpace(expression)
+	if len(expression) == 0 {
+		return 0, nil
+	}
+
+	var result uint64
+	negate := false
+	start := 0
+	for i := 0; i <= len(expression); i++ {
+		if i < len(expression) && (expression[i] != '+' && expression[i] != '-' || i == start) {
+			continue
+		}
+		term := strings.TrimSpace(expression[start:i])
+		value, err := t.parseAddressTerm(term)
+		if err != nil {
+			return 0, fmt.Errorf("Bad address '%s': %w", expression, err)
+		}
+		if negate {
+			result -= value
+		} else {
+			result += value
+		}
+		if i < len(expression) {
+			negate = expression[i] == '-'
+		}
+		start = i + 1
+	}
+	return result, nil
+}
+
+func (t *SymbolTable) parseAddressTerm(term string) (uint64, error) {
+	if strings.HasPrefix(term, "sym:") {
+		name := strings.TrimPrefix(term, "sym:")
+		addr, found := t.LookupName(name)
+		if !found {
+			return 0, fmt.Errorf("unknown symbol '%s'", name)
+		}
+		return addr, nil
+	}
+	return strconv.ParseUint(term, 0, 64)
+}
+
+// Reads OIDs and the names of the objects they identify, one pair per line.
+func (t *SymbolTable) ReadOids(r io.Reader) error {
+	var oid uint64
+	var name string
+	for {
+		n, err := fmt.Fscanln(r, &oid, &name)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n == 2 && oid > 0 && len(name) > 0 {
+			t.AddOid(oid, name)
+		}
+	}
+	return nil
+}
+
+// Reads symbols in the format produced by "go tool nm". Symbol names can
+// contain spaces (e.g., generic instantiations), so everything after the
+// symbol kind is taken as the name.
+func (t *SymbolTable) ReadSymbols(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		addrInt, err := strconv.ParseUint(fields[0], 16, 64)
+		if err == nil {
+			name := strings.Join(fields[2:], " ")
+			t.mutex.Lock()
+			t.names[addrInt] = name
+			t.kinds[addrInt] = nmSymbolKind(fields[1], name)
+			t.symbols[name] = addrInt
+			t.sorted = nil
+			t.mutex.Unlock()
+		}
+	}
+	return scanner.Err()
+}
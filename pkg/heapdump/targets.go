@@ -0,0 +1,97 @@
+package heapdump
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The objects and stack frames of a dump, sorted by address, so that
+// PrintRecords can say what each pointer points into. Records can point
+// to ones that come later in the dump, so the index has to be read in a
+// pass of its own (see ReadRecordIndex) before the dump is printed. Only
+// the address, size, and name of each record are kept, with the names
+// shared, so the index is much smaller than the dump.
+type RecordIndex struct {
+	starts []uint64
+	sizes  []uint64
+	names  []uint32 // into kinds
+	kinds  []string
+}
+
+// The index of the records that PrintRecords describes pointers with, if any
+var recordIndex *RecordIndex
+
+// Sets the index that PrintRecords (and FindObjects) use to describe the
+// record that each pointer points into; nil stops them from doing so.
+func SetRecordIndex(index *RecordIndex) {
+	recordIndex = index
+}
+
+// Reads a dump and indexes its objects and stack frames. Objects are named
+// from the default symbol table, so any OIDs should be read before this is
+// called.
+func ReadRecordIndex(reader Reader) (*RecordIndex, error) {
+	defer StartPhase("parse")()
+	err := ReadHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Reading header: %w\n", err)
+	}
+	x := &RecordIndex{}
+	kinds := make(map[string]uint32)
+	add := func(address uint64, size int, name string) {
+		kind, found := kinds[name]
+		if !found {
+			kind = uint32(len(x.kinds))
+			kinds[name] = kind
+			x.kinds = append(x.kinds, name)
+		}
+		x.starts = append(x.starts, address)
+		x.sizes = append(x.sizes, uint64(size))
+		x.names = append(x.names, kind)
+	}
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch r := record.(type) {
+		case *Eof:
+			sort.Sort(byStart{x})
+			return x, nil
+		case *Object:
+			defaultSymbols.NameObject(r)
+			add(r.Address, len(r.Contents), r.GetName())
+		case *StackFrame:
+			add(r.Address, len(r.Contents), "StackFrame("+AbbreviateName(r.Name)+")")
+		}
+	}
+}
+
+// Describes the record that contains the indicated address, as its name,
+// the offset of the address into it, and its size (e.g., "session.Session
+// +0x18 (4352 bytes)").
+func (x *RecordIndex) Describe(address uint64) (string, bool) {
+	i := sort.Search(len(x.starts), func(i int) bool { return x.starts[i] > address }) - 1
+	if i < 0 {
+		return "", false
+	}
+	offset := address - x.starts[i]
+	if offset >= x.sizes[i] && (offset > 0 || x.sizes[i] > 0) {
+		return "", false
+	}
+	name := x.kinds[x.names[i]]
+	if offset > 0 {
+		name += fmt.Sprintf("+0x%x", offset)
+	}
+	return fmt.Sprintf("%s (%d bytes)", name, x.sizes[i]), true
+}
+
+type byStart struct{ *RecordIndex }
+
+func (x byStart) Len() int           { return len(x.starts) }
+func (x byStart) Less(i, j int) bool { return x.starts[i] < x.starts[j] }
+func (x byStart) Swap(i, j int) {
+	x.starts[i], x.starts[j] = x.starts[j], x.starts[i]
+	x.sizes[i], x.sizes[j] = x.sizes[j], x.sizes[i]
+	x.names[i], x.names[j] = x.names[j], x.names[i]
+}
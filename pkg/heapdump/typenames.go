@@ -0,0 +1,187 @@
+package heapdump
+
+import (
+	"strings"
+)
+
+// Names longer than this have their innermost type arguments elided
+const maxTypeNameLength = 80
+
+var fullNames bool
+
+// Controls whether type, function, and symbol names are displayed exactly
+// as they appear in the program, or abbreviated by AbbreviateName.
+func SetFullNames(full bool) {
+	fullNames = full
+}
+
+// Shortens a type, function, or symbol name for display. Generic
+// instantiations can produce names that run to hundreds of characters, so:
+//
+//   - Import paths are reduced to the package name ("github.com/a/b/c.T"
+//     becomes "c.T").
+//   - GC shape types are written as "~" followed by the underlying type
+//     ("go.shape.string" becomes "~string").
+//   - If the name is still too long, the innermost type argument lists are
+//     replaced with "…", one level at a time, until it fits.
+//
+// Abbreviation is skipped entirely if SetFullNames(true) has been called.
+func AbbreviateName(name string) string {
+	if fullNames {
+		return name
+	}
+	name = strings.ReplaceAll(name, "go.shape.", "~")
+	name = trimImportPaths(name)
+	for len(name) > maxTypeNameLength {
+		elided := elideTypeArguments(name)
+		if elided == name {
+			break
+		}
+		name = elided
+	}
+	return name
+}
+
+// Type names that are aliases for others
+var typeAliases = map[string]string{
+	"byte": "uint8",
+	"rune": "int32",
+	"any":  "interface {}",
+}
+
+// Returns the name that the runtime gives the named type, so that names of
+// the same type that were written differently compare equal: aliases (such
+// as "byte" for "uint8" and "any" for "interface {}") are resolved, and
+// packages vendored into the standard library ("vendor/golang.org/x/net/
+// http2/hpack.Decoder") are named as the packages they're copies of. Names
+// from OID files and from other programs' debug info (such as plugins and
+// shared libraries) can use either.
+func CanonicalTypeName(name string) string {
+	name = strings.ReplaceAll(name, "interface{}", "interface {}")
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && !isNameDelimiter(name[i]) {
+			continue
+		}
+		identifier := strings.TrimPrefix(name[start:i], "vendor/")
+		if alias, found := typeAliases[identifier]; found {
+			identifier = alias
+		}
+		b.WriteString(identifier)
+		if i < len(name) {
+			b.WriteByte(name[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+// Characters that end one qualified identifier and start the next
+func isNameDelimiter(b byte) bool {
+	return strings.IndexByte("[](){},;*~ \t", b) >= 0
+}
+
+func trimImportPaths(name string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && !isNameDelimiter(name[i]) {
+			continue
+		}
+		identifier := name[start:i]
+		if slash := strings.LastIndexByte(identifier, '/'); slash >= 0 {
+			identifier = identifier[slash+1:]
+		}
+		b.WriteString(identifier)
+		if i < len(name) {
+			b.WriteByte(name[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+// Reports whether the '[' at the indicated position opens a list of type
+// arguments, rather than a slice, array, or map type.
+func isTypeArgumentList(name string, open int) bool {
+	start := open
+	for start > 0 && !isNameDelimiter(name[start-1]) {
+		start--
+	}
+	identifier := name[start:open]
+	return len(identifier) > 0 && identifier != "map" && !strings.HasSuffix(identifier, ".map")
+}
+
+// Replaces the contents of the most deeply nested type argument lists with
+// "…". Returns the name unchanged if it has no type arguments left to elide.
+func elideTypeArguments(name string) string {
+	// Find the deepest level of type argument nesting
+	deepest := 0
+	depth := 0
+	typeArguments := make([]bool, 0) // one entry per open bracket
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '[':
+			isArgs := isTypeArgumentList(name, i)
+			typeArguments = append(typeArguments, isArgs)
+			if isArgs {
+				depth++
+				if depth > deepest {
+					deepest = depth
+				}
+			}
+		case ']':
+			if len(typeArguments) > 0 {
+				if typeArguments[len(typeArguments)-1] {
+					depth--
+				}
+				typeArguments = typeArguments[:len(typeArguments)-1]
+			}
+		}
+	}
+	if deepest == 0 {
+		return name
+	}
+
+	var b strings.Builder
+	depth = 0
+	brackets := 0 // open brackets of any kind inside the list being elided
+	typeArguments = typeArguments[:0]
+	for i := 0; i < len(name); i++ {
+		inside := depth == deepest
+		switch name[i] {
+		case '[':
+			isArgs := isTypeArgumentList(name, i)
+			typeArguments = append(typeArguments, isArgs)
+			if inside {
+				brackets++
+				continue
+			}
+			if isArgs {
+				depth++
+				if depth == deepest {
+					b.WriteString("[…")
+					continue
+				}
+			}
+		case ']':
+			isArgs := false
+			if len(typeArguments) > 0 {
+				isArgs = typeArguments[len(typeArguments)-1]
+				typeArguments = typeArguments[:len(typeArguments)-1]
+			}
+			if inside && brackets > 0 {
+				brackets--
+				continue
+			}
+			if isArgs {
+				depth--
+			}
+		}
+		if !inside || name[i] == ']' {
+			b.WriteByte(name[i])
+		}
+	}
+	return b.String()
+}
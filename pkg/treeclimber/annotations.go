@@ -0,0 +1,207 @@
+package treeclimber
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// Tags are tracked as bits in a mask while totaling retained memory
+const maxTags = 64
+
+// Fill colors for tagged nodes, in the order the tags first appear
+var tagColors = []string{
+	"#a6cee3", "#b2df8a", "#fb9a99", "#fdbf6f", "#cab2d6", "#ffff99",
+	"#8dd3c7", "#bebada", "#fb8072", "#80b1d3", "#fccde5", "#d9d9d9",
+}
+
+// A line of an annotations file, giving a tag to either the objects whose
+// names match a pattern or the record containing an address.
+type annotation struct {
+	tag     string
+	pattern *regexp.Regexp
+	address uint64
+}
+
+// Reads an annotations file, which tags records with what they are in the
+// terms of the program being analyzed (e.g., "cache", "per-request", or
+// "config"). Each line is a tag followed by any number of address
+// expressions (see SymbolTable.ParseAddress), which tag the records
+// containing those addresses, or regular expressions, which tag the objects
+// whose names match. Blank lines and lines starting with "#" are ignored.
+// A record gets the tag of the first line to pick it out, with addresses
+// taking precedence over patterns.
+//
+// Tagged objects are filled with a color for their tag in graphs, and
+// PrintTags totals the memory each tag uses and retains.
+func (c *TreeClimber) ReadAnnotations(r io.Reader) error {
+	c.annotations = make([]annotation, 0)
+	c.tagNames = make([]string, 0)
+	c.tags = nil
+	known := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("Line %d: no types or addresses for tag '%s'", line, fields[0])
+		}
+		tag := fields[0]
+		if !known[tag] {
+			if len(c.tagNames) == maxTags {
+				return fmt.Errorf("Line %d: too many tags (at most %d are allowed)", line, maxTags)
+			}
+			known[tag] = true
+			c.tagNames = append(c.tagNames, tag)
+		}
+		for _, field := range fields[1:] {
+			address, err := c.symbols.ParseAddress(field)
+			if err == nil {
+				c.annotations = append(c.annotations, annotation{tag: tag, address: address})
+				continue
+			}
+			if strings.HasPrefix(field, "sym:") {
+				return fmt.Errorf("Line %d: %w", line, err)
+			}
+			pattern, err := regexp.Compile(field)
+			if err != nil {
+				return fmt.Errorf("Line %d: bad pattern '%s': %w", line, field, err)
+			}
+			c.annotations = append(c.annotations, annotation{tag: tag, pattern: pattern})
+		}
+	}
+	return scanner.Err()
+}
+
+// Returns the tag of the record at the indicated address, if it has one.
+func (c *TreeClimber) tagOf(address uint64) (string, bool) {
+	if c.tags == nil {
+		c.tags = make(map[uint64]string)
+		for _, a := range c.annotations {
+			if a.pattern != nil {
+				continue
+			}
+			o, found := c.containing(a.address)
+			if !found {
+				heapdump.Logger().Warn("Annotated address isn't in any record", "tag", a.tag, "address", fmt.Sprintf("0x%x", a.address))
+				continue
+			}
+			if _, tagged := c.tags[o.GetAddress()]; !tagged {
+				c.tags[o.GetAddress()] = a.tag
+			}
+		}
+		matches := make(map[string]string) // object name -> tag
+		for address, r := range c.memory {
+			o, isObject := r.(*heapdump.Object)
+			if !isObject || len(o.Name) == 0 {
+				continue
+			}
+			if _, tagged := c.tags[address]; tagged {
+				continue
+			}
+			tag, matched := matches[o.Name]
+			if !matched {
+				for _, a := range c.annotations {
+					if a.pattern != nil && a.pattern.MatchString(o.GetFullName()) {
+						tag = a.tag
+						break
+					}
+				}
+				matches[o.Name] = tag
+			}
+			if len(tag) > 0 {
+				c.tags[address] = tag
+			}
+		}
+	}
+	tag, found := c.tags[address]
+	return tag, found
+}
+
+func (c *TreeClimber) tagColor(tag string) string {
+	for i, name := range c.tagNames {
+		if name == tag {
+			return tagColors[i%len(tagColors)]
+		}
+	}
+	return ""
+}
+
+type tagTotals struct {
+	tag      string
+	objects  uint64
+	bytes    uint64
+	retained uint64
+}
+
+// Prints how many objects have each tag given by ReadAnnotations, how much
+// memory they use, and how much they retain between them: what would be
+// freed if every object with the tag were.
+func (c *TreeClimber) PrintTags() error {
+	if len(c.tagNames) == 0 {
+		return fmt.Errorf("No tags have been read (is --annotations set?)")
+	}
+	totals := make([]tagTotals, len(c.tagNames)+1)
+	index := make(map[string]int)
+	for i, tag := range c.tagNames {
+		totals[i].tag = tag
+		index[tag] = i
+	}
+	untagged := len(c.tagNames)
+	totals[untagged].tag = "(untagged)"
+
+	g := c.retentionGraph()
+	_, retainedBytes := g.retainedTotals()
+	masks := make([]uint64, len(g.addresses)) // tags of each node's dominators
+	order := g.postorder()
+	for i := len(order) - 1; i >= 0; i-- {
+		node := order[i]
+		if node == 0 {
+			continue
+		}
+		parent := g.idom[node]
+		masks[node] = masks[parent]
+		if parent != 0 {
+			if tag, tagged := c.tagOf(g.addresses[parent]); tagged {
+				masks[node] |= 1 << index[tag]
+			}
+		}
+		if !g.isObject[node] {
+			continue
+		}
+		tag, tagged := c.tagOf(g.addresses[node])
+		t := untagged
+		if tagged {
+			t = index[tag]
+			// Memory retained by an object dominated by another with the
+			// same tag is already counted
+			if masks[node]&(1<<t) == 0 {
+				totals[t].retained += retainedBytes[node]
+			}
+		}
+		totals[t].objects++
+		totals[t].bytes += g.sizes[node]
+	}
+
+	sort.SliceStable(totals[:untagged], func(i, j int) bool {
+		return totals[i].retained > totals[j].retained
+	})
+	for i, t := range totals {
+		if i == untagged {
+			if t.objects > 0 {
+				fmt.Fprintf(c.out, "%s: %d objects, %s\n", t.tag, t.objects, units.Format(t.bytes))
+			}
+			break
+		}
+		fmt.Fprintf(c.out, "%s: %d objects, %s, retaining %s\n", t.tag, t.objects, units.Format(t.bytes), units.Format(t.retained))
+	}
+	return nil
+}
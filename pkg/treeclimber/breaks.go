@@ -0,0 +1,318 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The most references that PrintBreaks will suggest breaking; past that,
+// what keeps the object alive is too tangled for a list to help.
+const maxBreaks = 50
+
+// A reference in the retention graph that PrintBreaks suggests breaking:
+// every pointer from one node into another.
+type breakEdge struct {
+	from, to int
+	pointers int
+}
+
+// A residual edge of the flow network that breaks are found in.
+type flowEdge struct {
+	to       int
+	reverse  int // index of the opposite edge in the list of edges of "to"
+	capacity int
+}
+
+// Suggests the fewest references to break to free the object at the
+// indicated address: a minimum cut, in the retention graph, between the
+// anchors and the object. Breaking every reference listed (clearing the
+// fields and variables they're in, or dropping the objects holding them)
+// leaves no path from an anchor to the object, so it and everything only
+// it retains can be freed. Of the cuts with the fewest references, the one
+// closest to the object is chosen, since that's usually where a fix
+// belongs. References are ranked by how much memory breaking each of them
+// on its own would free, then by how much the node each points to retains,
+// and listed along with where they're declared, given debug info.
+func (c *TreeClimber) PrintBreaks(address uint64) error {
+	g := c.retentionGraph()
+	target := -1
+	for i := 1; i < len(g.addresses); i++ {
+		if g.addresses[i] == address && g.isObject[i] {
+			target = i
+		}
+	}
+	if target == -1 {
+		return fmt.Errorf("Could not find object at address 0x%x", address)
+	}
+	objects, bytes := g.retainedTotals()
+	defer heapdump.StartPhase("traversal")()
+
+	for _, anchor := range g.children[0][:g.anchors] {
+		if anchor == target {
+			fmt.Fprintf(c.out, "%s is itself a runtime root, so breaking references can't free it\n", g.labels[target])
+			return nil
+		}
+	}
+
+	cut, complete := g.minCut(target)
+	if !complete {
+		fmt.Fprintf(c.out, "%s can't be freed without breaking more than %d references; see --retainers for what keeps it alive\n",
+			g.labels[target], maxBreaks)
+		return nil
+	}
+	if len(cut) == 0 {
+		fmt.Fprintf(c.out, "%s is not reachable from any anchor (except, perhaps, through weak references), so nothing retains it\n", g.labels[target])
+		return nil
+	}
+
+	freed := c.freedByBreaks(g, cut)
+	sort.Slice(cut, func(i, j int) bool {
+		a, b := cut[i], cut[j]
+		if freed[a] != freed[b] {
+			return freed[a] > freed[b]
+		}
+		if bytes[a.to] != bytes[b.to] {
+			return bytes[a.to] > bytes[b.to]
+		}
+		if g.addresses[a.from] != g.addresses[b.from] {
+			return g.addresses[a.from] < g.addresses[b.from]
+		}
+		return g.addresses[a.to] < g.addresses[b.to]
+	})
+	fmt.Fprintf(c.out, "Breaking these %d references would free %s, and the %d objects (%s) it retains:\n",
+		len(cut), g.labels[target], objects[target], units.Format(bytes[target]))
+	for i, e := range cut {
+		pointers := ""
+		if e.pointers > 1 {
+			pointers = fmt.Sprintf(" (%d pointers)", e.pointers)
+		}
+		alone := ""
+		if len(cut) > 1 {
+			alone = ", and breaking it alone would free " + units.Format(freed[e])
+		}
+		fmt.Fprintf(c.out, "%3d. %s%s -> %s%s, which retains %s%s\n", i+1, g.labels[e.from], c.breakLocation(g, e),
+			g.labels[e.to], pointers, units.Format(bytes[e.to]), alone)
+	}
+	return nil
+}
+
+// Identifies a reference across rebuilds of the retention graph, which
+// number its nodes differently each time.
+func breakKey(g *retentionGraph, e breakEdge) [2]uint64 {
+	return [2]uint64{g.addresses[e.from], g.addresses[e.to]}
+}
+
+// Returns the bytes of objects that breaking each of the references would
+// free on its own, with the others left in place. The totals are kept, so
+// that suggesting breaks for several objects that share references (in a
+// script, say) only works out each one once.
+func (c *TreeClimber) freedByBreaks(g *retentionGraph, cut []breakEdge) map[breakEdge]uint64 {
+	if c.breakFreed == nil {
+		c.breakFreed = make(map[[2]uint64]uint64)
+	}
+	var reached []bool
+	totals := make(map[breakEdge]uint64, len(cut))
+	for _, e := range cut {
+		freed, found := c.breakFreed[breakKey(g, e)]
+		if !found {
+			if reached == nil {
+				reached = g.reach(g.children[0][:g.anchors])
+			}
+			freed = g.freedWithout(e, reached)
+			c.breakFreed[breakKey(g, e)] = freed
+		}
+		totals[e] = freed
+	}
+	return totals
+}
+
+// Works out the bytes of objects that would no longer be reachable from the
+// anchors without the indicated reference, given which nodes are reachable
+// with it.
+func (g *retentionGraph) freedWithout(e breakEdge, reached []bool) uint64 {
+	still := make([]bool, len(g.addresses))
+	queue := make([]int, 0, g.anchors)
+	for _, anchor := range g.children[0][:g.anchors] {
+		if !still[anchor] {
+			still[anchor] = true
+			queue = append(queue, anchor)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, child := range g.children[node] {
+			if still[child] || node == e.from && child == e.to {
+				continue
+			}
+			if child == e.to {
+				// Everything reachable through the reference can still be
+				// reached some other way
+				return 0
+			}
+			still[child] = true
+			queue = append(queue, child)
+		}
+	}
+	freed := uint64(0)
+	for node := range reached {
+		if reached[node] && !still[node] && g.isObject[node] {
+			freed += g.sizes[node]
+		}
+	}
+	return freed
+}
+
+// Finds a minimum cut between the anchors and the target node, as the
+// references to break. Each reference counts once for each pointer it
+// stands for, and the edges from the synthetic root to the anchors can't be
+// cut. Returns false if the cut would be larger than maxBreaks.
+func (g *retentionGraph) minCut(target int) ([]breakEdge, bool) {
+	// Only nodes from which the target can be reached matter
+	predecessors := make([][]int, len(g.addresses))
+	for from, children := range g.children {
+		if from == 0 {
+			children = children[:g.anchors]
+		}
+		for _, to := range children {
+			predecessors[to] = append(predecessors[to], from)
+		}
+	}
+	local := map[int]int{target: 0}
+	nodes := []int{target}
+	for i := 0; i < len(nodes); i++ {
+		for _, p := range predecessors[nodes[i]] {
+			if _, seen := local[p]; !seen {
+				local[p] = len(nodes)
+				nodes = append(nodes, p)
+			}
+		}
+	}
+	source, found := local[0]
+	if !found {
+		return nil, true
+	}
+	sink := local[target]
+
+	// Parallel edges, from several pointers to the same record, are merged
+	// into one with that many times the capacity
+	unbreakable := maxBreaks + 1
+	edges := make([][]flowEdge, len(nodes))
+	index := make(map[[2]int]int)
+	for _, from := range nodes {
+		children := g.children[from]
+		if from == 0 {
+			children = children[:g.anchors]
+		}
+		for _, to := range children {
+			if _, relevant := local[to]; !relevant {
+				continue
+			}
+			u, v := local[from], local[to]
+			if i, found := index[[2]int{u, v}]; found {
+				edges[u][i].capacity++
+				continue
+			}
+			capacity := 1
+			if from == 0 {
+				capacity = unbreakable
+			}
+			index[[2]int{u, v}] = len(edges[u])
+			edges[u] = append(edges[u], flowEdge{to: v, reverse: len(edges[v]), capacity: capacity})
+			edges[v] = append(edges[v], flowEdge{to: u, reverse: len(edges[u]) - 1})
+		}
+	}
+
+	// Augmenting paths, shortest first (Edmonds-Karp), until there are no
+	// more or there are too many
+	flow := 0
+	for flow <= maxBreaks {
+		parent := make([][2]int, len(nodes)) // node -> (previous node, index of edge from it)
+		for i := range parent {
+			parent[i] = [2]int{-1, -1}
+		}
+		parent[source] = [2]int{source, -1}
+		queue := []int{source}
+		for len(queue) > 0 && parent[sink][0] == -1 {
+			u := queue[0]
+			queue = queue[1:]
+			for i, e := range edges[u] {
+				if e.capacity > 0 && parent[e.to][0] == -1 {
+					parent[e.to] = [2]int{u, i}
+					queue = append(queue, e.to)
+				}
+			}
+		}
+		if parent[sink][0] == -1 {
+			break
+		}
+		bottleneck := unbreakable
+		for v := sink; v != source; v = parent[v][0] {
+			bottleneck = min(bottleneck, edges[parent[v][0]][parent[v][1]].capacity)
+		}
+		for v := sink; v != source; v = parent[v][0] {
+			e := &edges[parent[v][0]][parent[v][1]]
+			e.capacity -= bottleneck
+			edges[v][e.reverse].capacity += bottleneck
+		}
+		flow += bottleneck
+	}
+	if flow > maxBreaks {
+		return nil, false
+	}
+
+	// The cut closest to the target is made of the edges into the nodes
+	// that can still reach it through what's left of the network
+	reaches := make([]bool, len(nodes))
+	reaches[sink] = true
+	queue := []int{sink}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, e := range edges[v] {
+			// Something can reach v if the edge from it to v isn't full
+			if !reaches[e.to] && edges[e.to][e.reverse].capacity > 0 {
+				reaches[e.to] = true
+				queue = append(queue, e.to)
+			}
+		}
+	}
+	cut := make([]breakEdge, 0)
+	for pair, i := range index {
+		u, v := pair[0], pair[1]
+		if reaches[u] || !reaches[v] {
+			continue
+		}
+		// The capacity an edge started with is what's left of it plus the
+		// flow through it, which its reverse edge has picked up
+		pointers := edges[u][i].capacity + edges[v][edges[u][i].reverse].capacity
+		cut = append(cut, breakEdge{from: nodes[u], to: nodes[v], pointers: pointers})
+	}
+	return cut, true
+}
+
+// Describes where the reference from one node to another is declared, as
+// sourceOf does; references from globals are described by the global's
+// declaration.
+func (c *TreeClimber) breakLocation(g *retentionGraph, e breakEdge) string {
+	from, to := g.addresses[e.from], g.addresses[e.to]
+	if g.isObject[e.from] {
+		return c.sourceOf(from, to)
+	}
+	if _, isFrame := c.memory[from].(*heapdump.StackFrame); isFrame {
+		return c.sourceOf(from, to)
+	}
+	// Anything else is a global pointer slot
+	o, found := c.containing(from)
+	if !found {
+		return ""
+	}
+	location, found := c.symbols.GetSourceLocation(o.(heapdump.Record), from-o.GetAddress())
+	if !found {
+		return ""
+	}
+	return " [" + location + "]"
+}
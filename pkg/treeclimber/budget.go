@@ -0,0 +1,170 @@
+package treeclimber
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"gopkg.in/yaml.v3"
+)
+
+// The number of instances of a type over budget whose paths are printed
+const budgetPaths = 3
+
+// The layout of a budget file
+type budgetFile struct {
+	Types    map[string]string `yaml:"types"`
+	Packages map[string]string `yaml:"packages"`
+}
+
+// A limit on the memory used by objects of one type, or of every type in a
+// package, and what the dump actually has of them.
+type budget struct {
+	kind      string // "type" or "package"
+	name      string
+	limit     uint64
+	bytes     uint64
+	instances []*heapdump.Object
+}
+
+// Checks the objects in the dump against a YAML budget file, which limits
+// how much memory the objects of each type, or of every type in a package,
+// may use; for example:
+//
+//	types:
+//	  main.session: 10MiB
+//	packages:
+//	  github.com/example/app/cache: 1.5GB
+//
+// Types are matched against the full names of objects (see ReadOids), and
+// packages against the import paths those names start with, including
+// those of subpackages. Sizes are in bytes, or have a suffix of B, kB, MB,
+// GB, or TB (powers of 1000) or KiB, MiB, GiB, or TiB (powers of 1024). An
+// object can count against any number of budgets.
+//
+// Prints every budget with how much of it is used; for those that are
+// exceeded, the paths from an anchor to the largest instances are printed
+// as well. Returns the number of budgets that are exceeded.
+func (c *TreeClimber) CheckBudgets(r io.Reader) (int, error) {
+	var file budgetFile
+	err := yaml.NewDecoder(r).Decode(&file)
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("Bad budget file: %w", err)
+	}
+	budgets := make([]*budget, 0, len(file.Types)+len(file.Packages))
+	for _, list := range []struct {
+		kind   string
+		limits map[string]string
+	}{{"type", file.Types}, {"package", file.Packages}} {
+		for name, size := range list.limits {
+			limit, err := units.ParseSize(size)
+			if err != nil {
+				return 0, fmt.Errorf("Bad budget for %s '%s': %w", list.kind, name, err)
+			}
+			budgets = append(budgets, &budget{kind: list.kind, name: name, limit: limit})
+		}
+	}
+	if len(budgets) == 0 {
+		return 0, fmt.Errorf("The budget file doesn't have any budgets")
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].kind != budgets[j].kind {
+			return budgets[i].kind > budgets[j].kind
+		}
+		return budgets[i].name < budgets[j].name
+	})
+
+	defer heapdump.StartPhase("traversal")()
+	for _, address := range c.sortedObjects() {
+		o := c.memory[address].(*heapdump.Object)
+		if len(o.Name) == 0 {
+			continue
+		}
+		pkg := packageOf(o.Name)
+		for _, b := range budgets {
+			if b.kind == "type" && b.name == o.Name ||
+				b.kind == "package" && (pkg == b.name || strings.HasPrefix(pkg, b.name+"/")) {
+				b.bytes += uint64(len(o.Contents))
+				b.instances = append(b.instances, o)
+			}
+		}
+	}
+
+	exceeded := 0
+	for _, b := range budgets {
+		if b.bytes > b.limit {
+			exceeded++
+		}
+	}
+	fmt.Fprintf(c.out, "%d of %d budgets exceeded\n", exceeded, len(budgets))
+	for _, b := range budgets {
+		status := "ok  "
+		if b.bytes > b.limit {
+			status = "OVER"
+		}
+		fmt.Fprintf(c.out, "%s %s %s: %s in %d objects, of a budget of %s\n",
+			status, b.kind, b.name, units.Format(b.bytes), len(b.instances), units.Format(b.limit))
+		if b.bytes <= b.limit {
+			continue
+		}
+		sort.SliceStable(b.instances, func(i, j int) bool {
+			return len(b.instances[i].Contents) > len(b.instances[j].Contents)
+		})
+		for i, o := range b.instances {
+			if i == budgetPaths {
+				fmt.Fprintf(c.out, "  ... and %d more objects\n", len(b.instances)-budgetPaths)
+				break
+			}
+			fmt.Fprintf(c.out, "  Path to %s:\n", o.String())
+			err := c.PrintPath(o.Address)
+			if err != nil {
+				fmt.Fprintf(c.out, "  %v\n", err)
+			}
+		}
+	}
+	return exceeded, nil
+}
+
+// Returns the addresses of every object in the dump, in order.
+func (c *TreeClimber) sortedObjects() []uint64 {
+	addresses := make([]uint64, 0)
+	for address, r := range c.memory {
+		if _, isObject := r.(*heapdump.Object); isObject {
+			addresses = append(addresses, address)
+		}
+	}
+	sortAddresses(addresses)
+	return addresses
+}
+
+// Returns the import path of the package that declares the named type, or
+// an empty string if it's a builtin type. Type arguments are ignored, as
+// are the pointer, slice, and array types built on it.
+func packageOf(name string) string {
+	name = strings.TrimLeft(name, "*")
+	for strings.HasPrefix(name, "[") {
+		end := strings.Index(name, "]")
+		if end < 0 {
+			return ""
+		}
+		name = strings.TrimLeft(name[end+1:], "*")
+	}
+	if open := strings.Index(name, "["); open >= 0 {
+		name = name[:open]
+	}
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 || dot < strings.LastIndex(name, "/") {
+		return ""
+	}
+	return name[:dot]
+}
+
+// Parses a size in bytes, which may have a unit suffix (e.g., "64MiB").
+//
+// Deprecated: use units.ParseSize.
+func ParseSize(size string) (uint64, error) {
+	return units.ParseSize(size)
+}
@@ -0,0 +1,115 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+type chain struct {
+	Head     uint64
+	Tail     uint64
+	Length   int
+	Element  string // description of the chain's element type
+	Retained uint64 // bytes retained by the head of the chain
+	Cycle    bool
+}
+
+// Prints every chain of at least minLength objects in which each object
+// points to exactly one other object of the same shape (size and pointer
+// layout). These are typically linked lists, and are a common way for
+// memory to leak a little bit at a time.
+func (c *TreeClimber) PrintChains(minLength int) error {
+	chains := c.findChains(minLength)
+	if len(chains) == 0 {
+		return fmt.Errorf("No chains of %d or more objects found", minLength)
+	}
+	for _, ch := range chains {
+		kind := "Chain"
+		if ch.Cycle {
+			kind = "Cycle"
+		}
+		fmt.Fprintf(c.out, "%s of %d %s: head 0x%x, tail 0x%x, retains %s\n",
+			kind, ch.Length, ch.Element, ch.Head, ch.Tail, units.Format(ch.Retained))
+	}
+	return nil
+}
+
+func (c *TreeClimber) findChains(minLength int) []chain {
+	g := c.retentionGraph()
+	_, retained := g.retainedTotals()
+
+	shape := func(i int) string {
+		o := c.memory[g.addresses[i]].(*heapdump.Object)
+		return fmt.Sprintf("%d/%v", len(o.Contents), o.Fields)
+	}
+
+	// next[i] is the single same-shaped object that i points to, if any
+	next := make([]int, len(g.addresses))
+	predecessors := make([]int, len(g.addresses))
+	for i := range g.addresses {
+		next[i] = -1
+		if !g.isObject[i] {
+			continue
+		}
+		s := shape(i)
+		for _, child := range g.children[i] {
+			if child == i || child == next[i] || !g.isObject[child] || shape(child) != s {
+				continue
+			}
+			if next[i] != -1 {
+				next[i] = -1
+				break
+			}
+			next[i] = child
+		}
+		if next[i] != -1 {
+			predecessors[next[i]]++
+		}
+	}
+
+	visited := make([]bool, len(g.addresses))
+	chains := make([]chain, 0)
+	walk := func(head int) {
+		ch := chain{Head: g.addresses[head]}
+		node := head
+		for {
+			visited[node] = true
+			ch.Length++
+			ch.Tail = g.addresses[node]
+			if next[node] == -1 {
+				break
+			}
+			if visited[next[node]] {
+				ch.Cycle = next[node] == head
+				break
+			}
+			node = next[node]
+		}
+		if ch.Length >= minLength {
+			o := c.memory[ch.Head].(*heapdump.Object)
+			ch.Element = fmt.Sprintf("%s (%s)", o.GetName(), units.Format(uint64(len(o.Contents))))
+			ch.Retained = retained[head]
+			chains = append(chains, ch)
+		}
+	}
+
+	for i := range g.addresses {
+		if next[i] != -1 && predecessors[i] == 0 {
+			walk(i)
+		}
+	}
+	// Whatever is left over is part of a cycle
+	for i := range g.addresses {
+		if next[i] != -1 && !visited[i] {
+			walk(i)
+		}
+	}
+
+	sort.SliceStable(chains, func(i, j int) bool {
+		return chains[i].Length > chains[j].Length
+	})
+	return chains
+}
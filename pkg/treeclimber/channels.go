@@ -0,0 +1,150 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// A channel found in the heap. Channels are runtime.hchan objects, which the
+// dump doesn't identify as such, so they are recognized by the shape of
+// their contents.
+type channel struct {
+	address  uint64
+	length   uint64 // number of elements currently buffered
+	capacity uint64
+	elemSize uint64
+	elemType string
+	buffer   uint64 // address of the buffer, if it is a separate object
+}
+
+func (ch *channel) String() string {
+	return fmt.Sprintf("chan %s @ 0x%x: %d/%d elements of %d bytes", ch.elemType, ch.address, ch.length, ch.capacity, ch.elemSize)
+}
+
+// Prints every channel in the heap, along with the memory that it keeps
+// alive; for buffered channels, this includes whatever the elements in the
+// buffer point to. Channels with the most retained memory are listed first.
+func (c *TreeClimber) PrintChannels() error {
+	channels := c.channels()
+	if len(channels) == 0 {
+		return fmt.Errorf("No channels found")
+	}
+
+	g := c.retentionGraph()
+	objects, bytes := g.retainedTotals()
+	index := make(map[uint64]int)
+	for i, address := range g.addresses {
+		if g.isObject[i] {
+			index[address] = i
+		}
+	}
+
+	addresses := make([]uint64, 0, len(channels))
+	for address := range channels {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		bi, bj := bytes[index[addresses[i]]], bytes[index[addresses[j]]]
+		if bi != bj {
+			return bi > bj
+		}
+		return addresses[i] < addresses[j]
+	})
+	for _, address := range addresses {
+		node := index[address]
+		fmt.Fprintf(c.out, "%s; retains %d objects, %s\n", channels[address], objects[node], units.Format(bytes[node]))
+	}
+	return nil
+}
+
+// Finds everything in the heap that looks like a runtime.hchan:
+//
+//	qcount   uint           // 0x00
+//	dataqsiz uint           // 0x08
+//	buf      unsafe.Pointer // 0x10
+//	elemsize uint16         // 0x18
+//	...
+//	elemtype *_type         // 0x20 before Go 1.23, 0x28 after
+//
+// The buffer is allocated along with the hchan if the elements contain no
+// pointers, and separately if they do. Unbuffered channels (and channels of
+// zero-sized elements) have buf pointing at their own buf field. Only 64-bit
+// dumps are supported.
+func (c *TreeClimber) channels() map[uint64]*channel {
+	channels := make(map[uint64]*channel)
+	if c.params.PointerSize != 8 {
+		return channels
+	}
+	for address, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if !isObject {
+			continue
+		}
+		ch, found := c.asChannel(address, o.Contents)
+		if found {
+			channels[address] = ch
+		}
+	}
+	return channels
+}
+
+func (c *TreeClimber) asChannel(address uint64, contents []byte) (*channel, bool) {
+	size := uint64(len(contents))
+	if size < 96 {
+		return nil, false
+	}
+	ch := &channel{
+		address:  address,
+		length:   c.word(contents[0x00:]),
+		capacity: c.word(contents[0x08:]),
+		elemSize: uint64(c.byteOrder().Uint16(contents[0x18:])),
+	}
+	buf := c.pointerAt(contents[0x10:])
+	if ch.length > ch.capacity || ch.capacity > 1<<32 {
+		return nil, false
+	}
+
+	// The size of the hchan itself tells us where to find the element type
+	hchanSize := size
+	mem := ch.capacity * ch.elemSize
+	switch {
+	case mem == 0:
+		if buf != address+0x10 || size > 128 {
+			return nil, false
+		}
+	case buf > address && buf < address+size:
+		hchanSize = buf - address
+		if hchanSize < 96 || hchanSize > 128 || size < hchanSize+mem {
+			return nil, false
+		}
+	default:
+		b, isObject := c.memory[buf].(*heapdump.Object)
+		if !isObject || size > 128 || uint64(len(b.Contents)) < mem || uint64(len(b.Contents)) > 2*mem {
+			return nil, false
+		}
+		ch.buffer = buf
+	}
+
+	elemTypeOffset := uint64(0x28)
+	if hchanSize == 96 {
+		elemTypeOffset = 0x20
+	}
+	elemType := c.word(contents[elemTypeOffset:])
+	if elemType == 0 || c.inHeap(elemType) {
+		return nil, false
+	}
+	ch.elemType = c.typeName(elemType)
+	return ch, true
+}
+
+// Names the runtime type descriptor at the indicated address, using the
+// program if we have it, and type descriptor records in the dump if not.
+func (c *TreeClimber) typeName(address uint64) string {
+	if name, found := c.symbols.ResolveType(address); found {
+		return heapdump.AbbreviateName(name)
+	}
+	return fmt.Sprintf("<type 0x%x>", address)
+}
@@ -0,0 +1,116 @@
+package treeclimber
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// How the objects of one type changed between two dumps
+type TypeChange struct {
+	Name          string
+	ObjectsBefore uint64
+	ObjectsAfter  uint64
+	BytesBefore   uint64
+	BytesAfter    uint64
+}
+
+// The differences between two dumps, as found by Compare
+type Comparison struct {
+	Types            []TypeChange // Types whose objects changed, the biggest change in size first
+	ObjectsBefore    uint64
+	ObjectsAfter     uint64
+	BytesBefore      uint64
+	BytesAfter       uint64
+	GoroutinesBefore int
+	GoroutinesAfter  int
+}
+
+// Compares two dumps by how many objects of each type they have and how
+// much memory those use, as with PrintHistogram. Types are matched by
+// name, so each dump should have its own symbol table (see
+// NewTreeClimberWithSymbols) with the same OIDs read into it; objects that
+// haven't been named are matched by size.
+func Compare(a, b *TreeClimber) *Comparison {
+	cmp := &Comparison{
+		GoroutinesBefore: len(a.goroutines),
+		GoroutinesAfter:  len(b.goroutines),
+	}
+	changes := make(map[string]*TypeChange)
+	change := func(name string) *TypeChange {
+		t, found := changes[name]
+		if !found {
+			t = &TypeChange{Name: name}
+			changes[name] = t
+		}
+		return t
+	}
+	var before, after []*histogramEntry
+	before, cmp.ObjectsBefore, cmp.BytesBefore = a.histogram()
+	after, cmp.ObjectsAfter, cmp.BytesAfter = b.histogram()
+	for _, e := range before {
+		t := change(e.name)
+		t.ObjectsBefore, t.BytesBefore = e.count, e.bytes
+	}
+	for _, e := range after {
+		t := change(e.name)
+		t.ObjectsAfter, t.BytesAfter = e.count, e.bytes
+	}
+
+	cmp.Types = make([]TypeChange, 0, len(changes))
+	for _, t := range changes {
+		if t.ObjectsBefore != t.ObjectsAfter || t.BytesBefore != t.BytesAfter {
+			cmp.Types = append(cmp.Types, *t)
+		}
+	}
+	sort.Slice(cmp.Types, func(i, j int) bool {
+		di, dj := cmp.Types[i].growth(), cmp.Types[j].growth()
+		if abs(di) != abs(dj) {
+			return abs(di) > abs(dj)
+		}
+		return cmp.Types[i].Name < cmp.Types[j].Name
+	})
+	return cmp
+}
+
+// Returns how many bytes the type's objects grew by (or, if negative,
+// shrank by).
+func (t *TypeChange) growth() int64 {
+	return int64(t.BytesAfter) - int64(t.BytesBefore)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Prints the comparison, limited to the indicated number of types (or all
+// of them, if that isn't positive).
+func (cmp *Comparison) Print(w io.Writer, limit int) {
+	fmt.Fprintf(w, "Objects: %d -> %d (%+d), %s -> %s (%s)\n",
+		cmp.ObjectsBefore, cmp.ObjectsAfter, int64(cmp.ObjectsAfter)-int64(cmp.ObjectsBefore),
+		units.Format(cmp.BytesBefore), units.Format(cmp.BytesAfter), signedUnitize(int64(cmp.BytesAfter)-int64(cmp.BytesBefore)))
+	fmt.Fprintf(w, "Goroutines: %d -> %d (%+d)\n",
+		cmp.GoroutinesBefore, cmp.GoroutinesAfter, cmp.GoroutinesAfter-cmp.GoroutinesBefore)
+	fmt.Fprintf(w, "%d types changed:\n", len(cmp.Types))
+	for i, t := range cmp.Types {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(w, "  ... and %d more types\n", len(cmp.Types)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  %s: %d -> %d objects (%+d), %s -> %s (%s)\n",
+			t.Name, t.ObjectsBefore, t.ObjectsAfter, int64(t.ObjectsAfter)-int64(t.ObjectsBefore),
+			units.Format(t.BytesBefore), units.Format(t.BytesAfter), signedUnitize(t.growth()))
+	}
+}
+
+func signedUnitize(n int64) string {
+	if n < 0 {
+		return "-" + units.Format(uint64(-n))
+	}
+	return "+" + units.Format(uint64(n))
+}
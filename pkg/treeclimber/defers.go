@@ -0,0 +1,116 @@
+package treeclimber
+
+import (
+	"fmt"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// Returns the pending defers of a goroutine, starting with the one that
+// will run first. A corrupted dump can have links that lead nowhere, or
+// around in a circle; the list stops there.
+func (c *TreeClimber) defersOf(g *heapdump.Goroutine) []*heapdump.DeferRecord {
+	defers := make([]*heapdump.DeferRecord, 0)
+	seen := make(map[uint64]bool)
+	for address := g.TopDefer; address != 0 && !seen[address]; {
+		d, found := c.defers[address]
+		if !found {
+			break
+		}
+		seen[address] = true
+		defers = append(defers, d)
+		address = d.Next
+	}
+	return defers
+}
+
+// Returns the panics in progress on a goroutine, starting with the most
+// recent.
+func (c *TreeClimber) panicsOf(g *heapdump.Goroutine) []*heapdump.PanicRecord {
+	panics := make([]*heapdump.PanicRecord, 0)
+	seen := make(map[uint64]bool)
+	for address := g.TopPanic; address != 0 && !seen[address]; {
+		p, found := c.panics[address]
+		if !found {
+			break
+		}
+		seen[address] = true
+		panics = append(panics, p)
+		address = p.Next
+	}
+	return panics
+}
+
+// Returns the entry point of the function that a defer will call. That's
+// the entry point it records, if the program's symbols have a function
+// there; failing that, the code pointer that the funcval starts with, if
+// the funcval is in the dump and the symbols have a function there.
+func (c *TreeClimber) deferredPC(d *heapdump.DeferRecord) uint64 {
+	if _, offset, found := c.symbols.NearestSymbol(d.EntryPointPc); found && offset == 0 {
+		return d.EntryPointPc
+	}
+	if o, found := c.containing(d.FuncVal); found && c.params != nil {
+		offset := d.FuncVal - o.GetAddress()
+		if offset+c.params.PointerSize <= uint64(len(o.GetContents())) {
+			pc := c.word(o.GetContents()[offset:])
+			if _, offset, found := c.symbols.NearestSymbol(pc); found && offset == 0 {
+				return pc
+			}
+		}
+	}
+	return d.EntryPointPc
+}
+
+// Names the function that a defer will call (see deferredPC).
+func (c *TreeClimber) deferredFunc(d *heapdump.DeferRecord) string {
+	pc := c.deferredPC(d)
+	if name, offset, found := c.symbols.NearestSymbol(pc); found && offset == 0 {
+		return name
+	}
+	return fmt.Sprintf("0x%x", pc)
+}
+
+// Describes the heap object that a defer or panic holds on to through the
+// indicated pointer, if there is one, with how much memory it retains,
+// given the retained bytes of each record.
+func (c *TreeClimber) heldBy(pointer uint64, retained map[uint64]uint64) string {
+	o, found := c.containing(pointer)
+	if !found {
+		return ""
+	}
+	object, isObject := c.memory[o.GetAddress()].(*heapdump.Object)
+	if !isObject {
+		return ""
+	}
+	return fmt.Sprintf("; holds %s, retaining %s", object.String(), units.Format(retained[object.Address]))
+}
+
+// Prints every goroutine with pending defers or a panic in progress, along
+// with each of them: the function each defer will call, and the value each
+// panic was raised with. Closures that defers will call, and panic values,
+// can keep a lot of memory alive, so what each one points to is printed
+// with how much memory it retains.
+func (c *TreeClimber) PrintDefers() error {
+	retained := c.retainedBytes()
+	defer heapdump.StartPhase("traversal")()
+	printed := 0
+	for _, g := range c.goroutines {
+		defers, panics := c.defersOf(g), c.panicsOf(g)
+		if len(defers) == 0 && len(panics) == 0 {
+			continue
+		}
+		printed++
+		fmt.Fprintf(c.out, "%s: %d defers, %d panics\n", g.String(), len(defers), len(panics))
+		for _, p := range panics {
+			fmt.Fprintf(c.out, "  Panic @ 0x%x: %s value 0x%x%s\n", p.Address, c.typeName(p.PanicArgType), p.PanicArgData, c.heldBy(p.PanicArgData, retained))
+		}
+		for _, d := range defers {
+			fmt.Fprintf(c.out, "  Defer @ 0x%x: %s%s\n", d.Address, c.symbols.FormatPC(c.deferredPC(d)), c.heldBy(d.FuncVal, retained))
+		}
+	}
+	if printed == 0 {
+		return fmt.Errorf("No goroutines have pending defers or panics")
+	}
+	return nil
+}
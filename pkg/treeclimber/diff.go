@@ -0,0 +1,159 @@
+package treeclimber
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Compares the contents of the object at the indicated address against the
+// same object in another dump. If the address doesn't hold a matching object
+// in the other dump (e.g., because it was allocated somewhere else), we look
+// for an object with the same name and size that is reached by the same
+// path from its anchor.
+func (c *TreeClimber) Diff(other *TreeClimber, address uint64) (string, error) {
+	r, found := c.memory[address]
+	if !found {
+		return "", fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	o, isOwner := r.(heapdump.Owner)
+	if !isOwner {
+		return "", fmt.Errorf("Object of type %T does not have Contents", r)
+	}
+
+	match, err := other.match(c, o)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n", r.(fmt.Stringer).String())
+	fmt.Fprintf(&b, "+++ %s\n", match.(fmt.Stringer).String())
+
+	oldContents := o.GetContents()
+	newContents := match.GetContents()
+	if len(oldContents) != len(newContents) {
+		fmt.Fprintf(&b, "Size changed: %d -> %d bytes\n", len(oldContents), len(newContents))
+	}
+
+	// Field-level diff: compare each pointer-sized word, noting which ones
+	// are known to hold pointers.
+	pointers := make(map[uint64]bool)
+	for _, field := range o.GetFields() {
+		pointers[field.Offset] = true
+	}
+	wordSize := c.params.PointerSize
+	fmt.Fprintf(&b, "Fields:\n")
+	for offset := uint64(0); offset+wordSize <= uint64(len(oldContents)) && offset+wordSize <= uint64(len(newContents)); offset += wordSize {
+		before := c.word(oldContents[offset:])
+		after := c.word(newContents[offset:])
+		if before == after {
+			continue
+		}
+		if pointers[offset] {
+			fmt.Fprintf(&b, "  +0x%04x: %s -> %s (pointer)\n", offset, c.symbols.FormatAddr(before), c.symbols.FormatAddr(after))
+		} else {
+			fmt.Fprintf(&b, "  +0x%04x: %d -> %d (0x%x -> 0x%x)\n", offset, before, after, before, after)
+		}
+	}
+
+	// Byte-level diff, in hexdump form, of only the lines that changed
+	fmt.Fprintf(&b, "Bytes:\n")
+	for offset := 0; offset < len(oldContents) || offset < len(newContents); offset += 16 {
+		oldLine := line(oldContents, offset)
+		newLine := line(newContents, offset)
+		if bytes.Equal(oldLine, newLine) {
+			continue
+		}
+		if len(oldLine) > 0 {
+			fmt.Fprintf(&b, "- %08x  %s\n", offset, hex.EncodeToString(oldLine))
+		}
+		if len(newLine) > 0 {
+			fmt.Fprintf(&b, "+ %08x  %s\n", offset, hex.EncodeToString(newLine))
+		}
+	}
+	return b.String(), nil
+}
+
+func line(contents []byte, offset int) []byte {
+	if offset >= len(contents) {
+		return nil
+	}
+	end := offset + 16
+	if end > len(contents) {
+		end = len(contents)
+	}
+	return contents[offset:end]
+}
+
+func (c *TreeClimber) byteOrder() binary.ByteOrder {
+	if c.params.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func (c *TreeClimber) word(b []byte) uint64 {
+	switch c.params.PointerSize {
+	case 4:
+		return uint64(c.byteOrder().Uint32(b))
+	case 2:
+		return uint64(c.byteOrder().Uint16(b))
+	}
+	return c.byteOrder().Uint64(b)
+}
+
+// Finds the record in this dump that corresponds to the indicated record
+// from another dump. Objects are matched by fingerprint, so that they can
+// be found even if they were allocated somewhere else; anything else (or
+// an object with no match) has to be at the same address.
+func (c *TreeClimber) match(other *TreeClimber, o heapdump.Owner) (heapdump.Owner, error) {
+	object, isObject := o.(*heapdump.Object)
+	if isObject {
+		match, _ := c.matchObject(other, object)
+		if match != nil {
+			return match, nil
+		}
+	}
+
+	r, found := c.memory[o.GetAddress()]
+	if found {
+		candidate, isOwner := r.(heapdump.Owner)
+		if isOwner && sameKind(o, candidate) {
+			return candidate, nil
+		}
+	}
+
+	if !isObject {
+		return nil, fmt.Errorf("No matching record for 0x%x", o.GetAddress())
+	}
+	path := strings.Join(other.rootPath(object.Address), " <- ")
+	return nil, fmt.Errorf("No object matching %s reached via %s", object.String(), path)
+}
+
+func sameKind(a, b heapdump.Owner) bool {
+	return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b) &&
+		len(a.GetContents()) == len(b.GetContents())
+}
+
+// Returns the owners of the indicated record, including those that point
+// into the middle of it.
+func (c *TreeClimber) ownersOf(address uint64) []heapdump.Record {
+	owners := make([]heapdump.Record, 0)
+	end := address + 1
+	r, found := c.memory[address]
+	if found {
+		o, isOwner := r.(heapdump.Owner)
+		if isOwner {
+			end = address + uint64(len(o.GetContents()))
+		}
+	}
+	for _, dest := range between(c.ownedIndex, address, end) {
+		owners = append(owners, c.owners[dest]...)
+	}
+	return owners
+}
@@ -0,0 +1,148 @@
+package treeclimber
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// An object is listed by PrintEntropy if it's at least this big...
+const sparseMinBytes = 64 * 1024
+
+// ...and at least this much of it is zeros
+const sparseRatio = 0.9
+
+// The number of objects and types listed by PrintEntropy
+const entropyTop = 20
+
+// What an object's contents are made of
+type contentStats struct {
+	size    uint64
+	zeros   uint64  // bytes that are zero
+	longest uint64  // the longest run of zero bytes
+	entropy float64 // Shannon entropy, in bits per byte
+}
+
+// The contents of the objects of one type, added up
+type typeContents struct {
+	name    string
+	objects uint64
+	size    uint64
+	zeros   uint64
+	entropy float64 // weighted by size, until it's divided by size
+}
+
+func newContentStats(contents []byte) contentStats {
+	var counts [256]uint64
+	s := contentStats{size: uint64(len(contents))}
+	var run uint64
+	for _, b := range contents {
+		counts[b]++
+		if b == 0 {
+			run++
+			s.longest = max(s.longest, run)
+		} else {
+			run = 0
+		}
+	}
+	s.zeros = counts[0]
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(s.size)
+			s.entropy -= p * math.Log2(p)
+		}
+	}
+	return s
+}
+
+func (s contentStats) zeroRatio() float64 {
+	if s.size == 0 {
+		return 0
+	}
+	return float64(s.zeros) / float64(s.size)
+}
+
+// Prints how much of the heap is zeros, and how much information the rest
+// holds: the big objects that are mostly zeros, which could be trimmed,
+// allocated lazily, or kept out of the heap altogether (e.g., with mmap),
+// and the types whose objects hold the most zero bytes, with the entropy of
+// their contents in bits per byte. Entropy near 8 means compressed or random
+// data; near 0, the same few bytes over and over.
+func (c *TreeClimber) PrintEntropy() error {
+	defer heapdump.StartPhase("traversal")()
+	sparse := make([]*heapdump.Object, 0)
+	stats := make(map[uint64]contentStats)
+	types := make(map[string]*typeContents)
+	var total contentStats
+	for o := range c.AllObjects() {
+		s := newContentStats(o.Contents)
+		name := histogramName(o)
+		t, found := types[name]
+		if !found {
+			t = &typeContents{name: name}
+			types[name] = t
+		}
+		t.objects++
+		t.size += s.size
+		t.zeros += s.zeros
+		t.entropy += s.entropy * float64(s.size)
+		total.size += s.size
+		total.zeros += s.zeros
+		if s.size >= sparseMinBytes && s.zeroRatio() >= sparseRatio {
+			sparse = append(sparse, o)
+			stats[o.Address] = s
+		}
+	}
+	if total.size == 0 {
+		return fmt.Errorf("Could not find any objects in the dump")
+	}
+
+	fmt.Fprintf(c.out, "%s of %s of objects (%.1f%%) is zeros\n",
+		units.Format(total.zeros), units.Format(total.size), 100*total.zeroRatio())
+	sort.Slice(sparse, func(i, j int) bool {
+		a, b := stats[sparse[i].Address], stats[sparse[j].Address]
+		if a.zeros != b.zeros {
+			return a.zeros > b.zeros
+		}
+		return sparse[i].Address < sparse[j].Address
+	})
+	if len(sparse) == 0 {
+		fmt.Fprintf(c.out, "No objects of at least %s are at least %.0f%% zeros\n", units.Format(sparseMinBytes), 100*sparseRatio)
+	} else {
+		fmt.Fprintf(c.out, "%d objects of at least %s are at least %.0f%% zeros:\n", len(sparse), units.Format(sparseMinBytes), 100*sparseRatio)
+	}
+	for i, o := range sparse {
+		if i == entropyTop {
+			fmt.Fprintf(c.out, "  ... and %d more\n", len(sparse)-entropyTop)
+			break
+		}
+		s := stats[o.Address]
+		fmt.Fprintf(c.out, "  %s @ 0x%x (%s): %.1f%% zeros, longest run %s, %.2f bits/byte\n",
+			o.GetName(), o.Address, units.Format(s.size), 100*s.zeroRatio(), units.Format(s.longest), s.entropy)
+	}
+
+	list := make([]*typeContents, 0, len(types))
+	for _, t := range types {
+		t.entropy /= max(float64(t.size), 1)
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].zeros != list[j].zeros {
+			return list[i].zeros > list[j].zeros
+		}
+		return list[i].name < list[j].name
+	})
+	fmt.Fprintf(c.out, "Types with the most zeros:\n")
+	for i, t := range list {
+		if i == entropyTop || t.zeros == 0 {
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %d objects, %s, %.1f%% zeros (%s), %.2f bits/byte\n",
+			t.name, t.objects, units.Format(t.size),
+			100*float64(t.zeros)/float64(max(t.size, 1)), units.Format(t.zeros), t.entropy)
+	}
+	return nil
+}
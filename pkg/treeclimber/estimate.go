@@ -0,0 +1,245 @@
+package treeclimber
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The number of objects reachable from an object that EstimateRetained
+// checks; if there are no more than this, they're all checked, and the
+// estimate is exact.
+const retainedSamples = 400
+
+// How much memory an object retains, as estimated by EstimateRetained.
+type RetainedEstimate struct {
+	Objects      uint64 `json:"objects"`       // retained objects, including the object itself
+	Bytes        uint64 `json:"bytes"`         // their total size
+	ObjectsError uint64 `json:"objects_error"` // half the width of a 95% confidence interval for Objects
+	BytesError   uint64 `json:"bytes_error"`   // likewise, for Bytes
+	Reachable    uint64 `json:"reachable"`     // objects reachable from the object, not counting itself
+	Sampled      int    `json:"sampled"`       // how many of those were checked
+	Exact        bool   `json:"exact"`         // whether all of them were checked, so there's no error
+}
+
+// Estimates how much memory the object at the indicated address retains,
+// without computing the dominator tree of the whole heap, which can take
+// minutes for huge heaps. Instead, a random sample of the objects reachable
+// from it is checked, by searching back through each one's owners for an
+// anchor, skipping the object; those without a way to reach an anchor are
+// retained. The searches share what they find, so an owner already known
+// to lead to an anchor (or not to) isn't searched again.
+//
+// The estimate is exact if there are few enough reachable objects to check
+// them all. Otherwise, it's within the indicated error 95% of the time;
+// objects that only some of their siblings retain (as in a linked list
+// whose middle is also pointed to from elsewhere) need the most samples to
+// pin down. Objects that can't be reached from an anchor at all are counted
+// as retained by whatever can reach them, which is where the estimate
+// differs from the dominator tree, which hangs them from the root instead.
+func (c *TreeClimber) EstimateRetained(address uint64) (RetainedEstimate, error) {
+	defer heapdump.StartPhase("traversal")()
+	o, isObject := c.memory[address].(*heapdump.Object)
+	if !isObject {
+		return RetainedEstimate{}, fmt.Errorf("Could not find object at address 0x%x", address)
+	}
+	estimate := RetainedEstimate{Objects: 1, Bytes: uint64(len(o.Contents))}
+
+	// Everything reachable from the object, in address order so that the
+	// sample doesn't depend on the order the dump was read in
+	reachable := c.reachableFrom(address)
+	estimate.Reachable = uint64(len(reachable))
+	sample := reachable
+	if len(reachable) > retainedSamples {
+		random := rand.New(rand.NewPCG(address, uint64(len(reachable))))
+		sample = make([]uint64, len(reachable))
+		copy(sample, reachable)
+		for i := 0; i < retainedSamples; i++ {
+			j := i + random.IntN(len(sample)-i)
+			sample[i], sample[j] = sample[j], sample[i]
+		}
+		sample = sample[:retainedSamples]
+	}
+	estimate.Sampled = len(sample)
+	estimate.Exact = len(sample) == len(reachable)
+
+	escapes := make(map[uint64]bool) // whether an object can reach an anchor without the object
+	var objects, bytes, squares float64
+	for _, s := range sample {
+		if c.escapes(s, address, escapes) {
+			continue
+		}
+		size := float64(len(c.memory[s].(*heapdump.Object).Contents))
+		objects++
+		bytes += size
+		squares += size * size
+	}
+	if estimate.Exact {
+		estimate.Objects += uint64(objects)
+		estimate.Bytes += uint64(bytes)
+		return estimate, nil
+	}
+
+	// Each sampled object stands for this many reachable ones
+	n := float64(len(sample))
+	scale := float64(len(reachable)) / n
+	estimate.Objects += uint64(math.Round(objects * scale))
+	estimate.Bytes += uint64(math.Round(bytes * scale))
+	p := objects / n
+	estimate.ObjectsError = uint64(math.Round(1.96 * scale * math.Sqrt(n*p*(1-p))))
+	mean := bytes / n
+	variance := max(squares/n-mean*mean, 0)
+	estimate.BytesError = uint64(math.Round(1.96 * scale * math.Sqrt(n*variance)))
+	return estimate, nil
+}
+
+// Returns the objects that can be reached from the record at the indicated
+// address, not counting the record itself, sorted by address. Weak owners
+// aren't followed, just as they have no edges in the retention graph.
+func (c *TreeClimber) reachableFrom(address uint64) []uint64 {
+	seen := map[uint64]bool{address: true}
+	reachable := make([]uint64, 0)
+	queue := []uint64{address}
+	for len(queue) > 0 {
+		r := c.memory[queue[0]]
+		queue = queue[1:]
+		o, isOwner := r.(heapdump.Owner)
+		if !isOwner || c.isWeakOwner(r) {
+			continue
+		}
+		for _, target := range c.pointers(o) {
+			child, found := c.containing(target)
+			if !found || seen[child.GetAddress()] {
+				continue
+			}
+			if _, isObject := child.(*heapdump.Object); !isObject {
+				continue
+			}
+			seen[child.GetAddress()] = true
+			reachable = append(reachable, child.GetAddress())
+			queue = append(queue, child.GetAddress())
+		}
+	}
+	sortAddresses(reachable)
+	return reachable
+}
+
+// Reports whether the object at the indicated address can be reached from
+// an anchor without going through the excluded object, by searching back
+// through its owners. What's learned about the objects searched is added
+// to escapes: those on the way to an anchor can reach one, and if there's
+// no way, none of the objects searched can.
+func (c *TreeClimber) escapes(address uint64, excluded uint64, escapes map[uint64]bool) bool {
+	if known, found := escapes[address]; found {
+		return known
+	}
+	from := map[uint64]uint64{address: address} // each object -> the one it was found as an owner of
+	queue := []uint64{address}
+	escaped := func(a uint64) bool {
+		for ; from[a] != a; a = from[a] {
+			escapes[a] = true
+		}
+		escapes[a] = true
+		return true
+	}
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		if c.rootedInside(a) {
+			return escaped(a)
+		}
+		for _, owner := range c.ownersOf(a) {
+			o := owner.(heapdump.Addressable).GetAddress()
+			if o == excluded || c.isWeakOwner(owner) {
+				continue
+			}
+			if _, isObject := owner.(*heapdump.Object); !isObject {
+				// Stack frames and globals are anchors
+				return escaped(a)
+			}
+			if _, seen := from[o]; seen {
+				continue
+			}
+			from[o] = a
+			if known, found := escapes[o]; found {
+				if known {
+					return escaped(o)
+				}
+				continue
+			}
+			queue = append(queue, o)
+		}
+	}
+	for a := range from {
+		escapes[a] = false
+	}
+	return false
+}
+
+// Reports whether a strong runtime root points anywhere into the object at
+// the indicated address.
+func (c *TreeClimber) rootedInside(address uint64) bool {
+	end := address + uint64(len(c.memory[address].(*heapdump.Object).Contents))
+	for _, dest := range between(c.rootedIndex, address, max(end, address+1)) {
+		if len(c.strongRoots(dest)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Uses the dominator tree of the whole heap to find exactly what the
+// indicated object retains, rather than estimating it, for SetExact.
+func (c *TreeClimber) exactRetained(address uint64) (RetainedEstimate, error) {
+	if c.exactTotals == nil {
+		g := c.retentionGraph()
+		objects, bytes := g.retainedTotals()
+		c.exactTotals = make(map[uint64]RetainedEstimate)
+		for i := 1; i < len(g.addresses); i++ {
+			if g.isObject[i] {
+				c.exactTotals[g.addresses[i]] = RetainedEstimate{Objects: objects[i], Bytes: bytes[i], Exact: true}
+			}
+		}
+	}
+	estimate, found := c.exactTotals[address]
+	if !found {
+		return RetainedEstimate{}, fmt.Errorf("Could not find object at address 0x%x", address)
+	}
+	return estimate, nil
+}
+
+// Sets whether PrintRetained finds exactly what an object retains from the
+// dominator tree of the whole heap, rather than estimating it (see
+// EstimateRetained).
+func (c *TreeClimber) SetExact(exact bool) {
+	c.exact = exact
+}
+
+// Prints how much memory the object at the indicated address retains:
+// estimated, for a quick answer on huge heaps, unless SetExact has been
+// called.
+func (c *TreeClimber) PrintRetained(address uint64) error {
+	estimate := RetainedEstimate{}
+	var err error
+	if c.exact {
+		estimate, err = c.exactRetained(address)
+	} else {
+		estimate, err = c.EstimateRetained(address)
+	}
+	if err != nil {
+		return err
+	}
+	name := c.memory[address].(fmt.Stringer).String()
+	if estimate.Exact {
+		fmt.Fprintf(c.out, "%s retains %d objects, %s\n", name, estimate.Objects, units.Format(estimate.Bytes))
+		return nil
+	}
+	fmt.Fprintf(c.out, "%s retains about %d objects (± %d), %s (± %s)\n", name,
+		estimate.Objects, estimate.ObjectsError, units.Format(estimate.Bytes), units.Format(estimate.BytesError))
+	fmt.Fprintf(c.out, "Estimated from %d of the %d objects reachable from it, with 95%% confidence; --exact computes it exactly, which can take much longer\n",
+		estimate.Sampled, estimate.Reachable)
+	return nil
+}
@@ -0,0 +1,67 @@
+package treeclimber
+
+import (
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// What happened during a traversal
+type TraversalEventKind int
+
+const (
+	NodeDiscovered TraversalEventKind = iota // a record was reached for the first time
+	EdgeDiscovered                           // a pointer (or runtime root) into a record was found
+	Truncated                                // the traversal stopped at a record without following it
+)
+
+var traversalEventNames = []string{"NodeDiscovered", "EdgeDiscovered", "Truncated"}
+
+func (k TraversalEventKind) String() string {
+	if int(k) < len(traversalEventNames) {
+		return traversalEventNames[k]
+	}
+	return "Unknown"
+}
+
+// Something found while building a graph, as it's found.
+type TraversalEvent struct {
+	Kind TraversalEventKind
+
+	// For NodeDiscovered and Truncated: the record, and how many hops it
+	// is from where the traversal started, following owners or what's
+	// pointed to, whichever the traversal is following. The record is nil
+	// for pointers to addresses that aren't in any record.
+	Record heapdump.Record
+	Depth  int
+
+	// For EdgeDiscovered: the record holding the pointer (or 0, for a
+	// runtime root, which is in Root), the record pointed into, and the
+	// address actually pointed to, which may be inside it
+	Owner  uint64
+	Target uint64
+	Dest   uint64
+	Root   *heapdump.OtherRoot
+
+	// For Truncated: why the record wasn't followed, "depth" if the
+	// traversal went as deep as it was allowed to, or "pruned" if the
+	// record's type is pruned (see SetPrune)
+	Reason string
+}
+
+// A TraversalFunc is called with each event of the traversals that build
+// graphs, so that frontends (a web UI, say) can show a graph as it grows
+// rather than waiting for the whole thing, which can take a long time for
+// objects with huge numbers of owners. Events come from the goroutine doing
+// the traversal, one at a time and in the same order every time, and a
+// record is always discovered before any edge to or from it.
+type TraversalFunc func(event TraversalEvent)
+
+func (c *TreeClimber) SetTraversalFunc(f TraversalFunc) {
+	c.traversalFunc = f
+}
+
+// Reports an event to the TraversalFunc, if there is one.
+func (c *TreeClimber) emit(event TraversalEvent) {
+	if c.traversalFunc != nil {
+		c.traversalFunc(event)
+	}
+}
@@ -0,0 +1,481 @@
+package treeclimber
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// The most owners and children that a Focus lists, unless asked for more;
+// objects like big maps and slices can point to hundreds of thousands of
+// others, and those are best looked at in pages.
+const focusNeighbors = 100
+
+// A record as shown by the explorer, in a Focus.
+type FocusNode struct {
+	ID   string `json:"id"`   // the record's address, in hex
+	Kind string `json:"kind"` // as in JSONNode, or "Unknown" for addresses that aren't in any record
+	// The object's name (abbreviated, as in graphs), the stack frame's
+	// function, or the global's symbol
+	Name  string `json:"name,omitempty"`
+	Size  uint64 `json:"size"`
+	Color string `json:"color,omitempty"` // of its border in graphs, which shows how it's rooted
+	Tag   string `json:"tag,omitempty"`   // from the annotations file, if any
+	// For owners and children: where the pointer between this record and
+	// the focus lives, as an offset into its owner
+	Offset uint64 `json:"offset"`
+	Field  string `json:"field,omitempty"`   // the global holding that pointer, if known
+	HeldAs string `json:"held_as,omitempty"` // the interface type it's held as, if it's in an interface value
+}
+
+// A record along with the records that point to it and that it points to,
+// one hop in each direction: everything the explorer needs to draw it and
+// move on to one of its neighbors.
+type Focus struct {
+	Node        FocusNode   `json:"node"`
+	Description string      `json:"description"`     // the record, as printed by the at command
+	Stack       string      `json:"stack,omitempty"` // for stack frames, the frames from this one down
+	Roots       []string    `json:"roots,omitempty"` // runtime roots pointing into it
+	Owners      []FocusNode `json:"owners"`
+	Children    []FocusNode `json:"children"`
+	// How many more there are than were listed, and where the listing
+	// started, for fetching the next page
+	MoreOwners   int `json:"more_owners"`
+	MoreChildren int `json:"more_children"`
+	Skip         int `json:"skip"`
+}
+
+// Describes the record containing the indicated address, with the first
+// limit of its owners and children after skipping the first skip of each
+// (in the order they're in in the dump, or, for children, in the record).
+// A record that appears more than once among its owners or children, by
+// holding more than one pointer, is only listed for its first pointer.
+func (c *TreeClimber) Focus(address uint64, skip int, limit int) (*Focus, error) {
+	o, found := c.containing(address)
+	if !found {
+		return nil, fmt.Errorf("Could not find record containing address 0x%x", address)
+	}
+	address = o.GetAddress()
+	if limit <= 0 {
+		limit = focusNeighbors
+	}
+	focus := &Focus{
+		Node:        c.focusNode(address),
+		Description: c.memory[address].(fmt.Stringer).String(),
+		Owners:      make([]FocusNode, 0),
+		Children:    make([]FocusNode, 0),
+		Skip:        skip,
+	}
+	if _, isFrame := c.memory[address].(*heapdump.StackFrame); isFrame {
+		focus.Stack = c.fullStack(address, "\n")
+	}
+	end := address + uint64(len(o.GetContents()))
+	for _, dest := range between(c.rootedIndex, address, max(end, address+1)) {
+		for _, root := range c.roots[dest] {
+			focus.Roots = append(focus.Roots, root.Description)
+		}
+	}
+
+	seen := make(map[uint64]bool)
+	for _, owner := range c.ownersOf(address) {
+		oa := owner.(heapdump.Addressable).GetAddress()
+		if seen[oa] || oa == address {
+			continue
+		}
+		seen[oa] = true
+		if len(seen) <= skip {
+			continue
+		}
+		if len(focus.Owners) == limit {
+			focus.MoreOwners++
+			continue
+		}
+		node := c.focusNode(oa)
+		if source := c.pointerInto(owner.(heapdump.Owner), address); source != 0 {
+			node.Offset = source - oa
+			node.Field = c.symbols.GetName(source)
+			node.HeldAs = c.heldAs(owner.(heapdump.Owner), source)
+		}
+		focus.Owners = append(focus.Owners, node)
+	}
+
+	seen = make(map[uint64]bool)
+	sources, targets := c.pointerInfo(o)
+	for i, target := range targets {
+		if target == 0 {
+			continue
+		}
+		child := target
+		if r, found := c.containing(target); found {
+			child = r.GetAddress()
+		} else if c.renderOptions.HideUnknown {
+			continue
+		}
+		if seen[child] || child == address {
+			continue
+		}
+		seen[child] = true
+		if len(seen) <= skip {
+			continue
+		}
+		if len(focus.Children) == limit {
+			focus.MoreChildren++
+			continue
+		}
+		node := c.focusNode(child)
+		node.Offset = sources[i] - address
+		node.Field = c.symbols.GetName(sources[i])
+		node.HeldAs = c.heldAs(o, sources[i])
+		focus.Children = append(focus.Children, node)
+	}
+	return focus, nil
+}
+
+func (c *TreeClimber) focusNode(address uint64) FocusNode {
+	node := FocusNode{ID: fmt.Sprintf("0x%x", address), Kind: "Unknown"}
+	r, found := c.memory[address]
+	if !found {
+		return node
+	}
+	node.Kind = strings.TrimPrefix(fmt.Sprintf("%T", r), "*heapdump.")
+	if o, isOwner := r.(heapdump.Owner); isOwner {
+		node.Size = uint64(len(o.GetContents()))
+	}
+	switch r := r.(type) {
+	case *heapdump.Object:
+		node.Name = r.GetName()
+		node.Color = c.rootClass(address).color()
+		if c.finalizers[address] != nil {
+			node.Color = finalizerRooted.color()
+		}
+	case *heapdump.StackFrame:
+		node.Name = heapdump.AbbreviateName(r.Name)
+		node.Color = stackRooted.color()
+	case *heapdump.Global:
+		node.Name = heapdump.AbbreviateName(r.Name)
+		if _, inBss := r.Segment.(*heapdump.BssSegment); inBss {
+			node.Color = bssRooted.color()
+		} else {
+			node.Color = dataRooted.color()
+		}
+	case *heapdump.BssSegment:
+		node.Color = bssRooted.color()
+	case *heapdump.DataSegment:
+		node.Color = dataRooted.color()
+	}
+	if tag, tagged := c.tagOf(address); tagged {
+		node.Tag = tag
+	}
+	return node
+}
+
+// Returns a handler for a web page that explores the heap one record at a
+// time: it shows a record in the middle, its owners to the left and its
+// children to the right, and clicking any of them moves over to it, with
+// only that record's neighborhood fetched from the handler. However big the
+// heap, the browser only ever holds one neighborhood, so heaps far too big
+// to draw whole can still be explored. The page starts at the indicated
+// address, unless it's zero.
+//
+// Besides the page itself at "/", the handler answers "focus?address=...",
+// with the Focus of that address as JSON (skip and limit page through big
+// neighborhoods); "retained?address=...", with its RetainedEstimate; and
+// "find?pattern=...", with the addresses matching the regular expression.
+// A TreeClimber only does one thing at a time, so requests take turns; the
+// indexes and totals they share are built up front, so that nothing else
+// using the TreeClimber alongside the handler races to build them, and so
+// that, with SetExact, the first click doesn't pay for the dominator tree.
+func (c *TreeClimber) ExploreHandler(title string, start uint64) http.Handler {
+	c.containing(0)
+	c.rootClass(0)
+	c.tagOf(0)
+	if c.exact {
+		c.exactRetained(0)
+	}
+	var mutex sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page := struct {
+			Title string
+			Start string
+		}{title, ""}
+		if start != 0 {
+			page.Start = fmt.Sprintf("0x%x", start)
+		}
+		explorerTemplate.Execute(w, page)
+	})
+	mux.HandleFunc("/focus", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		address, err := c.symbols.ParseAddress(r.FormValue("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		skip, _ := strconv.Atoi(r.FormValue("skip"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		focus, err := c.Focus(address, max(skip, 0), limit)
+		writeJSON(w, focus, err)
+	})
+	mux.HandleFunc("/retained", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		address, err := c.symbols.ParseAddress(r.FormValue("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if c.exact {
+			estimate, err := c.exactRetained(address)
+			writeJSON(w, estimate, err)
+			return
+		}
+		estimate, err := c.EstimateRetained(address)
+		writeJSON(w, estimate, err)
+	})
+	mux.HandleFunc("/find", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		addresses, total, err := c.MatchAddresses(r.FormValue("pattern"), focusNeighbors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		found := struct {
+			Matches []FocusNode `json:"matches"`
+			Total   int         `json:"total"`
+		}{make([]FocusNode, 0, len(addresses)), total}
+		for _, address := range addresses {
+			if o, inDump := c.containing(address); inDump {
+				node := c.focusNode(o.GetAddress())
+				node.Offset = address - o.GetAddress()
+				node.Field = c.symbols.GetName(address)
+				found.Matches = append(found.Matches, node)
+			}
+		}
+		writeJSON(w, found, nil)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+var explorerTemplate = template.Must(template.New("explorer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+form { margin-bottom: 1em; }
+#trail a { margin-right: 0.3em; }
+#matches div { cursor: pointer; margin: 0.2em 0; }
+svg text { font-size: 12px; pointer-events: none; }
+svg .node rect { fill: white; stroke-width: 2; cursor: pointer; }
+svg .node:hover rect { fill: #eef4fb; }
+svg .focus rect { fill: yellow; cursor: default; }
+svg .more text { fill: #4a7fb5; text-decoration: underline; pointer-events: auto; cursor: pointer; }
+svg path { fill: none; stroke: #999; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<form id="find">
+<input id="pattern" size="40" placeholder="Type name regex, address, or sym:name">
+<button>Go</button>
+</form>
+<div id="matches"></div>
+<p id="trail"></p>
+<div id="graph"></div>
+<p><button id="retained" hidden>How much does it retain?</button> <span id="estimate"></span></p>
+<pre id="details" hidden></pre>
+<script>
+"use strict";
+// Only the neighborhood of one record is ever held here; everything else
+// is fetched again when it's needed, so huge heaps don't exhaust the
+// browser. The trail of records visited is kept short for the same reason.
+const maxTrail = 20;
+const width = 1100, boxWidth = 300, rowHeight = 28, boxHeight = 22;
+const svgNS = "http://www.w3.org/2000/svg";
+let trail = [];
+let current = null;
+
+function el(name, attrs, parent) {
+  const e = document.createElementNS(svgNS, name);
+  for (const [k, v] of Object.entries(attrs)) e.setAttribute(k, v);
+  if (parent) parent.appendChild(e);
+  return e;
+}
+
+function label(n) {
+  if (n.kind === "Unknown") return "??? " + n.id;
+  let text = (n.name || n.kind) + " (" + n.size + " B)";
+  if (n.tag) text += " [" + n.tag + "]";
+  return text.length > 44 ? text.slice(0, 43) + "…" : text;
+}
+
+function tooltip(n) {
+  let text = n.kind + " @ " + n.id;
+  if (n.name) text += "\n" + n.name;
+  if (n.field) text += "\nvia " + n.field;
+  if (n.offset) text += "\npointer at +0x" + n.offset.toString(16);
+  if (n.held_as) text += "\nheld as " + n.held_as;
+  return text;
+}
+
+function box(svg, n, x, y, cls) {
+  const g = el("g", {class: "node " + cls}, svg);
+  el("title", {}, g).textContent = tooltip(n);
+  el("rect", {x: x, y: y, width: boxWidth, height: boxHeight, rx: 4, stroke: n.color || "gray"}, g);
+  el("text", {x: x + 6, y: y + 15}, g).textContent = label(n);
+  if (cls !== "focus" && n.kind !== "Unknown") {
+    g.addEventListener("click", () => go(n.id));
+  }
+}
+
+function more(svg, count, x, y, address, skip) {
+  const g = el("g", {class: "more"}, svg);
+  const t = el("text", {x: x + 6, y: y + 15}, g);
+  t.textContent = "… and " + count + " more";
+  t.addEventListener("click", () => go(address, skip));
+}
+
+function draw(focus) {
+  const rows = Math.max(focus.owners.length + (focus.more_owners ? 1 : 0),
+                        focus.children.length + (focus.more_children ? 1 : 0), 1);
+  const height = rows * rowHeight + 10;
+  const svg = el("svg", {width: width, height: height});
+  const middle = (height - boxHeight) / 2;
+  const left = 0, center = (width - boxWidth) / 2, right = width - boxWidth;
+  const edge = (x1, y1, x2, y2) => {
+    const mx = (x1 + x2) / 2;
+    el("path", {d: "M" + x1 + "," + y1 + " C" + mx + "," + y1 + " " + mx + "," + y2 + " " + x2 + "," + y2}, svg);
+  };
+  focus.owners.forEach((n, i) => {
+    const y = 5 + i * rowHeight;
+    edge(left + boxWidth, y + boxHeight / 2, center, middle + boxHeight / 2);
+    box(svg, n, left, y, "owner");
+  });
+  if (focus.more_owners) {
+    more(svg, focus.more_owners, left, 5 + focus.owners.length * rowHeight, focus.node.id, focus.skip + focus.owners.length);
+  }
+  focus.children.forEach((n, i) => {
+    const y = 5 + i * rowHeight;
+    edge(center + boxWidth, middle + boxHeight / 2, right, y + boxHeight / 2);
+    box(svg, n, right, y, "child");
+  });
+  if (focus.more_children) {
+    more(svg, focus.more_children, right, 5 + focus.children.length * rowHeight, focus.node.id, focus.skip + focus.children.length);
+  }
+  box(svg, focus.node, center, middle, "focus");
+  return svg;
+}
+
+function showTrail() {
+  const p = document.getElementById("trail");
+  p.replaceChildren();
+  trail.forEach((t, i) => {
+    if (i > 0) p.append("→ ");
+    const a = document.createElement("a");
+    a.href = "#" + t.id;
+    a.textContent = t.name || t.id;
+    p.append(a);
+  });
+}
+
+function go(address, skip) {
+  location.hash = address + (skip ? "@" + skip : "");
+}
+
+async function load() {
+  const [address, skip] = location.hash.slice(1).split("@");
+  if (!address || address === "0x0") return;
+  const graph = document.getElementById("graph");
+  const response = await fetch("focus?address=" + encodeURIComponent(address) + "&skip=" + (skip || 0));
+  if (!response.ok) {
+    const error = document.createElement("p");
+    error.className = "error";
+    error.textContent = await response.text();
+    graph.replaceChildren(error);
+    return;
+  }
+  current = await response.json();
+  graph.replaceChildren(draw(current));
+  trail = trail.filter(t => t.id !== current.node.id);
+  trail.push({id: current.node.id, name: current.node.name});
+  trail = trail.slice(-maxTrail);
+  showTrail();
+  const details = document.getElementById("details");
+  details.textContent = [current.description, current.stack, ...(current.roots || []).map(r => "Runtime root: " + r)]
+    .filter(Boolean).join("\n");
+  details.hidden = false;
+  document.getElementById("retained").hidden = current.node.kind !== "Object";
+  document.getElementById("estimate").textContent = "";
+}
+
+document.getElementById("retained").addEventListener("click", async () => {
+  const estimate = document.getElementById("estimate");
+  estimate.textContent = "…";
+  const response = await fetch("retained?address=" + current.node.id);
+  if (!response.ok) {
+    estimate.textContent = await response.text();
+    return;
+  }
+  const e = await response.json();
+  estimate.textContent = e.exact
+    ? "Retains " + e.objects + " objects, " + e.bytes + " bytes"
+    : "Retains about " + e.objects + " objects (± " + e.objects_error + "), " + e.bytes + " bytes (± " + e.bytes_error + ")";
+});
+
+document.getElementById("find").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const pattern = document.getElementById("pattern").value.trim();
+  const matches = document.getElementById("matches");
+  matches.replaceChildren();
+  if (/^(0x|sym:)/.test(pattern)) {
+    go(pattern);
+    return;
+  }
+  const response = await fetch("find?pattern=" + encodeURIComponent(pattern));
+  if (!response.ok) {
+    matches.textContent = await response.text();
+    return;
+  }
+  const found = await response.json();
+  for (const n of found.matches) {
+    const div = document.createElement("div");
+    div.textContent = label(n) + " @ " + n.id + (n.field ? " (" + n.field + ")" : "");
+    div.addEventListener("click", () => { matches.replaceChildren(); go(n.id); });
+    matches.append(div);
+  }
+  if (found.total > found.matches.length) {
+    matches.append("… and " + (found.total - found.matches.length) + " more; narrow the pattern to see them");
+  } else if (found.total === 0) {
+    matches.textContent = "Nothing matches.";
+  }
+});
+
+window.addEventListener("hashchange", load);
+if (!location.hash && {{.Start}}) location.hash = {{.Start}};
+load();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,115 @@
+package treeclimber
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+type exportEdge struct {
+	from         uint64
+	to           uint64
+	sourceOffset uint64 // where in the owner the pointer lives
+	targetOffset uint64 // where in the target the pointer points
+}
+
+// Writes the entire owner graph as a pair of CSV files in the format expected
+// by "neo4j-admin database import" (which also works with Cypher's LOAD CSV).
+func (c *TreeClimber) WriteNeo4j(nodes io.Writer, edges io.Writer) error {
+	nw := csv.NewWriter(nodes)
+	nw.Write([]string{"id:ID", ":LABEL", "name", "size:long"})
+	for _, address := range c.sortedOwners() {
+		r := c.memory[address]
+		name := ""
+		switch o := r.(type) {
+		case *heapdump.Object:
+			name = o.GetFullName()
+		case *heapdump.StackFrame:
+			name = o.Name
+		case *heapdump.Global:
+			name = o.Name
+		}
+		nw.Write([]string{
+			fmt.Sprintf("0x%x", address),
+			strings.TrimPrefix(fmt.Sprintf("%T", r), "*heapdump."),
+			name,
+			fmt.Sprintf("%d", len(r.(heapdump.Owner).GetContents())),
+		})
+	}
+	nw.Flush()
+	if err := nw.Error(); err != nil {
+		return err
+	}
+
+	ew := csv.NewWriter(edges)
+	ew.Write([]string{":START_ID", ":END_ID", ":TYPE", "source_offset:long", "target_offset:long", "source_name"})
+	for _, e := range c.edges() {
+		ew.Write([]string{
+			fmt.Sprintf("0x%x", e.from),
+			fmt.Sprintf("0x%x", e.to),
+			"POINTS_TO",
+			fmt.Sprintf("%d", e.sourceOffset),
+			fmt.Sprintf("%d", e.targetOffset),
+			c.symbols.GetName(e.from + e.sourceOffset),
+		})
+	}
+	ew.Flush()
+	return ew.Error()
+}
+
+// Writes the owner graph as a simple CSV edge list.
+func (c *TreeClimber) WriteEdgeList(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"from", "to", "source_offset", "target_offset"})
+	for _, e := range c.edges() {
+		cw.Write([]string{
+			fmt.Sprintf("0x%x", e.from),
+			fmt.Sprintf("0x%x", e.to),
+			fmt.Sprintf("%d", e.sourceOffset),
+			fmt.Sprintf("%d", e.targetOffset),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (c *TreeClimber) sortedOwners() []uint64 {
+	addresses := make([]uint64, 0)
+	for address, r := range c.memory {
+		if _, isOwner := r.(heapdump.Owner); isOwner {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+// Returns every pointer between owner records, resolving interior pointers
+// to the record they point into.
+func (c *TreeClimber) edges() []exportEdge {
+	edges := make([]exportEdge, 0)
+	for _, address := range c.sortedOwners() {
+		o := c.memory[address].(heapdump.Owner)
+		sources, targets := c.pointerInfo(o)
+		for i, target := range targets {
+			if target == 0 {
+				continue
+			}
+			to, found := c.containing(target)
+			if !found {
+				continue
+			}
+			edges = append(edges, exportEdge{
+				from:         address,
+				to:           to.GetAddress(),
+				sourceOffset: sources[i] - address,
+				targetOffset: target - to.GetAddress(),
+			})
+		}
+	}
+	return edges
+}
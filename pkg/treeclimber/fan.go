@@ -0,0 +1,90 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+type fan struct {
+	address uint64
+	in      int // pointers into the object, from objects, stack frames, and globals
+	out     int // the object's non-nil pointers
+}
+
+// Prints how the number of pointers into each object (its fan-in) and out
+// of it (its fan-out) are distributed, and then lists the objects with a
+// fan-in of at least minIn and a fan-out of at least minOut, if either is
+// positive. Objects with a huge fan-in are shared by much of the heap, as
+// global caches and registries are; those with a huge fan-out are usually
+// arrays and maps holding a great many children. Pointers from runtime
+// roots aren't counted.
+func (c *TreeClimber) PrintFanStats(minIn int, minOut int) error {
+	defer heapdump.StartPhase("traversal")()
+	fans := make([]fan, 0)
+	for _, address := range c.sortedObjects() {
+		o := c.memory[address].(*heapdump.Object)
+		f := fan{address: address}
+		for _, target := range between(c.ownedIndex, address, address+uint64(len(o.Contents))) {
+			f.in += len(c.owners[target])
+		}
+		for _, target := range c.pointers(o) {
+			if target != 0 {
+				f.out++
+			}
+		}
+		fans = append(fans, f)
+	}
+	if len(fans) == 0 {
+		return fmt.Errorf("Could not find any objects in the dump")
+	}
+
+	ins := make([]int, len(fans))
+	outs := make([]int, len(fans))
+	for i, f := range fans {
+		ins[i], outs[i] = f.in, f.out
+	}
+	fmt.Fprintf(c.out, "Fan-in of %d objects: %s\n", len(fans), fanSummary(ins))
+	fmt.Fprintf(c.out, "Fan-out of %d objects: %s\n", len(fans), fanSummary(outs))
+	if minIn <= 0 && minOut <= 0 {
+		return nil
+	}
+
+	selected := make([]fan, 0)
+	for _, f := range fans {
+		if f.in >= minIn && f.out >= minOut {
+			selected = append(selected, f)
+		}
+	}
+	// Whichever is being looked for comes first
+	sort.SliceStable(selected, func(i, j int) bool {
+		if minIn > 0 && selected[i].in != selected[j].in {
+			return selected[i].in > selected[j].in
+		}
+		return selected[i].out > selected[j].out
+	})
+	fmt.Fprintf(c.out, "%d objects have a fan-in of at least %d and a fan-out of at least %d:\n",
+		len(selected), max(minIn, 0), max(minOut, 0))
+	for _, f := range selected {
+		fmt.Fprintf(c.out, "  %s: %d in, %d out\n", c.memory[f.address], f.in, f.out)
+	}
+	return nil
+}
+
+// Describes the distribution of fan-ins or fan-outs, e.g., "mean 1.2,
+// p50=1, p90=2, p99=9, max=18213".
+func fanSummary(counts []int) string {
+	sort.Ints(counts)
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	parts := []string{fmt.Sprintf("mean %.1f", float64(total)/float64(len(counts)))}
+	for _, p := range []int{50, 90, 99} {
+		parts = append(parts, fmt.Sprintf("p%d=%d", p, counts[p*(len(counts)-1)/100]))
+	}
+	parts = append(parts, fmt.Sprintf("max=%d", counts[len(counts)-1]))
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,237 @@
+package treeclimber
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// Identifies an object in terms that don't depend on where it happened to
+// be allocated, so that the same object can be found in another dump even
+// though address space randomization and reallocation put it somewhere
+// else.
+type fingerprint struct {
+	shape    string // name, size, and path back to the object's anchor
+	contents uint64 // hash of the bytes that aren't pointers
+}
+
+func (c *TreeClimber) fingerprint(o *heapdump.Object) fingerprint {
+	if c.fingerprints == nil {
+		c.fingerprints = make(map[uint64]fingerprint)
+	}
+	if f, found := c.fingerprints[o.Address]; found {
+		return f
+	}
+	pointers := make(map[uint64]bool)
+	for _, field := range o.Fields {
+		pointers[field.Offset] = true
+	}
+	h := fnv.New64a()
+	wordSize := c.params.PointerSize
+	for offset := uint64(0); offset < uint64(len(o.Contents)); offset += wordSize {
+		if pointers[offset] {
+			continue
+		}
+		end := offset + wordSize
+		if end > uint64(len(o.Contents)) {
+			end = uint64(len(o.Contents))
+		}
+		h.Write(o.Contents[offset:end])
+	}
+	f := fingerprint{
+		shape:    strings.Join(c.rootPath(o.Address), " <- "),
+		contents: h.Sum64(),
+	}
+	c.fingerprints[o.Address] = f
+	return f
+}
+
+// Returns the objects in this dump with each shape, sorted by address.
+func (c *TreeClimber) shapes() map[string][]*heapdump.Object {
+	if c.shapeIndex == nil {
+		c.shapeIndex = make(map[string][]*heapdump.Object)
+		for _, address := range c.sortedOwners() {
+			o, isObject := c.memory[address].(*heapdump.Object)
+			if isObject {
+				shape := c.fingerprint(o).shape
+				c.shapeIndex[shape] = append(c.shapeIndex[shape], o)
+			}
+		}
+	}
+	return c.shapeIndex
+}
+
+// Finds the object in this dump that corresponds to the indicated object
+// from another dump: one with the same name and size, reached by the same
+// path from its anchor. If there are several, one with the same contents is
+// preferred, then one at the same address. Also reports whether the
+// contents are unchanged.
+func (c *TreeClimber) matchObject(other *TreeClimber, object *heapdump.Object) (*heapdump.Object, bool) {
+	want := other.fingerprint(object)
+	candidates := c.shapes()[want.shape]
+	var best *heapdump.Object
+	for _, candidate := range candidates {
+		if c.fingerprint(candidate).contents == want.contents {
+			return candidate, true
+		}
+		if best == nil || candidate.Address == object.Address {
+			best = candidate
+		}
+	}
+	return best, false
+}
+
+// For a single object (if address is nonzero), prints whether and where it
+// can be found in each of the other dumps. Otherwise, summarizes by type
+// the objects in this dump that can be found, unchanged, in all of them.
+func (c *TreeClimber) PrintPersistence(others []*TreeClimber, names []string, address uint64) error {
+	if address != 0 {
+		object, isObject := c.memory[address].(*heapdump.Object)
+		if !isObject {
+			return fmt.Errorf("Could not find object at address 0x%x", address)
+		}
+		fmt.Fprintln(c.out, object.String())
+		for i, other := range others {
+			match, unchanged := other.matchObject(c, object)
+			switch {
+			case match == nil:
+				fmt.Fprintf(c.out, "  %s: not found\n", names[i])
+			case unchanged:
+				fmt.Fprintf(c.out, "  %s: unchanged @ 0x%x\n", names[i], match.Address)
+			default:
+				fmt.Fprintf(c.out, "  %s: changed @ 0x%x\n", names[i], match.Address)
+			}
+		}
+		return nil
+	}
+
+	// Identical objects can appear several times in a dump, so count how
+	// many of each there are everywhere.
+	counts := make([]map[fingerprint]int, len(others))
+	for i, other := range others {
+		counts[i] = other.fingerprintCounts()
+	}
+	type persisted struct {
+		name    string
+		objects int
+		bytes   uint64
+	}
+	byName := make(map[string]*persisted)
+	var total persisted
+	for f, n := range c.fingerprintCounts() {
+		for _, count := range counts {
+			if count[f] < n {
+				n = count[f]
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		o := c.shapes()[f.shape][0]
+		p, found := byName[o.GetName()]
+		if !found {
+			p = &persisted{name: o.GetName()}
+			byName[o.GetName()] = p
+		}
+		p.objects += n
+		p.bytes += uint64(n * len(o.Contents))
+		total.objects += n
+		total.bytes += uint64(n * len(o.Contents))
+	}
+
+	list := make([]*persisted, 0, len(byName))
+	for _, p := range byName {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].name < list[j].name
+	})
+	fmt.Fprintf(c.out, "Objects unchanged in all %d other dumps:\n", len(others))
+	for _, p := range list {
+		fmt.Fprintf(c.out, "  %s: %d objects, %s\n", p.name, p.objects, units.Format(p.bytes))
+	}
+	fmt.Fprintf(c.out, "Total: %d objects, %s\n", total.objects, units.Format(total.bytes))
+	return nil
+}
+
+func (c *TreeClimber) fingerprintCounts() map[fingerprint]int {
+	counts := make(map[fingerprint]int)
+	for _, objects := range c.shapes() {
+		for _, o := range objects {
+			counts[c.fingerprint(o)]++
+		}
+	}
+	return counts
+}
+
+// Describes the path from an object back to its anchor, following the
+// first owner found at each step, in terms that don't depend on where
+// objects happened to be allocated. Only the nearest steps are included,
+// since they say the most about what the object is, and since following
+// long chains (such as linked lists) from every object would take a very
+// long time.
+func (c *TreeClimber) rootPath(address uint64) []string {
+	owners := c.firstOwners()
+	path := make([]string, 0)
+	visited := make(map[uint64]bool)
+	for !visited[address] && len(path) < maxRootPath {
+		visited[address] = true
+		r, found := c.memory[address]
+		if !found {
+			break
+		}
+		switch o := r.(type) {
+		case *heapdump.Object:
+			path = append(path, fmt.Sprintf("%s(%d)", o.GetFullName(), len(o.Contents)))
+		case *heapdump.StackFrame:
+			return append(path, o.Name)
+		default:
+			return append(path, fmt.Sprintf("%T", r))
+		}
+		owner, found := owners[address]
+		if !found {
+			break
+		}
+		source := c.pointerSource(owner, address)
+		if name := c.symbols.GetName(source); name != "" {
+			return append(path, name)
+		}
+		address = owner.GetAddress()
+	}
+	return path
+}
+
+// The most steps back towards an anchor that rootPath takes
+const maxRootPath = 32
+
+// Maps each record to its first owner: the one that points to the lowest
+// address in it, in the order the owners appear in the dump.
+func (c *TreeClimber) firstOwners() map[uint64]heapdump.Owner {
+	if c.firstOwner != nil {
+		return c.firstOwner
+	}
+	c.firstOwner = make(map[uint64]heapdump.Owner)
+	lowest := make(map[uint64]uint64)
+	for target, owners := range c.owners {
+		r, found := c.containing(target)
+		if !found || len(owners) == 0 {
+			continue
+		}
+		o, isOwner := owners[0].(heapdump.Owner)
+		if !isOwner {
+			continue
+		}
+		if l, seen := lowest[r.GetAddress()]; !seen || target < l {
+			lowest[r.GetAddress()] = target
+			c.firstOwner[r.GetAddress()] = o
+		}
+	}
+	return c.firstOwner
+}
@@ -0,0 +1,132 @@
+package treeclimber
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"github.com/goccy/go-graphviz"
+	"github.com/goccy/go-graphviz/cgraph"
+)
+
+// Renders the stack of the goroutine with the indicated ID as a chain of
+// its frames, from the function it started in down to the one it's
+// running, with the records that each frame points to fanned out beside
+// it. Those records are followed for the indicated number of hops, so with
+// more than one, the graph also shows what they point to. Pending defers
+// and panics in progress hang off the goroutine in the same way, leading
+// to the closures they'll call and the values they were raised with. This
+// gives a picture of everything that one goroutine (such as a stuck one)
+// is keeping alive.
+func (c *TreeClimber) WriteGoroutine(id uint64, hops int, w io.Writer, format graphviz.Format) error {
+	goroutine, frames, err := c.goroutineStack(id)
+	if err != nil {
+		return err
+	}
+
+	return c.render(w, format, func(graph *cgraph.Graph) {
+		defer heapdump.StartPhase("traversal")()
+		top, _ := graph.CreateNode(fmt.Sprintf("goroutine-%d", id))
+		label := fmt.Sprintf("Goroutine %d\n%s", id, goroutine.Status.String())
+		if goroutine.Status == heapdump.Waiting {
+			label += fmt.Sprintf(" (%s)", goroutine.WaitReason)
+		}
+		top.SetLabel(label)
+		top.SetShape(cgraph.BoxShape)
+		top.SetStyle(cgraph.FilledNodeStyle)
+		top.SetFillColor("yellow")
+
+		// The frames all go in before anything they point to, so that
+		// pointers between frames lead to these nodes rather than to
+		// stack frame nodes labeled with the whole stack.
+		nodes := make([]*cgraph.Node, len(frames))
+		previous := top
+		for i, f := range frames {
+			c.visited[f.Address] = true
+			c.emit(TraversalEvent{Kind: NodeDiscovered, Record: f})
+			node, _ := graph.CreateNode(fmt.Sprintf("0x%x", f.Address))
+			node.SetLabel(fmt.Sprintf("[%d] %s\n%s", f.Depth, heapdump.AbbreviateName(f.Name), units.Format(uint64(len(f.Contents)))))
+			node.SetShape(cgraph.BoxShape)
+			node.SetColor(stackRooted.color())
+			node.SetPenWidth(2)
+			node.SetTooltip(c.tooltip(f))
+			edge, _ := graph.CreateEdge("", previous, node)
+			edge.SetPenWidth(3)
+			nodes[i] = node
+			previous = node
+		}
+		for i, f := range frames {
+			c.addChildren(graph, nodes[i], f.Address, hops)
+		}
+
+		previous = top
+		for _, p := range c.panicsOf(goroutine) {
+			node, _ := graph.CreateNode(fmt.Sprintf("panic-0x%x", p.Address))
+			node.SetLabel(fmt.Sprintf("Panic @ 0x%x\n%s", p.Address, c.typeName(p.PanicArgType)))
+			node.SetShape(cgraph.OctagonShape)
+			node.SetColor("red")
+			edge, _ := graph.CreateEdge("", previous, node)
+			edge.SetStyle(cgraph.DashedEdgeStyle)
+			c.addHeld(graph, node, p.PanicArgData, hops)
+			previous = node
+		}
+		previous = top
+		for _, d := range c.defersOf(goroutine) {
+			node, _ := graph.CreateNode(fmt.Sprintf("defer-0x%x", d.Address))
+			node.SetLabel(fmt.Sprintf("Defer @ 0x%x\n%s", d.Address, heapdump.AbbreviateName(c.deferredFunc(d))))
+			node.SetShape(cgraph.OctagonShape)
+			edge, _ := graph.CreateEdge("", previous, node)
+			edge.SetStyle(cgraph.DashedEdgeStyle)
+			c.addHeld(graph, node, d.FuncVal, hops)
+			previous = node
+		}
+	})
+}
+
+// Returns the goroutine with the indicated ID, along with its stack frames,
+// from the function it started in down to the one it's running.
+func (c *TreeClimber) goroutineStack(id uint64) (*heapdump.Goroutine, []*heapdump.StackFrame, error) {
+	var goroutine *heapdump.Goroutine
+	for _, g := range c.goroutines {
+		if g.RoutineId == id {
+			goroutine = g
+			break
+		}
+	}
+	if goroutine == nil {
+		return nil, nil, fmt.Errorf("Could not find goroutine %d", id)
+	}
+
+	// Frames are linked from caller to callee, so the chain is read off
+	// from the running frame back to the first one.
+	callers := c.callers()
+	frames := make([]*heapdump.StackFrame, 0)
+	frame, _ := c.memory[goroutine.StackPointer].(*heapdump.StackFrame)
+	for frame != nil && len(frames) <= len(callers) {
+		frames = append([]*heapdump.StackFrame{frame}, frames...)
+		frame = callers[frame.Address]
+	}
+	if len(frames) == 0 {
+		return nil, nil, fmt.Errorf("Goroutine %d has no stack frames in the dump", id)
+	}
+	return goroutine, frames, nil
+}
+
+// Adds the record that a defer or panic points to, and what it points to
+// for the indicated number of hops, beside the defer or panic's node.
+func (c *TreeClimber) addHeld(graph *cgraph.Graph, node *cgraph.Node, pointer uint64, hops int) {
+	o, found := c.containing(pointer)
+	if !found {
+		return
+	}
+	seen := c.visited[o.GetAddress()]
+	if !seen {
+		c.emit(TraversalEvent{Kind: NodeDiscovered, Record: c.memory[o.GetAddress()]})
+	}
+	held := c.addNode(graph, o.GetAddress(), false, false)
+	graph.CreateEdge("", node, held)
+	if !seen {
+		c.addChildren(graph, held, o.GetAddress(), hops-1)
+	}
+}
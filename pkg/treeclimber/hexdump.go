@@ -0,0 +1,140 @@
+package treeclimber
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+const hexdumpLine = 16
+
+// Returns a hexdump of the record at the indicated address, in the same
+// layout as hex.Dump, with its pointer fields marked: the bytes of each
+// are set off by brackets, and the end of the line holding the start of
+// the field says what it points to.
+func (c *TreeClimber) Hexdump(address uint64) (string, error) {
+	return c.HexdumpContext(address, 0)
+}
+
+// Returns a hexdump of the record containing the indicated address, as
+// Hexdump does, but if the context isn't zero, only of the lines within
+// that many bytes of the address. Each line then starts with its absolute
+// address as well as its offset into the record, and a line before the
+// hexdump says where the address is. This is meant for pointers into the
+// middle of records, and for records too big to read all of.
+func (c *TreeClimber) HexdumpContext(address uint64, context uint64) (string, error) {
+	r, found := c.memory[address]
+	if !found {
+		containing, inside := c.containing(address)
+		if !inside {
+			return "", fmt.Errorf("Cound not find record for address 0x%x", address)
+		}
+		r = c.memory[containing.GetAddress()]
+	}
+
+	o, isOwner := r.(heapdump.Owner)
+	if !isOwner {
+		return "", fmt.Errorf("Object of type %T does not have Contents", r)
+	}
+
+	contents := o.GetContents()
+	size := uint64(len(contents))
+	offset := address - o.GetAddress()
+	wordSize := c.params.PointerSize
+	// Whether each byte starts or ends a pointer field
+	starts := make(map[uint64]bool)
+	ends := make(map[uint64]bool)
+	for _, field := range o.GetFields() {
+		if field.Offset+wordSize <= size {
+			starts[field.Offset] = true
+			ends[field.Offset+wordSize-1] = true
+		}
+	}
+
+	var b strings.Builder
+	first, last := uint64(0), size
+	if context > 0 {
+		if offset > context {
+			first = (offset - context) / hexdumpLine * hexdumpLine
+		}
+		if offset+context < size {
+			last = min(size, (offset+context+hexdumpLine-1)/hexdumpLine*hexdumpLine)
+		}
+		fmt.Fprintf(&b, "0x%x is at +0x%x of %s @ 0x%x (%d bytes); showing +0x%x-0x%x\n",
+			address, offset, ownerType(r), o.GetAddress(), size, first, last)
+	} else if offset > 0 {
+		fmt.Fprintf(&b, "0x%x is at +0x%x of %s @ 0x%x (%d bytes)\n",
+			address, offset, ownerType(r), o.GetAddress(), size)
+	}
+	for line := first; line < last; line += hexdumpLine {
+		fmt.Fprintf(&b, "%08x", line)
+		if context > 0 {
+			fmt.Fprintf(&b, "  %0*x", 2*wordSize, o.GetAddress()+line)
+		}
+		notes := make([]string, 0)
+		var ascii strings.Builder
+		for i := line; i < line+hexdumpLine; i++ {
+			width := 1
+			if i == line || i == line+hexdumpLine/2 {
+				width = 2
+			}
+			b.WriteString(hexdumpGap(width, i > line && ends[i-1], i < size && starts[i]))
+			if i >= size {
+				b.WriteString("  ")
+				continue
+			}
+			fmt.Fprintf(&b, "%02x", contents[i])
+			if contents[i] >= 0x20 && contents[i] <= 0x7e {
+				ascii.WriteByte(contents[i])
+			} else {
+				ascii.WriteByte('.')
+			}
+			if starts[i] {
+				notes = append(notes, c.hexdumpNote(i, c.word(contents[i:])))
+			}
+		}
+		end := line + hexdumpLine - 1
+		b.WriteString(hexdumpGap(1, end < size && ends[end], false))
+		fmt.Fprintf(&b, " |%s|", ascii.String())
+		if len(notes) > 0 {
+			fmt.Fprintf(&b, "  %s", strings.Join(notes, "; "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// Returns the space between two bytes of a hexdump line, marking the end
+// of the pointer field before it and the start of the one after it.
+func hexdumpGap(width int, closing, opening bool) string {
+	switch {
+	case closing && opening && width == 1:
+		return "|"
+	case closing && opening:
+		return "][" + strings.Repeat(" ", width-2)
+	case closing:
+		return "]" + strings.Repeat(" ", width-1)
+	case opening:
+		return strings.Repeat(" ", width-1) + "["
+	}
+	return strings.Repeat(" ", width)
+}
+
+// Describes the pointer at the indicated offset of a record and what it
+// points to.
+func (c *TreeClimber) hexdumpNote(offset uint64, value uint64) string {
+	if value == 0 {
+		return fmt.Sprintf("+0x%x: nil", offset)
+	}
+	note := fmt.Sprintf("+0x%x: %s", offset, c.symbols.FormatAddr(value))
+	target, found := c.containing(value)
+	if !found {
+		return note + " -> ???"
+	}
+	note += " -> " + ownerType(c.memory[target.GetAddress()])
+	if value != target.GetAddress() {
+		note += fmt.Sprintf(" +0x%x", value-target.GetAddress())
+	}
+	return note
+}
@@ -0,0 +1,85 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+type histogramEntry struct {
+	name  string
+	count uint64
+	bytes uint64
+}
+
+// Prints how many objects of each type there are and how much memory they
+// use, largest first, limited to the indicated number of types (or all of
+// them, if that isn't positive). Objects that haven't been named (see
+// ReadOids) are grouped by size instead; those named for the same type in
+// different ways (see heapdump.CanonicalTypeName) are grouped together.
+func (c *TreeClimber) PrintHistogram(limit int) error {
+	list, count, bytes := c.histogram()
+	if count == 0 {
+		return fmt.Errorf("Could not find any objects in the dump")
+	}
+
+	fmt.Fprintf(c.out, "%d objects of %d types use %s\n", count, len(list), units.Format(bytes))
+	for i, e := range list {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(c.out, "  ... and %d more types\n", len(list)-limit)
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %d objects, %s (%.1f%%)\n",
+			e.name, e.count, units.Format(e.bytes), 100*float64(e.bytes)/float64(bytes))
+	}
+	return nil
+}
+
+// Returns the types of object in the dump, largest first, along with the
+// number of objects and their total size.
+func (c *TreeClimber) histogram() ([]*histogramEntry, uint64, uint64) {
+	types := make(map[string]*histogramEntry)
+	var count, bytes uint64
+	for _, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if !isObject {
+			continue
+		}
+		name := histogramName(o)
+		e, found := types[name]
+		if !found {
+			e = &histogramEntry{name: name}
+			types[name] = e
+		}
+		e.count++
+		e.bytes += uint64(len(o.Contents))
+		count++
+		bytes += uint64(len(o.Contents))
+	}
+
+	list := make([]*histogramEntry, 0, len(types))
+	for _, e := range types {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].name < list[j].name
+	})
+
+	return list, count, bytes
+}
+
+// Returns the name an object is counted under in a histogram: its type,
+// or its size if it hasn't been named.
+func histogramName(o *heapdump.Object) string {
+	// Names from OID files can spell the same type differently
+	name := heapdump.CanonicalTypeName(o.GetName())
+	if len(o.Name) == 0 {
+		name = fmt.Sprintf("%s (%d bytes)", name, len(o.Contents))
+	}
+	return name
+}
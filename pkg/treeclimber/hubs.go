@@ -0,0 +1,132 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The smallest share of anchors whose paths go through a record for it to
+// count as a hub
+const hubFraction = 0.1
+
+// The number of anchors listed under each hub
+const hubAnchors = 5
+
+// A record that the paths from many anchors to an object go through, and
+// the anchors whose paths do.
+type hub struct {
+	address uint64
+	anchors []uint64
+}
+
+// Prints the anchors that keep the record at the indicated address alive,
+// grouped under the hubs that their paths go through. Heaps often have
+// hundreds of anchors whose paths all lead into a single registry or
+// cache before reaching the object; listing those anchors one by one hides
+// the one record that matters.
+//
+// Each anchor is followed along its shortest path to the record, as with
+// PrintPath. A hub is a record (other than an anchor) on the paths of at
+// least two anchors, and of at least a tenth of them. Anchors are grouped
+// under the first hub along their path, which is printed with how much
+// memory it retains and its own path to the record. Anchors whose paths
+// don't go through a hub are listed at the end.
+func (c *TreeClimber) PrintHubs(address uint64) error {
+	if _, found := c.memory[address]; !found {
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	retained := c.retainedBytes()
+	defer heapdump.StartPhase("traversal")()
+
+	// The same search as PrintPath, but carried on until every anchor
+	// has been found
+	next := map[uint64]uint64{address: address}
+	queue := []uint64{address}
+	anchors := make([]uint64, 0)
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		if c.isAnchor(a) {
+			anchors = append(anchors, a)
+			continue
+		}
+		for _, owner := range c.ownersOf(a) {
+			if c.isWeakOwner(owner) {
+				continue
+			}
+			o := owner.(heapdump.Addressable).GetAddress()
+			if _, seen := next[o]; !seen {
+				next[o] = a
+				queue = append(queue, o)
+			}
+		}
+	}
+	if len(anchors) == 0 {
+		return fmt.Errorf("No path from an anchor to 0x%x", address)
+	}
+	sortAddresses(anchors)
+
+	paths := make(map[uint64]int)
+	for _, anchor := range anchors {
+		for a := next[anchor]; a != address; a = next[a] {
+			paths[a]++
+		}
+	}
+	threshold := max(2, int(hubFraction*float64(len(anchors))+0.999))
+	hubs := make(map[uint64]*hub)
+	others := make([]uint64, 0)
+	for _, anchor := range anchors {
+		a := next[anchor]
+		for a != address && paths[a] < threshold {
+			a = next[a]
+		}
+		if a == address {
+			others = append(others, anchor)
+			continue
+		}
+		if _, found := hubs[a]; !found {
+			hubs[a] = &hub{address: a}
+		}
+		hubs[a].anchors = append(hubs[a].anchors, anchor)
+	}
+
+	sorted := make([]*hub, 0, len(hubs))
+	for _, h := range hubs {
+		sorted = append(sorted, h)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].anchors) != len(sorted[j].anchors) {
+			return len(sorted[i].anchors) > len(sorted[j].anchors)
+		}
+		return sorted[i].address < sorted[j].address
+	})
+
+	fmt.Fprintf(c.out, "%d anchors, through %d hubs\n", len(anchors), len(sorted))
+	for _, h := range sorted {
+		fmt.Fprintf(c.out, "%d anchors (%.0f%%) go through %s (retains %s):\n",
+			len(h.anchors), 100*float64(len(h.anchors))/float64(len(anchors)),
+			c.memory[h.address].(fmt.Stringer).String(), units.Format(retained[h.address]))
+		c.printAnchorList(h.anchors)
+		fmt.Fprintln(c.out, "  Path from there:")
+		c.printSteps(h.address, next, address, "    ")
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(c.out, "%d anchors don't go through a hub:\n", len(others))
+		c.printAnchorList(others)
+	}
+	return nil
+}
+
+// Prints the first few of a list of anchors.
+func (c *TreeClimber) printAnchorList(anchors []uint64) {
+	for i, anchor := range anchors {
+		if i == hubAnchors {
+			fmt.Fprintf(c.out, "  ... and %d more\n", len(anchors)-hubAnchors)
+			break
+		}
+		fmt.Fprintf(c.out, "  %s\n", c.memory[anchor].(fmt.Stringer).String())
+	}
+}
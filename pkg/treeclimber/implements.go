@@ -0,0 +1,138 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// Prints every heap object that is held in an interface value of the named
+// type (e.g., "io.Closer"), along with the memory each one retains. Heap
+// objects don't record their own types, so we find them by looking for
+// interface values -- an itab pointer followed by a data pointer -- whose
+// itab is for the indicated interface. Identifying itabs requires the
+// program to have been read with heapdump.ReadProgram, or its symbols to
+// name them (see heapdump.SymbolTable.ResolveItab).
+func (c *TreeClimber) PrintImplements(iface string) error {
+	itabs := make(map[uint64]string) // itab address -> concrete type
+	for address, r := range c.memory {
+		if _, isItab := r.(*heapdump.Itab); !isItab {
+			continue
+		}
+		name, concrete, ok := c.symbols.ResolveItab(address)
+		if ok && name == iface {
+			itabs[address] = concrete
+		}
+	}
+	if len(itabs) == 0 {
+		return fmt.Errorf("No itabs found for interface '%s' (is --program set?)", iface)
+	}
+
+	types := c.implementors(itabs)
+	if len(types) == 0 {
+		return fmt.Errorf("No objects found implementing %s", iface)
+	}
+
+	g := c.retentionGraph()
+	objects, bytes := g.retainedTotals()
+	index := make(map[uint64]int)
+	for i, address := range g.addresses {
+		if g.isObject[i] {
+			index[address] = i
+		}
+	}
+
+	retainers := make([]retainer, 0, len(types))
+	var totalObjects, totalBytes uint64
+	for address, concrete := range types {
+		r := retainer{Label: heapdump.AbbreviateName(concrete) + " " + c.memory[address].(fmt.Stringer).String(), Address: address}
+		node, found := index[address]
+		if found {
+			r.Objects = objects[node]
+			r.Bytes = bytes[node]
+			// Objects retained by another implementor are already
+			// counted in that implementor's total.
+			if !dominatedByAny(g, node, types) {
+				totalObjects += r.Objects
+				totalBytes += r.Bytes
+			}
+		}
+		retainers = append(retainers, r)
+	}
+	sort.Slice(retainers, func(i, j int) bool {
+		if retainers[i].Bytes != retainers[j].Bytes {
+			return retainers[i].Bytes > retainers[j].Bytes
+		}
+		return retainers[i].Address < retainers[j].Address
+	})
+
+	for _, r := range retainers {
+		fmt.Fprintf(c.out, "%s: %d objects, %s\n", r.Label, r.Objects, units.Format(r.Bytes))
+	}
+	fmt.Fprintf(c.out, "%d objects implement %s, retaining %d objects, %s\n",
+		len(retainers), iface, totalObjects, units.Format(totalBytes))
+	return nil
+}
+
+// Scans every owner for interface values using one of the indicated itabs,
+// and returns the objects they point to, mapped to their concrete types.
+func (c *TreeClimber) implementors(itabs map[uint64]string) map[uint64]string {
+	types := make(map[uint64]string)
+	size := c.params.PointerSize
+	for _, r := range c.memory {
+		o, isOwner := r.(heapdump.Owner)
+		if !isOwner {
+			continue
+		}
+		// The itab word isn't necessarily listed as a pointer field, so
+		// every word has to be checked.
+		contents := o.GetContents()
+		for field := uint64(0); field+2*size <= uint64(len(contents)); field += size {
+			concrete, isItab := itabs[c.word(contents[field:])]
+			if !isItab {
+				continue
+			}
+			object, found := c.containing(c.pointerAt(contents[field+size:]))
+			if !found {
+				continue
+			}
+			if _, isObject := object.(*heapdump.Object); isObject {
+				types[object.GetAddress()] = concrete
+			}
+		}
+	}
+	return types
+}
+
+// Returns the name of the interface that the pointer at the indicated
+// address in an owner is held as, if it's the data word of an interface
+// value whose itab can be named, or an empty string if it isn't.
+func (c *TreeClimber) heldAs(owner heapdump.Owner, pointer uint64) string {
+	size := c.params.PointerSize
+	offset := pointer - owner.GetAddress()
+	if pointer < owner.GetAddress()+size || offset > uint64(len(owner.GetContents())) {
+		return ""
+	}
+	// Only the words that the dump says are itabs are taken for them, as
+	// the word before any pointer could otherwise be mistaken for one
+	itab := c.word(owner.GetContents()[offset-size:])
+	if _, isItab := c.memory[itab].(*heapdump.Itab); !isItab {
+		return ""
+	}
+	iface, _, ok := c.symbols.ResolveItab(itab)
+	if !ok {
+		return ""
+	}
+	return heapdump.AbbreviateName(iface)
+}
+
+func dominatedByAny(g *retentionGraph, node int, set map[uint64]string) bool {
+	for n := g.idom[node]; n != 0 && n != -1; n = g.idom[n] {
+		if _, inSet := set[g.addresses[n]]; inSet && g.isObject[n] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// How many levels of nested structs PrintInlineFields looks into
+const inlineDepth = 2
+
+// Inline fields that take up less than this share of their type's bytes
+// aren't listed on their own
+const inlineMinShare = 0.01
+
+type inlineEntry struct {
+	name     string
+	layout   *heapdump.StructLayout
+	count    uint64 // objects
+	elements uint64 // values of the type in them; more than count for arrays
+	bytes    uint64
+}
+
+// Prints the struct types whose objects use the most memory, limited to
+// the indicated number of them, with how those bytes divide up among the
+// structs and arrays embedded in them, the rest of their fields, the
+// padding between fields, and the rounding up of each object to its size
+// class. A type that's mostly one inline buffer, say, is a candidate for
+// allocating that buffer separately, only when it's needed, or for
+// shrinking it.
+//
+// Layouts are read from the program's debug info, so this only covers
+// objects that have been named (see ReadOids) after struct types in a
+// program passed to ReadProgram. Objects bigger than their type are
+// taken to be arrays of it.
+func (c *TreeClimber) PrintInlineFields(limit int) error {
+	types := make(map[string]*inlineEntry)
+	var total, known uint64
+	for _, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if !isObject {
+			continue
+		}
+		total += uint64(len(o.Contents))
+		if len(o.Name) == 0 {
+			continue
+		}
+		e, found := types[o.Name]
+		if !found {
+			layout, found := c.symbols.GetStructLayout(o.Name, inlineDepth)
+			if !found {
+				types[o.Name] = nil
+				continue
+			}
+			e = &inlineEntry{name: o.GetName(), layout: layout}
+			types[o.Name] = e
+		} else if e == nil {
+			continue
+		}
+		elements := uint64(len(o.Contents)) / e.layout.Size
+		if elements == 0 {
+			// Too small to be one of the type, so it's been misnamed
+			continue
+		}
+		e.count++
+		e.elements += elements
+		e.bytes += uint64(len(o.Contents))
+		known += uint64(len(o.Contents))
+	}
+
+	list := make([]*inlineEntry, 0, len(types))
+	for _, e := range types {
+		if e != nil && e.count > 0 {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("No objects of known struct types found (are --oid and --program set?)")
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].name < list[j].name
+	})
+
+	fmt.Fprintf(c.out, "%d struct types with known layouts use %s (%.1f%% of the heap)\n",
+		len(list), units.Format(known), 100*float64(known)/float64(total))
+	for i, e := range list {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(c.out, "  ... and %d more types\n", len(list)-limit)
+			break
+		}
+		c.printInlineEntry(e)
+	}
+	return nil
+}
+
+func (c *TreeClimber) printInlineEntry(e *inlineEntry) {
+	fmt.Fprintf(c.out, "  %s: %d objects, %s\n", e.name, e.count, units.Format(e.bytes))
+	share := func(label string, bytes uint64, indent int) {
+		fmt.Fprintf(c.out, "%s%s: %s (%.1f%%)\n", strings.Repeat("  ", indent+2), label,
+			units.Format(bytes), 100*float64(bytes)/float64(e.bytes))
+	}
+	other := e.layout.Size - e.layout.Padding
+	for _, f := range e.layout.Inline {
+		bytes := f.Size * e.elements
+		listed := float64(bytes) >= inlineMinShare*float64(e.bytes)
+		if f.Depth == 0 && listed {
+			other -= f.Size
+		}
+		if listed {
+			share(f.Path+" "+f.Type, bytes, f.Depth)
+		}
+	}
+	if other > 0 {
+		share("other fields", other*e.elements, 0)
+	}
+	if e.layout.Padding > 0 {
+		share("padding", e.layout.Padding*e.elements, 0)
+	}
+	if rounding := e.bytes - e.layout.Size*e.elements; rounding > 0 {
+		share("rounding up to size classes", rounding, 0)
+	}
+}
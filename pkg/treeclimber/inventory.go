@@ -0,0 +1,146 @@
+package treeclimber
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"gopkg.in/yaml.v3"
+)
+
+// The number of unexpected instances of a type whose paths are printed
+const inventoryPaths = 3
+
+// How many objects of one type a dump is expected to have, and what it
+// actually has of them.
+type inventoryEntry struct {
+	name      string
+	spec      string
+	least     uint64
+	most      uint64
+	bytes     uint64
+	instances []*heapdump.Object
+}
+
+func (e *inventoryEntry) expected() bool {
+	count := uint64(len(e.instances))
+	return count >= e.least && count <= e.most
+}
+
+// Checks the objects in the dump against a YAML inventory file, which says
+// how many objects of each type there should be; for example, to check
+// that a teardown released everything:
+//
+//	main.session: 0
+//	sql.Conn: 0
+//	cache.entry: 100-1000
+//	worker.Job: 1+
+//
+// A count is a number, a range of numbers (inclusive), or a number followed
+// by "+" for at least that many. Types are matched against the full names
+// of objects (see ReadOids), without any leading "*", since an object
+// pointed to by a *T is a T.
+//
+// Prints every type with how many objects of it there are; for those with
+// more than expected, the paths from an anchor to the first few instances
+// are printed as well, to show what's keeping them around. Returns the
+// number of types whose counts aren't as expected.
+func (c *TreeClimber) CheckInventory(r io.Reader) (int, error) {
+	var file map[string]string
+	err := yaml.NewDecoder(r).Decode(&file)
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("Bad inventory file: %w", err)
+	}
+	if len(file) == 0 {
+		return 0, fmt.Errorf("The inventory file doesn't list any types")
+	}
+	entries := make(map[string]*inventoryEntry, len(file))
+	for name, spec := range file {
+		least, most, err := parseCountRange(spec)
+		if err != nil {
+			return 0, fmt.Errorf("Bad count for type '%s': %w", name, err)
+		}
+		name = heapdump.CanonicalTypeName(strings.TrimLeft(name, "*"))
+		entries[name] = &inventoryEntry{name: name, spec: spec, least: least, most: most}
+	}
+
+	defer heapdump.StartPhase("traversal")()
+	for _, address := range c.sortedObjects() {
+		o := c.memory[address].(*heapdump.Object)
+		if len(o.Name) == 0 {
+			continue
+		}
+		if e, found := entries[heapdump.CanonicalTypeName(strings.TrimLeft(o.Name, "*"))]; found {
+			e.bytes += uint64(len(o.Contents))
+			e.instances = append(e.instances, o)
+		}
+	}
+
+	list := make([]*inventoryEntry, 0, len(entries))
+	unexpected := 0
+	for _, e := range entries {
+		list = append(list, e)
+		if !e.expected() {
+			unexpected++
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	fmt.Fprintf(c.out, "%d of %d types don't have the expected number of objects\n", unexpected, len(list))
+	for _, e := range list {
+		status := "ok  "
+		count := uint64(len(e.instances))
+		if count < e.least {
+			status = "FEW "
+		} else if count > e.most {
+			status = "MANY"
+		}
+		fmt.Fprintf(c.out, "%s %s: %d objects (%s), expected %s\n",
+			status, e.name, count, units.Format(e.bytes), e.spec)
+		if count <= e.most {
+			continue
+		}
+		for i, o := range e.instances {
+			if i == inventoryPaths {
+				fmt.Fprintf(c.out, "  ... and %d more objects\n", len(e.instances)-inventoryPaths)
+				break
+			}
+			fmt.Fprintf(c.out, "  Path to %s:\n", o.String())
+			err := c.PrintPath(o.Address)
+			if err != nil {
+				fmt.Fprintf(c.out, "  %v\n", err)
+			}
+		}
+	}
+	return unexpected, nil
+}
+
+// Parses an expected number of objects: a number ("0"), an inclusive range
+// ("10-20"), or a number followed by "+" ("5+") for at least that many.
+func parseCountRange(spec string) (uint64, uint64, error) {
+	spec = strings.TrimSpace(spec)
+	if least, found := strings.CutSuffix(spec, "+"); found {
+		n, err := strconv.ParseUint(strings.TrimSpace(least), 10, 64)
+		return n, math.MaxUint64, err
+	}
+	least, most, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		most = least
+	}
+	l, err := strconv.ParseUint(strings.TrimSpace(least), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := strconv.ParseUint(strings.TrimSpace(most), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if m < l {
+		return 0, 0, fmt.Errorf("the range '%s' is empty", spec)
+	}
+	return l, m, nil
+}
@@ -0,0 +1,57 @@
+package treeclimber
+
+import (
+	"iter"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// A pointer from an owner to a record
+type Edge struct {
+	Owner  heapdump.Record // The record holding the pointer
+	Source uint64          // The address of the pointer, inside the owner
+	Target uint64          // The address it points to, which may be inside the record pointed to
+}
+
+// Returns an iterator over every object in the dump, in no particular
+// order.
+func (c *TreeClimber) AllObjects() iter.Seq[*heapdump.Object] {
+	return func(yield func(*heapdump.Object) bool) {
+		for _, r := range c.memory {
+			o, isObject := r.(*heapdump.Object)
+			if isObject && !yield(o) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator over the pointers to the record at the indicated
+// address, including those that point into the middle of it, as with
+// PrintOwners: one edge for each pointer, so an owner that points to the
+// record more than once is yielded more than once. Runtime roots aren't
+// included.
+func (c *TreeClimber) OwnersOf(address uint64) iter.Seq[Edge] {
+	return func(yield func(Edge) bool) {
+		end := address + 1
+		if o, isOwner := c.memory[address].(heapdump.Owner); isOwner {
+			end = address + uint64(len(o.GetContents()))
+		}
+		for _, dest := range between(c.ownedIndex, address, end) {
+			// Owners are listed once for each of their pointers to dest
+			seen := make(map[heapdump.Record]bool)
+			for _, owner := range c.owners[dest] {
+				if seen[owner] {
+					continue
+				}
+				seen[owner] = true
+				sources, targets := c.pointerInfo(owner.(heapdump.Owner))
+				for i, target := range targets {
+					if target == dest && !yield(Edge{Owner: owner, Source: sources[i], Target: dest}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
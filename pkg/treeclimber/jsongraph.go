@@ -0,0 +1,184 @@
+package treeclimber
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// The version of the JSON graph format written by WriteJSONGraph. It's
+// bumped whenever a change could break a program that reads the format;
+// adding fields doesn't count.
+const JSONGraphVersion = 1
+
+// The whole owner graph, in a form meant to be read by other programs, such
+// as visualizers. This is the contract for those programs: fields are only
+// ever added, unless Version changes.
+type JSONGraph struct {
+	Schema  string      `json:"schema"` // always "heapspurs-graph"
+	Version int         `json:"version"`
+	Nodes   []JSONNode  `json:"nodes"`
+	Edges   []JSONEdge  `json:"edges"`
+	Params  *JSONParams `json:"params,omitempty"`
+}
+
+type JSONParams struct {
+	PointerSize uint64 `json:"pointer_size"`
+	BigEndian   bool   `json:"big_endian"`
+	HeapStart   string `json:"heap_start"`
+	HeapEnd     string `json:"heap_end"`
+}
+
+// A record that holds pointers: an object, a stack frame, a global, or a
+// segment (when there are no symbols to split it into globals by).
+type JSONNode struct {
+	ID   string `json:"id"`   // the record's address, in hex
+	Kind string `json:"kind"` // "Object", "StackFrame", "Global", "DataSegment", or "BssSegment"
+	// The object's full name (see ReadOids), the stack frame's function,
+	// the global's symbol, or empty for unnamed objects and segments
+	Type string `json:"type,omitempty"`
+	Size uint64 `json:"size"` // bytes of contents
+	// Bytes of objects that would be freed if this record were, including
+	// itself; for globals and segments, this is what all of their pointers
+	// retain
+	Retained uint64 `json:"retained"`
+	// The fewest pointers between an anchor and this record (0 for
+	// anchors), or absent if it can't be reached from any
+	RootDistance *int   `json:"root_distance,omitempty"`
+	Tag          string `json:"tag,omitempty"` // from the annotations file, if any
+}
+
+// A pointer from one record to another.
+type JSONEdge struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	SourceOffset uint64 `json:"source_offset"`  // where in the owner the pointer lives
+	TargetOffset uint64 `json:"target_offset"`  // where in the target it points
+	Name         string `json:"name,omitempty"` // the global holding the pointer, if known
+	Weak         bool   `json:"weak,omitempty"` // whether the owner doesn't retain what it points to
+}
+
+// Writes the entire owner graph as JSON, in the format described by
+// JSONGraph, along with how much memory each record retains and how far it
+// is from an anchor.
+func (c *TreeClimber) WriteJSONGraph(w io.Writer) error {
+	return json.NewEncoder(w).Encode(c.jsonGraph())
+}
+
+func (c *TreeClimber) jsonGraph() *JSONGraph {
+	retained := c.retainedBytes()
+	defer heapdump.StartPhase("traversal")()
+	edges := c.edges()
+	distances := c.rootDistances(edges)
+
+	graph := &JSONGraph{
+		Schema:  "heapspurs-graph",
+		Version: JSONGraphVersion,
+		Nodes:   make([]JSONNode, 0),
+		Edges:   make([]JSONEdge, 0, len(edges)),
+	}
+	if c.params != nil {
+		graph.Params = &JSONParams{
+			PointerSize: c.params.PointerSize,
+			BigEndian:   c.params.BigEndian,
+			HeapStart:   fmt.Sprintf("0x%x", c.params.HeapStart),
+			HeapEnd:     fmt.Sprintf("0x%x", c.params.HeapEnd),
+		}
+	}
+	for _, address := range c.sortedOwners() {
+		r := c.memory[address]
+		node := JSONNode{
+			ID:       fmt.Sprintf("0x%x", address),
+			Kind:     strings.TrimPrefix(fmt.Sprintf("%T", r), "*heapdump."),
+			Size:     uint64(len(r.(heapdump.Owner).GetContents())),
+			Retained: retained[address],
+		}
+		switch o := r.(type) {
+		case *heapdump.Object:
+			node.Type = o.Name
+		case *heapdump.StackFrame:
+			node.Type = o.Name
+		case *heapdump.Global:
+			node.Type = o.Name
+		}
+		if d, found := distances[address]; found {
+			node.RootDistance = &d
+		}
+		if tag, tagged := c.tagOf(address); tagged {
+			node.Tag = tag
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	for _, e := range edges {
+		graph.Edges = append(graph.Edges, JSONEdge{
+			From:         fmt.Sprintf("0x%x", e.from),
+			To:           fmt.Sprintf("0x%x", e.to),
+			SourceOffset: e.sourceOffset,
+			TargetOffset: e.targetOffset,
+			Name:         c.symbols.GetName(e.from + e.sourceOffset),
+			Weak:         c.isWeakOwner(c.memory[e.from]),
+		})
+	}
+	return graph
+}
+
+// Returns the bytes retained by each owner record. Globals and segments are
+// credited with what their pointer slots retain.
+func (c *TreeClimber) retainedBytes() map[uint64]uint64 {
+	g := c.retentionGraph()
+	_, bytes := g.retainedTotals()
+	retained := make(map[uint64]uint64)
+	for i := 1; i < len(g.addresses); i++ {
+		address := g.addresses[i]
+		if _, isRecord := c.memory[address]; isRecord {
+			retained[address] += bytes[i]
+		} else if segment, found := c.containing(address); found {
+			retained[segment.GetAddress()] += bytes[i]
+		}
+	}
+	return retained
+}
+
+// Returns the fewest pointers between an anchor and each record that can be
+// reached from one, following the indicated edges in a breadth-first search.
+func (c *TreeClimber) rootDistances(edges []exportEdge) map[uint64]int {
+	children := make(map[uint64][]uint64)
+	for _, e := range edges {
+		if !c.isWeakOwner(c.memory[e.from]) {
+			children[e.from] = append(children[e.from], e.to)
+		}
+	}
+	distances := make(map[uint64]int)
+	queue := make([]uint64, 0)
+	for _, address := range c.sortedOwners() {
+		if c.isAnchor(address) {
+			distances[address] = 0
+			queue = append(queue, address)
+		}
+	}
+	// Runtime roots can point into the middle of a record
+	for target := range c.roots {
+		o, found := c.containing(target)
+		if !found || len(c.strongRoots(target)) == 0 {
+			continue
+		}
+		if _, seen := distances[o.GetAddress()]; !seen {
+			distances[o.GetAddress()] = 0
+			queue = append(queue, o.GetAddress())
+		}
+	}
+	for len(queue) > 0 {
+		address := queue[0]
+		queue = queue[1:]
+		for _, child := range children[address] {
+			if _, seen := distances[child]; !seen {
+				distances[child] = distances[address] + 1
+				queue = append(queue, child)
+			}
+		}
+	}
+	return distances
+}
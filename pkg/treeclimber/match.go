@@ -0,0 +1,81 @@
+package treeclimber
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Finds the objects whose names match the indicated regular expression, as
+// with PrintFind, along with the global variables whose symbols match it,
+// so that a query can be run on each of them without looking up their
+// addresses first. If limit is positive, at most that many addresses are
+// returned, lowest first; the total number of matches is returned as well.
+func (c *TreeClimber) MatchAddresses(pattern string, limit int) ([]uint64, int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+	found := make(map[uint64]bool)
+	for address, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if isObject && re.MatchString(o.Name) {
+			found[address] = true
+		}
+	}
+	// Symbols also name functions and read-only data, which aren't in the
+	// dump; only those in the data and BSS segments are of any use.
+	for _, address := range c.symbols.MatchSymbols(re) {
+		if o, inDump := c.containing(address); inDump {
+			switch c.memory[o.GetAddress()].(type) {
+			case *heapdump.DataSegment, *heapdump.BssSegment, *heapdump.Global:
+				found[address] = true
+			}
+		}
+	}
+
+	addresses := make([]uint64, 0, len(found))
+	for address := range found {
+		addresses = append(addresses, address)
+	}
+	sortAddresses(addresses)
+	if limit > 0 && len(addresses) > limit {
+		return addresses[:limit], len(addresses), nil
+	}
+	return addresses, len(addresses), nil
+}
+
+// Finds the largest object whose name matches the indicated regular
+// expression, so that it can be looked at without finding its address
+// first. If byRetained is set, it's the object that retains the most memory
+// (as with PrintTopOwners) instead. Ties go to the lowest address.
+func (c *TreeClimber) BiggestMatch(pattern string, byRetained bool) (uint64, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+	var retained map[uint64]uint64
+	if byRetained {
+		retained = c.retainedBytes()
+	}
+	var biggest, biggestSize uint64
+	found := false
+	for _, address := range c.sortedObjects() {
+		o := c.memory[address].(*heapdump.Object)
+		if !re.MatchString(o.Name) {
+			continue
+		}
+		size := uint64(len(o.Contents))
+		if byRetained {
+			size = retained[address]
+		}
+		if !found || size > biggestSize {
+			biggest, biggestSize, found = address, size, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("No objects match '%s'", pattern)
+	}
+	return biggest, nil
+}
@@ -0,0 +1,226 @@
+package treeclimber
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// How much memory the objects of one type use and retain.
+type typeMetrics struct {
+	name     string
+	objects  uint64
+	bytes    uint64
+	retained uint64 // bytes that would be freed if every object of the type were
+}
+
+// The gauges written by WriteMetrics: their names, in Prometheus and
+// OpenTelemetry form, and how to get their value for a type.
+var metricGauges = []struct {
+	prometheus string
+	otlp       string
+	unit       string
+	help       string
+	value      func(t *typeMetrics) uint64
+}{
+	{"heapspurs_type_objects", "heapspurs.type.objects", "{object}",
+		"Number of heap objects of the type",
+		func(t *typeMetrics) uint64 { return t.objects }},
+	{"heapspurs_type_bytes", "heapspurs.type.bytes", "By",
+		"Bytes of heap objects of the type",
+		func(t *typeMetrics) uint64 { return t.bytes }},
+	{"heapspurs_type_retained_bytes", "heapspurs.type.retained_bytes", "By",
+		"Bytes of heap objects that would be freed if every object of the type were",
+		func(t *typeMetrics) uint64 { return t.retained }},
+}
+
+// Writes gauges of how many objects of each type the dump has, how many
+// bytes they take up, and how many bytes they retain, labeled with the
+// type's full name and its package, so that dumps taken on a schedule can
+// feed dashboards and alerts. The format is either "prometheus", for the
+// text format read by the node exporter's textfile collector, or "otlp",
+// for an OpenTelemetry metrics export request in JSON; taken is when the
+// dump was taken.
+//
+// What a type retains is what would be freed if all of its objects were,
+// so objects that retain other objects of the same type, like the nodes of
+// a linked list, aren't counted twice. Objects without names aren't
+// counted.
+func (c *TreeClimber) WriteMetrics(w io.Writer, format string, taken time.Time) error {
+	if format != "prometheus" && format != "otlp" {
+		return fmt.Errorf("Unknown metrics format '%s'; must be \"prometheus\" or \"otlp\"", format)
+	}
+	types := c.typeMetrics()
+	if format == "otlp" {
+		return c.writeOTLP(w, types, taken)
+	}
+	for _, gauge := range metricGauges {
+		fmt.Fprintf(w, "# HELP %s %s.\n", gauge.prometheus, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.prometheus)
+		for _, t := range types {
+			fmt.Fprintf(w, "%s{package=\"%s\",type=\"%s\"} %d\n", gauge.prometheus,
+				escapeLabel(packageOf(t.name)), escapeLabel(t.name), gauge.value(t))
+		}
+	}
+	return nil
+}
+
+// Returns the metrics of each type of object in the dump, by name.
+func (c *TreeClimber) typeMetrics() []*typeMetrics {
+	g := c.retentionGraph()
+	_, bytes := g.retainedTotals()
+	defer heapdump.StartPhase("traversal")()
+
+	byName := make(map[string]*typeMetrics)
+	names := make([]string, len(g.addresses))
+	for i := 1; i < len(g.addresses); i++ {
+		o, isObject := c.memory[g.addresses[i]].(*heapdump.Object)
+		if !isObject || !g.isObject[i] || len(o.Name) == 0 {
+			continue
+		}
+		names[i] = o.Name
+		t, found := byName[o.Name]
+		if !found {
+			t = &typeMetrics{name: o.Name}
+			byName[o.Name] = t
+		}
+		t.objects++
+		t.bytes += g.sizes[i]
+	}
+
+	// An object's retained bytes count toward its type unless another
+	// object of the type dominates it, in which case they've already been
+	// counted. The dominator tree is walked depth first, keeping track of
+	// the types of the objects above the current one.
+	dominated := make([][]int, len(g.addresses))
+	for i := 1; i < len(g.addresses); i++ {
+		dominated[g.idom[i]] = append(dominated[g.idom[i]], i)
+	}
+	above := make(map[string]int)
+	type visit struct {
+		node    int
+		leaving bool
+	}
+	stack := []visit{{node: 0}}
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		name := names[v.node]
+		if v.leaving {
+			above[name]--
+			continue
+		}
+		if len(name) > 0 {
+			if above[name] == 0 {
+				byName[name].retained += bytes[v.node]
+			}
+			above[name]++
+			stack = append(stack, visit{node: v.node, leaving: true})
+		}
+		for _, child := range dominated[v.node] {
+			stack = append(stack, visit{node: child})
+		}
+	}
+
+	types := make([]*typeMetrics, 0, len(byName))
+	for _, t := range byName {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].name < types[j].name
+	})
+	return types
+}
+
+// Escapes a Prometheus label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// The parts of the OpenTelemetry metrics protocol, in its JSON encoding,
+// that WriteMetrics uses. 64-bit integers are written as strings, as the
+// protocol requires.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Unit        string    `json:"unit"`
+	Gauge       otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsInt        string          `json:"asInt"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (c *TreeClimber) writeOTLP(w io.Writer, types []*typeMetrics, taken time.Time) error {
+	scope := otlpScopeMetrics{Scope: otlpScope{Name: "heapspurs"}}
+	for _, gauge := range metricGauges {
+		metric := otlpMetric{
+			Name:        gauge.otlp,
+			Description: gauge.help,
+			Unit:        gauge.unit,
+			Gauge:       otlpGauge{DataPoints: make([]otlpDataPoint, 0, len(types))},
+		}
+		for _, t := range types {
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpDataPoint{
+				Attributes: []otlpAttribute{
+					{Key: "package", Value: otlpValue{StringValue: packageOf(t.name)}},
+					{Key: "type", Value: otlpValue{StringValue: t.name}},
+				},
+				TimeUnixNano: strconv.FormatInt(taken.UnixNano(), 10),
+				AsInt:        strconv.FormatUint(gauge.value(t), 10),
+			})
+		}
+		scope.Metrics = append(scope.Metrics, metric)
+	}
+	return json.NewEncoder(w).Encode(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				{Key: "service.name", Value: otlpValue{StringValue: "heapspurs"}},
+			}},
+			ScopeMetrics: []otlpScopeMetrics{scope},
+		}},
+	})
+}
@@ -0,0 +1,273 @@
+package treeclimber
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The sources of names for objects, and how much each is trusted when they
+// disagree: an OID is written into the object by the program itself; an
+// itab names the concrete type of an interface value, which is exact but
+// says nothing about objects pointed to from inside it; and the declared
+// type of a pointer can be defeated by unsafe conversions.
+var namingSources = []struct {
+	name   string
+	weight float64
+}{
+	{"oid", 3},
+	{"itab", 2},
+	{"dwarf", 1},
+}
+
+// The number of groups of conflicting names listed by PrintNamingConflicts
+const namingTop = 20
+
+// A name that an object could have, as suggested by one or more sources.
+type NamingCandidate struct {
+	Name       string
+	Sources    []string // which of "oid", "itab", and "dwarf" suggest it
+	References int      // how many itabs and typed pointers suggest it
+	Confidence float64  // from 0 to 1; see NamingCandidates
+	TooBig     bool     // whether the debug info says the type is bigger than the object
+}
+
+// Returns every name suggested for each object by the sources available:
+// the OID it starts with (see heapdump.SymbolTable.ReadOids), the concrete
+// types of interface values pointing to it, and the declared types of
+// pointers to it, from fields and variables whose types are in the
+// program's debug info. Only the objects that at least one source names are
+// included. Names are compared after heapdump.CanonicalTypeName, so OIDs
+// are only comparable if they're named after the objects' types.
+//
+// Each source has a weight (see namingSources), which is split between the
+// names it suggests for an object in proportion to how many references
+// suggest each; a candidate's confidence is the weight it gets, over the
+// weight of all of the sources that name the object. Candidates are ordered
+// from most to least confident.
+func (c *TreeClimber) NamingCandidates() map[uint64][]NamingCandidate {
+	defer heapdump.StartPhase("traversal")()
+	votes := make(map[uint64]map[string]map[string]int) // object -> source -> name -> references
+	vote := func(address uint64, source string, name string) {
+		name = heapdump.CanonicalTypeName(strings.TrimPrefix(name, "*"))
+		if votes[address] == nil {
+			votes[address] = make(map[string]map[string]int)
+		}
+		if votes[address][source] == nil {
+			votes[address][source] = make(map[string]int)
+		}
+		votes[address][source][name]++
+	}
+
+	// Objects that a source names are walked as the first type they're
+	// given in turn, so that names propagate along typed pointers from the
+	// globals, stack frames, and named objects to everything they lead to
+	walked := make(map[uint64]string) // object -> the name it's walked as
+	queue := make([]uint64, 0)
+	walk := func(r heapdump.Record) {
+		contents := r.(heapdump.Owner).GetContents()
+		for _, p := range c.symbols.GetTypedPointers(r) {
+			if p.Offset+c.params.PointerSize > uint64(len(contents)) {
+				continue
+			}
+			// Only pointers to the start of an object say what the object is
+			target := c.pointerAt(contents[p.Offset:])
+			if _, isObject := c.memory[target].(*heapdump.Object); isObject {
+				vote(target, "dwarf", p.Type)
+				if _, found := walked[target]; !found {
+					walked[target] = p.Type
+					queue = append(queue, target)
+				}
+			}
+		}
+	}
+
+	itabs := make(map[uint64]string)
+	for address, r := range c.memory {
+		switch o := r.(type) {
+		case *heapdump.Object:
+			if len(o.Name) > 0 {
+				vote(address, "oid", o.Name)
+			}
+		case *heapdump.Itab:
+			if _, concrete, ok := c.symbols.ResolveItab(address); ok {
+				itabs[address] = concrete
+			}
+		}
+	}
+	if len(itabs) > 0 {
+		for address, concrete := range c.implementors(itabs) {
+			if c.memory[address] != nil {
+				vote(address, "itab", concrete)
+				walked[address] = strings.TrimPrefix(concrete, "*")
+				queue = append(queue, address)
+			}
+		}
+	}
+	for address, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if _, isOwner := r.(heapdump.Owner); !isOwner || isObject && len(o.Name) == 0 {
+			continue
+		}
+		walked[address] = ""
+		walk(r)
+	}
+	for len(queue) > 0 {
+		o := *c.memory[queue[0]].(*heapdump.Object)
+		o.Name = walked[o.Address]
+		queue = queue[1:]
+		walk(&o)
+	}
+
+	candidates := make(map[uint64][]NamingCandidate, len(votes))
+	for address, bySource := range votes {
+		byName := make(map[string]*NamingCandidate)
+		var total float64
+		for _, source := range namingSources {
+			names, found := bySource[source.name]
+			if !found {
+				continue
+			}
+			total += source.weight
+			references := 0
+			for _, count := range names {
+				references += count
+			}
+			for name, count := range names {
+				candidate, found := byName[name]
+				if !found {
+					candidate = &NamingCandidate{Name: name}
+					byName[name] = candidate
+				}
+				candidate.Sources = append(candidate.Sources, source.name)
+				if source.name != "oid" {
+					candidate.References += count
+				}
+				candidate.Confidence += source.weight * float64(count) / float64(references)
+			}
+		}
+		size := uint64(len(c.memory[address].(*heapdump.Object).Contents))
+		list := make([]NamingCandidate, 0, len(byName))
+		for _, candidate := range byName {
+			candidate.Confidence /= total
+			if typeSize, found := c.symbols.GetTypeSize(candidate.Name); found && typeSize > size {
+				candidate.TooBig = true
+			}
+			list = append(list, *candidate)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Confidence != list[j].Confidence {
+				return list[i].Confidence > list[j].Confidence
+			}
+			return list[i].Name < list[j].Name
+		})
+		candidates[address] = list
+	}
+	return candidates
+}
+
+// Objects whose sources disagree in the same way
+type namingConflict struct {
+	names      string // the candidates' names, sorted and joined
+	candidates []NamingCandidate
+	objects    int
+	bytes      uint64
+	example    uint64
+}
+
+// Prints the objects that the sources of names disagree about (see
+// NamingCandidates), rather than silently picking one name for each.
+// Objects with the same candidates are grouped together, with the average
+// confidence of each candidate, largest groups first.
+func (c *TreeClimber) PrintNamingConflicts() error {
+	candidates := c.NamingCandidates()
+	if len(candidates) == 0 {
+		return fmt.Errorf("No objects are named (are --program and --oid set?)")
+	}
+	groups := make(map[string]*namingConflict)
+	for address, list := range candidates {
+		if len(list) < 2 {
+			continue
+		}
+		names := make([]string, len(list))
+		for i, candidate := range list {
+			names[i] = candidate.Name
+		}
+		sort.Strings(names)
+		key := strings.Join(names, "\x00")
+		group, found := groups[key]
+		if !found {
+			group = &namingConflict{names: key, example: address}
+			for _, name := range names {
+				group.candidates = append(group.candidates, NamingCandidate{Name: name})
+			}
+			groups[key] = group
+		}
+		group.objects++
+		group.bytes += uint64(len(c.memory[address].(*heapdump.Object).Contents))
+		group.example = min(group.example, address)
+		for _, candidate := range list {
+			for i := range group.candidates {
+				g := &group.candidates[i]
+				if g.Name != candidate.Name {
+					continue
+				}
+				g.Confidence += candidate.Confidence
+				g.References += candidate.References
+				g.TooBig = g.TooBig || candidate.TooBig
+				for _, source := range candidate.Sources {
+					if !slices.Contains(g.Sources, source) {
+						g.Sources = append(g.Sources, source)
+					}
+				}
+			}
+		}
+	}
+	if len(groups) == 0 {
+		fmt.Fprintf(c.out, "The sources of names agree about all %d objects they name\n", len(candidates))
+		return nil
+	}
+
+	list := make([]*namingConflict, 0, len(groups))
+	conflicting := 0
+	for _, group := range groups {
+		list = append(list, group)
+		conflicting += group.objects
+		for i := range group.candidates {
+			group.candidates[i].Confidence /= float64(group.objects)
+		}
+		sort.SliceStable(group.candidates, func(i, j int) bool {
+			return group.candidates[i].Confidence > group.candidates[j].Confidence
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].objects != list[j].objects {
+			return list[i].objects > list[j].objects
+		}
+		return list[i].names < list[j].names
+	})
+	fmt.Fprintf(c.out, "%d of %d named objects have conflicting names:\n", conflicting, len(candidates))
+	for i, group := range list {
+		if i == namingTop {
+			fmt.Fprintf(c.out, "  ... and %d more groups\n", len(list)-namingTop)
+			break
+		}
+		fmt.Fprintf(c.out, "  %d objects (%s), e.g., 0x%x:\n", group.objects, units.Format(group.bytes), group.example)
+		for _, candidate := range group.candidates {
+			note := ""
+			if candidate.References > 0 {
+				note = fmt.Sprintf(", %d references", candidate.References)
+			}
+			if candidate.TooBig {
+				note += ", too big for the objects"
+			}
+			fmt.Fprintf(c.out, "    %3.0f%% %s (%s%s)\n", 100*candidate.Confidence,
+				heapdump.AbbreviateName(candidate.Name), strings.Join(candidate.Sources, ", "), note)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package treeclimber
+
+import (
+	"fmt"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// About how many pointer fields each worker reads at a time while the owner
+// map is built. Owners with more than this, which are mostly Data and Bss
+// segments that weren't split into globals, are read a piece at a time.
+const ownerShardFields = 16 * 1024
+
+// A range of one owner's pointer fields
+type ownerPiece struct {
+	owner heapdump.Record
+	start int
+	end   int
+}
+
+// A pointer read from an owner while building the owner map
+type ownerPointer struct {
+	target    uint64
+	owner     heapdump.Record
+	discarded bool // whether it's outside of the heap and segments
+}
+
+// Splits the pointer fields of the indicated owners into shards of about
+// ownerShardFields each, keeping them in order.
+func shardOwners(owners []heapdump.Record) [][]ownerPiece {
+	shards := make([][]ownerPiece, 0)
+	shard := make([]ownerPiece, 0)
+	fields := 0
+	for _, record := range owners {
+		count := len(record.(heapdump.Owner).GetFields())
+		for start := 0; start < count; start += ownerShardFields {
+			end := min(start+ownerShardFields, count)
+			shard = append(shard, ownerPiece{record, start, end})
+			fields += end - start
+			if fields >= ownerShardFields {
+				shards = append(shards, shard)
+				shard = make([]ownerPiece, 0)
+				fields = 0
+			}
+		}
+	}
+	if len(shard) > 0 {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// Reads the pointers in a shard. This only reads from the climber, so it is
+// safe to call from several goroutines at once.
+func (c *TreeClimber) readShard(shard []ownerPiece, segments []heapdump.Owner) []ownerPointer {
+	pointers := make([]ownerPointer, 0)
+	for _, piece := range shard {
+		_, targets := heapdump.GetPointerRange(piece.owner.(heapdump.Owner), c.params, piece.start, piece.end)
+		for _, target := range targets {
+			if target == 0 {
+				continue
+			}
+			target = c.translatePointer(target)
+			// Anything outside of the heap is only a pointer if it lands
+			// in a known segment; everything else is most likely an
+			// integer that happens to look like an address.
+			discarded := !c.inHeap(target) && !inSegment(segments, target)
+			pointers = append(pointers, ownerPointer{target, piece.owner, discarded})
+		}
+	}
+	return pointers
+}
+
+// Adds the pointers of the indicated owners to the owner map, returning how
+// many were discarded. Global-heavy programs can have hundreds of thousands
+// of pointers in their Data and Bss segments alone, so the owners are read
+// in shards by a pool of workers, while this goroutine adds what each shard
+// found as soon as it and the shards before it are done. Adding them in
+// order keeps the owners of each address in the order they were dumped.
+func (c *TreeClimber) mapOwners(owners []heapdump.Record, segments []heapdump.Owner) int {
+	shards := shardOwners(owners)
+	found := make([][]ownerPointer, len(shards))
+	done := make([]chan struct{}, len(shards))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go parallelize(len(shards), func(i int) {
+		found[i] = c.readShard(shards[i], segments)
+		close(done[i])
+	})
+
+	discarded := 0
+	for i := range shards {
+		<-done[i]
+		for _, p := range found[i] {
+			if !p.discarded {
+				c.addOwner(p.target, p.owner)
+				continue
+			}
+			discarded++
+			heapdump.Logger().Debug("Ignoring value outside of heap and segments",
+				"value", fmt.Sprintf("0x%x", p.target),
+				"owner", fmt.Sprintf("0x%x", p.owner.(heapdump.Addressable).GetAddress()))
+		}
+		found[i] = nil
+	}
+	heapdump.Logger().Debug("Read owners' pointers", "owners", len(owners), "shards", len(shards))
+	return discarded
+}
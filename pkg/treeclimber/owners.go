@@ -0,0 +1,100 @@
+package treeclimber
+
+import (
+	"fmt"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Controls how PrintOwners and PrintAnchors walk the owner graph.
+type OwnerTraversal struct {
+	// Visit owners level by level, so that each record is printed at its
+	// shortest distance from the starting record. Otherwise, each owner's
+	// owners are followed all the way before moving on to the next owner,
+	// which can hide a short path behind the first long one visited.
+	BreadthFirst bool
+
+	// Only stop at records that are already on the path being printed,
+	// rather than at any record that has been printed before. This shows
+	// every distinct path, which can be a lot of output.
+	PerPathCycles bool
+
+	// Limits for PrintAnchors, which otherwise follows every owner back
+	// to its anchors: the most anchors to print, and the most hops of
+	// owners to follow looking for them. Zero means no limit. The paths
+	// that are cut short are counted, so it's clear that there's more.
+	MaxPaths int
+	MaxDepth int
+}
+
+func (c *TreeClimber) SetOwnerTraversal(traversal OwnerTraversal) {
+	c.ownerTraversal = traversal
+}
+
+// A record in the tree of owners found by a breadth-first walk
+type ownerEntry struct {
+	address  uint64
+	parent   *ownerEntry
+	children []*ownerEntry
+}
+
+func (e *ownerEntry) onPath(address uint64) bool {
+	for p := e; p != nil; p = p.parent {
+		if p.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints the owners of the record at the indicated address, up to the
+// indicated number of levels (including the record itself), or all of them
+// if the depth is negative.
+func (c *TreeClimber) printOwnersBreadthFirst(address uint64, depth int) error {
+	if _, found := c.memory[address]; !found {
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	root := &ownerEntry{address: address}
+	seen := map[uint64]bool{address: true}
+	level := []*ownerEntry{root}
+	for levels := 1; len(level) > 0 && (depth < 0 || levels < depth); levels++ {
+		next := make([]*ownerEntry, 0)
+		for _, e := range level {
+			for _, owner := range c.owners[e.address] {
+				a, addressable := owner.(heapdump.Addressable)
+				if !addressable {
+					continue
+				}
+				o := a.GetAddress()
+				if c.ownerTraversal.PerPathCycles {
+					if e.onPath(o) {
+						continue
+					}
+				} else {
+					if seen[o] {
+						continue
+					}
+					seen[o] = true
+				}
+				child := &ownerEntry{address: o, parent: e}
+				e.children = append(e.children, child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+	c.printOwnerTree(root, "")
+	return nil
+}
+
+func (c *TreeClimber) printOwnerTree(e *ownerEntry, indent string) {
+	source := ""
+	if e.parent != nil {
+		source = c.sourceOf(e.address, e.parent.address)
+	}
+	s, _ := c.memory[e.address].(fmt.Stringer)
+	fmt.Fprintf(c.out, "%s%s%s\n", indent, s.String(), source)
+	for _, child := range e.children {
+		c.printOwnerTree(child, indent+"  ")
+	}
+}
@@ -0,0 +1,110 @@
+package treeclimber
+
+import (
+	"fmt"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Prints the shortest chain of pointers from an anchor (a stack frame, a
+// global, or a runtime root) to the record at the indicated address.
+func (c *TreeClimber) PrintPath(address uint64) error {
+	defer heapdump.StartPhase("traversal")()
+	if _, found := c.memory[address]; !found {
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+
+	// Search backwards through owners, remembering which record each owner
+	// was found from so the path can be read off forwards.
+	next := map[uint64]uint64{address: address}
+	queue := []uint64{address}
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		if c.isAnchor(a) {
+			c.printPath(a, next, address)
+			return nil
+		}
+		for _, owner := range c.ownersOf(a) {
+			if c.isWeakOwner(owner) {
+				continue
+			}
+			o := owner.(heapdump.Addressable).GetAddress()
+			if _, seen := next[o]; !seen {
+				next[o] = a
+				queue = append(queue, o)
+			}
+		}
+	}
+	return fmt.Errorf("No path from an anchor to 0x%x", address)
+}
+
+func (c *TreeClimber) isAnchor(address uint64) bool {
+	if len(c.strongRoots(address)) > 0 {
+		return true
+	}
+	switch c.memory[address].(type) {
+	case *heapdump.StackFrame, *heapdump.BssSegment, *heapdump.DataSegment, *heapdump.Global:
+		return true
+	}
+	return false
+}
+
+func (c *TreeClimber) printPath(anchor uint64, next map[uint64]uint64, target uint64) {
+	for _, root := range c.strongRoots(anchor) {
+		fmt.Fprintf(c.out, "Runtime roots: %s: %s\n", root.Category(), root.String())
+	}
+	fmt.Fprintln(c.out, c.memory[anchor].(fmt.Stringer).String())
+	c.printSteps(anchor, next, target, "  ")
+}
+
+// Prints each pointer along a path found by a search through owners, from
+// the indicated record to the target.
+func (c *TreeClimber) printSteps(from uint64, next map[uint64]uint64, target uint64, indent string) {
+	for a := from; a != target; a = next[a] {
+		owner := c.memory[a].(heapdump.Owner)
+		child := c.memory[next[a]]
+		pointer := c.pointerInto(owner, next[a])
+		held := ""
+		if iface := c.heldAs(owner, pointer); len(iface) > 0 {
+			held = " (held as " + iface + ")"
+		}
+		fmt.Fprintf(c.out, "%s%s%s%s -> %s\n", indent, c.symbols.FormatAddr(pointer), c.sourceOf(a, next[a]), held, child.(fmt.Stringer).String())
+	}
+}
+
+// Returns the address of the first pointer in the owner that points into
+// the record at the indicated address.
+func (c *TreeClimber) pointerInto(owner heapdump.Owner, address uint64) uint64 {
+	end := address + 1
+	if o, isOwner := c.memory[address].(heapdump.Owner); isOwner {
+		end = address + uint64(len(o.GetContents()))
+	}
+	sources, targets := c.pointerInfo(owner)
+	for i, t := range targets {
+		if t >= address && t < end {
+			return sources[i]
+		}
+	}
+	return 0
+}
+
+// Describes where in the source the owner at the indicated address declares
+// its pointer into the record at the target address, formatted to be
+// appended to a description of the owner. Returns an empty string if that
+// can't be worked out (which is always the case without debug info).
+func (c *TreeClimber) sourceOf(owner uint64, target uint64) string {
+	o, isOwner := c.memory[owner].(heapdump.Owner)
+	if !isOwner || target == 0 {
+		return ""
+	}
+	pointer := c.pointerInto(o, target)
+	if pointer < owner {
+		return ""
+	}
+	location, found := c.symbols.GetSourceLocation(c.memory[owner], pointer-owner)
+	if !found {
+		return ""
+	}
+	return " [" + location + "]"
+}
@@ -0,0 +1,190 @@
+package treeclimber
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// The most paths PrintPathMatches looks at when --max-paths doesn't say;
+// the number of paths through a heap can grow exponentially with its size.
+const pathMatchLimit = 100000
+
+// A search for the paths from anchors to a record that match a pattern
+type pathMatch struct {
+	re      *regexp.Regexp
+	invert  bool
+	target  uint64
+	path    []uint64 // from the target back to the record being searched
+	names   []string // the name of each record along path, likewise
+	onPath  map[uint64]bool
+	dead    map[uint64]bool // records from which no anchor can be reached
+	paths   int             // how many have been looked at
+	matches int
+	// how many paths were cut short by MaxDepth, or not looked at
+	// because of MaxPaths
+	truncated int
+}
+
+// Prints the paths from anchors to the record at the indicated address
+// whose strings match the indicated regular expression, to test hypotheses
+// about how it's kept alive. A path's string is the names of the records
+// along it, from the anchor to the record, joined by "->": stack frames as
+// "StackFrame(main.handle)", globals as "Global main.cache", objects by
+// their full names (or "Object"), and runtime roots as
+// "RuntimeRoot(finalizers)" in front of the object they point to. For
+// example, 'Cache->.*Session$' matches the paths that reach a Session
+// through a Cache. A pattern starting with "!" prints the paths that don't
+// match the rest of it instead, which answers questions like "does anything
+// keep this Session alive other than through the Cache?"
+//
+// Every distinct path is looked at, rather than just the shortest from
+// each anchor, so MaxPaths (100,000 if it's zero) and MaxDepth limit the
+// search; how many paths they cut short is reported.
+func (c *TreeClimber) PrintPathMatches(address uint64, pattern string) error {
+	defer heapdump.StartPhase("traversal")()
+	if _, found := c.memory[address]; !found {
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	search := &pathMatch{
+		target: address,
+		onPath: make(map[uint64]bool),
+		dead:   make(map[uint64]bool),
+	}
+	pattern, search.invert = strings.CutPrefix(pattern, "!")
+	var err error
+	search.re, err = regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+
+	c.matchPaths(address, 0, search)
+	verb := "match"
+	if search.invert {
+		verb = "don't match"
+	}
+	fmt.Fprintf(c.out, "%d of %d paths from anchors %s '%s'\n", search.matches, search.paths, verb, pattern)
+	if search.truncated > 0 {
+		fmt.Fprintf(c.out, "%d paths truncated\n", search.truncated)
+	}
+	return nil
+}
+
+// Follows the owners of the indicated record back to anchors, depth first,
+// checking the path to each anchor found. Returns whether any anchor was
+// reached, or might have been if not for the limits on the search.
+func (c *TreeClimber) matchPaths(address uint64, depth int, search *pathMatch) bool {
+	maxPaths := c.ownerTraversal.MaxPaths
+	if maxPaths == 0 {
+		maxPaths = pathMatchLimit
+	}
+	if search.paths == maxPaths {
+		search.truncated++
+		return true
+	}
+	search.path = append(search.path, address)
+	search.names = append(search.names, c.pathName(address, search))
+	search.onPath[address] = true
+	defer func() {
+		search.path = search.path[:len(search.path)-1]
+		search.names = search.names[:len(search.names)-1]
+		delete(search.onPath, address)
+	}()
+
+	reached := false
+	if c.isAnchor(address) {
+		reached = true
+		categories := make([]string, 0)
+		for _, root := range c.strongRoots(address) {
+			if !slices.Contains(categories, root.Category()) {
+				categories = append(categories, root.Category())
+			}
+		}
+		if _, isObject := c.memory[address].(*heapdump.Object); !isObject || len(categories) == 0 {
+			c.checkPath("", search)
+		}
+		for _, category := range categories {
+			c.checkPath("RuntimeRoot("+category+")", search)
+		}
+	}
+
+	// A record whose owners were all followed without reaching an anchor
+	// can't lead to one along any other path, either; but one that was
+	// only cut off by the path it was reached along might.
+	complete := true
+	for _, owner := range c.ownersOf(address) {
+		o := owner.(heapdump.Addressable).GetAddress()
+		if c.isWeakOwner(owner) || search.dead[o] {
+			continue
+		}
+		if search.onPath[o] {
+			complete = false
+			continue
+		}
+		if c.ownerTraversal.MaxDepth > 0 && depth == c.ownerTraversal.MaxDepth {
+			search.truncated++
+			reached = true
+			continue
+		}
+		if c.matchPaths(o, depth+1, search) {
+			reached = true
+		}
+	}
+	if !reached && complete {
+		search.dead[address] = true
+	}
+	return reached
+}
+
+// Checks the path currently being followed, which starts at an anchor,
+// printing it if it's wanted. The root, if any, is the runtime root that
+// makes its first record an anchor.
+func (c *TreeClimber) checkPath(root string, search *pathMatch) {
+	search.paths++
+	names := slices.Clone(search.names)
+	slices.Reverse(names)
+	if len(root) > 0 {
+		names = append([]string{root}, names...)
+	}
+	if search.re.MatchString(strings.Join(names, "->")) == search.invert {
+		return
+	}
+	search.matches++
+	next := make(map[uint64]uint64)
+	for i := 1; i < len(search.path); i++ {
+		next[search.path[i]] = search.path[i-1]
+	}
+	anchor := search.path[len(search.path)-1]
+	fmt.Fprintf(c.out, "%s\n", strings.Join(names, "->"))
+	fmt.Fprintf(c.out, "  %s\n", c.memory[anchor].(fmt.Stringer).String())
+	c.printSteps(anchor, next, search.target, "    ")
+}
+
+// Names a record along a path, as matched by PrintPathMatches. Segments
+// that haven't been split into globals are named after the global holding
+// the pointer to the next record along the path, if there's a symbol for
+// it.
+func (c *TreeClimber) pathName(address uint64, search *pathMatch) string {
+	switch r := c.memory[address].(type) {
+	case *heapdump.Object:
+		return r.GetFullName()
+	case *heapdump.StackFrame:
+		return "StackFrame(" + r.Name + ")"
+	case *heapdump.Global:
+		return "Global " + r.Name
+	case *heapdump.BssSegment, *heapdump.DataSegment:
+		kind := strings.TrimPrefix(fmt.Sprintf("%T", r), "*heapdump.")
+		if len(search.path) < 2 {
+			return kind
+		}
+		pointer := c.pointerInto(r.(heapdump.Owner), search.path[len(search.path)-2])
+		if name := c.symbols.GetName(pointer); pointer != 0 && len(name) > 0 {
+			return "Global " + name
+		}
+		return kind
+	}
+	return fmt.Sprintf("%T", c.memory[address])
+}
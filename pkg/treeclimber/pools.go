@@ -0,0 +1,183 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The size of a sync.poolLocal, which is padded to keep each P's cache on
+// cache lines of its own.
+const poolLocalSize = 128
+
+// A sync.Pool found in the dump. Pools keep their cached values in arrays
+// with one entry per P: local, which Get and Put use, and victim, which
+// holds what local held before the last collection. Values in either are
+// free for reuse, and are dropped after two collections without use.
+type pool struct {
+	address uint64
+	name    string
+	local   uint64 // address of the local array, if any
+	victim  uint64 // address of the victim array, if any
+	slots   uint64 // entries in the local array (or the victim array, if there's no local one)
+}
+
+func (p *pool) String() string {
+	return fmt.Sprintf("sync.Pool %s", p.name)
+}
+
+// Prints every sync.Pool in the dump, with the memory it keeps cached,
+// most first. That memory is retained, but only until the pool is used
+// less; a heap that stays mysteriously large without growing is often
+// just full pools, which aren't leaks.
+func (c *TreeClimber) PrintPools() error {
+	pools := c.pools()
+	if len(pools) == 0 {
+		return fmt.Errorf("No sync.Pools found")
+	}
+	g := c.retentionGraph()
+	objects, bytes := g.retainedTotals()
+	pooled := g.pooled(pools, objects, bytes)
+
+	list := make([]*pool, 0, len(pools))
+	var totalObjects, totalBytes uint64
+	for _, p := range pools {
+		list = append(list, p)
+		totalObjects += pooled[p.address][0]
+		totalBytes += pooled[p.address][1]
+	}
+	sort.Slice(list, func(i, j int) bool {
+		bi, bj := pooled[list[i].address][1], pooled[list[j].address][1]
+		if bi != bj {
+			return bi > bj
+		}
+		return list[i].address < list[j].address
+	})
+	fmt.Fprintf(c.out, "%d sync.Pools cache %s in %d objects, which is free for reuse rather than leaked\n",
+		len(list), units.Format(totalBytes), totalObjects)
+	for _, p := range list {
+		fmt.Fprintf(c.out, "  %s @ 0x%x: %s in %d objects, over %d Ps\n",
+			p, p.address, units.Format(pooled[p.address][1]), pooled[p.address][0], p.slots)
+	}
+	return nil
+}
+
+// Returns the number of objects and bytes cached by each pool (by its
+// address): what its local and victim arrays retain, including themselves.
+func (g *retentionGraph) pooled(pools map[uint64]*pool, objects []uint64, bytes []uint64) map[uint64][2]uint64 {
+	index := make(map[uint64]int)
+	for i, address := range g.addresses {
+		if g.isObject[i] {
+			index[address] = i
+		}
+	}
+	pooled := make(map[uint64][2]uint64)
+	for _, p := range pools {
+		var total [2]uint64
+		for _, array := range []uint64{p.local, p.victim} {
+			if node, found := index[array]; found && array != 0 {
+				total[0] += objects[node]
+				total[1] += bytes[node]
+			}
+		}
+		pooled[p.address] = total
+	}
+	return pooled
+}
+
+// Finds everything that looks like a sync.Pool:
+//
+//	local      unsafe.Pointer // [localSize]poolLocal
+//	localSize  uintptr
+//	victim     unsafe.Pointer // [victimSize]poolLocal
+//	victimSize uintptr
+//	New        func() any
+//
+// That is, a pointer to an object just big enough for a number of
+// poolLocals, followed by that number, and then either the same again or
+// two zero words. Pools can be globals, or be in heap objects. Since every
+// collection moves local to victim, a pool with only a victim array looks
+// like one whose local array is at the victim's offset; if the two words
+// before it are zero, that's taken to be what it is. Keyed by the address
+// of each pool.
+func (c *TreeClimber) pools() map[uint64]*pool {
+	pools := make(map[uint64]*pool)
+	if c.params == nil {
+		return pools
+	}
+	ps := c.params.PointerSize
+	classes := heapdump.GetSizeClasses(c.params)
+	// Whether the object at the indicated address is an array of the
+	// indicated number of poolLocals
+	locals := func(address uint64, slots uint64) bool {
+		o, isObject := c.memory[address].(*heapdump.Object)
+		return isObject && slots > 0 && slots <= uint64(len(o.Contents)) &&
+			uint64(len(o.Contents)) == classes.RoundUp(slots*poolLocalSize)
+	}
+
+	for address, r := range c.memory {
+		o, isOwner := r.(heapdump.Owner)
+		if !isOwner || c.isWeakOwner(r) {
+			continue
+		}
+		contents := o.GetContents()
+		word := func(at uint64) (uint64, bool) {
+			offset := at - o.GetAddress()
+			if at < o.GetAddress() || offset+ps > uint64(len(contents)) {
+				return 0, false
+			}
+			return c.word(contents[offset:]), true
+		}
+		sources, targets := c.pointerInfo(o)
+		skip := uint64(0)
+		for i, target := range targets {
+			source := sources[i]
+			if target == 0 || source < skip {
+				continue
+			}
+			slots, _ := word(source + ps)
+			if !locals(target, slots) {
+				continue
+			}
+			victim, found := word(source + 2*ps)
+			victimSlots, _ := word(source + 3*ps)
+			if victim != 0 {
+				victim = c.translatePointer(victim)
+			}
+			if !found || !(victim == 0 && victimSlots == 0 || locals(victim, victimSlots)) {
+				continue
+			}
+			p := &pool{address: source, local: target, victim: victim, slots: slots}
+			if victim == 0 {
+				before, found := word(source - 2*ps)
+				beforeSlots, _ := word(source - ps)
+				if found && before == 0 && beforeSlots == 0 {
+					p = &pool{address: source - 2*ps, victim: target, slots: slots}
+				}
+			}
+			p.name = c.poolName(address, p.address)
+			pools[p.address] = p
+			skip = source + 4*ps
+		}
+	}
+	return pools
+}
+
+// Names a pool after the global variable it is, or the record it's in.
+func (c *TreeClimber) poolName(owner uint64, address uint64) string {
+	var name string
+	switch r := c.memory[owner].(type) {
+	case *heapdump.Object:
+		name = r.GetName()
+	case *heapdump.StackFrame:
+		name = "StackFrame(" + heapdump.AbbreviateName(r.Name) + ")"
+	default:
+		return c.globalName(address, "(global)")
+	}
+	if address == owner {
+		return name
+	}
+	return fmt.Sprintf("%s+0x%x", name, address-owner)
+}
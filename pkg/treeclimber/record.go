@@ -0,0 +1,129 @@
+package treeclimber
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Prints everything known about the record containing the indicated
+// address: what it is, each word of its contents (with the names of
+// globals, the fields or locals they belong to, and what its pointers
+// point to), and how many pointers lead into and out of it. For data and
+// BSS segments, which hold all of a program's globals, only the word at
+// the address is shown.
+func (c *TreeClimber) PrintRecord(address uint64) error {
+	o, found := c.containing(address)
+	if !found {
+		if r, found := c.memory[address]; found {
+			fmt.Fprintln(c.out, r.(fmt.Stringer).String())
+			return nil
+		}
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	r := c.memory[o.GetAddress()]
+	fmt.Fprintln(c.out, r.(fmt.Stringer).String())
+	if address != o.GetAddress() {
+		fmt.Fprintf(c.out, "  0x%x is at offset +0x%x\n", address, address-o.GetAddress())
+	}
+	switch r := r.(type) {
+	case *heapdump.Object:
+		fmt.Fprintf(c.out, "  Name: %s\n", r.GetName())
+		if finalizer, found := c.finalizers[r.Address]; found {
+			fmt.Fprintf(c.out, "  Finalizer: %s\n", finalizer.(fmt.Stringer).String())
+		}
+		fmt.Fprintf(c.out, "  Reachable from: %s\n", c.rootClass(r.Address))
+	case *heapdump.StackFrame:
+		fmt.Fprintf(c.out, "  Stack:\n")
+		for _, frame := range c.framesFrom(r.Address) {
+			fmt.Fprintf(c.out, "    [%d] %s\n", frame.Depth, frame.Location())
+		}
+		if r.ContinuationPc != 0 && r.ContinuationPc != r.CurrentPc {
+			fmt.Fprintf(c.out, "  Resumes at: %s\n", c.symbols.FormatPC(r.ContinuationPc))
+		}
+	}
+
+	start, end := o.GetAddress(), o.GetAddress()+uint64(len(o.GetContents()))
+	switch r.(type) {
+	case *heapdump.DataSegment, *heapdump.BssSegment:
+		start = address - (address-o.GetAddress())%c.params.PointerSize
+		end = start + c.params.PointerSize
+	}
+	c.printWords(o, start, end)
+
+	out, unknown := 0, 0
+	sources, targets := c.pointerInfo(o)
+	for i, target := range targets {
+		if target == 0 || sources[i] < start || sources[i] >= end {
+			continue
+		}
+		out++
+		if _, found := c.containing(target); !found {
+			unknown++
+		}
+	}
+	in, owners, roots := 0, make(map[uint64]bool), 0
+	for _, dest := range between(c.ownedIndex, start, end) {
+		for _, owner := range c.owners[dest] {
+			in++
+			owners[owner.(heapdump.Owner).GetAddress()] = true
+		}
+	}
+	for _, dest := range between(c.rootedIndex, start, end) {
+		roots += len(c.roots[dest])
+	}
+	fmt.Fprintf(c.out, "Pointers out: %d (%d to unknown targets)\n", out, unknown)
+	fmt.Fprintf(c.out, "Pointers in: %d from %d owners, plus %d runtime roots\n", in, len(owners), roots)
+	return nil
+}
+
+// Prints each word of an owner's contents between the indicated addresses,
+// leaving out runs of zeros.
+func (c *TreeClimber) printWords(o heapdump.Owner, start, end uint64) {
+	pointers := make(map[uint64]bool)
+	for _, field := range o.GetFields() {
+		pointers[field.Offset] = true
+	}
+	contents := o.GetContents()
+	wordSize := c.params.PointerSize
+	zeros := uint64(0)
+	fmt.Fprintf(c.out, "Contents:\n")
+	for address := start; address+wordSize <= end; address += wordSize {
+		offset := address - o.GetAddress()
+		value := c.word(contents[offset:])
+		if value == 0 {
+			zeros += wordSize
+			continue
+		}
+		if zeros > 0 {
+			fmt.Fprintf(c.out, "  ... %d bytes of zeros\n", zeros)
+			zeros = 0
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "  +0x%04x", offset)
+		if name := c.symbols.GetName(address); name != "" {
+			fmt.Fprintf(&b, " (%s)", name)
+		}
+		if pointers[offset] {
+			fmt.Fprintf(&b, ": %s", c.symbols.FormatAddr(value))
+			if target, found := c.containing(value); found {
+				fmt.Fprintf(&b, " -> %s", target.(fmt.Stringer).String())
+				if value != target.GetAddress() {
+					fmt.Fprintf(&b, " +0x%x", value-target.GetAddress())
+				}
+			} else {
+				fmt.Fprintf(&b, " -> ???")
+			}
+		} else {
+			fmt.Fprintf(&b, ": %d (0x%x)", value, value)
+		}
+		if location, found := c.symbols.GetSourceLocation(c.memory[o.GetAddress()], offset); found {
+			fmt.Fprintf(&b, " [%s]", location)
+		}
+		fmt.Fprintln(c.out, b.String())
+	}
+	if zeros > 0 {
+		fmt.Fprintf(c.out, "  ... %d bytes of zeros\n", zeros)
+	}
+}
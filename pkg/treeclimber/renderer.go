@@ -0,0 +1,84 @@
+package treeclimber
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Writes the owner graph in an output format of its own. Renderers are
+// given the same model that --format jsongraph writes (see JSONGraph), so
+// that formats that heapspurs doesn't know about (wiki markup, say, or
+// the input of an in-house dashboard) can be added without changing it.
+type Renderer interface {
+	Render(graph *JSONGraph, w io.Writer) error
+}
+
+// Lets an ordinary function be used as a Renderer.
+type RendererFunc func(graph *JSONGraph, w io.Writer) error
+
+func (f RendererFunc) Render(graph *JSONGraph, w io.Writer) error {
+	return f(graph, w)
+}
+
+// A Renderer that runs a command, with the graph written to its standard
+// input as JSON, and copies what it writes to its standard output. What
+// it writes to standard error goes to heapspurs' own. This lets renderers
+// be written in any language.
+type CommandRenderer struct {
+	Path string
+	Args []string
+}
+
+func (r *CommandRenderer) Render(graph *JSONGraph, w io.Writer) error {
+	cmd := exec.Command(r.Path, r.Args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Running renderer '%s': %w", r.Path, err)
+	}
+	err = json.NewEncoder(in).Encode(graph)
+	in.Close()
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("Renderer '%s' failed: %w", r.Path, waitErr)
+	}
+	return err
+}
+
+var renderersMutex sync.Mutex
+var renderers = map[string]Renderer{}
+
+// Registers a renderer for the indicated format, replacing any that was
+// registered for it before. This is usually done from the init function
+// of the package that defines the renderer.
+func RegisterRenderer(format string, r Renderer) {
+	renderersMutex.Lock()
+	defer renderersMutex.Unlock()
+	renderers[format] = r
+}
+
+// Returns the renderer registered for the indicated format, if any.
+func LookupRenderer(format string) (Renderer, bool) {
+	renderersMutex.Lock()
+	defer renderersMutex.Unlock()
+	r, found := renderers[format]
+	return r, found
+}
+
+// Writes the owner graph with the renderer registered for the indicated
+// format.
+func (c *TreeClimber) Render(format string, w io.Writer) error {
+	r, found := LookupRenderer(format)
+	if !found {
+		return fmt.Errorf("No renderer registered for format '%s'", format)
+	}
+	return r.Render(c.jsonGraph(), w)
+}
@@ -0,0 +1,481 @@
+package treeclimber
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"github.com/goccy/go-graphviz"
+	"github.com/goccy/go-graphviz/cgraph"
+)
+
+// How many types, owners, and leak suspects the report shows
+const (
+	reportTypes     = 25
+	reportRetainers = 20
+	reportSuspects  = 5
+)
+
+// An owner is a leak suspect if it retains at least this much of the heap
+// (the same threshold as Eclipse MAT's)...
+const suspectShare = 0.1
+
+// ...and the memory it retains is said to accumulate at the deepest owner
+// below it that still retains at least this much of what it does.
+const accumulationShare = 0.8
+
+// How many hops of owners are drawn around each suspect's accumulation point
+const suspectHops = 3
+
+type reportFact struct {
+	Name  string
+	Value string
+}
+
+type reportType struct {
+	Name    string
+	Count   uint64
+	Bytes   string
+	Percent float64
+	Width   float64 // of its bar, relative to the largest type's
+}
+
+type reportRetainer struct {
+	Label   string
+	Objects uint64
+	Bytes   string
+	Percent float64
+}
+
+type reportSuspect struct {
+	Number       int
+	Owner        reportRetainer
+	Accumulation reportRetainer
+	Path         string
+	Graph        string // the name of the graph's file, if it could be drawn
+}
+
+// A page of its own for one of the records drawn in the suspects' graphs
+type reportPage struct {
+	Title    string
+	Retained string
+	Record   string
+	Owners   []reportLink
+	Hexdump  string
+}
+
+type reportLink struct {
+	Label string
+	Page  string // empty if the record has no page
+}
+
+type reportData struct {
+	Title      string
+	Overview   []reportFact
+	Types      []reportType
+	MoreTypes  int
+	Retainers  []reportRetainer
+	Suspects   []reportSuspect
+	Goroutines string
+}
+
+// Writes a static HTML report on the dump to the indicated directory, for
+// attaching to a bug or an incident: an overview of the dump, the types
+// that use the most memory, the owners that retain the most, the leak
+// suspects along with their paths from an anchor and graphs of their
+// owners, and a summary of the goroutines. The report is index.html; the
+// graphs are SVG files next to it. Each record in the graphs links to a
+// page of its own (see writeReportPage), so that the report can be
+// explored from one record to the next without heapspurs running.
+//
+// A leak suspect is an owner that retains at least a tenth of the heap,
+// and isn't itself retained by another owner (just by the anchors). Since
+// the owner at the top is often a global or a stack frame that leads to the
+// real culprit, each suspect also has an accumulation point: the owner
+// furthest down the chain of owners that each retain most of what the
+// suspect does. That's usually the cache or list that's growing.
+func (c *TreeClimber) WriteReport(dir string, title string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	data := &reportData{Title: title}
+
+	types, count, total := c.histogram()
+	if count == 0 {
+		return fmt.Errorf("Could not find any objects in the dump")
+	}
+	data.Overview = c.reportOverview(len(types), count, total)
+	for i, e := range types {
+		if i == reportTypes {
+			data.MoreTypes = len(types) - reportTypes
+			break
+		}
+		data.Types = append(data.Types, reportType{
+			Name:    e.name,
+			Count:   e.count,
+			Bytes:   units.Format(e.bytes),
+			Percent: 100 * float64(e.bytes) / float64(total),
+			Width:   100 * float64(e.bytes) / float64(types[0].bytes),
+		})
+	}
+
+	g := c.retentionGraph()
+	objects, retained := g.retainedTotals()
+	pages := make(map[uint64]bool) // the records drawn in the graphs
+	if pools := c.pools(); len(pools) > 0 {
+		var pooled uint64
+		for _, p := range g.pooled(pools, objects, retained) {
+			pooled += p[1]
+		}
+		data.Overview = append(data.Overview, reportFact{"Pooled",
+			fmt.Sprintf("%s cached in %d sync.Pools, free for reuse rather than leaked", units.Format(pooled), len(pools))})
+	}
+	for _, r := range g.topOwners(reportRetainers, objects, retained) {
+		data.Retainers = append(data.Retainers, reportRetainer{
+			Label:   r.Label,
+			Objects: r.Objects,
+			Bytes:   units.Format(r.Bytes),
+			Percent: 100 * float64(r.Bytes) / float64(total),
+		})
+	}
+
+	for i, suspect := range g.leakSuspects(retained, total) {
+		owner, accumulation := suspect[0], suspect[1]
+		s := reportSuspect{
+			Number:       i + 1,
+			Owner:        g.reportRetainer(owner, objects, retained, total),
+			Accumulation: g.reportRetainer(accumulation, objects, retained, total),
+		}
+		address := g.addresses[accumulation]
+		s.Path = c.captureOutput(func() error { return c.PrintPath(address) })
+
+		if _, found := c.memory[address]; found {
+			s.Graph = fmt.Sprintf("suspect-%d.svg", s.Number)
+			err = c.writeReportGraph(filepath.Join(dir, s.Graph), address, pages)
+			if err != nil {
+				return err
+			}
+		}
+		data.Suspects = append(data.Suspects, s)
+	}
+
+	nodes := make(map[uint64]int)
+	for node := 1; node < len(g.addresses); node++ {
+		if _, isFrame := c.memory[g.addresses[node]].(*heapdump.StackFrame); g.isObject[node] || isFrame {
+			nodes[g.addresses[node]] = node
+		}
+	}
+	for address := range pages {
+		share := ""
+		if node, found := nodes[address]; found {
+			share = fmt.Sprintf("%s in %d objects (%.1f%% of the heap)",
+				units.Format(retained[node]), objects[node], 100*float64(retained[node])/float64(total))
+		}
+		err = c.writeReportPage(dir, address, share, pages)
+		if err != nil {
+			return err
+		}
+	}
+
+	data.Goroutines = c.captureOutput(c.PrintStackStats)
+
+	out, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	err = reportTemplate.Execute(out, data)
+	if err != nil {
+		return fmt.Errorf("Write report: %w", err)
+	}
+	return out.Close()
+}
+
+func (c *TreeClimber) reportOverview(types int, count uint64, total uint64) []reportFact {
+	var frames, globals int
+	for _, r := range c.memory {
+		switch r.(type) {
+		case *heapdump.StackFrame:
+			frames++
+		case *heapdump.Global:
+			globals++
+		}
+	}
+	facts := []reportFact{
+		{"Objects", fmt.Sprintf("%d of %d types, using %s", count, types, units.Format(total))},
+		{"Goroutines", fmt.Sprintf("%d, with %d stack frames", len(c.goroutines), frames)},
+		{"Globals", fmt.Sprintf("%d", globals)},
+	}
+	if c.params != nil {
+		facts = append(facts, reportFact{"Architecture",
+			fmt.Sprintf("%s, %d-byte pointers, %d CPUs", c.params.Architecture, c.params.PointerSize, c.params.Ncpu)})
+	}
+	if m := c.memStats; m != nil {
+		facts = append(facts,
+			reportFact{"Heap", fmt.Sprintf("%s allocated of %s obtained from the OS (%.1f%% fragmentation)",
+				units.Format(m.HeapAlloc), units.Format(m.HeapSys), 100*m.HeapFragmentation())},
+			reportFact{"Total memory", units.Format(m.Sys)},
+			reportFact{"Garbage collections", fmt.Sprintf("%d", m.NumGC)})
+	}
+	return facts
+}
+
+// Returns the leak suspects (see WriteReport), largest first, each as the
+// node of the suspect and that of its accumulation point.
+func (g *retentionGraph) leakSuspects(bytes []uint64, total uint64) [][2]int {
+	dominated := make([][]int, len(g.addresses))
+	for node := 1; node < len(g.addresses); node++ {
+		if g.idom[node] >= 0 {
+			dominated[g.idom[node]] = append(dominated[g.idom[node]], node)
+		}
+	}
+	// What no anchor can reach is garbage, not a leak
+	garbage := make(map[int]bool)
+	for _, node := range g.children[0][g.anchors:] {
+		garbage[node] = true
+	}
+
+	suspects := make([][2]int, 0)
+	for _, node := range dominated[0] {
+		if garbage[node] || float64(bytes[node]) < suspectShare*float64(total) {
+			continue
+		}
+		accumulation := node
+		for {
+			biggest := -1
+			for _, child := range dominated[accumulation] {
+				if biggest < 0 || bytes[child] > bytes[biggest] {
+					biggest = child
+				}
+			}
+			if biggest < 0 || float64(bytes[biggest]) < accumulationShare*float64(bytes[accumulation]) {
+				break
+			}
+			accumulation = biggest
+		}
+		suspects = append(suspects, [2]int{node, accumulation})
+	}
+	sort.SliceStable(suspects, func(i, j int) bool {
+		return bytes[suspects[i][0]] > bytes[suspects[j][0]]
+	})
+	if len(suspects) > reportSuspects {
+		suspects = suspects[:reportSuspects]
+	}
+	return suspects
+}
+
+func (g *retentionGraph) reportRetainer(node int, objects []uint64, bytes []uint64, total uint64) reportRetainer {
+	return reportRetainer{
+		Label:   g.labels[node],
+		Objects: objects[node],
+		Bytes:   units.Format(bytes[node]),
+		Percent: 100 * float64(bytes[node]) / float64(total),
+	}
+}
+
+// Draws the owners within a few hops of a suspect's accumulation point.
+// Its children are left out, since there are usually a great many of them.
+// Each record drawn links to its page, and is added to the pages to write.
+func (c *TreeClimber) writeReportGraph(path string, address uint64, pages map[uint64]bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = c.render(f, graphviz.SVG, func(graph *cgraph.Graph) {
+		c.addOwners(graph, address, suspectHops)
+		for node := graph.FirstNode(); node != nil; node = graph.NextNode(node) {
+			// Records are drawn as nodes named for their addresses
+			a, err := strconv.ParseUint(node.Name(), 0, 64)
+			if err != nil {
+				continue
+			}
+			if _, found := c.memory[a]; found {
+				node.SetURL(reportPageName(a))
+				node.SetTarget("_top")
+				pages[a] = true
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Draw '%s': %w", path, err)
+	}
+	return f.Close()
+}
+
+func reportPageName(address uint64) string {
+	return fmt.Sprintf("record-0x%x.html", address)
+}
+
+// Writes the page for the record at the indicated address: what it is, what
+// it retains, each word of its contents, its owners (linked to their own
+// pages, if they have them), and a hexdump.
+func (c *TreeClimber) writeReportPage(dir string, address uint64, retained string, pages map[uint64]bool) error {
+	page := &reportPage{
+		Title:    c.memory[address].(fmt.Stringer).String(),
+		Retained: retained,
+		Record:   c.captureOutput(func() error { return c.PrintRecord(address) }),
+	}
+	seen := make(map[uint64]bool)
+	for _, owner := range c.ownersOf(address) {
+		a := owner.(heapdump.Owner).GetAddress()
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		link := reportLink{Label: owner.(fmt.Stringer).String()}
+		if pages[a] {
+			link.Page = reportPageName(a)
+		}
+		page.Owners = append(page.Owners, link)
+	}
+	sort.Slice(page.Owners, func(i, j int) bool { return page.Owners[i].Label < page.Owners[j].Label })
+	hexdump, err := c.Hexdump(address)
+	if err == nil {
+		page.Hexdump = hexdump
+	}
+
+	out, err := os.Create(filepath.Join(dir, reportPageName(address)))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	err = reportPageTemplate.Execute(out, page)
+	if err != nil {
+		return fmt.Errorf("Write report page: %w", err)
+	}
+	return out.Close()
+}
+
+// Returns what the indicated Print* method writes, followed by the error it
+// returns, if any.
+func (c *TreeClimber) captureOutput(print func() error) string {
+	var b bytes.Buffer
+	previous := c.out
+	c.SetOutput(&b)
+	err := print()
+	c.SetOutput(previous)
+	if err != nil {
+		fmt.Fprintln(&b, err)
+	}
+	return b.String()
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.3em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; vertical-align: top; }
+td.number { text-align: right; white-space: nowrap; }
+.bar { background: #4a7fb5; height: 1em; min-width: 1px; }
+.chart { width: 30em; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+.suspect { margin-bottom: 2em; }
+.suspect object { max-width: 100%; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<h2>Overview</h2>
+<table>
+{{- range .Overview}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Leak Suspects</h2>
+{{- range $s := .Suspects}}
+<div class="suspect">
+<h3>Suspect {{$s.Number}}</h3>
+<p><code>{{$s.Owner.Label}}</code> retains {{$s.Owner.Bytes}} ({{printf "%.1f" $s.Owner.Percent}}% of the heap) in {{$s.Owner.Objects}} objects.
+{{- if ne $s.Owner.Label $s.Accumulation.Label}}
+The memory accumulates at <code>{{$s.Accumulation.Label}}</code>, which retains {{$s.Accumulation.Bytes}} in {{$s.Accumulation.Objects}} objects.
+{{- end}}</p>
+<pre>{{$s.Path}}</pre>
+{{- if $s.Graph}}
+<p><object data="{{$s.Graph}}" type="image/svg+xml">Owners of {{$s.Accumulation.Label}}</object></p>
+{{- end}}
+</div>
+{{- else}}
+<p>No single owner retains a tenth of the heap.</p>
+{{- end}}
+
+<h2>Types</h2>
+<table>
+<tr><th>Type</th><th>Objects</th><th>Size</th><th></th><th class="chart"></th></tr>
+{{- range .Types}}
+<tr><td><code>{{.Name}}</code></td><td class="number">{{.Count}}</td><td class="number">{{.Bytes}}</td><td class="number">{{printf "%.1f" .Percent}}%</td><td class="chart"><div class="bar" style="width: {{printf "%.1f" .Width}}%"></div></td></tr>
+{{- end}}
+</table>
+{{- if .MoreTypes}}
+<p>... and {{.MoreTypes}} more types</p>
+{{- end}}
+
+<h2>Top Retainers</h2>
+<table>
+<tr><th>Owner</th><th>Objects</th><th>Retains</th><th></th></tr>
+{{- range .Retainers}}
+<tr><td><code>{{.Label}}</code></td><td class="number">{{.Objects}}</td><td class="number">{{.Bytes}}</td><td class="number">{{printf "%.1f" .Percent}}%</td></tr>
+{{- end}}
+</table>
+
+<h2>Goroutines</h2>
+<pre>{{.Goroutines}}</pre>
+</body>
+</html>
+`))
+
+var reportPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.3em; }
+h2 { font-size: 1.1em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<p><a href="index.html">Back to the report</a></p>
+<h1><code>{{.Title}}</code></h1>
+{{- if .Retained}}
+<p>Retains {{.Retained}}.</p>
+{{- end}}
+
+<h2>Contents</h2>
+<pre>{{.Record}}</pre>
+
+<h2>Owners</h2>
+{{- if .Owners}}
+<ul>
+{{- range .Owners}}
+<li>{{if .Page}}<a href="{{.Page}}"><code>{{.Label}}</code></a>{{else}}<code>{{.Label}}</code>{{end}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>Nothing in the dump points to it.</p>
+{{- end}}
+{{- if .Hexdump}}
+
+<h2>Hexdump</h2>
+<pre>{{.Hexdump}}</pre>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,84 @@
+package treeclimber
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// Prints every object that would be freed if the owner at the indicated
+// address went away: that is, everything it dominates.
+func (c *TreeClimber) PrintRetainedSet(address uint64) error {
+	addresses, err := c.retainedSet(address)
+	if err != nil {
+		return err
+	}
+	var total uint64
+	for _, a := range addresses {
+		o := c.memory[a].(*heapdump.Object)
+		total += uint64(len(o.Contents))
+		fmt.Fprintln(c.out, o.String())
+	}
+	fmt.Fprintf(c.out, "%d objects, %s\n", len(addresses), units.Format(total))
+	return nil
+}
+
+// Writes the retained set of the owner at the indicated address as CSV.
+func (c *TreeClimber) WriteRetainedSet(address uint64, w io.Writer) error {
+	addresses, err := c.retainedSet(address)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"address", "type", "size"})
+	for _, a := range addresses {
+		o := c.memory[a].(*heapdump.Object)
+		cw.Write([]string{
+			fmt.Sprintf("0x%x", a),
+			o.GetFullName(),
+			fmt.Sprintf("%d", len(o.Contents)),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Returns the addresses of all objects dominated by the owner at the
+// indicated address (including the owner itself, if it is an object),
+// sorted by address. The address can also name a global pointer slot.
+func (c *TreeClimber) retainedSet(address uint64) ([]uint64, error) {
+	g := c.retentionGraph()
+	start := -1
+	for i := 1; i < len(g.addresses); i++ {
+		if g.addresses[i] == address && (start == -1 || g.isObject[i]) {
+			start = i
+		}
+	}
+	if start == -1 {
+		return nil, fmt.Errorf("Could not find an owner at address 0x%x", address)
+	}
+
+	dominated := make([][]int, len(g.addresses))
+	for node := 1; node < len(g.idom); node++ {
+		if g.idom[node] >= 0 {
+			dominated[g.idom[node]] = append(dominated[g.idom[node]], node)
+		}
+	}
+
+	addresses := make([]uint64, 0)
+	stack := []int{start}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if g.isObject[node] {
+			addresses = append(addresses, g.addresses[node])
+		}
+		stack = append(stack, dominated[node]...)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses, nil
+}
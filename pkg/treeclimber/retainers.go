@@ -0,0 +1,113 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Explains what is keeping the object at the indicated address alive when
+// more than one thing is. Prints every anchor from which the object can be
+// reached -- all of which have to let go of it before it can be freed --
+// then classifies each of the object's direct owners as exclusive (reachable
+// from only one anchor) or shared (reachable from several). Finally, lists
+// the owners that the object can't be reached without, if any; removing any
+// one of those is enough to free it.
+func (c *TreeClimber) PrintRetainers(address uint64) error {
+	g := c.retentionGraph()
+	target := -1
+	for i := 1; i < len(g.addresses); i++ {
+		if g.addresses[i] == address && g.isObject[i] {
+			target = i
+		}
+	}
+	if target == -1 {
+		return fmt.Errorf("Could not find object at address 0x%x", address)
+	}
+
+	predecessors := make([][]int, len(g.addresses))
+	for from, children := range g.children {
+		for _, to := range children {
+			predecessors[to] = append(predecessors[to], from)
+		}
+	}
+
+	isAnchor := make(map[int]bool)
+	for _, anchor := range g.children[0][:g.anchors] {
+		isAnchor[anchor] = true
+	}
+
+	// Everything that can reach the target, and which of those are anchors
+	ancestors := map[int]bool{target: true}
+	anchors := make([]int, 0)
+	queue := []int{target}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, p := range predecessors[node] {
+			if p == 0 {
+				if isAnchor[node] {
+					anchors = append(anchors, node)
+				}
+				continue
+			}
+			if !ancestors[p] {
+				ancestors[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	sort.Slice(anchors, func(i, j int) bool { return g.addresses[anchors[i]] < g.addresses[anchors[j]] })
+
+	// Count how many anchors each ancestor can be reached from
+	reach := make(map[int]int)
+	for _, anchor := range anchors {
+		seen := map[int]bool{anchor: true}
+		queue := []int{anchor}
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+			reach[node]++
+			for _, child := range g.children[node] {
+				if ancestors[child] && !seen[child] {
+					seen[child] = true
+					queue = append(queue, child)
+				}
+			}
+		}
+	}
+
+	if len(anchors) == 0 {
+		fmt.Fprintf(c.out, "%s is not reachable from any anchor (except, perhaps, through weak references), so nothing retains it\n", g.labels[target])
+		return nil
+	}
+	fmt.Fprintf(c.out, "%s is reachable from %d anchors, all of which must release it:\n", g.labels[target], len(anchors))
+	for _, anchor := range anchors {
+		fmt.Fprintf(c.out, "  %s\n", g.labels[anchor])
+	}
+
+	owners := make([]int, 0)
+	for _, p := range predecessors[target] {
+		if p != 0 {
+			owners = append(owners, p)
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool { return g.addresses[owners[i]] < g.addresses[owners[j]] })
+	fmt.Fprintf(c.out, "Owners:\n")
+	for _, owner := range owners {
+		if reach[owner] > 1 {
+			fmt.Fprintf(c.out, "  %s: shared (reachable from %d anchors)\n", g.labels[owner], reach[owner])
+		} else {
+			fmt.Fprintf(c.out, "  %s: exclusive\n", g.labels[owner])
+		}
+	}
+
+	if g.idom[target] == 0 {
+		fmt.Fprintf(c.out, "No single owner retains it; every anchor above must let go of it.\n")
+		return nil
+	}
+	fmt.Fprintf(c.out, "Freeing any one of these would free it:\n")
+	for node := g.idom[target]; node > 0; node = g.idom[node] {
+		fmt.Fprintf(c.out, "  %s\n", g.labels[node])
+	}
+	return nil
+}
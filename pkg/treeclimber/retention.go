@@ -0,0 +1,333 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The retention graph is a compact, index-based view of the heap used for
+// whole-heap analyses. Node 0 is a synthetic root that points to every
+// anchor (stack frames, individual global pointer slots, and runtime roots),
+// as well as to anything that can't otherwise be reached from an anchor.
+type retentionGraph struct {
+	addresses []uint64 // node index -> address
+	labels    []string // node index -> human-readable description
+	sizes     []uint64 // node index -> bytes of heap object (0 for non-objects)
+	isObject  []bool
+	children  [][]int
+	idom      []int // immediate dominator of each node; idom[0] == 0
+	anchors   int   // the number of the root's children that are anchors; the rest are unreachable
+}
+
+type retainer struct {
+	Label   string
+	Address uint64
+	Objects uint64 // number of distinct objects retained, including itself
+	Bytes   uint64 // total bytes of those objects
+}
+
+// Prints the n individual owners that retain the most memory. An owner
+// "retains" an object if every path from an anchor to that object passes
+// through the owner, i.e., the object would be freed if the owner were.
+func (c *TreeClimber) PrintTopOwners(n int) error {
+	retainers := c.topOwners(n)
+	if len(retainers) == 0 {
+		return fmt.Errorf("No owners found")
+	}
+	for i, r := range retainers {
+		fmt.Fprintf(c.out, "%3d. %s: %d objects, %s\n", i+1, r.Label, r.Objects, units.Format(r.Bytes))
+	}
+	return nil
+}
+
+func (c *TreeClimber) topOwners(n int) []retainer {
+	g := c.retentionGraph()
+	objects, bytes := g.retainedTotals()
+	return g.topOwners(n, objects, bytes)
+}
+
+// Returns the n owners in the graph that retain the most, given what each
+// node retains.
+func (g *retentionGraph) topOwners(n int, objects []uint64, bytes []uint64) []retainer {
+	retainers := make([]retainer, 0)
+	for i := 1; i < len(g.addresses); i++ {
+		if len(g.children[i]) == 0 {
+			continue
+		}
+		retainers = append(retainers, retainer{
+			Label:   g.labels[i],
+			Address: g.addresses[i],
+			Objects: objects[i],
+			Bytes:   bytes[i],
+		})
+	}
+	sort.SliceStable(retainers, func(i, j int) bool {
+		if retainers[i].Bytes != retainers[j].Bytes {
+			return retainers[i].Bytes > retainers[j].Bytes
+		}
+		if retainers[i].Objects != retainers[j].Objects {
+			return retainers[i].Objects > retainers[j].Objects
+		}
+		// Nodes are added in no particular order, so ties are broken by
+		// address to list them the same way every time
+		return retainers[i].Address < retainers[j].Address
+	})
+	if n < len(retainers) {
+		retainers = retainers[:n]
+	}
+	return retainers
+}
+
+// Finds the Owner record whose contents contain the indicated address, so
+// that interior pointers can be attributed to the thing they point into.
+func (c *TreeClimber) containing(address uint64) (heapdump.Owner, bool) {
+	if c.ownerIndex == nil {
+		c.ownerIndex = make([]heapdump.Owner, 0)
+		for _, r := range c.memory {
+			o, isOwner := r.(heapdump.Owner)
+			if isOwner {
+				c.ownerIndex = append(c.ownerIndex, o)
+			}
+		}
+		sort.Slice(c.ownerIndex, func(i, j int) bool {
+			return c.ownerIndex[i].GetAddress() < c.ownerIndex[j].GetAddress()
+		})
+	}
+	i := sort.Search(len(c.ownerIndex), func(i int) bool {
+		return c.ownerIndex[i].GetAddress() > address
+	}) - 1
+	if i < 0 {
+		return nil, false
+	}
+	o := c.ownerIndex[i]
+	if address-o.GetAddress() >= uint64(len(o.GetContents())) {
+		return nil, false
+	}
+	return o, true
+}
+
+func (c *TreeClimber) retentionGraph() *retentionGraph {
+	defer heapdump.StartPhase("traversal")()
+	g := &retentionGraph{}
+	index := make(map[uint64]int)
+
+	addNode := func(address uint64, label string, size uint64, isObject bool) int {
+		g.addresses = append(g.addresses, address)
+		g.labels = append(g.labels, label)
+		g.sizes = append(g.sizes, size)
+		g.isObject = append(g.isObject, isObject)
+		g.children = append(g.children, nil)
+		return len(g.addresses) - 1
+	}
+	addNode(0, "Root", 0, false)
+
+	// Objects and stack frames become nodes of their own; segments (and
+	// globals, which can be arrays or structs) are split up into one node
+	// per pointer slot.
+	// Channels are labeled as such, since what their buffers hold is a
+	// common source of leaks. So are the caches of sync.Pools, since what
+	// they hold isn't.
+	channels := c.channels()
+	caches := make(map[uint64]string)
+	for _, p := range c.pools() {
+		if p.local != 0 {
+			caches[p.local] = fmt.Sprintf("Local cache of %s", p)
+		}
+		if p.victim != 0 {
+			caches[p.victim] = fmt.Sprintf("Victim cache of %s", p)
+		}
+	}
+	for address, r := range c.memory {
+		switch o := r.(type) {
+		case *heapdump.Object:
+			label := o.String()
+			if ch, isChannel := channels[address]; isChannel {
+				label = ch.String()
+			} else if cache, isCache := caches[address]; isCache {
+				label = fmt.Sprintf("%s: %s", cache, label)
+			}
+			index[address] = addNode(address, label, uint64(len(o.Contents)), true)
+		case *heapdump.StackFrame:
+			index[address] = addNode(address, o.String(), 0, false)
+		}
+	}
+
+	addEdges := func(from int, target uint64) {
+		o, found := c.containing(target)
+		if !found {
+			return
+		}
+		to, isNode := index[o.GetAddress()]
+		if isNode && to != from {
+			g.children[from] = append(g.children[from], to)
+		}
+	}
+
+	for address, r := range c.memory {
+		o, isOwner := r.(heapdump.Owner)
+		if !isOwner || c.isWeakOwner(r) {
+			continue
+		}
+		sources, targets := c.pointerInfo(o)
+		switch r.(type) {
+		case *heapdump.DataSegment, *heapdump.BssSegment, *heapdump.Global:
+			for i, target := range targets {
+				if target == 0 {
+					continue
+				}
+				slot := addNode(sources[i], "Global @ "+c.symbols.FormatAddr(sources[i]), 0, false)
+				g.children[0] = append(g.children[0], slot)
+				addEdges(slot, target)
+			}
+		default:
+			from := index[address]
+			if _, isFrame := r.(*heapdump.StackFrame); isFrame {
+				g.children[0] = append(g.children[0], from)
+			}
+			for _, target := range targets {
+				if target != 0 {
+					addEdges(from, target)
+				}
+			}
+		}
+	}
+
+	for address := range c.roots {
+		if len(c.strongRoots(address)) > 0 {
+			addEdges(0, address)
+		}
+	}
+
+	g.anchors = len(g.children[0])
+	g.computeDominators()
+	return g
+}
+
+// Computes immediate dominators using the iterative algorithm from Cooper,
+// Harvey, and Kennedy, "A Simple, Fast Dominance Algorithm".
+func (g *retentionGraph) computeDominators() {
+	count := len(g.addresses)
+	order := g.postorder()
+	rpo := make([]int, count) // node -> position in reverse postorder
+	for i, node := range order {
+		rpo[node] = count - 1 - i
+	}
+
+	predecessors := make([][]int, count)
+	for from, children := range g.children {
+		for _, to := range children {
+			predecessors[to] = append(predecessors[to], from)
+		}
+	}
+
+	g.idom = make([]int, count)
+	for i := range g.idom {
+		g.idom[i] = -1
+	}
+	g.idom[0] = 0
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = g.idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = g.idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			node := order[i]
+			newIdom := -1
+			for _, p := range predecessors[node] {
+				if g.idom[p] == -1 {
+					continue
+				}
+				if newIdom == -1 {
+					newIdom = p
+				} else {
+					newIdom = intersect(p, newIdom)
+				}
+			}
+			if newIdom != g.idom[node] {
+				g.idom[node] = newIdom
+				changed = true
+			}
+		}
+	}
+}
+
+// Returns all nodes in postorder from the synthetic root. Anything that
+// can't be reached from an anchor gets hung directly off of the root,
+// so that unanchored cycles (e.g., those kept by finalizers) still count.
+func (g *retentionGraph) postorder() []int {
+	count := len(g.addresses)
+	visited := make([]bool, count)
+	order := make([]int, 0, count)
+
+	type frame struct {
+		node  int
+		child int
+	}
+	walk := func(start int) {
+		stack := []frame{{start, 0}}
+		visited[start] = true
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.child < len(g.children[top.node]) {
+				next := g.children[top.node][top.child]
+				top.child++
+				if !visited[next] {
+					visited[next] = true
+					stack = append(stack, frame{next, 0})
+				}
+				continue
+			}
+			order = append(order, top.node)
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	walk(0)
+	order = order[:len(order)-1]
+	for node := 1; node < count; node++ {
+		if !visited[node] {
+			g.children[0] = append(g.children[0], node)
+			walk(node)
+		}
+	}
+	return append(order, 0)
+}
+
+// Sums the object count and bytes of each node's dominator subtree.
+func (g *retentionGraph) retainedTotals() (objects, bytes []uint64) {
+	count := len(g.addresses)
+	objects = make([]uint64, count)
+	bytes = make([]uint64, count)
+	for i := 0; i < count; i++ {
+		if g.isObject[i] {
+			objects[i] = 1
+			bytes[i] = g.sizes[i]
+		}
+	}
+
+	// Children in the dominator tree always come later in reverse
+	// postorder than their dominators, so one backwards pass suffices.
+	order := g.postorder()
+	for _, node := range order {
+		if node == 0 {
+			continue
+		}
+		parent := g.idom[node]
+		objects[parent] += objects[node]
+		bytes[parent] += bytes[node]
+	}
+	return
+}
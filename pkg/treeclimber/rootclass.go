@@ -0,0 +1,119 @@
+package treeclimber
+
+import (
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Ways in which an object can ultimately be kept alive. An object may be
+// reachable in more than one way, so these are combined as a bitmask.
+type rootClass uint8
+
+const (
+	stackRooted rootClass = 1 << iota
+	bssRooted
+	dataRooted
+	finalizerRooted
+)
+
+// Colors used for node borders, in order of precedence
+var rootClassColors = []struct {
+	class rootClass
+	color string
+}{
+	{stackRooted, "blue"},
+	{bssRooted, "#008080"}, // teal
+	{dataRooted, "green"},
+	{finalizerRooted, "red"},
+}
+
+const unrootedColor = "gray"
+
+// Names for each class, in the same order as rootClassColors
+var rootClassNames = []string{"stack", "BSS segment", "data segment", "finalizer"}
+
+// Lists the ways in which something is rooted, e.g., "stack, BSS segment".
+func (c rootClass) String() string {
+	names := make([]string, 0)
+	for i, rc := range rootClassColors {
+		if c&rc.class != 0 {
+			names = append(names, rootClassNames[i])
+		}
+	}
+	if len(names) == 0 {
+		return "no anchors"
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c rootClass) color() string {
+	for _, rc := range rootClassColors {
+		if c&rc.class != 0 {
+			return rc.color
+		}
+	}
+	return unrootedColor
+}
+
+// Returns the ways in which the record at the indicated address is rooted.
+func (c *TreeClimber) rootClass(address uint64) rootClass {
+	if c.rootClasses == nil {
+		c.computeRootClasses()
+	}
+	return c.rootClasses[address]
+}
+
+// Walks forward from each kind of anchor, marking everything reachable
+// from it with that anchor's class.
+func (c *TreeClimber) computeRootClasses() {
+	c.rootClasses = make(map[uint64]rootClass)
+	seeds := make(map[rootClass][]uint64)
+	for address, r := range c.memory {
+		switch r := r.(type) {
+		case *heapdump.StackFrame:
+			seeds[stackRooted] = append(seeds[stackRooted], address)
+		case *heapdump.BssSegment:
+			seeds[bssRooted] = append(seeds[bssRooted], address)
+		case *heapdump.DataSegment:
+			seeds[dataRooted] = append(seeds[dataRooted], address)
+		case *heapdump.Global:
+			if _, inBss := r.Segment.(*heapdump.BssSegment); inBss {
+				seeds[bssRooted] = append(seeds[bssRooted], address)
+			} else {
+				seeds[dataRooted] = append(seeds[dataRooted], address)
+			}
+		}
+	}
+	for address := range c.finalizers {
+		seeds[finalizerRooted] = append(seeds[finalizerRooted], address)
+	}
+
+	for class, queue := range seeds {
+		for _, address := range queue {
+			c.rootClasses[address] |= class
+		}
+		for len(queue) > 0 {
+			address := queue[0]
+			queue = queue[1:]
+			o, isOwner := c.memory[address].(heapdump.Owner)
+			if !isOwner {
+				continue
+			}
+			for _, target := range c.pointers(o) {
+				if target == 0 {
+					continue
+				}
+				child, found := c.containing(target)
+				if !found {
+					continue
+				}
+				childAddress := child.GetAddress()
+				if c.rootClasses[childAddress]&class == 0 {
+					c.rootClasses[childAddress] |= class
+					queue = append(queue, childAddress)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package treeclimber
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// How many types are drawn in each column of a Sankey diagram; the rest
+// are drawn together as one
+const sankeyTypes = 15
+
+// The layout of a Sankey diagram, in pixels
+const (
+	sankeyWidth     = 1400
+	sankeyHeight    = 800
+	sankeyMargin    = 20
+	sankeyNodeWidth = 14
+	sankeyGap       = 6 // between the nodes of a column
+)
+
+// Where the columns of a Sankey diagram are drawn, from the left
+var sankeyColumns = []float64{sankeyMargin, 460, 900}
+
+// The color of flows out of the middle column
+const sankeyColor = "#4a7fb5"
+
+// What's drawn for everything past the largest types of a column
+const sankeyOther = "other types"
+
+type sankeyNode struct {
+	Label   string
+	Title   string
+	Color   string
+	X, Y, H float64
+	bytes   uint64
+	in, out float64 // how much of its height the links into and out of it have taken up so far
+}
+
+type sankeyLink struct {
+	Path  string
+	Width float64
+	Color string
+	Title string
+	from  *sankeyNode
+	to    *sankeyNode
+	bytes uint64
+}
+
+type sankeyData struct {
+	Title         string
+	Width, Height int
+	Nodes         []*sankeyNode
+	Links         []*sankeyLink
+}
+
+// One column of a Sankey diagram, as it's being added up
+type sankeyColumn struct {
+	sizes map[string]uint64
+	names map[string]string // what each name is drawn as, once the column is lumped
+	nodes map[string]*sankeyNode
+	order []string // what's drawn, from the top
+}
+
+// Writes an HTML page with a Sankey diagram of where the heap's memory
+// goes: from the way it's kept alive (see rootClass), through the types of
+// the outermost objects that retain it, to the types of the objects it's
+// made of. Each object is counted under the object just below the anchors
+// that dominates it (see PrintTopOwners), which is colored the way the
+// borders of graphs are. Only the largest types in each column are drawn;
+// the rest are drawn together.
+func (c *TreeClimber) WriteSankey(w io.Writer) error {
+	g := c.retentionGraph()
+	defer heapdump.StartPhase("traversal")()
+
+	// The outermost object that retains each object; dominators come
+	// before what they dominate in reverse postorder
+	top := make([]int, len(g.addresses))
+	order := g.postorder()
+	for i := len(order) - 1; i >= 0; i-- {
+		node := order[i]
+		if !g.isObject[node] {
+			continue
+		}
+		top[node] = node
+		if parent := g.idom[node]; parent != 0 && g.isObject[parent] {
+			top[node] = top[parent]
+		}
+	}
+	reachable := g.reach(g.children[0][:g.anchors])
+
+	columns := make([]*sankeyColumn, len(sankeyColumns))
+	for i := range columns {
+		columns[i] = &sankeyColumn{sizes: make(map[string]uint64)}
+	}
+	flows := make([]map[[2]string]uint64, len(columns)-1)
+	for i := range flows {
+		flows[i] = make(map[[2]string]uint64)
+	}
+	colors := make(map[string]string)
+	var total uint64
+	for node := 1; node < len(g.addresses); node++ {
+		if !g.isObject[node] {
+			continue
+		}
+		root, color := c.sankeyRoot(g, top[node], reachable)
+		colors[root] = color
+		names := []string{
+			root,
+			histogramName(c.memory[g.addresses[top[node]]].(*heapdump.Object)),
+			histogramName(c.memory[g.addresses[node]].(*heapdump.Object)),
+		}
+		for i, name := range names {
+			columns[i].sizes[name] += g.sizes[node]
+			if i > 0 {
+				flows[i-1][[2]string{names[i-1], name}] += g.sizes[node]
+			}
+		}
+		total += g.sizes[node]
+	}
+	if total == 0 {
+		return fmt.Errorf("Could not find any objects in the dump")
+	}
+
+	// Every column is drawn to the same scale, so that flows are as wide
+	// at both ends
+	tallest := 0
+	for _, column := range columns {
+		column.lump()
+		tallest = max(tallest, len(column.order))
+	}
+	scale := (sankeyHeight - 2*sankeyMargin - sankeyGap*float64(tallest-1)) / float64(total)
+
+	data := &sankeyData{
+		Title:  fmt.Sprintf("Where %s of heap goes", units.Format(total)),
+		Width:  sankeyWidth,
+		Height: sankeyHeight,
+	}
+	for i, column := range columns {
+		column.nodes = make(map[string]*sankeyNode)
+		for name, size := range column.sizes {
+			node, found := column.nodes[column.names[name]]
+			if !found {
+				node = &sankeyNode{Label: column.names[name], Color: sankeyColor}
+				column.nodes[node.Label] = node
+			}
+			node.bytes += size
+		}
+		y := float64(sankeyMargin)
+		for _, name := range column.order {
+			node := column.nodes[name]
+			if i == 0 {
+				node.Color = colors[name]
+			}
+			node.X, node.Y, node.H = sankeyColumns[i], y, max(float64(node.bytes)*scale, 1)
+			node.Title = fmt.Sprintf("%s: %s (%.1f%%)", name, units.Format(node.bytes), 100*float64(node.bytes)/float64(total))
+			data.Nodes = append(data.Nodes, node)
+			y += node.H + sankeyGap
+		}
+	}
+
+	links := make(map[[2]*sankeyNode]*sankeyLink)
+	for i, columnFlows := range flows {
+		for f, size := range columnFlows {
+			from, to := columns[i].nodes[columns[i].names[f[0]]], columns[i+1].nodes[columns[i+1].names[f[1]]]
+			link, found := links[[2]*sankeyNode{from, to}]
+			if !found {
+				link = &sankeyLink{from: from, to: to, Color: sankeyColor}
+				if i == 0 {
+					link.Color = from.Color
+				}
+				links[[2]*sankeyNode{from, to}] = link
+				data.Links = append(data.Links, link)
+			}
+			link.bytes += size
+		}
+	}
+	// Links leave and enter their nodes in the order of the nodes at their
+	// other ends, so that they cross as little as possible
+	sort.Slice(data.Links, func(i, j int) bool {
+		a, b := data.Links[i], data.Links[j]
+		if a.from.X != b.from.X {
+			return a.from.X < b.from.X
+		}
+		if a.from.Y != b.from.Y {
+			return a.from.Y < b.from.Y
+		}
+		return a.to.Y < b.to.Y
+	})
+	for _, link := range data.Links {
+		link.Width = float64(link.bytes) * scale
+		y0 := link.from.Y + link.from.out + link.Width/2
+		link.from.out += link.Width
+		y1 := link.to.Y + link.to.in + link.Width/2
+		link.to.in += link.Width
+		x0, x1 := link.from.X+sankeyNodeWidth, link.to.X
+		link.Path = fmt.Sprintf("M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f",
+			x0, y0, (x0+x1)/2, y0, (x0+x1)/2, y1, x1, y1)
+		link.Width = max(link.Width, 1)
+		link.Title = fmt.Sprintf("%s → %s: %s", link.from.Label, link.to.Label, units.Format(link.bytes))
+	}
+
+	return sankeyTemplate.Execute(w, data)
+}
+
+// Orders a column's names by size, largest first, and lumps everything
+// past the largest together.
+func (column *sankeyColumn) lump() {
+	names := make([]string, 0, len(column.sizes))
+	for name := range column.sizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if column.sizes[names[i]] != column.sizes[names[j]] {
+			return column.sizes[names[i]] > column.sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	column.names = make(map[string]string)
+	for i, name := range names {
+		column.names[name] = name
+		if i >= sankeyTypes {
+			column.names[name] = sankeyOther
+		}
+	}
+	if len(names) > sankeyTypes {
+		names = append(names[:sankeyTypes], sankeyOther)
+	}
+	column.order = names
+}
+
+// Names and colors the way that the object at the indicated node is kept
+// alive: the first of its root classes, as with the borders of graphs, or
+// if it has none, whether it's reachable at all.
+func (c *TreeClimber) sankeyRoot(g *retentionGraph, node int, reachable []bool) (string, string) {
+	class := c.rootClass(g.addresses[node])
+	for i, rc := range rootClassColors {
+		if class&rc.class != 0 {
+			name := rootClassNames[i]
+			return strings.ToUpper(name[:1]) + name[1:], rc.color
+		}
+	}
+	if reachable[node] {
+		return "Runtime roots", unrootedColor
+	}
+	return "Unreachable", unrootedColor
+}
+
+var sankeyTemplate = template.Must(template.New("sankey").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+svg text { font-size: 12px; paint-order: stroke; stroke: white; stroke-width: 3px; }
+path { fill: none; stroke-opacity: 0.35; }
+path:hover { stroke-opacity: 0.6; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>From how memory is kept alive, through the types of the outermost objects that retain it, to the types of the objects it's made of.</p>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+{{- range .Links}}
+<path d="{{.Path}}" stroke="{{.Color}}" stroke-width="{{printf "%.1f" .Width}}"><title>{{.Title}}</title></path>
+{{- end}}
+{{- range .Nodes}}
+<g><title>{{.Title}}</title>
+<rect x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" width="14" height="{{printf "%.1f" .H}}" fill="{{.Color}}"></rect>
+<text x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" dx="18" dy="1em">{{.Label}}</text>
+</g>
+{{- end}}
+</svg>
+</body>
+</html>
+`))
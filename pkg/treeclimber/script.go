@@ -0,0 +1,216 @@
+package treeclimber
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/goccy/go-graphviz"
+)
+
+type scriptCommand struct {
+	line int
+	name string
+	args []string
+}
+
+// The number of arguments each script command takes, at minimum and at most
+var scriptCommands = map[string][2]int{
+	"find":      {1, 1}, // find <regex>
+	"owners":    {1, 2}, // owners <address> [depth]
+	"anchors":   {1, 1}, // anchors <address>
+	"path":      {1, 1}, // path <address>
+	"paths":     {2, 2}, // paths <address> <regex>
+	"breaks":    {1, 1}, // breaks <address>
+	"retained":  {1, 1}, // retained <address>
+	"hexdump":   {1, 2}, // hexdump <address> [context]
+	"graph":     {1, 2}, // graph <address> [hops]
+	"histogram": {0, 1}, // histogram [count]
+	"sites":     {0, 2}, // sites [count] [frames]
+}
+
+// Runs a script of commands against the dump, writing the output of each
+// command to its own numbered file in the indicated directory, along with
+// an index of what was run. Each line of the script is a command followed by
+// its arguments; blank lines and lines starting with "#" are ignored.
+// Addresses can be any expression accepted by SymbolTable.ParseAddress.
+//
+// A command that fails has its error written to its output file, and the
+// rest of the script still runs.
+func (c *TreeClimber) RunScript(script io.Reader, dir string, format graphviz.Format) error {
+	commands, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	index, err := os.Create(filepath.Join(dir, "index.txt"))
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	failures := 0
+	for i, command := range commands {
+		ext := "txt"
+		if command.name == "graph" {
+			ext = string(format)
+		}
+		name := fmt.Sprintf("%03d-%s.%s", i+1, command.name, ext)
+		heapdump.Logger().Info("Running script command",
+			"line", command.line, "command", command.String(), "output", name)
+
+		err := c.runScriptCommand(command, filepath.Join(dir, name), format)
+		status := "ok"
+		if err != nil {
+			failures++
+			status = "error: " + err.Error()
+			heapdump.Logger().Warn("Script command failed", "line", command.line, "error", err)
+		}
+		fmt.Fprintf(index, "%s\t%s\t%s\n", name, command.String(), status)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d script commands failed; see %s", failures, len(commands), filepath.Join(dir, "index.txt"))
+	}
+	return nil
+}
+
+func (command *scriptCommand) String() string {
+	return strings.Join(append([]string{command.name}, command.args...), " ")
+}
+
+// Reads the whole script up front, so that mistakes are caught before
+// spending time on any of the commands.
+func parseScript(script io.Reader) ([]*scriptCommand, error) {
+	commands := make([]*scriptCommand, 0)
+	scanner := bufio.NewScanner(script)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		command := &scriptCommand{line: line, name: fields[0], args: fields[1:]}
+		limits, known := scriptCommands[command.name]
+		if !known {
+			return nil, fmt.Errorf("Line %d: unknown command '%s'", line, command.name)
+		}
+		if len(command.args) < limits[0] || len(command.args) > limits[1] {
+			return nil, fmt.Errorf("Line %d: wrong number of arguments for '%s'", line, command.name)
+		}
+		commands = append(commands, command)
+	}
+	return commands, scanner.Err()
+}
+
+func (c *TreeClimber) runScriptCommand(command *scriptCommand, filename string, format graphviz.Format) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = c.execute(command, out, format)
+	if err != nil && command.name != "graph" {
+		fmt.Fprintf(out, "Error: %v\n", err)
+	}
+	return err
+}
+
+// Runs a single script command (see RunScript), writing its output, or the
+// graph it draws in the indicated format, to out.
+func (c *TreeClimber) Execute(name string, args []string, out io.Writer, format graphviz.Format) error {
+	limits, known := scriptCommands[name]
+	if !known {
+		return fmt.Errorf("Unknown command '%s'", name)
+	}
+	if len(args) < limits[0] || len(args) > limits[1] {
+		return fmt.Errorf("Wrong number of arguments for '%s'", name)
+	}
+	return c.execute(&scriptCommand{name: name, args: args}, out, format)
+}
+
+func (c *TreeClimber) execute(command *scriptCommand, out io.Writer, format graphviz.Format) error {
+	previous := c.out
+	c.SetOutput(out)
+	defer c.SetOutput(previous)
+
+	switch command.name {
+	case "find":
+		return c.PrintFind(command.args[0])
+	case "histogram":
+		limit := 0
+		if len(command.args) > 0 {
+			var err error
+			limit, err = strconv.Atoi(command.args[0])
+			if err != nil {
+				return fmt.Errorf("Bad count '%s': %w", command.args[0], err)
+			}
+		}
+		return c.PrintHistogram(limit)
+	case "sites":
+		limit, frames := 0, defaultSiteFrames
+		for i, arg := range command.args {
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("Bad count '%s': %w", arg, err)
+			}
+			if i == 0 {
+				limit = n
+			} else {
+				frames = n
+			}
+		}
+		return c.PrintAllocSites(limit, frames)
+	}
+
+	address, err := c.symbols.ParseAddress(command.args[0])
+	if err != nil {
+		return err
+	}
+	if command.name == "paths" {
+		return c.PrintPathMatches(address, command.args[1])
+	}
+	count := 0
+	if len(command.args) > 1 {
+		count, err = strconv.Atoi(command.args[1])
+		if err != nil {
+			return fmt.Errorf("Bad count '%s': %w", command.args[1], err)
+		}
+	}
+
+	switch command.name {
+	case "owners":
+		if count == 0 {
+			count = 1
+		}
+		return c.PrintOwners(address, count)
+	case "anchors":
+		return c.PrintAnchors(address)
+	case "path":
+		return c.PrintPath(address)
+	case "breaks":
+		return c.PrintBreaks(address)
+	case "retained":
+		return c.PrintRetained(address)
+	case "hexdump":
+		hexdump, err := c.HexdumpContext(address, uint64(max(count, 0)))
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, hexdump)
+		return err
+	case "graph":
+		if count > 0 {
+			return c.WriteNeighborhood(address, count, out, format)
+		}
+		return c.WriteImage(address, out, format)
+	}
+	return fmt.Errorf("Unknown command '%s'", command.name)
+}
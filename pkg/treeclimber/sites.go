@@ -0,0 +1,153 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// How many frames of each allocation's stack tell sites apart, by default.
+const defaultSiteFrames = 3
+
+type allocSite struct {
+	stack        string
+	allocations  uint64 // according to the profile
+	inUse        uint64 // allocations less frees, according to the profile
+	inUseBytes   uint64
+	found        uint64 // sampled objects actually in the dump
+	foundBytes   uint64
+	foundByTypes map[string]uint64
+}
+
+// Prints how many of the sampled objects in the dump were allocated from
+// each call stack, and how much memory they use, largest first, limited to
+// the indicated number of sites (or all of them, if that isn't positive).
+// Stacks are cut off after the indicated number of frames, merging the
+// sites that only differ below that.
+//
+// This requires the allocation profile that the runtime dumps along with
+// the heap, which only covers the allocations it sampled (one every
+// runtime.MemProfileRate bytes, on average), so the numbers are those of
+// the samples rather than of the whole heap. Next to the objects actually
+// found, each site has the number that the profile says are still in use;
+// the profile is only brought up to date at the end of each collection, so
+// the two may differ a little, but an object that's found without being
+// counted, or counted without being found, is worth a second look.
+func (c *TreeClimber) PrintAllocSites(limit int, frames int) error {
+	if len(c.profiles) == 0 {
+		return fmt.Errorf("The dump has no allocation profile")
+	}
+	if frames <= 0 {
+		return fmt.Errorf("Allocation sites need at least one frame")
+	}
+
+	sites := make(map[string]*allocSite)
+	bySite := make(map[uint64]*allocSite) // by profile bucket
+	for id, r := range c.profiles {
+		bucket := r.(*heapdump.AllocFreeProfileRecord)
+		// Stacks start in the allocator (runtime.mallocgc, runtime.makeslice,
+		// and so on), which everything has in common; the site is whatever
+		// called it, unless the whole stack is in the runtime.
+		stack := bucket.Frames
+		for len(stack) > 0 && strings.HasPrefix(stack[0].Name, "runtime.") {
+			stack = stack[1:]
+		}
+		if len(stack) == 0 {
+			stack = bucket.Frames
+		}
+		names := make([]string, 0, frames)
+		for _, f := range stack {
+			if len(names) == frames {
+				break
+			}
+			names = append(names, heapdump.AbbreviateName(f.Name))
+		}
+		key := strings.Join(names, " <- ")
+		if len(key) == 0 {
+			key = "(unknown)"
+		}
+		site, found := sites[key]
+		if !found {
+			site = &allocSite{stack: key, foundByTypes: make(map[string]uint64)}
+			sites[key] = site
+		}
+		site.allocations += bucket.AllocationCount
+		if bucket.AllocationCount > bucket.FreeCount {
+			site.inUse += bucket.AllocationCount - bucket.FreeCount
+			site.inUseBytes += (bucket.AllocationCount - bucket.FreeCount) * bucket.Size
+		}
+		bySite[id] = site
+	}
+
+	var found, foundBytes, missing uint64
+	for address, id := range c.samples {
+		site, known := bySite[id]
+		o, isObject := c.memory[address].(*heapdump.Object)
+		if !known || !isObject {
+			missing++
+			continue
+		}
+		site.found++
+		site.foundBytes += uint64(len(o.Contents))
+		site.foundByTypes[o.GetName()]++
+		found++
+		foundBytes += uint64(len(o.Contents))
+	}
+
+	list := make([]*allocSite, 0, len(sites))
+	var inUse uint64
+	for _, site := range sites {
+		if site.found == 0 && site.inUse == 0 {
+			continue
+		}
+		list = append(list, site)
+		inUse += site.inUse
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].foundBytes != list[j].foundBytes {
+			return list[i].foundBytes > list[j].foundBytes
+		}
+		if list[i].inUseBytes != list[j].inUseBytes {
+			return list[i].inUseBytes > list[j].inUseBytes
+		}
+		return list[i].stack < list[j].stack
+	})
+
+	fmt.Fprintf(c.out, "%d sampled objects from %d allocation sites use %s; the profile counts %d in use\n",
+		found, len(list), units.Format(foundBytes), inUse)
+	if missing > 0 {
+		fmt.Fprintf(c.out, "%d samples are of objects that aren't in the dump\n", missing)
+	}
+	for i, site := range list {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(c.out, "  ... and %d more sites\n", len(list)-limit)
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %d objects, %s", site.stack, site.found, units.Format(site.foundBytes))
+		if len(site.foundByTypes) > 0 {
+			fmt.Fprintf(c.out, " (%s)", commonestType(site.foundByTypes))
+		}
+		fmt.Fprintf(c.out, "; profile: %d in use, %s, of %d allocated\n",
+			site.inUse, units.Format(site.inUseBytes), site.allocations)
+	}
+	return nil
+}
+
+// Names the type of a site's objects, or the one that most of them are.
+func commonestType(types map[string]uint64) string {
+	var commonest string
+	var count, total uint64
+	for name, n := range types {
+		total += n
+		if n > count || (n == count && name < commonest) {
+			commonest, count = name, n
+		}
+	}
+	if count == total {
+		return commonest
+	}
+	return fmt.Sprintf("mostly %s", commonest)
+}
@@ -0,0 +1,120 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The number of types listed in the size class report
+const sizeClassTop = 20
+
+type sizeClassWaste struct {
+	name      string
+	count     uint64
+	size      uint64 // of the type
+	allocated uint64 // for each object, after rounding up to a size class
+	previous  uint64 // the next smaller size class
+}
+
+func (w *sizeClassWaste) wasted() uint64 {
+	return w.count * (w.allocated - w.size)
+}
+
+// Bytes saved by shrinking the type into the next smaller size class
+func (w *sizeClassWaste) savings() uint64 {
+	if w.previous == 0 {
+		return 0
+	}
+	return w.count * (w.allocated - w.previous)
+}
+
+// Prints the types that lose the most memory to the runtime rounding their
+// allocations up to a size class, along with how much would be saved by
+// shrinking each one into the next smaller class. Sizes of types are read
+// from the program's debug info, so this only covers objects that have
+// been named (see ReadOids) after struct types in a program passed to
+// ReadProgram. Objects bigger than their type, such as the backing arrays
+// of slices, aren't included, since how many elements were asked for isn't
+// known.
+func (c *TreeClimber) PrintSizeClassWaste() error {
+	classes := heapdump.GetSizeClasses(c.params)
+	types := make(map[string]*sizeClassWaste)
+	var known, unknown, unknownBytes, arrays uint64
+	for _, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if !isObject {
+			continue
+		}
+		allocated := uint64(len(o.Contents))
+		size, found := c.symbols.GetTypeSize(o.Name)
+		if !found {
+			unknown++
+			unknownBytes += allocated
+			continue
+		}
+		if classes.RoundUp(size) != allocated {
+			arrays++
+			continue
+		}
+		known++
+		w, found := types[o.Name]
+		if !found {
+			w = &sizeClassWaste{
+				name:      o.GetName(),
+				size:      size,
+				allocated: allocated,
+				previous:  classes.Previous(size),
+			}
+			types[o.Name] = w
+		}
+		w.count++
+	}
+	if known == 0 {
+		return fmt.Errorf("No objects of known size found (are --oid and --program set?)")
+	}
+
+	list := make([]*sizeClassWaste, 0, len(types))
+	var wasted, allocated uint64
+	for _, w := range types {
+		list = append(list, w)
+		wasted += w.wasted()
+		allocated += w.count * w.allocated
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].wasted() != list[j].wasted() {
+			return list[i].wasted() > list[j].wasted()
+		}
+		if list[i].savings() != list[j].savings() {
+			return list[i].savings() > list[j].savings()
+		}
+		return list[i].name < list[j].name
+	})
+
+	if len(classes.Version) > 0 {
+		fmt.Fprintf(c.out, "Using the size classes of %s\n", classes.Version)
+	}
+	fmt.Fprintf(c.out, "%d objects of known size use %s, of which %s (%.1f%%) is lost to rounding up\n",
+		known, units.Format(allocated), units.Format(wasted), 100*float64(wasted)/float64(allocated))
+	if unknown > 0 {
+		fmt.Fprintf(c.out, "%d objects (%s) have no known size and aren't included\n", unknown, units.Format(unknownBytes))
+	}
+	if arrays > 0 {
+		fmt.Fprintf(c.out, "%d objects are bigger than their types (e.g., arrays) and aren't included\n", arrays)
+	}
+	for i, w := range list {
+		if i == sizeClassTop {
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %d objects, %d of %d bytes used (%s lost)",
+			w.name, w.count, w.size, w.allocated, units.Format(w.wasted()))
+		if w.previous > 0 {
+			fmt.Fprintf(c.out, "; shaving %d bytes would fit them in %d, saving %s",
+				w.size-w.previous, w.previous, units.Format(w.savings()))
+		}
+		fmt.Fprintln(c.out)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// A slice is reported if its capacity is at least this many times its
+// length...
+const slackRatio = 4
+
+// ...and the capacity beyond its length takes up at least this many bytes
+const slackMinBytes = 1024
+
+// The number of slices listed by PrintSlackSlices
+const slackTop = 20
+
+type slackSlice struct {
+	owner    heapdump.Owner
+	header   heapdump.SliceHeader
+	array    uint64 // the backing array's object
+	length   uint64
+	capacity uint64
+}
+
+// Bytes of the backing array past the slice's length
+func (s *slackSlice) stranded() uint64 {
+	return (s.capacity - s.length) * s.header.ElemSize
+}
+
+// Prints the slices whose capacity is far beyond their length, with how
+// much of their backing arrays that leaves unused: the classic leak of
+// appending many elements to a slice, then trimming it (s = s[:0], or
+// s = s[len(s)-1:]) and keeping it around, which keeps the whole backing
+// array alive. Slices are found by the types of the objects, stack frames,
+// and globals that hold them, so this requires ReadProgram (and ReadOids,
+// for the slices in objects). Each backing array is only counted once,
+// under the slice that uses the least of it.
+func (c *TreeClimber) PrintSlackSlices() error {
+	ps := c.params.PointerSize
+	var found int
+	arrays := make(map[uint64]*slackSlice)
+	for _, r := range c.memory {
+		o, isOwner := r.(heapdump.Owner)
+		if !isOwner {
+			continue
+		}
+		contents := o.GetContents()
+		for _, header := range c.symbols.GetSliceHeaders(r) {
+			if header.Offset+3*ps > uint64(len(contents)) {
+				continue
+			}
+			found++
+			data := c.pointerAt(contents[header.Offset:])
+			length := c.word(contents[header.Offset+ps:])
+			capacity := c.word(contents[header.Offset+2*ps:])
+			if data == 0 || length > capacity || capacity < slackRatio*max(length, 1) {
+				continue
+			}
+			array, inHeap := c.containing(data)
+			if !inHeap {
+				continue
+			}
+			// Anything that doesn't fit in its backing array isn't a slice
+			// header after all
+			if data-array.GetAddress()+capacity*header.ElemSize > uint64(len(array.GetContents())) {
+				continue
+			}
+			s := &slackSlice{owner: o, header: header, array: array.GetAddress(), length: length, capacity: capacity}
+			if s.stranded() < slackMinBytes {
+				continue
+			}
+			if other, seen := arrays[s.array]; !seen || s.length < other.length {
+				arrays[s.array] = s
+			}
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("No slices found (are --program and --oid set?)")
+	}
+	if len(arrays) == 0 {
+		fmt.Fprintf(c.out, "None of %d slices has a capacity of %dx its length and %s unused\n",
+			found, slackRatio, units.Format(slackMinBytes))
+		return nil
+	}
+
+	list := make([]*slackSlice, 0, len(arrays))
+	var total uint64
+	for _, s := range arrays {
+		list = append(list, s)
+		total += s.stranded()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].stranded() != list[j].stranded() {
+			return list[i].stranded() > list[j].stranded()
+		}
+		return list[i].array < list[j].array
+	})
+	fmt.Fprintf(c.out, "%d of %d slices have at least %dx the capacity they use, stranding %s\n",
+		len(list), found, slackRatio, units.Format(total))
+	for i, s := range list {
+		if i == slackTop {
+			fmt.Fprintf(c.out, "  ... and %d more\n", len(list)-slackTop)
+			break
+		}
+		fmt.Fprintf(c.out, "  %s in %s @ 0x%x: len %d, cap %d of %d-byte elements, stranding %s of the array @ 0x%x\n",
+			s.header.Path, ownerType(s.owner.(heapdump.Record)), s.owner.GetAddress(),
+			s.length, s.capacity, s.header.ElemSize, units.Format(s.stranded()), s.array)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package treeclimber
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+const sqlSchema = `PRAGMA synchronous = OFF;
+BEGIN;
+DROP TABLE IF EXISTS types;
+DROP TABLE IF EXISTS objects;
+DROP TABLE IF EXISTS goroutines;
+DROP TABLE IF EXISTS frames;
+DROP TABLE IF EXISTS segments;
+DROP TABLE IF EXISTS edges;
+DROP TABLE IF EXISTS roots;
+CREATE TABLE types (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE objects (
+  address INTEGER PRIMARY KEY,
+  type_id INTEGER REFERENCES types(id),
+  size INTEGER NOT NULL,
+  finalizer INTEGER NOT NULL
+);
+CREATE TABLE goroutines (
+  id INTEGER PRIMARY KEY,
+  address INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  wait_reason TEXT,
+  system INTEGER NOT NULL,
+  background INTEGER NOT NULL,
+  stack_pointer INTEGER NOT NULL
+);
+CREATE TABLE frames (
+  address INTEGER PRIMARY KEY,
+  goroutine_id INTEGER REFERENCES goroutines(id),
+  depth INTEGER NOT NULL,
+  function TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  child INTEGER
+);
+CREATE TABLE segments (
+  address INTEGER PRIMARY KEY,
+  kind TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  name TEXT
+);
+CREATE TABLE edges (
+  source INTEGER NOT NULL,
+  source_offset INTEGER NOT NULL,
+  target INTEGER NOT NULL,
+  target_offset INTEGER NOT NULL,
+  symbol TEXT
+);
+CREATE TABLE roots (
+  target INTEGER NOT NULL,
+  category TEXT NOT NULL,
+  description TEXT NOT NULL
+);
+`
+
+const sqlIndexes = `CREATE INDEX objects_type ON objects(type_id);
+CREATE INDEX frames_goroutine ON frames(goroutine_id);
+CREATE INDEX edges_source ON edges(source);
+CREATE INDEX edges_target ON edges(target);
+CREATE INDEX roots_target ON roots(target);
+COMMIT;
+`
+
+// The number of rows put in each INSERT statement
+const sqlBatchSize = 500
+
+// Writes the dump as a script of SQL statements (in the SQLite dialect)
+// that creates and fills tables of objects, their types, goroutines, stack
+// frames, segments, the pointers between them (edges), and runtime roots.
+// Addresses are stored as integers. Any existing tables of the same names
+// are replaced.
+func (c *TreeClimber) WriteSQL(w io.Writer) error {
+	s := &sqlWriter{w: bufio.NewWriter(w)}
+	s.write(sqlSchema)
+
+	types := make(map[string]int)
+	for _, address := range c.sortedOwners() {
+		o, isObject := c.memory[address].(*heapdump.Object)
+		if !isObject || len(o.Name) == 0 {
+			continue
+		}
+		if _, found := types[o.Name]; !found {
+			types[o.Name] = len(types) + 1
+			s.insert("types", types[o.Name], o.Name)
+		}
+	}
+
+	goroutines := make(map[uint64]uint64) // frame address -> goroutine ID
+	callers := c.callers()
+	for _, g := range c.goroutines {
+		s.insert("goroutines", g.RoutineId, g.Address, g.Status.String(), g.WaitReason, g.System, g.Background, g.StackPointer)
+		frame, _ := c.memory[g.StackPointer].(*heapdump.StackFrame)
+		for frame != nil {
+			if _, seen := goroutines[frame.Address]; seen {
+				break
+			}
+			goroutines[frame.Address] = g.RoutineId
+			frame = callers[frame.Address]
+		}
+	}
+
+	for _, address := range c.sortedOwners() {
+		switch r := c.memory[address].(type) {
+		case *heapdump.Object:
+			var typeID any
+			if len(r.Name) > 0 {
+				typeID = types[r.Name]
+			}
+			_, finalizer := c.finalizers[address]
+			s.insert("objects", address, typeID, len(r.Contents), finalizer)
+		case *heapdump.StackFrame:
+			var goroutine, child any
+			if id, found := goroutines[address]; found {
+				goroutine = id
+			}
+			if r.ChildPointer != 0 {
+				child = r.ChildPointer
+			}
+			s.insert("frames", address, goroutine, r.Depth, r.Name, len(r.Contents), child)
+		case *heapdump.DataSegment:
+			s.insert("segments", address, "data", len(r.Contents), nil)
+		case *heapdump.BssSegment:
+			s.insert("segments", address, "bss", len(r.Contents), nil)
+		case *heapdump.Global:
+			kind := "data"
+			if _, inBss := r.Segment.(*heapdump.BssSegment); inBss {
+				kind = "bss"
+			}
+			var name any
+			if len(r.Name) > 0 {
+				name = r.Name
+			}
+			s.insert("segments", address, kind, len(r.Contents), name)
+		}
+	}
+
+	for _, e := range c.edges() {
+		s.insert("edges", e.from, e.sourceOffset, e.to, e.targetOffset, c.symbols.GetName(e.from+e.sourceOffset))
+	}
+
+	targets := make([]uint64, 0, len(c.roots))
+	for target := range c.roots {
+		targets = append(targets, target)
+	}
+	sortAddresses(targets)
+	for _, target := range targets {
+		for _, root := range c.roots[target] {
+			s.insert("roots", target, root.Category(), root.Description)
+		}
+	}
+
+	s.flush()
+	s.write(sqlIndexes)
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+// Writes the dump to a SQLite database (see WriteSQL), by running the
+// SQLite "sqlite3" command. The database is created if it doesn't exist.
+func (c *TreeClimber) WriteSQLite(filename string) error {
+	cmd := exec.Command("sqlite3", "-bail", filename)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Writing a SQLite database requires the 'sqlite3' command: %w", err)
+	}
+	err = c.WriteSQL(stdin)
+	stdin.Close()
+	waitErr := cmd.Wait()
+	if err != nil {
+		return err
+	}
+	if waitErr != nil {
+		return fmt.Errorf("Running sqlite3: %w", waitErr)
+	}
+	return nil
+}
+
+// Maps each stack frame's address to the frame that called it. Frames only
+// point towards the top of the stack, so this is needed to walk down from
+// the top.
+func (c *TreeClimber) callers() map[uint64]*heapdump.StackFrame {
+	callers := make(map[uint64]*heapdump.StackFrame)
+	for _, r := range c.memory {
+		frame, isFrame := r.(*heapdump.StackFrame)
+		if isFrame && frame.ChildPointer != 0 {
+			callers[frame.ChildPointer] = frame
+		}
+	}
+	return callers
+}
+
+// Batches rows into multi-row INSERT statements, which SQLite loads much
+// faster than one statement per row.
+type sqlWriter struct {
+	w     *bufio.Writer
+	table string
+	rows  []string
+	err   error
+}
+
+func (s *sqlWriter) write(text string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(text)
+	}
+}
+
+func (s *sqlWriter) insert(table string, values ...any) {
+	if table != s.table || len(s.rows) == sqlBatchSize {
+		s.flush()
+		s.table = table
+	}
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = sqlLiteral(v)
+	}
+	s.rows = append(s.rows, "("+strings.Join(literals, ",")+")")
+}
+
+func (s *sqlWriter) flush() {
+	if len(s.rows) > 0 {
+		s.write("INSERT INTO " + s.table + " VALUES\n" + strings.Join(s.rows, ",\n") + ";\n")
+		s.rows = s.rows[:0]
+	}
+}
+
+func sqlLiteral(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		if len(v) == 0 {
+			return "NULL"
+		}
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprintf("%d", v)
+}
@@ -0,0 +1,116 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The number of goroutines and functions listed in the stack statistics
+const stackStatsTop = 10
+
+type goroutineStack struct {
+	goroutine *heapdump.Goroutine
+	frames    int
+	bytes     uint64
+	bottom    string // the function the goroutine started in
+}
+
+type functionFrames struct {
+	name    string
+	count   int
+	largest uint64
+	bytes   uint64
+}
+
+// Prints a summary of goroutine stacks: how many frames and bytes they use,
+// the goroutines with the biggest stacks, and the functions with the
+// biggest frames.
+func (c *TreeClimber) PrintStackStats() error {
+	if len(c.goroutines) == 0 {
+		return fmt.Errorf("No goroutines found")
+	}
+
+	callers := c.callers()
+	functions := make(map[string]*functionFrames)
+	for _, r := range c.memory {
+		frame, isFrame := r.(*heapdump.StackFrame)
+		if !isFrame {
+			continue
+		}
+		f, found := functions[frame.Name]
+		if !found {
+			f = &functionFrames{name: frame.Name}
+			functions[frame.Name] = f
+		}
+		size := uint64(len(frame.Contents))
+		f.count++
+		f.bytes += size
+		if size > f.largest {
+			f.largest = size
+		}
+	}
+
+	stacks := make([]goroutineStack, 0, len(c.goroutines))
+	var totalFrames int
+	var totalBytes uint64
+	for _, g := range c.goroutines {
+		stack := goroutineStack{goroutine: g}
+		frame, _ := c.memory[g.StackPointer].(*heapdump.StackFrame)
+		for frame != nil && stack.frames <= len(callers) {
+			stack.frames++
+			stack.bytes += uint64(len(frame.Contents))
+			if frame.Name != "runtime.goexit" {
+				stack.bottom = frame.Name
+			}
+			frame = callers[frame.Address]
+		}
+		totalFrames += stack.frames
+		totalBytes += stack.bytes
+		stacks = append(stacks, stack)
+	}
+
+	fmt.Fprintf(c.out, "%d goroutines, %d frames, %s of frames (average %.1f frames, %s per goroutine)\n",
+		len(stacks), totalFrames, units.Format(totalBytes),
+		float64(totalFrames)/float64(len(stacks)), units.Format(totalBytes/uint64(len(stacks))))
+
+	sort.Slice(stacks, func(i, j int) bool {
+		if stacks[i].bytes != stacks[j].bytes {
+			return stacks[i].bytes > stacks[j].bytes
+		}
+		return stacks[i].goroutine.RoutineId < stacks[j].goroutine.RoutineId
+	})
+	fmt.Fprintf(c.out, "Largest stacks:\n")
+	for i, s := range stacks {
+		if i == stackStatsTop {
+			break
+		}
+		status := s.goroutine.Status.String()
+		if len(s.goroutine.WaitReason) > 0 {
+			status += ": " + s.goroutine.WaitReason
+		}
+		fmt.Fprintf(c.out, "  Goroutine[%d] (%s): %d frames, %s, started in %s\n",
+			s.goroutine.RoutineId, status, s.frames, units.Format(s.bytes), heapdump.AbbreviateName(s.bottom))
+	}
+
+	largest := make([]*functionFrames, 0, len(functions))
+	for _, f := range functions {
+		largest = append(largest, f)
+	}
+	sort.Slice(largest, func(i, j int) bool {
+		if largest[i].largest != largest[j].largest {
+			return largest[i].largest > largest[j].largest
+		}
+		return largest[i].name < largest[j].name
+	})
+	fmt.Fprintf(c.out, "Largest frames:\n")
+	for i, f := range largest {
+		if i == stackStatsTop {
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %s (%d frames, %s total)\n", heapdump.AbbreviateName(f.name), units.Format(f.largest), f.count, units.Format(f.bytes))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package treeclimber
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+// The instances of a type in one dump, grouped by the shape of their
+// fingerprints (see fingerprint), with the size of each shape's objects.
+type trackedShapes struct {
+	counts map[string]int
+	sizes  map[string]uint64
+}
+
+// Follows the objects whose names match a regular expression through a
+// series of dumps of the same process, starting with this one; names are
+// those of this dump and then the others, in order. For each interval
+// between two dumps, prints how many of the objects are new, how many
+// survive, and how many were freed, as well as how many of the objects
+// that have appeared since the first dump are still around. That last
+// number only grows when objects are being kept for good, which is what a
+// leak looks like.
+//
+// Objects are matched between dumps by fingerprint, as with
+// PrintPersistence, but ignoring their contents, since objects that
+// survive usually change. Objects with the same fingerprint can't be told
+// apart, so they're matched by count: if there were three and now there
+// are five, three survived and two are new.
+func (c *TreeClimber) PrintTrack(pattern string, others []*TreeClimber, names []string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+	dumps := append([]*TreeClimber{c}, others...)
+	shapes := make([]*trackedShapes, len(dumps))
+	for i, dump := range dumps {
+		shapes[i] = dump.trackedShapes(re)
+	}
+
+	first := shapes[0]
+	objects, bytes := first.totals()
+	fmt.Fprintf(c.out, "%s: %d objects, %s\n", names[0], objects, units.Format(bytes))
+	// The fewest instances of each shape seen so far; that many have been
+	// around since the first dump.
+	fewest := make(map[string]int)
+	for shape, count := range first.counts {
+		fewest[shape] = count
+	}
+	for i := 1; i < len(dumps); i++ {
+		before, after := shapes[i-1], shapes[i]
+		var newObjects, surviving, freed, kept int
+		var newBytes, survivingBytes, freedBytes, keptBytes uint64
+		for shape, count := range after.counts {
+			size := after.sizes[shape]
+			survived := min(count, before.counts[shape])
+			newObjects += count - survived
+			newBytes += uint64(count-survived) * size
+			surviving += survived
+			survivingBytes += uint64(survived) * size
+			fewest[shape] = min(fewest[shape], count)
+			kept += count - fewest[shape]
+			keptBytes += uint64(count-fewest[shape]) * size
+		}
+		for shape, count := range before.counts {
+			gone := count - min(count, after.counts[shape])
+			freed += gone
+			freedBytes += uint64(gone) * before.sizes[shape]
+			if _, found := after.counts[shape]; !found {
+				fewest[shape] = 0
+			}
+		}
+		objects, bytes := after.totals()
+		fmt.Fprintf(c.out, "%s: %d objects, %s\n", names[i], objects, units.Format(bytes))
+		fmt.Fprintf(c.out, "  New: %d objects, %s\n", newObjects, units.Format(newBytes))
+		fmt.Fprintf(c.out, "  Surviving: %d objects, %s\n", surviving, units.Format(survivingBytes))
+		fmt.Fprintf(c.out, "  Freed: %d objects, %s\n", freed, units.Format(freedBytes))
+		fmt.Fprintf(c.out, "  Still here since %s: %d objects, %s\n", names[0], objects-kept, units.Format(bytes-keptBytes))
+		fmt.Fprintf(c.out, "  Appeared since %s and still here: %d objects, %s\n", names[0], kept, units.Format(keptBytes))
+	}
+	return nil
+}
+
+// Groups the objects whose names match a regular expression by the shape
+// of their fingerprints.
+func (c *TreeClimber) trackedShapes(re *regexp.Regexp) *trackedShapes {
+	defer heapdump.StartPhase("traversal")()
+	t := &trackedShapes{counts: make(map[string]int), sizes: make(map[string]uint64)}
+	for _, address := range c.sortedObjects() {
+		o := c.memory[address].(*heapdump.Object)
+		if !re.MatchString(o.Name) {
+			continue
+		}
+		shape := c.fingerprint(o).shape
+		t.counts[shape]++
+		t.sizes[shape] = uint64(len(o.Contents))
+	}
+	return t
+}
+
+// Returns the number of objects, and their total size.
+func (t *trackedShapes) totals() (int, uint64) {
+	objects, bytes := 0, uint64(0)
+	for shape, count := range t.counts {
+		objects += count
+		bytes += uint64(count) * t.sizes[shape]
+	}
+	return objects, bytes
+}
@@ -0,0 +1,68 @@
+package treeclimber
+
+import (
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Maps an address as it's found in the dump -- in a pointer, or in the
+// runtime's records of roots, finalizers, defers, and panics -- to the
+// address that what it points to was dumped at, which is what the memory
+// map is keyed by.
+type addressTranslation func(address uint64) uint64
+
+func identity(address uint64) uint64 {
+	return address
+}
+
+// Returns the address translation for a dump with the indicated parameters.
+// Every dump so far has a single flat address space, in which pointers hold
+// the very addresses that records are dumped at, so this is the identity.
+// Dumps whose records have moved since they were pointed to (as with a
+// moving collector), or whose address space is split into arenas that are
+// based separately, would plug their translation in here, where every
+// analysis picks it up.
+func translationFor(params *heapdump.DumpParams) addressTranslation {
+	return identity
+}
+
+// Returns the targets of an owner's pointers, translated.
+func (c *TreeClimber) pointers(o heapdump.Owner) []uint64 {
+	_, targets := c.pointerInfo(o)
+	return targets
+}
+
+// Returns where each of an owner's pointers is, and its target, translated.
+// Nil pointers stay nil.
+func (c *TreeClimber) pointerInfo(o heapdump.Owner) (sources, targets []uint64) {
+	sources, targets = heapdump.GetPointerInfo(o, c.params)
+	for i, target := range targets {
+		targets[i] = c.translatePointer(target)
+	}
+	return
+}
+
+// Returns the address of an owner's first pointer to the indicated
+// (translated) target, or zero if it has none.
+func (c *TreeClimber) pointerSource(o heapdump.Owner, target uint64) uint64 {
+	sources, targets := c.pointerInfo(o)
+	for i, t := range targets {
+		if t == target {
+			return sources[i]
+		}
+	}
+	return 0
+}
+
+// Reads a pointer from the start of a record's contents that isn't one of
+// its pointer fields, and translates it.
+func (c *TreeClimber) pointerAt(b []byte) uint64 {
+	return c.translatePointer(c.word(b))
+}
+
+// Translates a pointer, leaving nil pointers nil.
+func (c *TreeClimber) translatePointer(address uint64) uint64 {
+	if address == 0 {
+		return 0
+	}
+	return c.translate(address)
+}
@@ -1,64 +1,198 @@
 package treeclimber
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
 	"github.com/goccy/go-graphviz"
 	"github.com/goccy/go-graphviz/cgraph"
 )
 
 type TreeClimber struct {
-	params     *heapdump.DumpParams
-	memory     map[uint64]heapdump.Record   // Map of all records that represet an in-memory construct
-	owners     map[uint64][]heapdump.Record // Maps from pointed-to objects to the thing(s) pointing to them
-	visited    map[uint64]bool              // Temporary state used to keep track of already-visited nodes during graph traversal
-	finalizers map[uint64]heapdump.Record   // Map of object address to its finalizer (if any)
+	params         *heapdump.DumpParams
+	memory         map[uint64]heapdump.Record       // Map of all records that represet an in-memory construct
+	owners         map[uint64][]heapdump.Record     // Maps from pointed-to objects to the thing(s) pointing to them
+	visited        map[uint64]bool                  // Temporary state used to keep track of already-visited nodes during graph traversal
+	finalizers     map[uint64]heapdump.Record       // Map of object address to its finalizer (if any)
+	roots          map[uint64][]*heapdump.OtherRoot // Maps from pointed-to objects to the runtime roots pointing to them
+	rootClasses    map[uint64]rootClass             // Lazily computed record of how each record is ultimately rooted
+	prune          []*regexp.Regexp                 // Object names whose owners are not followed when graphing
+	ownerIndex     []heapdump.Owner                 // Owners sorted by address, for finding the record containing an address
+	ownedIndex     []uint64                         // Addresses that owners point to, sorted, for finding those within a record
+	rootedIndex    []uint64                         // Addresses that runtime roots point to, sorted likewise
+	out            io.Writer                        // Where the Print* methods write their results
+	goroutines     []*heapdump.Goroutine            // All goroutine records, which share addresses with heap objects
+	defers         map[uint64]*heapdump.DeferRecord // Defer records by address, which they share with heap objects or stack frames
+	panics         map[uint64]*heapdump.PanicRecord // Panic records by address, which they share with heap objects or stack frames
+	threads        map[uint64]*heapdump.OsThread    // OS thread records by descriptor address, which they share with heap objects
+	renderOptions  RenderOptions                    // Attributes applied to rendered graphs
+	labelFunc      LabelFunc                        // Optional override for node labels
+	traversalFunc  TraversalFunc                    // Optional observer of graph traversals
+	ownerTraversal OwnerTraversal                   // How PrintOwners walks the owner graph
+	weak           []*regexp.Regexp                 // Object names whose pointers don't retain anything
+	weakFinalizers bool                             // Whether the finalizer queue retains anything
+	exact          bool                             // Whether PrintRetained uses the dominator tree rather than estimating
+	exactTotals    map[uint64]RetainedEstimate      // Lazily computed totals retained by each object, from the dominator tree
+	breakFreed     map[[2]uint64]uint64             // Lazily computed bytes freed by breaking each reference suggested by PrintBreaks
+	firstOwner     map[uint64]heapdump.Owner        // Lazily computed owner followed from each record by rootPath
+	fingerprints   map[uint64]fingerprint           // Lazily computed fingerprint of each object
+	shapeIndex     map[string][]*heapdump.Object    // Objects with each fingerprint shape, sorted by address
+	hiddenUnknown  int                              // Pointers to unknown targets left out of the graph being rendered
+	symbols        *heapdump.SymbolTable            // Names of symbols and objects in this dump
+	annotations    []annotation                     // Rules for tagging records, from an annotations file
+	tagNames       []string                         // Every tag in the annotations file, in order of appearance
+	tags           map[uint64]string                // Lazily computed tag of each tagged record
+	translate      addressTranslation               // Maps addresses found in the dump to those of the records they point to
+	profiles       map[uint64]heapdump.Record       // Allocation profile buckets, by identifier
+	samples        map[uint64]uint64                // Maps sampled objects to the profile bucket they were allocated in
+	memStats       *heapdump.MemStats               // The runtime's memory statistics, if the dump has them
 }
 
-func NewTreeClimber(reader *bufio.Reader) (*TreeClimber, error) {
-	c := &TreeClimber{}
+// Reads a dump, naming what's in it from the default symbol table.
+func NewTreeClimber(reader heapdump.Reader) (*TreeClimber, error) {
+	return NewTreeClimberWithSymbols(reader, heapdump.DefaultSymbols())
+}
+
+// Reads a dump, naming what's in it from the indicated symbol table. Giving
+// each dump its own table lets several be read and analyzed at once.
+func NewTreeClimberWithSymbols(reader heapdump.Reader, symbols *heapdump.SymbolTable) (*TreeClimber, error) {
+	c := &TreeClimber{out: os.Stdout, symbols: symbols}
 	err := c.build(reader)
 	return c, err
 }
 
+// Sets where the Print* methods write their results; the default is stdout.
+func (c *TreeClimber) SetOutput(w io.Writer) {
+	c.out = w
+}
+
+// Sets the regular expressions for object names whose owners should not be
+// followed when graphing, which keeps uninteresting hubs from swamping the
+// graph.
+func (c *TreeClimber) SetPrune(patterns []string) error {
+	c.prune = make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+		}
+		c.prune = append(c.prune, re)
+	}
+	return nil
+}
+
+func (c *TreeClimber) isPruned(name string) bool {
+	for _, re := range c.prune {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints the owners of the record at the indicated address, to the indicated
+// depth (or all the way to the anchors, if the depth is negative), walking
+// the owner graph as set by SetOwnerTraversal.
 func (c *TreeClimber) PrintOwners(address uint64, depth int) error {
-	c.visited = make(map[uint64]bool)
-	defer func() { c.visited = nil }()
+	defer heapdump.StartPhase("traversal")()
 	if depth > 0 {
 		depth++
 	}
-	return c.printOwners(address, depth)
+	if c.ownerTraversal.BreadthFirst {
+		return c.printOwnersBreadthFirst(address, depth)
+	}
+	c.visited = make(map[uint64]bool)
+	defer func() { c.visited = nil }()
+	return c.printOwners(address, 0, depth)
 }
 
+// Prints the anchors that keep the record at the indicated address alive,
+// within the limits set by SetOwnerTraversal.
 func (c *TreeClimber) PrintAnchors(address uint64) error {
+	defer heapdump.StartPhase("traversal")()
 	c.visited = make(map[uint64]bool)
 	defer func() { c.visited = nil }()
-	return c.printAnchors(address)
+	search := &anchorSearch{}
+	err := c.printAnchors(address, 0, search)
+	if search.truncated > 0 {
+		fmt.Fprintf(c.out, "%d paths truncated\n", search.truncated)
+	}
+	return err
 }
 
-func (c *TreeClimber) Hexdump(address uint64) (string, error) {
-	r, found := c.memory[address]
-	if !found {
-		return "", fmt.Errorf("Cound not find record for address 0x%x", address)
-	}
+// How many anchors a search has found, and how many of the paths it could
+// have followed were cut short by the limits on it.
+type anchorSearch struct {
+	paths     int
+	truncated int
+}
 
-	o, isOwner := r.(heapdump.Owner)
-	if !isOwner {
-		return "", fmt.Errorf("Object of type %T does not have Contents", r)
+// Prints every object whose name matches the indicated regular expression.
+func (c *TreeClimber) PrintFind(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w", pattern, err)
 	}
+	addresses := make([]uint64, 0)
+	for address, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if isObject && re.MatchString(o.Name) {
+			addresses = append(addresses, address)
+		}
+	}
+	sortAddresses(addresses)
+	for _, address := range addresses {
+		fmt.Fprintln(c.out, c.memory[address].(fmt.Stringer).String())
+	}
+	return nil
+}
 
-	ret := hex.Dump(o.GetContents())
+// Formats that the embedded Graphviz library can render by itself
+var builtinFormats = map[graphviz.Format]bool{
+	graphviz.SVG:  true,
+	graphviz.PNG:  true,
+	graphviz.JPG:  true,
+	graphviz.XDOT: true,
+}
 
-	for _, field := range o.GetFields() {
-		ret = ret + fmt.Sprintf("Pointer: 0x%x\n", field)
-	}
+// Options that control what goes into rendered graphs, and how they're laid
+// out. DPI, Size, Page, and RankDir are passed along to Graphviz as the
+// graph attributes of the same names.
+type RenderOptions struct {
+	DPI     float64 // Resolution of raster images
+	Size    string  // Maximum size of the drawing, in inches (e.g., "8.5,11")
+	Page    string  // Size of each page, in inches, for formats that support paging
+	Layout  string  // Graphviz layout engine: "dot" (the default), "neato", "fdp", or "sfdp", which copes far better with thousands of nodes
+	RankDir string  // Direction that dot lays out owners and what they own: "TB" (the default), "LR", "BT", or "RL"
+
+	HideUnknown   bool // Leave out pointers to addresses that aren't in any record ("???" nodes)
+	MinEdgeWeight int  // In graphs collapsed by type, leave out edges standing for fewer pointers
+}
+
+func (c *TreeClimber) SetRenderOptions(options RenderOptions) {
+	c.renderOptions = options
+}
+
+// A LabelFunc returns the label for a record's node in a graph, or an empty
+// string to use the default label. This lets programs that embed heapspurs
+// decode their own objects (for example, to show an ID or a key stored in
+// them) without changing how graphs are built.
+type LabelFunc func(record heapdump.Record) string
 
-	return ret, nil
+func (c *TreeClimber) SetLabelFunc(f LabelFunc) {
+	c.labelFunc = f
 }
 
 func (c *TreeClimber) WritePNG(address uint64, w io.Writer) error {
@@ -70,39 +204,126 @@ func (c *TreeClimber) WriteSVG(address uint64, w io.Writer) error {
 }
 
 func (c *TreeClimber) WriteImage(address uint64, w io.Writer, format graphviz.Format) error {
+	return c.render(w, format, func(graph *cgraph.Graph) {
+		c.addOwners(graph, address, -1)
+	})
+}
+
+func (c *TreeClimber) WriteNeighborhoodSVG(address uint64, hops int, w io.Writer) error {
+	return c.WriteNeighborhood(address, hops, w, graphviz.SVG)
+}
+
+// Renders the object at the indicated address along with everything within
+// the indicated number of hops of it, in both directions: its owners (and
+// their owners, etc.), as well as the things it points to.
+func (c *TreeClimber) WriteNeighborhood(address uint64, hops int, w io.Writer, format graphviz.Format) error {
+	return c.render(w, format, func(graph *cgraph.Graph) {
+		node := c.addOwners(graph, address, hops)
+		c.addChildren(graph, node, address, hops)
+	})
+}
+
+func (c *TreeClimber) render(w io.Writer, format graphviz.Format, build func(graph *cgraph.Graph)) error {
 	c.visited = make(map[uint64]bool)
 	defer func() { c.visited = nil }()
 
 	g := graphviz.New()
 	defer g.Close()
+	if len(c.renderOptions.Layout) > 0 {
+		g.SetLayout(graphviz.Layout(c.renderOptions.Layout))
+	}
 	graph, err := g.Graph()
 	if err != nil {
 		return err
 	}
 	defer graph.Close()
 
-	c.addNode(graph, address, true)
+	if c.renderOptions.DPI > 0 {
+		graph.SetDPI(c.renderOptions.DPI)
+	}
+	if len(c.renderOptions.Size) > 0 {
+		graph.SafeSet("size", c.renderOptions.Size, "")
+	}
+	if len(c.renderOptions.Page) > 0 {
+		graph.SafeSet("page", c.renderOptions.Page, "")
+	}
+	if len(c.renderOptions.RankDir) > 0 {
+		graph.SetRankDir(cgraph.RankDir(c.renderOptions.RankDir))
+	}
+
+	c.hiddenUnknown = 0
+	build(graph)
+	defer heapdump.StartPhase("render")()
+
+	if c.hiddenUnknown > 0 {
+		heapdump.Logger().Info("Left out pointers to unknown targets", "count", c.hiddenUnknown)
+	}
+
+	heapdump.Logger().Info("Rendering graph", "nodes", graph.NumberNodes())
+	if builtinFormats[format] {
+		return g.Render(graph, format, w)
+	}
 
-	fmt.Printf("Rendering graph (%d nodes)...\n", len(c.visited))
-	return g.Render(graph, format, w)
+	// Anything else is handed off to the Graphviz "dot" command, which
+	// supports many more formats than the embedded library does.
+	var dot bytes.Buffer
+	err = g.Render(graph, graphviz.XDOT, &dot)
+	if err != nil {
+		return err
+	}
+	args := []string{"-T" + string(format)}
+	if len(c.renderOptions.Layout) > 0 {
+		args = append(args, "-K"+c.renderOptions.Layout)
+	}
+	cmd := exec.Command("dot", args...)
+	cmd.Stdin = &dot
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Rendering %s requires the Graphviz 'dot' command: %w", format, err)
+	}
+	return nil
 }
 
 ///////////////////////////////////////////////////////////////////////////
 
-func unitize(x uint64) string {
-	switch {
-	case x < 2*1024:
-		return fmt.Sprintf("%d B", x)
-	case x < 2*1024*1024:
-		return fmt.Sprintf("%.f kiB", float64(x)/(1024))
-	case x < 2*1024*1024*1024:
-		return fmt.Sprintf("%.2f MiB", float64(x)/(1024*1024))
-	case x < 2*1024*1024*1024*1024:
-		return fmt.Sprintf("%.2f GiB", float64(x)/(1024*1024*1024))
-	default:
-		return fmt.Sprintf("%.2f TiB", float64(x)/(1024*1024*1024*1024))
+// Adds the record at the indicated address to the graph, along with its
+// owners (and their owners, etc.) to the indicated depth; a negative depth
+// means following them all the way back to their anchors.
+func (c *TreeClimber) addOwners(graph *cgraph.Graph, address uint64, depth int) *cgraph.Node {
+	walk := c.walkOwners(address, depth)
+	for _, n := range walk.nodes {
+		c.addNode(graph, n, n == address, walk.expanded[n] && !walk.owned[n])
+	}
+	for _, r := range walk.roots {
+		rn := c.addRuntimeRootNode(graph, r.root.Category())
+		node, _ := graph.Node(fmt.Sprintf("0x%x", r.target))
+		edge, _ := graph.CreateEdge("", rn, node)
+		edge.SetTailLabel(r.root.Description)
 	}
-	return ""
+	for _, e := range walk.edges {
+		on, _ := graph.Node(fmt.Sprintf("0x%x", e.owner))
+		node, _ := graph.Node(fmt.Sprintf("0x%x", e.target))
+		edge, _ := graph.CreateEdge("", on, node)
+		if e.dest != e.target {
+			edge.SetHeadLabel(fmt.Sprintf("0x%x\n(offset = %d)", e.dest, e.dest-e.target))
+			edge.SetColor("red")
+		}
+		owner := c.memory[e.owner].(heapdump.Owner)
+		ps := c.pointerSource(owner, e.dest)
+		if ps != 0 {
+			name := c.symbols.GetName(ps)
+			if name != "" {
+				edge.SetTailLabel(name)
+			}
+			if iface := c.heldAs(owner, ps); len(iface) > 0 {
+				edge.SetLabel("held as " + iface)
+			}
+		}
+	}
+	node, _ := graph.Node(fmt.Sprintf("0x%x", address))
+	return node
 }
 
 // There are four owner types in a heap dump:
@@ -110,7 +331,10 @@ func unitize(x uint64) string {
 // StackFrame
 // BssSegment
 // DataSegment
-func (c *TreeClimber) addNode(graph *cgraph.Graph, address uint64, spotlight bool) *cgraph.Node {
+//
+// Objects that were searched for owners without finding any are marked as
+// orphans.
+func (c *TreeClimber) addNode(graph *cgraph.Graph, address uint64, spotlight bool, orphan bool) *cgraph.Node {
 	record, found := c.memory[address]
 	if !found {
 		node, _ := graph.CreateNode(fmt.Sprintf("0x%x", address))
@@ -138,61 +362,73 @@ func (c *TreeClimber) addNode(graph *cgraph.Graph, address uint64, spotlight boo
 		if name != "Object" {
 			node.SetFontColor("#008000")
 		}
-		label := fmt.Sprintf("%s (%s)\n0x%x", name, unitize(uint64(len(r.Contents))), address)
+		label := fmt.Sprintf("%s (%s)\n0x%x", name, units.Format(uint64(len(r.Contents))), address)
 		if finalizer != nil {
 			label += fmt.Sprintf("\n%T", finalizer)
 			node.SetColor("red")
 			node.SetPenWidth(5)
+		} else {
+			// Border color shows how the object is ultimately rooted
+			node.SetColor(c.rootClass(address).color())
+			node.SetPenWidth(2)
+		}
+		tag, tagged := c.tagOf(address)
+		if tagged {
+			label += "\n[" + tag + "]"
 		}
 		node.SetLabel(label)
 		node.SetShape(cgraph.EllipseShape)
-
-		// Objects generally have owners; track them down and graph them.
-		// Because owners can point to subfields within an object, we need to scan
-		// for references anywhere inside the object.
-		foundOwner := false
-		end := uint64(len(r.Contents)) + address
-		for dest := address; dest < end; dest++ {
-			o, hasOwners := c.owners[dest]
-			if hasOwners {
-				for _, owner := range o {
-					a, isOwner := owner.(heapdump.Owner)
-					if isOwner {
-						foundOwner = true
-						on := c.addNode(graph, a.GetAddress(), false)
-						edge, _ := graph.CreateEdge("", on, node)
-						if dest != address {
-							edge.SetHeadLabel(fmt.Sprintf("0x%x\n(offset = %d)", dest, dest-address))
-							edge.SetColor("red")
-						}
-						ps := heapdump.GetPointersSourceAddress(a, dest, c.params)
-						if ps != 0 {
-							name := heapdump.GetName(ps)
-							if name != "" {
-								edge.SetTailLabel(name)
-							}
-						}
-					}
-				}
-			}
+		// A node can be filled (by its tag, or as an orphan) and dashed (as
+		// pruned) at once, so the styles are combined
+		styles := make([]string, 0, 2)
+		if tagged || orphan {
+			styles = append(styles, string(cgraph.FilledNodeStyle))
 		}
-		if !foundOwner {
-			node.SetStyle(cgraph.FilledNodeStyle)
+		if tagged {
+			node.SetFillColor(c.tagColor(tag))
+		}
+		if orphan {
 			node.SetFillColor("gray")
 		}
+		if !spotlight && c.isPruned(r.GetFullName()) {
+			styles = append(styles, string(cgraph.DashedNodeStyle))
+		}
+		if len(styles) > 0 {
+			node.SetStyle(cgraph.NodeStyle(strings.Join(styles, ",")))
+		}
 	case *heapdump.StackFrame:
 		node.SetLabel(fmt.Sprintf("StackFrame @ 0x%x\n%s", address, c.fullStack(address, "\\l")+"\\l"))
 		node.SetShape(cgraph.BoxShape)
+		node.SetColor(stackRooted.color())
 	case *heapdump.BssSegment:
 		node.SetLabel("BssSegment")
 		node.SetShape(cgraph.DoubleOctagonShape)
+		node.SetColor(bssRooted.color())
 	case *heapdump.DataSegment:
 		node.SetLabel("DataSegment")
 		node.SetShape(cgraph.TripleOctagonShape)
+		node.SetColor(dataRooted.color())
+	case *heapdump.Global:
+		node.SetLabel(fmt.Sprintf("Global @ 0x%x\n%s", address, heapdump.AbbreviateName(r.Name)))
+		if _, inBss := r.Segment.(*heapdump.BssSegment); inBss {
+			node.SetShape(cgraph.DoubleOctagonShape)
+			node.SetColor(bssRooted.color())
+		} else {
+			node.SetShape(cgraph.TripleOctagonShape)
+			node.SetColor(dataRooted.color())
+		}
 	default:
 		node.SetLabel(fmt.Sprintf("%T\n0x%x", r, address))
 		node.SetShape(cgraph.HouseShape)
 	}
+	if c.labelFunc != nil {
+		if label := c.labelFunc(record); len(label) > 0 {
+			node.SetLabel(label)
+		}
+	}
+	if o, isOwner := record.(heapdump.Owner); isOwner {
+		node.SetTooltip(c.tooltip(o))
+	}
 	if spotlight {
 		node.SetStyle(cgraph.FilledNodeStyle)
 		node.SetFillColor("yellow")
@@ -201,22 +437,153 @@ func (c *TreeClimber) addNode(graph *cgraph.Graph, address uint64, spotlight boo
 	return node
 }
 
+// Limits on how much of a record's contents are shown in its tooltip
+const (
+	tooltipBytes  = 64
+	tooltipFields = 16
+)
+
+// Describes the start of a record's contents and what its pointers point
+// to, for display when hovering over its node in a browser.
+func (c *TreeClimber) tooltip(o heapdump.Owner) string {
+	contents := o.GetContents()
+	if len(contents) > tooltipBytes {
+		contents = contents[:tooltipBytes]
+	}
+	text := hex.Dump(contents)
+	if len(o.GetContents()) > tooltipBytes {
+		text += fmt.Sprintf("... %d more bytes\n", len(o.GetContents())-tooltipBytes)
+	}
+
+	sources, targets := c.pointerInfo(o)
+	shown := 0
+	for i, target := range targets {
+		if target == 0 {
+			continue
+		}
+		if shown == tooltipFields {
+			text += "... more pointers\n"
+			break
+		}
+		shown++
+		text += fmt.Sprintf("+0x%x: %s", sources[i]-o.GetAddress(), c.symbols.FormatAddr(target))
+		if r, found := c.containing(target); found {
+			text += " -> " + r.(fmt.Stringer).String()
+		}
+		text += "\n"
+	}
+
+	// Graphviz treats backslashes in attributes as escape sequences
+	return strings.ReplaceAll(text, "\\", "\\\\")
+}
+
+// Runtime roots are grouped by category under a single synthetic
+// "Runtime roots" node, rather than being drawn individually.
+func (c *TreeClimber) addRuntimeRootNode(graph *cgraph.Graph, category string) *cgraph.Node {
+	top, _ := graph.Node("runtime-roots")
+	if top == nil {
+		top, _ = graph.CreateNode("runtime-roots")
+		top.SetLabel("Runtime roots")
+		top.SetShape(cgraph.DoubleOctagonShape)
+	}
+	name := "runtime-roots/" + category
+	node, _ := graph.Node(name)
+	if node == nil {
+		node, _ = graph.CreateNode(name)
+		node.SetLabel(category)
+		node.SetShape(cgraph.OctagonShape)
+		graph.CreateEdge("", top, node)
+	}
+	return node
+}
+
+// Adds nodes for the things the indicated record points to, following
+// pointers to the indicated depth.
+func (c *TreeClimber) addChildren(graph *cgraph.Graph, node *cgraph.Node, address uint64, depth int) {
+	c.addChildrenAt(graph, node, address, depth, 1)
+}
+
+// Adds the children of a record as addChildren does, given how many hops
+// from where the traversal started they are.
+func (c *TreeClimber) addChildrenAt(graph *cgraph.Graph, node *cgraph.Node, address uint64, depth int, hop int) {
+	record, found := c.memory[address]
+	if !found {
+		return
+	}
+	o, isOwner := record.(heapdump.Owner)
+	if !isOwner {
+		return
+	}
+	if depth == 0 {
+		c.emit(TraversalEvent{Kind: Truncated, Record: record, Depth: hop - 1, Reason: "depth"})
+		return
+	}
+	sources, targets := c.pointerInfo(o)
+	for i, target := range targets {
+		if target == 0 {
+			continue
+		}
+		childAddress := target
+		child, found := c.containing(target)
+		if found {
+			childAddress = child.GetAddress()
+		} else if c.renderOptions.HideUnknown {
+			c.hiddenUnknown++
+			continue
+		}
+		if childAddress == address {
+			continue
+		}
+		seen := c.visited[childAddress]
+		if !seen {
+			c.emit(TraversalEvent{Kind: NodeDiscovered, Record: c.memory[childAddress], Depth: hop})
+		}
+		c.emit(TraversalEvent{Kind: EdgeDiscovered, Owner: address, Target: childAddress, Dest: target})
+		cn := c.addNode(graph, childAddress, false, false)
+		edge, _ := graph.CreateEdge("", node, cn)
+		if target != childAddress {
+			edge.SetHeadLabel(fmt.Sprintf("0x%x\n(offset = %d)", target, target-childAddress))
+		}
+		if iface := c.heldAs(o, sources[i]); len(iface) > 0 {
+			edge.SetLabel("held as " + iface)
+		}
+		if !seen {
+			c.addChildrenAt(graph, cn, childAddress, depth-1, hop+1)
+		}
+	}
+}
+
 func (c *TreeClimber) fullStack(address uint64, separator string) string {
 	out := make([]string, 0)
-	framePtr := address
-	for framePtr != 0 {
-		frameRecord, found := c.memory[framePtr]
-		frame := frameRecord.(*heapdump.StackFrame)
-		if !found {
+	for _, frame := range c.framesFrom(address) {
+		out = append(out, fmt.Sprintf("[%d] %s", frame.Depth, heapdump.AbbreviateName(frame.Name)))
+	}
+	return strings.Join(out, separator)
+}
+
+// Returns the stack frame at the indicated address followed by its child
+// frames, in order. A corrupted dump can have child pointers that lead to
+// something other than a stack frame, or back up the stack; the frames
+// stop there.
+func (c *TreeClimber) framesFrom(address uint64) []*heapdump.StackFrame {
+	frames := make([]*heapdump.StackFrame, 0)
+	seen := make(map[uint64]bool)
+	for address != 0 && !seen[address] {
+		frame, isFrame := c.memory[address].(*heapdump.StackFrame)
+		if !isFrame {
 			break
 		}
-		out = append(out, fmt.Sprintf("[%d] %s", frame.Depth, frame.Name))
-		framePtr = frame.ChildPointer
+		seen[address] = true
+		frames = append(frames, frame)
+		address = frame.ChildPointer
 	}
-	return strings.Join(out, separator)
+	return frames
 }
 
-func (c *TreeClimber) printOwners(address uint64, depth int, prefix ...string) error {
+// Prints the record at the indicated address and, recursively, its owners.
+// If the record is an owner of another record (child), it is annotated with
+// where in the source its pointer to that child is declared.
+func (c *TreeClimber) printOwners(address uint64, child uint64, depth int, prefix ...string) error {
 	if depth == 0 {
 		return nil
 	}
@@ -225,6 +592,9 @@ func (c *TreeClimber) printOwners(address uint64, depth int, prefix ...string) e
 		// return fmt.Errorf("Loop: already visited address 0x%x", address)
 	}
 	c.visited[address] = true
+	if c.ownerTraversal.PerPathCycles {
+		defer delete(c.visited, address)
+	}
 	r, found := c.memory[address]
 	if !found {
 		return fmt.Errorf("Cound not find record for address 0x%x", address)
@@ -235,7 +605,7 @@ func (c *TreeClimber) printOwners(address uint64, depth int, prefix ...string) e
 	}
 	//fmt.Printf("%s%T @ 0x%x\n", indent, r, address)
 	s, _ := r.(fmt.Stringer)
-	fmt.Printf("%s%s\n", indent, s.String())
+	fmt.Fprintf(c.out, "%s%s%s\n", indent, s.String(), c.sourceOf(address, child))
 
 	o, found := c.owners[address]
 	if !found {
@@ -244,16 +614,16 @@ func (c *TreeClimber) printOwners(address uint64, depth int, prefix ...string) e
 	for _, owner := range o {
 		a, addressable := owner.(heapdump.Addressable)
 		if addressable {
-			err := c.printOwners(a.GetAddress(), depth-1, indent, "  ")
+			err := c.printOwners(a.GetAddress(), address, depth-1, indent, "  ")
 			if err != nil {
-				fmt.Printf("%s  %v\n", indent, err)
+				fmt.Fprintf(c.out, "%s  %v\n", indent, err)
 			}
 		}
 	}
 	return nil
 }
 
-func (c *TreeClimber) printAnchors(address uint64) error {
+func (c *TreeClimber) printAnchors(address uint64, depth int, search *anchorSearch) error {
 	if c.visited[address] {
 		return fmt.Errorf("Loop: already visited address 0x%x", address)
 	}
@@ -263,25 +633,30 @@ func (c *TreeClimber) printAnchors(address uint64) error {
 		return fmt.Errorf("Cound not find record for address 0x%x", address)
 	}
 
+	if c.isAnchor(address) {
+		if c.ownerTraversal.MaxPaths > 0 && search.paths == c.ownerTraversal.MaxPaths {
+			search.truncated++
+			return nil
+		}
+		search.paths++
+	}
+
+	for _, root := range c.strongRoots(address) {
+		fmt.Fprintf(c.out, "Runtime roots: %s: %s\n", root.Category(), root.String())
+	}
+
 	switch root := r.(type) {
-	case *heapdump.OtherRoot:
-		fmt.Println(root.String())
 	case *heapdump.StackFrame:
-		fmt.Println(root.String())
-		childPtr := root.ChildPointer
-		for childPtr != 0 {
-			childRecord, found := c.memory[childPtr]
-			child := childRecord.(*heapdump.StackFrame)
-			if !found {
-				return fmt.Errorf("Cound not find stack frame at address 0x%x", childPtr)
-			}
-			fmt.Printf("  %s\n", child.String())
-			childPtr = child.ChildPointer
+		fmt.Fprintln(c.out, root.String())
+		for _, child := range c.framesFrom(root.ChildPointer) {
+			fmt.Fprintf(c.out, "  %s\n", child.String())
 		}
 	case *heapdump.BssSegment:
-		fmt.Println(root.String())
+		fmt.Fprintln(c.out, root.String())
 	case *heapdump.DataSegment:
-		fmt.Println(root.String())
+		fmt.Fprintln(c.out, root.String())
+	case *heapdump.Global:
+		fmt.Fprintln(c.out, root.String())
 	}
 
 	o, found := c.owners[address]
@@ -290,14 +665,21 @@ func (c *TreeClimber) printAnchors(address uint64) error {
 	}
 	for _, owner := range o {
 		a, addressable := owner.(heapdump.Addressable)
-		if addressable {
-			c.printAnchors(a.GetAddress())
+		if !addressable || c.isWeakOwner(owner) || c.visited[a.GetAddress()] {
+			continue
+		}
+		if c.ownerTraversal.MaxDepth > 0 && depth == c.ownerTraversal.MaxDepth ||
+			c.ownerTraversal.MaxPaths > 0 && search.paths == c.ownerTraversal.MaxPaths {
+			search.truncated++
+			continue
 		}
+		c.printAnchors(a.GetAddress(), depth+1, search)
 	}
 	return nil
 }
 
-func (c *TreeClimber) build(reader *bufio.Reader) error {
+func (c *TreeClimber) build(reader heapdump.Reader) error {
+	endParse := heapdump.StartPhase("parse")
 	err := heapdump.ReadHeader(reader)
 	if err != nil {
 		return fmt.Errorf("Reading header: %w\n", err)
@@ -306,6 +688,26 @@ func (c *TreeClimber) build(reader *bufio.Reader) error {
 	c.memory = make(map[uint64]heapdump.Record)
 	c.owners = make(map[uint64][]heapdump.Record)
 	c.finalizers = make(map[uint64]heapdump.Record)
+	c.roots = make(map[uint64][]*heapdump.OtherRoot)
+	c.defers = make(map[uint64]*heapdump.DeferRecord)
+	c.panics = make(map[uint64]*heapdump.PanicRecord)
+	c.threads = make(map[uint64]*heapdump.OsThread)
+	c.profiles = make(map[uint64]heapdump.Record)
+	c.samples = make(map[uint64]uint64)
+	c.translate = identity
+	segments := make([]heapdump.Owner, 0)
+	descriptors := make([]*heapdump.TypeDescriptor, 0)
+	interner := heapdump.NewInterner(reader)
+
+	// Pointers can only be made sense of with the dump parameters, which
+	// the format doesn't require to come first, so records are collected
+	// in one pass and their pointers resolved in another. Runtime roots,
+	// finalizers, and samples are kept by the addresses they refer to,
+	// which are translated along with the rest.
+	owners := make([]heapdump.Record, 0)
+	finalizers := make([]heapdump.Record, 0)
+	roots := make([]*heapdump.OtherRoot, 0)
+	samples := make([]*heapdump.AllocStackTraceSample, 0)
 
 readloop:
 	for {
@@ -313,42 +715,184 @@ readloop:
 		if err != nil {
 			return err
 		}
+		interner.Intern(record)
 
 		switch r := record.(type) {
 		case *heapdump.Eof:
 			break readloop
+		case *heapdump.Object:
+			c.symbols.NameObject(r)
 		case *heapdump.DumpParams:
 			c.params = r
-		case *heapdump.QueuedFinalizer:
-			c.finalizers[r.ObjectAddress] = r
-		case *heapdump.RegisteredFinalizer:
-			c.finalizers[r.ObjectAddress] = r
+		case *heapdump.MemStats:
+			c.memStats = r
+		case *heapdump.TypeDescriptor:
+			descriptors = append(descriptors, r)
+		case *heapdump.QueuedFinalizer, *heapdump.RegisteredFinalizer:
+			finalizers = append(finalizers, r)
+		case *heapdump.Goroutine:
+			// Goroutine descriptors live in heap objects, which we'd
+			// otherwise clobber in the memory map.
+			c.goroutines = append(c.goroutines, r)
+			continue
+		case *heapdump.DeferRecord:
+			// Likewise for defer and panic records, which live in heap
+			// objects or stack frames, and thread descriptors.
+			c.defers[r.Address] = r
+			continue
+		case *heapdump.PanicRecord:
+			c.panics[r.Address] = r
+			continue
+		case *heapdump.OsThread:
+			c.threads[r.ThreadDescriptorAddress] = r
+			continue
+		case *heapdump.AllocFreeProfileRecord:
+			c.profiles[r.Id] = r
+			continue
+		case *heapdump.AllocStackTraceSample:
+			// Samples share their addresses with the objects sampled.
+			samples = append(samples, r)
+			continue
+		case *heapdump.OtherRoot:
+			// The "address" of an OtherRoot is the thing it points to,
+			// so we keep it out of the memory map to avoid clobbering
+			// the record that actually lives there.
+			roots = append(roots, r)
+			continue
 		}
 
-		a, isAddressable := record.(heapdump.Addressable)
-		if isAddressable {
-			c.memory[a.GetAddress()] = record
+		// Once the program's symbols are known, segments are split into
+		// the globals in them, which stand in for the segments from here on.
+		records := []heapdump.Record{record}
+		switch r := record.(type) {
+		case *heapdump.DataSegment:
+			segments = append(segments, r)
+			records = c.splitSegment(r)
+		case *heapdump.BssSegment:
+			segments = append(segments, r)
+			records = c.splitSegment(r)
 		}
 
-		// Dump parameters isn't *defined* to come before other
-		// records; but in practice, it does. If this changes,
-		// we may need to move the construction of owner pointers
-		// to after we read all of the records in the file.
-		o, isOwner := record.(heapdump.Owner)
-		if isOwner {
-			pointers := heapdump.GetPointers(o, c.params)
-			for i := 0; i < len(pointers); i++ {
-				if pointers[i] != 0 {
-					c.addOwner(pointers[i], record)
-				}
+		for _, record := range records {
+			a, isAddressable := record.(heapdump.Addressable)
+			if isAddressable {
+				c.memory[a.GetAddress()] = record
+			}
+			if _, isOwner := record.(heapdump.Owner); isOwner {
+				owners = append(owners, record)
 			}
 		}
+	}
 
+	endParse()
+	for address, name := range typeNames(descriptors) {
+		c.symbols.AddType(address, name)
+	}
+	if interner.Saved() > 0 {
+		heapdump.Logger().Debug("Shared repeated contents and names", "saved", units.Format(interner.Saved()))
+	}
+	defer heapdump.StartPhase("owner map")()
+
+	if c.params == nil {
+		return fmt.Errorf("The dump has no parameters, so its pointers can't be read")
+	}
+	for _, r := range owners {
+		if err := heapdump.CheckFields(r.(heapdump.Owner), c.params); err != nil {
+			return err
+		}
+	}
+	c.translate = translationFor(c.params)
+	for _, r := range finalizers {
+		switch f := r.(type) {
+		case *heapdump.QueuedFinalizer:
+			c.finalizers[c.translatePointer(f.ObjectAddress)] = r
+		case *heapdump.RegisteredFinalizer:
+			c.finalizers[c.translatePointer(f.ObjectAddress)] = r
+		}
+	}
+	// What defers and panics hold on to is translated like any other pointer
+	for _, d := range c.defers {
+		d.FuncVal = c.translatePointer(d.FuncVal)
+	}
+	for _, p := range c.panics {
+		p.PanicArgData = c.translatePointer(p.PanicArgData)
+	}
+	for _, r := range samples {
+		c.samples[c.translatePointer(r.Address)] = r.AllocFreeProfileRecordId
+	}
+	for _, r := range roots {
+		r.Address = c.translatePointer(r.Address)
+		c.roots[r.Address] = append(c.roots[r.Address], r)
+		heapdump.Logger().Debug("Runtime root",
+			"description", r.Description,
+			"category", r.Category(),
+			"target", fmt.Sprintf("0x%x", r.Address))
 	}
 
+	discarded := c.mapOwners(owners, segments)
+
+	// Records can be megabytes long, so rather than looking up every
+	// address in them, the pointers into them are found by searching
+	// sorted lists of everything that's pointed to.
+	c.ownedIndex = make([]uint64, 0, len(c.owners))
+	for address := range c.owners {
+		c.ownedIndex = append(c.ownedIndex, address)
+	}
+	sortAddresses(c.ownedIndex)
+	c.rootedIndex = make([]uint64, 0, len(c.roots))
+	for address := range c.roots {
+		c.rootedIndex = append(c.rootedIndex, address)
+	}
+	sortAddresses(c.rootedIndex)
+
+	heapdump.Logger().Debug("Built owner map",
+		"records", len(c.memory),
+		"targets", len(c.owners),
+		"runtime_roots", len(c.roots),
+		"finalizers", len(c.finalizers),
+		"discarded_pointers", discarded)
 	return nil
 }
 
+// Returns the globals in a segment, or just the segment if there are no
+// symbols to split it by.
+func (c *TreeClimber) splitSegment(segment heapdump.Owner) []heapdump.Record {
+	globals := heapdump.SplitSegment(segment, c.symbols)
+	if len(globals) == 0 {
+		return []heapdump.Record{segment.(heapdump.Record)}
+	}
+	records := make([]heapdump.Record, len(globals))
+	for i, g := range globals {
+		records[i] = g
+	}
+	return records
+}
+
+func inSegment(segments []heapdump.Owner, address uint64) bool {
+	for _, segment := range segments {
+		start := segment.GetAddress()
+		if address >= start && address < start+uint64(len(segment.GetContents())) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TreeClimber) inHeap(address uint64) bool {
+	if c.params == nil || c.params.HeapEnd == 0 {
+		return true
+	}
+	return address >= c.params.HeapStart && address < c.params.HeapEnd
+}
+
+// Returns the addresses in a sorted index that are at least start and less
+// than end.
+func between(index []uint64, start uint64, end uint64) []uint64 {
+	first := sort.Search(len(index), func(i int) bool { return index[i] >= start })
+	last := sort.Search(len(index), func(i int) bool { return index[i] >= end })
+	return index[first:max(first, last)]
+}
+
 func (c *TreeClimber) addOwner(address uint64, r heapdump.Record) {
 	_, found := c.owners[address]
 	if !found {
@@ -357,3 +901,17 @@ func (c *TreeClimber) addOwner(address uint64, r heapdump.Record) {
 
 	c.owners[address] = append(c.owners[address], r)
 }
+
+// Names the global variable at or containing the indicated address, with
+// how far into it the address is, or returns the indicated default if
+// there's no such global.
+func (c *TreeClimber) globalName(address uint64, unknown string) string {
+	r, found := c.symbols.Resolve(address)
+	if !found {
+		return unknown
+	}
+	if r.Offset == 0 {
+		return heapdump.AbbreviateName(r.Name)
+	}
+	return fmt.Sprintf("%s+0x%x", heapdump.AbbreviateName(r.Name), r.Offset)
+}
@@ -0,0 +1,217 @@
+package treeclimber
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+	"github.com/goccy/go-graphviz"
+	"github.com/goccy/go-graphviz/cgraph"
+)
+
+// A graph in which all of the records of each type (see ownerType) are
+// collapsed into a single node, and all of the pointers between two types
+// into a single edge, weighted by how many pointers it stands for.
+type typeGraph struct {
+	nodes map[string]*typeNode
+	edges map[typeEdge]int
+}
+
+type typeNode struct {
+	records map[uint64]bool
+	bytes   uint64 // of the objects among those records
+}
+
+type typeEdge struct {
+	from string
+	to   string
+}
+
+// Node names for runtime roots start with this, so that they can't be
+// confused with types.
+const rootNodePrefix = "runtime-roots/"
+
+func (g *typeGraph) addRecord(c *TreeClimber, address uint64) string {
+	r := c.memory[address]
+	name := ownerType(r)
+	n, found := g.nodes[name]
+	if !found {
+		n = &typeNode{records: make(map[uint64]bool)}
+		g.nodes[name] = n
+	}
+	if !n.records[address] {
+		n.records[address] = true
+		if o, isObject := r.(*heapdump.Object); isObject {
+			n.bytes += uint64(len(o.Contents))
+		}
+	}
+	return name
+}
+
+// Renders the owners of the record at the indicated address, all the way
+// back to their anchors, collapsed by type; if the address is zero, the
+// whole heap is rendered that way. This gives a picture of how memory is
+// structured when there are far too many objects to draw one by one. Edges
+// standing for fewer pointers than the MinEdgeWeight render option are left
+// out, along with any types that are left without edges.
+func (c *TreeClimber) WriteTypeGraph(address uint64, w io.Writer, format graphviz.Format) error {
+	if address == 0 {
+		return c.writeTypeGraph(nil, w, format)
+	}
+	if _, found := c.memory[address]; !found {
+		return fmt.Errorf("Could not find record for address 0x%x", address)
+	}
+	return c.writeTypeGraph([]uint64{address}, w, format)
+}
+
+// Renders, collapsed by type as WriteTypeGraph does, every path from an
+// anchor to any object whose name matches the indicated regular
+// expression. This shows all of the ways that objects of a type are
+// retained in a single picture.
+func (c *TreeClimber) WriteTypeGraphMatching(pattern string, w io.Writer, format graphviz.Format) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+	addresses := make([]uint64, 0)
+	for address, r := range c.memory {
+		o, isObject := r.(*heapdump.Object)
+		if isObject && re.MatchString(o.Name) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("No objects found with names matching '%s'", pattern)
+	}
+	heapdump.Logger().Info("Graphing owners", "objects", len(addresses))
+	return c.writeTypeGraph(addresses, w, format)
+}
+
+// Renders the owners of the records at the indicated addresses collapsed by
+// type, with the types of those records highlighted, or the whole heap if
+// there are no addresses.
+func (c *TreeClimber) writeTypeGraph(addresses []uint64, w io.Writer, format graphviz.Format) error {
+	g := &typeGraph{
+		nodes: make(map[string]*typeNode),
+		edges: make(map[typeEdge]int),
+	}
+	spotlight := make(map[string]bool)
+	if len(addresses) > 0 {
+		walk := c.walkOwnersOf(addresses, -1)
+		for _, address := range addresses {
+			spotlight[g.addRecord(c, address)] = true
+		}
+		for _, e := range walk.edges {
+			g.edges[typeEdge{g.addRecord(c, e.owner), g.addRecord(c, e.target)}]++
+		}
+		for _, r := range walk.roots {
+			g.edges[typeEdge{rootNodePrefix + r.root.Category(), g.addRecord(c, r.target)}]++
+		}
+	} else {
+		for _, e := range c.edges() {
+			g.edges[typeEdge{g.addRecord(c, e.from), g.addRecord(c, e.to)}]++
+		}
+		for target, roots := range c.roots {
+			o, found := c.containing(target)
+			if !found {
+				continue
+			}
+			for _, root := range roots {
+				g.edges[typeEdge{rootNodePrefix + root.Category(), g.addRecord(c, o.GetAddress())}]++
+			}
+		}
+	}
+
+	return c.render(w, format, func(graph *cgraph.Graph) {
+		edges := make([]typeEdge, 0, len(g.edges))
+		hidden := 0
+		for e, weight := range g.edges {
+			if weight < c.renderOptions.MinEdgeWeight {
+				hidden++
+				continue
+			}
+			edges = append(edges, e)
+		}
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].from != edges[j].from {
+				return edges[i].from < edges[j].from
+			}
+			return edges[i].to < edges[j].to
+		})
+		if hidden > 0 {
+			heapdump.Logger().Info("Left out light edges", "count", hidden, "min-weight", c.renderOptions.MinEdgeWeight)
+		}
+
+		names := make([]string, 0, len(spotlight))
+		for name := range spotlight {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.addTypeNode(graph, g, name, true)
+		}
+		for _, e := range edges {
+			var from *cgraph.Node
+			if category, isRoot := strings.CutPrefix(e.from, rootNodePrefix); isRoot {
+				from = c.addRuntimeRootNode(graph, category)
+			} else {
+				from = c.addTypeNode(graph, g, e.from, false)
+			}
+			to := c.addTypeNode(graph, g, e.to, false)
+			edge, _ := graph.CreateEdge("", from, to)
+			weight := g.edges[e]
+			if weight > 1 {
+				edge.SetLabel(fmt.Sprintf("%d", weight))
+			}
+			edge.SetPenWidth(1 + math.Log10(float64(weight)))
+		}
+	})
+}
+
+func (c *TreeClimber) addTypeNode(graph *cgraph.Graph, g *typeGraph, name string, spotlight bool) *cgraph.Node {
+	id := "type/" + name
+	node, _ := graph.Node(id)
+	if node != nil {
+		return node
+	}
+	node, _ = graph.CreateNode(id)
+	n := g.nodes[name]
+	var label string
+	if n.bytes > 0 {
+		label = fmt.Sprintf("%s\n%d objects (%s)", name, len(n.records), units.Format(n.bytes))
+		node.SetShape(cgraph.EllipseShape)
+	} else {
+		label = fmt.Sprintf("%s\n%d records", name, len(n.records))
+		node.SetShape(cgraph.BoxShape)
+	}
+	if tag, tagged := c.typeTag(n); tagged {
+		label += "\n[" + tag + "]"
+		node.SetStyle(cgraph.FilledNodeStyle)
+		node.SetFillColor(c.tagColor(tag))
+	}
+	node.SetLabel(label)
+	if spotlight {
+		node.SetStyle(cgraph.FilledNodeStyle)
+		node.SetFillColor("yellow")
+	}
+	return node
+}
+
+// Returns the tag shared by every record of a type, if they all have the
+// same one.
+func (c *TreeClimber) typeTag(n *typeNode) (string, bool) {
+	shared := ""
+	for address := range n.records {
+		tag, tagged := c.tagOf(address)
+		if !tagged || (len(shared) > 0 && tag != shared) {
+			return "", false
+		}
+		shared = tag
+	}
+	return shared, len(shared) > 0
+}
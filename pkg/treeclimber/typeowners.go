@@ -0,0 +1,189 @@
+package treeclimber
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+	"github.com/adamroach/heapspurs/pkg/units"
+)
+
+type ownerGroup struct {
+	name    string
+	owners  int    // distinct owners in the group
+	objects int    // matching objects they own
+	bytes   uint64 // used by those objects
+}
+
+// Summarizes what owns the objects whose names match a regular expression:
+// their direct owners, grouped by type (or by function, for stack frames,
+// and by variable, for globals), with how many of the objects each group
+// owns and how much memory those use. An object with owners in several
+// groups is counted in each.
+//
+// If the goroutine ID isn't zero, only retention paths rooted in that
+// goroutine's stack are followed: objects that can't be reached from its
+// frames are left out, as are owners that can't. Where many goroutines
+// legitimately hold similar objects (one set per request, say), this
+// picks out what one of them is holding on to. Objects that can't be
+// reached from any other anchor are counted as held only by the
+// goroutine; those are what would be freed if it exited.
+func (c *TreeClimber) PrintTypeOwners(pattern string, goroutine uint64) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+	}
+	g := c.retentionGraph()
+	defer heapdump.StartPhase("traversal")()
+
+	// With a goroutine, what its frames reach, and what everything else does
+	var reachable, elsewhere []bool
+	if goroutine != 0 {
+		_, frames, err := c.goroutineStack(goroutine)
+		if err != nil {
+			return err
+		}
+		isFrame := make(map[uint64]bool)
+		for _, f := range frames {
+			isFrame[f.Address] = true
+		}
+		starts := make([]int, 0, len(frames))
+		others := make([]int, 0, g.anchors)
+		for _, anchor := range g.children[0][:g.anchors] {
+			if isFrame[g.addresses[anchor]] && !g.isObject[anchor] {
+				starts = append(starts, anchor)
+			} else {
+				others = append(others, anchor)
+			}
+		}
+		reachable = g.reach(starts)
+		elsewhere = g.reach(others)
+	}
+
+	groups := make(map[string]*ownerGroup)
+	seen := make(map[string]map[int]bool) // owners already counted in each group
+	var count, only int
+	var bytes, onlyBytes uint64
+	predecessors := make([][]int, len(g.addresses))
+	for from, children := range g.children {
+		for _, to := range children {
+			predecessors[to] = append(predecessors[to], from)
+		}
+	}
+	// What no anchor can reach hangs off the root too, but isn't owned by it
+	garbage := make(map[int]bool)
+	for _, node := range g.children[0][g.anchors:] {
+		garbage[node] = true
+	}
+	for node := 1; node < len(g.addresses); node++ {
+		if !g.isObject[node] || (reachable != nil && !reachable[node]) {
+			continue
+		}
+		o := c.memory[g.addresses[node]].(*heapdump.Object)
+		if !re.MatchString(o.Name) {
+			continue
+		}
+		count++
+		bytes += g.sizes[node]
+		if elsewhere != nil && !elsewhere[node] {
+			only++
+			onlyBytes += g.sizes[node]
+		}
+		counted := make(map[string]bool)
+		for _, p := range predecessors[node] {
+			if (reachable != nil && !reachable[p]) || (p == 0 && garbage[node]) {
+				continue
+			}
+			name := c.ownerGroupName(g, p)
+			group, found := groups[name]
+			if !found {
+				group = &ownerGroup{name: name}
+				groups[name] = group
+				seen[name] = make(map[int]bool)
+			}
+			if !seen[name][p] {
+				seen[name][p] = true
+				group.owners++
+			}
+			if !counted[name] {
+				counted[name] = true
+				group.objects++
+				group.bytes += g.sizes[node]
+			}
+		}
+	}
+	if count == 0 {
+		if goroutine != 0 {
+			return fmt.Errorf("No objects matching '%s' are reachable from goroutine %d", pattern, goroutine)
+		}
+		return fmt.Errorf("No objects matching '%s'", pattern)
+	}
+
+	list := make([]*ownerGroup, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].name < list[j].name
+	})
+
+	if goroutine != 0 {
+		fmt.Fprintf(c.out, "%d objects matching '%s' are reachable from goroutine %d, using %s; %d of them (%s) from nowhere else\n",
+			count, pattern, goroutine, units.Format(bytes), only, units.Format(onlyBytes))
+	} else {
+		fmt.Fprintf(c.out, "%d objects matching '%s' use %s\n", count, pattern, units.Format(bytes))
+	}
+	fmt.Fprintf(c.out, "Owned by:\n")
+	for _, group := range list {
+		fmt.Fprintf(c.out, "  %s (%d owners): %d objects, %s\n", group.name, group.owners, group.objects, units.Format(group.bytes))
+	}
+	return nil
+}
+
+// Names the group that an owner in the retention graph is summarized in.
+func (c *TreeClimber) ownerGroupName(g *retentionGraph, node int) string {
+	if node == 0 {
+		return "runtime roots"
+	}
+	switch r := c.memory[g.addresses[node]].(type) {
+	case *heapdump.Object:
+		if g.isObject[node] {
+			return r.GetName()
+		}
+	case *heapdump.StackFrame:
+		return "StackFrame(" + heapdump.AbbreviateName(r.Name) + ")"
+	}
+	// Anything else is a global pointer slot
+	r, found := c.symbols.Resolve(g.addresses[node])
+	if !found {
+		return "Global"
+	}
+	return "Global " + heapdump.AbbreviateName(r.Name)
+}
+
+// Returns which nodes can be reached from the indicated ones.
+func (g *retentionGraph) reach(starts []int) []bool {
+	reached := make([]bool, len(g.addresses))
+	queue := make([]int, 0, len(starts))
+	for _, node := range starts {
+		if !reached[node] {
+			reached[node] = true
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, child := range g.children[node] {
+			if !reached[child] {
+				reached[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return reached
+}
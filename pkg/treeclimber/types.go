@@ -0,0 +1,36 @@
+package treeclimber
+
+import (
+	"fmt"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Names the types described by the dump's type descriptors, by address.
+// Programs built with shared libraries or plugins can have several
+// descriptors for the same type, which get the same name; so do those whose
+// names only differ by an alias (see heapdump.CanonicalTypeName). If
+// descriptors with the same name describe types of different sizes, those
+// really are different types, so their names include their sizes.
+func typeNames(descriptors []*heapdump.TypeDescriptor) map[uint64]string {
+	sizes := make(map[string]map[uint64]bool)
+	for _, t := range descriptors {
+		name := heapdump.CanonicalTypeName(t.Name)
+		if sizes[name] == nil {
+			sizes[name] = make(map[uint64]bool)
+		}
+		sizes[name][t.TypeSize] = true
+	}
+	names := make(map[uint64]string, len(descriptors))
+	for _, t := range descriptors {
+		name := heapdump.CanonicalTypeName(t.Name)
+		if len(sizes[name]) > 1 {
+			name = fmt.Sprintf("%s (%d bytes)", name, t.TypeSize)
+		}
+		names[t.Address] = name
+	}
+	if len(names) > len(sizes) {
+		heapdump.Logger().Debug("Merged duplicate type descriptors", "descriptors", len(descriptors), "types", len(sizes))
+	}
+	return names
+}
@@ -0,0 +1,254 @@
+package treeclimber
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// The number of owner types listed in the summary of unknown targets
+const unknownTop = 20
+
+// Counts of pointers to addresses that aren't in any record of the dump
+type unknownTargets struct {
+	label     string
+	inHeap    int // pointers into the heap, but not into any object
+	offHeap   int // pointers outside of the heap entirely
+	addresses map[uint64]bool
+}
+
+func (u *unknownTargets) add(target uint64, inHeap bool) {
+	if inHeap {
+		u.inHeap++
+	} else {
+		u.offHeap++
+	}
+	u.addresses[target] = true
+}
+
+// Prints a summary of the pointers whose targets aren't in any record of
+// the dump; these are what show up as "???" in graphs. A target inside the
+// heap is most likely a freed object that something still points to (which
+// is harmless for, e.g., the unused part of a slice's backing array). One
+// outside of the heap is off-heap memory, such as an mmap region, memory
+// allocated by C code, or one of the runtime's own structures.
+func (c *TreeClimber) PrintUnknownTargets() error {
+	defer heapdump.StartPhase("traversal")()
+	total := &unknownTargets{addresses: make(map[uint64]bool)}
+	owners := make(map[string]*unknownTargets)
+	for _, address := range c.sortedOwners() {
+		r := c.memory[address]
+		for _, target := range c.pointers(r.(heapdump.Owner)) {
+			if target == 0 {
+				continue
+			}
+			if _, found := c.containing(target); found {
+				continue
+			}
+			inHeap := target >= c.params.HeapStart && target < c.params.HeapEnd
+			label := ownerType(r)
+			u, found := owners[label]
+			if !found {
+				u = &unknownTargets{label: label, addresses: make(map[uint64]bool)}
+				owners[label] = u
+			}
+			u.add(target, inHeap)
+			total.add(target, inHeap)
+		}
+	}
+	if len(total.addresses) == 0 {
+		fmt.Fprintf(c.out, "No pointers to unknown targets\n")
+		return nil
+	}
+
+	fmt.Fprintf(c.out, "%d pointers to %d unknown targets\n", total.inHeap+total.offHeap, len(total.addresses))
+	fmt.Fprintf(c.out, "  %d pointers into the heap, but not into any object (likely freed objects)\n", total.inHeap)
+	fmt.Fprintf(c.out, "  %d pointers outside of the heap (off-heap memory, such as mmap regions or runtime structures)\n", total.offHeap)
+
+	list := make([]*unknownTargets, 0, len(owners))
+	for _, u := range owners {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].inHeap+list[i].offHeap, list[j].inHeap+list[j].offHeap
+		if a != b {
+			return a > b
+		}
+		return list[i].label < list[j].label
+	})
+	fmt.Fprintf(c.out, "By owner type:\n")
+	for i, u := range list {
+		if i == unknownTop {
+			fmt.Fprintf(c.out, "  ... and %d more owner types\n", len(list)-unknownTop)
+			break
+		}
+		fmt.Fprintf(c.out, "  %s: %d pointers to %d targets (%d in the heap, %d outside)\n",
+			u.label, u.inHeap+u.offHeap, len(u.addresses), u.inHeap, u.offHeap)
+	}
+	return nil
+}
+
+// Describes the kind of record that owns a pointer, for grouping owners
+// together: objects by name, stack frames by function, and globals by
+// segment.
+func ownerType(r heapdump.Record) string {
+	switch o := r.(type) {
+	case *heapdump.Object:
+		return o.GetName()
+	case *heapdump.StackFrame:
+		return "StackFrame " + heapdump.AbbreviateName(o.Name)
+	case *heapdump.BssSegment:
+		return "BssSegment"
+	case *heapdump.DataSegment:
+		return "DataSegment"
+	case *heapdump.Global:
+		return ownerType(o.Segment.(heapdump.Record))
+	}
+	return fmt.Sprintf("%T", r)
+}
+
+// The number of fields listed for each owner type in the off-heap report
+const offHeapFields = 5
+
+// Pointers from one field of a type of owner to memory outside of the dump
+type offHeapField struct {
+	label   string
+	count   int
+	targets map[uint64]bool
+	low     uint64
+	high    uint64
+}
+
+type offHeapOwner struct {
+	label  string
+	count  int
+	fields map[string]*offHeapField
+}
+
+// Prints the fields whose pointers lead outside of both the heap and the
+// data and BSS segments, grouped by the type of owner that holds them.
+// Unlike pointers to freed objects, these can't be explained by the Go
+// heap: they're most likely to memory allocated by C code through cgo, to
+// mmap regions, or to corruption. If ReadProgram has been called, pointers
+// into the program's code and read-only data are left out; otherwise,
+// they're included, and will usually account for most of the report.
+//
+// Fields of objects and stack frames are identified by their offset, and
+// by their declaration if it's known (see GetSourceLocation); those of the
+// segments are identified by the global that holds them, if the program's
+// symbols have been read.
+func (c *TreeClimber) PrintOffHeapPointers() error {
+	if c.params == nil || c.params.HeapEnd == 0 {
+		return fmt.Errorf("The dump doesn't record where the heap is")
+	}
+	defer heapdump.StartPhase("traversal")()
+	owners := make(map[string]*offHeapOwner)
+	total := 0
+	program := 0
+	for _, address := range c.sortedOwners() {
+		r := c.memory[address]
+		sources, targets := c.pointerInfo(r.(heapdump.Owner))
+		for i, target := range targets {
+			if target == 0 || (target >= c.params.HeapStart && target < c.params.HeapEnd) {
+				continue
+			}
+			if _, found := c.containing(target); found {
+				continue
+			}
+			if c.symbols.InProgram(target) {
+				program++
+				continue
+			}
+			total++
+
+			label := ownerType(r)
+			o, found := owners[label]
+			if !found {
+				o = &offHeapOwner{label: label, fields: make(map[string]*offHeapField)}
+				owners[label] = o
+			}
+			o.count++
+			field := c.fieldLabel(r, sources[i])
+			f, found := o.fields[field]
+			if !found {
+				f = &offHeapField{label: field, targets: make(map[uint64]bool), low: target, high: target}
+				o.fields[field] = f
+			}
+			f.count++
+			f.targets[target] = true
+			f.low = min(f.low, target)
+			f.high = max(f.high, target)
+		}
+	}
+
+	if program > 0 {
+		fmt.Fprintf(c.out, "%d pointers into the program's code and read-only data aren't included\n", program)
+	} else {
+		fmt.Fprintf(c.out, "Without --program, pointers into the program's code and read-only data are included\n")
+	}
+	if total == 0 {
+		fmt.Fprintf(c.out, "No pointers outside of the heap, data, and BSS\n")
+		return nil
+	}
+	fmt.Fprintf(c.out, "%d pointers outside of the heap, data, and BSS\n", total)
+
+	list := make([]*offHeapOwner, 0, len(owners))
+	for _, o := range owners {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].label < list[j].label
+	})
+	for i, o := range list {
+		if i == unknownTop {
+			fmt.Fprintf(c.out, "... and %d more owner types\n", len(list)-unknownTop)
+			break
+		}
+		fmt.Fprintf(c.out, "%s: %d pointers from %d fields\n", o.label, o.count, len(o.fields))
+		fields := make([]*offHeapField, 0, len(o.fields))
+		for _, f := range o.fields {
+			fields = append(fields, f)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			if fields[i].count != fields[j].count {
+				return fields[i].count > fields[j].count
+			}
+			return fields[i].label < fields[j].label
+		})
+		for j, f := range fields {
+			if j == offHeapFields {
+				fmt.Fprintf(c.out, "  ... and %d more fields\n", len(fields)-offHeapFields)
+				break
+			}
+			fmt.Fprintf(c.out, "  %s: %d pointers to %d targets", f.label, f.count, len(f.targets))
+			if f.low == f.high {
+				fmt.Fprintf(c.out, " at 0x%x\n", f.low)
+			} else {
+				fmt.Fprintf(c.out, " in 0x%x-0x%x\n", f.low, f.high)
+			}
+		}
+	}
+	return nil
+}
+
+// Describes the field of a record that holds the pointer at the indicated
+// address, in terms that are the same for every record of its type.
+func (c *TreeClimber) fieldLabel(r heapdump.Record, pointer uint64) string {
+	o := r.(heapdump.Owner)
+	offset := pointer - o.GetAddress()
+	switch r.(type) {
+	case *heapdump.BssSegment, *heapdump.DataSegment, *heapdump.Global:
+		if name := c.globalName(pointer, ""); len(name) > 0 {
+			return name
+		}
+	}
+	label := fmt.Sprintf("+0x%x", offset)
+	if location, found := c.symbols.GetSourceLocation(r, offset); found {
+		label += " [" + location + "]"
+	}
+	return label
+}
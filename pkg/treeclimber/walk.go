@@ -0,0 +1,196 @@
+package treeclimber
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// A pointer from an owner into an object found while walking owners
+type ownerEdge struct {
+	owner  uint64 // address of the owning record
+	target uint64 // address of the object being pointed into
+	dest   uint64 // address actually pointed to, which may be inside the target
+}
+
+// A runtime root pointing into an object found while walking owners
+type rootEdge struct {
+	root   *heapdump.OtherRoot
+	target uint64
+}
+
+// The result of walking back through the owners of a record
+type ownerWalk struct {
+	nodes    []uint64        // every record reached, sorted by address
+	expanded map[uint64]bool // records whose owners were looked for
+	owned    map[uint64]bool // records for which at least one owner was found
+	edges    []ownerEdge
+	roots    []rootEdge
+}
+
+// The owners found for a single record
+type ownerScan struct {
+	expanded bool
+	edges    []ownerEdge
+	roots    []rootEdge
+}
+
+// Finds everything that owns the record at the indicated address, and
+// everything that owns those, and so on, to the indicated depth; a negative
+// depth means following them all the way back to their anchors. Each level
+// of the walk is spread across a pool of workers, since objects with huge
+// numbers of transitive owners can otherwise take a very long time. The
+// results are sorted so that they don't depend on which worker got where
+// first.
+func (c *TreeClimber) walkOwners(address uint64, depth int) *ownerWalk {
+	return c.walkOwnersOf([]uint64{address}, depth)
+}
+
+// Walks back through the owners of several records at once, as walkOwners
+// does for one. The records themselves are expanded even if pruned.
+func (c *TreeClimber) walkOwnersOf(addresses []uint64, depth int) *ownerWalk {
+	defer heapdump.StartPhase("traversal")()
+	walk := &ownerWalk{
+		expanded: make(map[uint64]bool),
+		owned:    make(map[uint64]bool),
+	}
+	var visited sync.Map
+	start := make(map[uint64]bool)
+	for _, address := range addresses {
+		visited.Store(address, true)
+		start[address] = true
+	}
+
+	frontier := append([]uint64{}, addresses...)
+	sortAddresses(frontier)
+	for _, address := range frontier {
+		c.emit(TraversalEvent{Kind: NodeDiscovered, Record: c.memory[address]})
+	}
+	for level := 0; len(frontier) > 0 && (depth < 0 || level < depth); level++ {
+		scans := make([]ownerScan, len(frontier))
+		next := make([]uint64, 0)
+		var mutex sync.Mutex
+		parallelize(len(frontier), func(i int) {
+			scans[i] = c.scanOwners(frontier[i], start[frontier[i]])
+			for _, e := range scans[i].edges {
+				if _, seen := visited.LoadOrStore(e.owner, true); !seen {
+					mutex.Lock()
+					next = append(next, e.owner)
+					mutex.Unlock()
+				}
+			}
+		})
+
+		discovered := make(map[uint64]bool, len(next))
+		for _, owner := range next {
+			discovered[owner] = true
+		}
+		for i, scan := range scans {
+			if !scan.expanded {
+				if _, isObject := c.memory[frontier[i]].(*heapdump.Object); isObject {
+					c.emit(TraversalEvent{Kind: Truncated, Record: c.memory[frontier[i]], Depth: level, Reason: "pruned"})
+				}
+				continue
+			}
+			walk.expanded[frontier[i]] = true
+			if len(scan.edges) > 0 || len(scan.roots) > 0 {
+				walk.owned[frontier[i]] = true
+			}
+			walk.edges = append(walk.edges, scan.edges...)
+			walk.roots = append(walk.roots, scan.roots...)
+			c.emitScan(scan, level, discovered)
+		}
+
+		sortAddresses(next)
+		frontier = next
+	}
+	for _, address := range frontier {
+		c.emit(TraversalEvent{Kind: Truncated, Record: c.memory[address], Depth: depth, Reason: "depth"})
+	}
+
+	visited.Range(func(key, _ interface{}) bool {
+		walk.nodes = append(walk.nodes, key.(uint64))
+		return true
+	})
+	sortAddresses(walk.nodes)
+	return walk
+}
+
+// Reports what a scan of the owners of a record at the indicated level of a
+// walk found, discovering the owners that the level found first as their
+// first edges are reported.
+func (c *TreeClimber) emitScan(scan ownerScan, level int, discovered map[uint64]bool) {
+	if c.traversalFunc == nil {
+		return
+	}
+	for _, r := range scan.roots {
+		c.emit(TraversalEvent{Kind: EdgeDiscovered, Root: r.root, Target: r.target, Dest: r.root.Address})
+	}
+	for _, e := range scan.edges {
+		if discovered[e.owner] {
+			delete(discovered, e.owner)
+			c.emit(TraversalEvent{Kind: NodeDiscovered, Record: c.memory[e.owner], Depth: level + 1})
+		}
+		c.emit(TraversalEvent{Kind: EdgeDiscovered, Owner: e.owner, Target: e.target, Dest: e.dest})
+	}
+}
+
+// Looks for the owners of a single object. Because owners can point to
+// subfields within an object, we need to scan for references anywhere
+// inside the object. This only reads from the climber, so it is safe to
+// call from several goroutines at once.
+func (c *TreeClimber) scanOwners(address uint64, spotlight bool) ownerScan {
+	scan := ownerScan{}
+	r, isObject := c.memory[address].(*heapdump.Object)
+	if !isObject || (!spotlight && c.isPruned(r.GetFullName())) {
+		return scan
+	}
+	scan.expanded = true
+
+	end := uint64(len(r.Contents)) + address
+	for _, dest := range between(c.rootedIndex, address, end) {
+		for _, root := range c.roots[dest] {
+			scan.roots = append(scan.roots, rootEdge{root, address})
+		}
+	}
+	for _, dest := range between(c.ownedIndex, address, end) {
+		for _, owner := range c.owners[dest] {
+			a, isOwner := owner.(heapdump.Owner)
+			if isOwner {
+				scan.edges = append(scan.edges, ownerEdge{a.GetAddress(), address, dest})
+			}
+		}
+	}
+	return scan
+}
+
+// Calls work for each index from 0 to n-1, using no more goroutines than
+// there are processors to run them on.
+func parallelize(n int, work func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				work(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+func sortAddresses(addresses []uint64) {
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+}
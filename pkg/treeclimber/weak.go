@@ -0,0 +1,65 @@
+package treeclimber
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/adamroach/heapspurs/pkg/heapdump"
+)
+
+// Sets which references are treated as weak, meaning that they don't keep
+// what they point to alive. Analyses based on what retains what (top
+// owners, retainers, retained sets, and so on) and searches for anchors and
+// paths ignore weak references, so that they don't conclude that something
+// is retained by, for example, a cache that would let go of it under memory
+// pressure.
+//
+// If finalizers is true, runtime roots from the finalizer queue are weak.
+// Objects whose names match any of the regular expressions only hold weak
+// references.
+func (c *TreeClimber) SetWeakReferences(finalizers bool, patterns []string) error {
+	c.weakFinalizers = finalizers
+	c.exactTotals = nil
+	c.breakFreed = nil
+	c.weak = make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Bad regex '%s': %w", pattern, err)
+		}
+		c.weak = append(c.weak, re)
+	}
+	return nil
+}
+
+// Reports whether the pointers held by the record are weak.
+func (c *TreeClimber) isWeakOwner(r heapdump.Record) bool {
+	o, isObject := r.(*heapdump.Object)
+	if !isObject || len(o.Name) == 0 {
+		return false
+	}
+	for _, re := range c.weak {
+		if re.MatchString(o.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the runtime roots that point to the indicated address and keep it
+// alive.
+func (c *TreeClimber) strongRoots(address uint64) []*heapdump.OtherRoot {
+	if !c.weakFinalizers {
+		return c.roots[address]
+	}
+	roots := make([]*heapdump.OtherRoot, 0, len(c.roots[address]))
+	for _, root := range c.roots[address] {
+		if root.Category() != "Finalizer queue" {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
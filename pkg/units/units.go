@@ -0,0 +1,74 @@
+// Package units formats and parses sizes in bytes, so that every size that
+// heapspurs prints, and every size it's given, uses the same units.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var si bool
+
+// Sets whether Format uses powers of 1000 (kB, MB, GB, TB), rather than
+// powers of 1024 (kiB, MiB, GiB, TiB), the default.
+func SetSI(enabled bool) {
+	si = enabled
+}
+
+var binaryUnits = []string{"kiB", "MiB", "GiB", "TiB"}
+var siUnits = []string{"kB", "MB", "GB", "TB"}
+
+// Formats a size in bytes in the largest unit that leaves at least two of
+// it (e.g., "3.50 MiB"), in powers of 1024 unless SetSI says otherwise.
+func Format(x uint64) string {
+	base, names := uint64(1024), binaryUnits
+	if si {
+		base, names = 1000, siUnits
+	}
+	if x < 2*base {
+		return fmt.Sprintf("%d B", x)
+	}
+	unit := base
+	for i, name := range names {
+		if i == len(names)-1 || x < 2*unit*base {
+			if i == 0 {
+				return fmt.Sprintf("%.f %s", float64(x)/float64(unit), name)
+			}
+			return fmt.Sprintf("%.2f %s", float64(x)/float64(unit), name)
+		}
+		unit *= base
+	}
+	return "" // Not reached
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	// Longer suffixes first, so that "KiB" isn't taken for "B"
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"b", 1},
+}
+
+// Parses a size in bytes, which may have a unit suffix (e.g., "64MiB" or
+// "1.5 GB"). Suffixes aren't case-sensitive; KB, MB, GB, and TB are powers
+// of 1000, and KiB, MiB, GiB, and TiB are powers of 1024, whatever SetSI
+// says.
+func ParseSize(size string) (uint64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	multiplier := uint64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Bad size '%s'", size)
+	}
+	return uint64(value * float64(multiplier)), nil
+}
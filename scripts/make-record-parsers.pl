@@ -44,28 +44,21 @@ while (<DATA>) {
     elsif ($type eq 'string') {
       $loads .= "\t${name}Len, err := binary.ReadUvarint(reader)\n";
       $loads .= "\tif err != nil {\n\t\treturn\n\t}\n";
-      $loads .= "\t${name}Buf := make([]byte, ${name}Len)\n";
-      $loads .= "\t_, err = io.ReadFull(reader, ${name}Buf)\n";
+      $loads .= "\tr.$name, err = readString(reader, ${name}Len)\n";
       $loads .= "\tif err != nil {\n\t\treturn\n\t}\n";
-      $loads .= "\tr.$name = string(${name}Buf)\n";
     }
     elsif ($type eq 'bytes') {
       $loads .= "\t${name}Len, err := binary.ReadUvarint(reader)\n";
       $loads .= "\tif err != nil {\n\t\treturn\n\t}\n";
-      $loads .= "\tr.${name} = make([]byte, ${name}Len)\n";
-      $loads .= "\t_, err = io.ReadFull(reader, r.${name})\n";
+      $loads .= "\tr.${name}, err = readBytes(reader, ${name}Len)\n";
       $loads .= "\tif err != nil {\n\t\treturn\n\t}\n";
     }
     elsif ($type eq 'fieldlist') {
-      $loads .= "\tr.${name} = make([]uint64, 0)\n";
-      $loads .= "\tfor {\n";
-      $loads .= "\t\tkind, err := binary.ReadUvarint(reader)\n";
-      $loads .= "\t\tif err != nil {\n\t\t\treturn\n\t\t}\n";
-      $loads .= "\t\tif kind == 0 {\n\t\t\tbreak\n\t\t}\n";
-      $loads .= "\t\tvalue, err := binary.ReadUvarint(reader)\n";
-      $loads .= "\t\tif kind == 0 {\n\t\t\tbreak\n\t\t}\n";
-      $loads .= "\t\tr.${name} = append(r.${name}, value)\n";
-      $loads .= "\t}\n";
+      # Field lists always follow the contents they describe
+      $var = lcfirst($name);
+      $loads .= "\t$var, err := readFieldList(reader, uint64(len(r.Contents)))\n";
+      $loads .= "\tif err != nil {\n\t\treturn\n\t}\n";
+      $loads .= "\tr.$name = fieldOffsets($var)\n";
     }
     else {
       die $type;
@@ -88,7 +81,7 @@ sub printclass {
   print "$fields";
   print "}\n\n";
 
-  print "func (r *$class) Read(reader io.Reader) (err error) {\n";
+  print "func (r *$class) Read(reader Reader) (err error) {\n";
   print $loads;
   print "\treturn\n}\n\n";
 
@@ -0,0 +1,15 @@
+#!/bin/sh
+#
+# Checks what several analyses print for each reference dump in dumps/
+# against the golden files in golden/, by running TestGolden in
+# cmd/heapspurs. With -update, the golden files are rewritten instead.
+#
+# Usage: testdata/corpus/check.sh [-update]
+
+set -e
+cd "$(dirname "$0")/../.."
+if [ "$1" = "-update" ]; then
+  go test ./cmd/heapspurs -run '^TestGolden$' -count 1 -update
+else
+  go test ./cmd/heapspurs -run '^TestGolden$' -count 1
+fi
@@ -0,0 +1,39 @@
+#!/bin/sh
+#
+# Captures reference dumps from the programs in programs/, built with each
+# Go release in the versions file (or just those named on the command line),
+# and writes their golden outputs. Releases other than the installed one are
+# fetched with golang.org/dl, which needs network access.
+#
+# Usage: testdata/corpus/generate.sh [go1.N.M...]
+
+set -e
+corpus=$(cd "$(dirname "$0")" && pwd)
+versions="$*"
+if [ -z "$versions" ]; then
+  versions=$(grep -v '^#' "$corpus/versions")
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+for version in $versions; do
+  if [ "$(go env GOVERSION)" = "$version" ]; then
+    gocmd=go
+  else
+    go install "golang.org/dl/$version@latest"
+    gocmd="$(go env GOPATH)/bin/$version"
+    "$gocmd" download
+  fi
+  mkdir -p "$corpus/dumps/$version"
+  for program in "$corpus"/programs/*/; do
+    name=$(basename "$program")
+    # Built outside of the module, which older releases can't build in
+    mkdir -p "$work/$name"
+    cp "$program"/*.go "$work/$name"
+    (cd "$work/$name" && GOTOOLCHAIN=local GO111MODULE=off "$gocmd" build -o "$work/$name/$name" .)
+    "$work/$name/$name" "$corpus/dumps/$version/$name.dump"
+    echo "Captured dumps/$version/$name.dump"
+  done
+done
+
+"$corpus/check.sh" -update
@@ -0,0 +1,75 @@
+// A program with a little of everything the analyses look at: structs that
+// point to each other, slices, maps, strings, interface values, a cycle,
+// and an object with a finalizer. It writes a heap dump to the file named
+// on its command line.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+type node struct {
+	name     string
+	next     *node
+	children []*node
+	data     []byte
+}
+
+type registry struct {
+	byName  map[string]*node
+	readers []io.Reader
+	sparse  []byte
+}
+
+type resource struct {
+	id   int
+	buf  [256]byte
+	peer *resource
+}
+
+var global *registry
+var list *node
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s dumpfile\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	global = &registry{byName: make(map[string]*node), sparse: make([]byte, 128*1024)}
+	for i := 0; i < 100; i++ {
+		list = &node{name: fmt.Sprintf("node-%d", i), next: list, data: make([]byte, 64)}
+		global.byName[list.name] = list
+	}
+	root := &node{name: "root"}
+	for i := 0; i < 10; i++ {
+		root.children = append(root.children, &node{name: "child", next: root})
+	}
+	global.byName["root"] = root
+	for i := 0; i < 5; i++ {
+		global.readers = append(global.readers, strings.NewReader(strings.Repeat("x", i+1)))
+	}
+
+	a, b := &resource{id: 1}, &resource{id: 2}
+	a.peer, b.peer = b, a
+	runtime.SetFinalizer(a, func(*resource) {})
+
+	runtime.GC()
+	dump(os.Args[1])
+	runtime.KeepAlive(a)
+}
+
+func dump(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	debug.WriteHeapDump(f.Fd())
+	f.Close()
+}
@@ -0,0 +1,68 @@
+// A program whose goroutines are blocked in the middle of things when the
+// dump is taken: on channels with values buffered in them, holding on to
+// objects of their own, and deep in recursion. It writes a heap dump to the
+// file named on its command line.
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+type request struct {
+	id      int
+	payload []byte
+}
+
+var queue = make(chan *request, 16)
+var done = make(chan struct{})
+
+func worker(wg *sync.WaitGroup, id int) {
+	held := &request{id: id, payload: make([]byte, 1024)}
+	wg.Done()
+	<-done
+	runtime.KeepAlive(held)
+}
+
+func recurse(wg *sync.WaitGroup, depth int) int {
+	if depth == 0 {
+		wg.Done()
+		<-done
+		return 0
+	}
+	var frame [16]int
+	frame[depth%16] = depth
+	return recurse(wg, depth-1) + frame[depth%16]
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s dumpfile\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	for i := 0; i < 10; i++ {
+		queue <- &request{id: i, payload: make([]byte, 512)}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go worker(&wg, i)
+	}
+	wg.Add(1)
+	go recurse(&wg, 20)
+	wg.Wait()
+
+	runtime.GC()
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	debug.WriteHeapDump(f.Fd())
+	f.Close()
+	close(done)
+}